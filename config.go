@@ -0,0 +1,267 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// configEntry is one key of a -config file with the values it sets, more
+// than one for a list.
+type configEntry struct {
+	key    string
+	line   int
+	values []string
+}
+
+// flagsFromConfig sets each flag that neither the command line nor the
+// environment set from a JSON or YAML object keyed by flag name, e.g.
+//
+//	{"maxWrite": 65536, "readOnly": true, "file": ["name=a.txt:content=a"]}
+//
+// or, for a file named *.toml, the same as TOML key/value pairs:
+//
+//	maxWrite = 65536
+//	file = ["name=a.txt:content=a"]
+//
+// A list sets a repeatable flag once per item. Unknown keys are returned
+// as warnings rather than failing, so a profile can be shared between
+// versions.
+func flagsFromConfig(fset *flag.FlagSet, file string) (warnings []string, err error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []configEntry
+	if strings.EqualFold(filepath.Ext(file), ".toml") {
+		entries, err = parseTOML(string(raw))
+	} else {
+		entries, err = parseYAMLConfig(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s%w", file, err)
+	}
+	set := map[string]bool{}
+	fset.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, e := range entries {
+		if fset.Lookup(e.key) == nil {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: unknown flag %q, ignoring it", file, e.line, e.key))
+			continue
+		}
+		if set[e.key] {
+			continue
+		}
+		for _, v := range e.values {
+			if err := fset.Set(e.key, v); err != nil {
+				return warnings, fmt.Errorf("%s:%d: %s=%q: %v", file, e.line, e.key, v, err)
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// parseYAMLConfig reads the entries of a JSON or YAML -config file. Errors
+// start with the position, to follow the file name.
+func parseYAMLConfig(raw []byte) ([]configEntry, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf(": %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	m := doc.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf(":%d: want an object of flag names", m.Line)
+	}
+	var entries []configEntry
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, val := m.Content[i], m.Content[i+1]
+		items := []*yaml.Node{val}
+		if val.Kind == yaml.SequenceNode {
+			items = val.Content
+		}
+		e := configEntry{key: key.Value, line: key.Line}
+		for _, v := range items {
+			if v.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf(":%d: %s: want a string, number or boolean", v.Line, key.Value)
+			}
+			e.values = append(e.values, v.Value)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// parseTOML reads the entries of a TOML -config file: key = value lines
+// whose value is a string, number, boolean or an array of them. Tables
+// are refused, as flags have no sections. Errors start with the position,
+// to follow the file name.
+func parseTOML(s string) ([]configEntry, error) {
+	p := &tomlParser{s: s, line: 1}
+	var entries []configEntry
+	seen := map[string]bool{}
+	for {
+		p.skip(true)
+		if p.eof() {
+			return entries, nil
+		}
+		line := p.line
+		if p.s[0] == '[' {
+			return nil, fmt.Errorf(":%d: tables are not supported, put flags at the top level", line)
+		}
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if seen[key] {
+			return nil, fmt.Errorf(":%d: %s is set twice", line, key)
+		}
+		seen[key] = true
+		p.skip(false)
+		if p.eof() || p.s[0] != '=' {
+			return nil, fmt.Errorf(":%d: want = after %s", p.line, key)
+		}
+		p.s = p.s[1:]
+		p.skip(false)
+		e := configEntry{key: key, line: line}
+		if !p.eof() && p.s[0] == '[' {
+			p.s = p.s[1:]
+			for {
+				p.skip(true)
+				if !p.eof() && p.s[0] == ']' {
+					p.s = p.s[1:]
+					break
+				}
+				v, err := p.scalar(key)
+				if err != nil {
+					return nil, err
+				}
+				e.values = append(e.values, v)
+				p.skip(true)
+				if !p.eof() && p.s[0] == ',' {
+					p.s = p.s[1:]
+				} else if p.eof() || p.s[0] != ']' {
+					return nil, fmt.Errorf(":%d: %s: want , or ] in the array", p.line, key)
+				}
+			}
+		} else {
+			v, err := p.scalar(key)
+			if err != nil {
+				return nil, err
+			}
+			e.values = []string{v}
+		}
+		p.skip(false)
+		if !p.eof() && p.s[0] != '\n' {
+			return nil, fmt.Errorf(":%d: %s: want a new line after the value", p.line, key)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// tomlParser holds what is left of a TOML document and the line it is at.
+type tomlParser struct {
+	s    string
+	line int
+}
+
+func (p *tomlParser) eof() bool { return p.s == "" }
+
+// skip drops blanks and a comment, and new lines too if lines is set.
+func (p *tomlParser) skip(lines bool) {
+	for !p.eof() {
+		switch c := p.s[0]; {
+		case c == ' ', c == '\t', c == '\r':
+			p.s = p.s[1:]
+		case c == '#':
+			if i := strings.IndexByte(p.s, '\n'); i >= 0 {
+				p.s = p.s[i:]
+			} else {
+				p.s = ""
+			}
+		case c == '\n' && lines:
+			p.s = p.s[1:]
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+// key reads a bare or quoted key.
+func (p *tomlParser) key() (string, error) {
+	if p.s[0] == '"' || p.s[0] == '\'' {
+		return p.str()
+	}
+	i := strings.IndexFunc(p.s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+	})
+	if i < 0 {
+		i = len(p.s)
+	}
+	if i == 0 {
+		return "", fmt.Errorf(":%d: want a flag name", p.line)
+	}
+	key := p.s[:i]
+	p.s = p.s[i:]
+	return key, nil
+}
+
+// scalar reads a string, number or boolean as the text to set a flag to.
+func (p *tomlParser) scalar(key string) (string, error) {
+	if p.eof() {
+		return "", fmt.Errorf(":%d: %s: want a value", p.line, key)
+	}
+	if p.s[0] == '"' || p.s[0] == '\'' {
+		return p.str()
+	}
+	i := strings.IndexAny(p.s, " \t\r\n,]#")
+	if i < 0 {
+		i = len(p.s)
+	}
+	v := p.s[:i]
+	switch {
+	case v == "true", v == "false":
+	case v != "" && strings.ContainsRune("+-0123456789", rune(v[0])):
+	default:
+		return "", fmt.Errorf(":%d: %s: want a string, number or boolean", p.line, key)
+	}
+	p.s = p.s[i:]
+	return v, nil
+}
+
+// str reads a basic "..." string, with backslash escapes, or a literal
+// '...' one, without. Strings don't span lines.
+func (p *tomlParser) str() (string, error) {
+	q := p.s[0]
+	for i := 1; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '\\':
+			if q == '"' {
+				i++
+			}
+		case '\n':
+			return "", fmt.Errorf(":%d: unterminated string", p.line)
+		case q:
+			lit := p.s[:i+1]
+			p.s = p.s[i+1:]
+			if q == '\'' {
+				return lit[1 : len(lit)-1], nil
+			}
+			v, err := strconv.Unquote(lit)
+			if err != nil {
+				return "", fmt.Errorf(":%d: invalid string %s", p.line, lit)
+			}
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf(":%d: unterminated string", p.line)
+}
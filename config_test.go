@@ -0,0 +1,117 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []configEntry
+		wantErr bool
+	}{
+		{
+			name: "scalars and comments",
+			in:   "# profile\nmaxWrite = 65536 # bytes\nreadOnly = true\nattrTimeout = \"5s\"\nname = 'C:\\dir'\n",
+			want: []configEntry{
+				{"maxWrite", 2, []string{"65536"}},
+				{"readOnly", 3, []string{"true"}},
+				{"attrTimeout", 4, []string{"5s"}},
+				{"name", 5, []string{`C:\dir`}},
+			},
+		},
+		{
+			name: "escapes in basic strings",
+			in:   `content = "a\tb\n\"c\""`,
+			want: []configEntry{{"content", 1, []string{"a\tb\n\"c\""}}},
+		},
+		{
+			name: "arrays over several lines",
+			in:   "file = [\n  \"name=a.txt\", # first\n  \"name=b.txt\",\n]\nuid = 0",
+			want: []configEntry{
+				{"file", 1, []string{"name=a.txt", "name=b.txt"}},
+				{"uid", 5, []string{"0"}},
+			},
+		},
+		{name: "empty", in: "\n# nothing\n"},
+		{name: "table", in: "[mount]\nreadOnly = true", wantErr: true},
+		{name: "no equals", in: "readOnly true", wantErr: true},
+		{name: "bare word", in: "logFormat = json", wantErr: true},
+		{name: "unterminated string", in: "name = \"a\nb\"", wantErr: true},
+		{name: "trailing junk", in: "uid = 1 2", wantErr: true},
+		{name: "duplicate key", in: "uid = 1\nuid = 2", wantErr: true},
+		{name: "unclosed array", in: "file = [\"a\"", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTOML(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	configs := map[string]string{
+		"profile.json": `{"maxWrite": 100, "name": "config", "debug": true, "future": 1}`,
+		"profile.yaml": "maxWrite: 100\nname: config\ndebug: true\nfuture: 1\n",
+		"profile.toml": "maxWrite = 100\nname = \"config\"\ndebug = true\nfuture = 1\n",
+	}
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		maxWrite int
+		want     string
+	}{
+		{name: "config", maxWrite: 100, want: "config"},
+		{name: "env over config", env: map[string]string{"TEST_NAME": "env"}, maxWrite: 100, want: "env"},
+		{name: "flag over env", env: map[string]string{"TEST_NAME": "env", "TEST_MAXWRITE": "200"}, args: []string{"-name=flag"}, maxWrite: 200, want: "flag"},
+		{name: "flag over config", args: []string{"-maxWrite=300"}, maxWrite: 300, want: "config"},
+	}
+	for file, body := range configs {
+		path := filepath.Join(t.TempDir(), file)
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			t.Run(file+"/"+tt.name, func(t *testing.T) {
+				for k, v := range tt.env {
+					t.Setenv(k, v)
+				}
+				fset := flag.NewFlagSet("test", flag.ContinueOnError)
+				maxWrite := fset.Int("maxWrite", 0, "")
+				name := fset.String("name", "", "")
+				debug := fset.Bool("debug", false, "")
+				// the order main applies them in
+				if err := fset.Parse(tt.args); err != nil {
+					t.Fatal(err)
+				}
+				if err := flagsFromEnv(fset, "TEST_"); err != nil {
+					t.Fatal(err)
+				}
+				warnings, err := flagsFromConfig(fset, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(warnings) != 1 {
+					t.Errorf("warnings = %q, want one for the unknown key", warnings)
+				}
+				if *maxWrite != tt.maxWrite || *name != tt.want || !*debug {
+					t.Errorf("maxWrite=%d name=%q debug=%v, want %d %q true", *maxWrite, *name, *debug, tt.maxWrite, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package generator is the interface between hello-fuse and plugins that
+// generate file content at runtime.
+//
+// A plugin is a Go plugin, built with go build -buildmode=plugin, whose
+// main package exports
+//
+//	func Register(r generator.Registry)
+//
+// Each generator it adds is served as gen/<name> and invoked whenever the
+// file is opened.
+//
+// Go plugins only load into a binary built with cgo enabled, by the same
+// Go toolchain, from the same version of this package and of every other
+// package the two have in common. Plugins can't be unloaded.
+package generator
+
+import "context"
+
+// Func produces the full content of a generated file. The context carries
+// the FUSE caller, see fuse.FromContext. An error wrapping a syscall.Errno
+// fails the open with that errno; other errors fail it with EIO, or with
+// -strictErrno the closest matching errno.
+type Func func(ctx context.Context) ([]byte, error)
+
+// Registry collects the generators provided by a plugin.
+type Registry interface {
+	// Add registers fn under name, which must be a valid file name.
+	Add(name string, fn Func)
+}
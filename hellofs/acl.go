@@ -0,0 +1,212 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// aclXattr holds a file's access ACL, in the kernel's binary format. The
+// kernel only uses it with -enableAcl.
+const aclXattr = "system.posix_acl_access"
+
+// ACL entry tags, in the order the kernel wants them
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+const aclVersion = 2
+
+// aclEntry is one entry of an ACL. id is only used by aclUser and
+// aclGroup entries.
+type aclEntry struct {
+	tag  uint16
+	perm uint16 // rwx bits
+	id   uint32
+}
+
+// parseACL reads an ACL in the short text form of setfacl, such as
+//
+//	u::rw-,u:1000:rw-,g::r--,m::rw-,o::r--
+//
+// Users and groups can be given by name or id. The owner, group and other
+// entries are required. Without a mask, one is computed when there are
+// named entries, like setfacl does.
+func parseACL(s string) ([]aclEntry, error) {
+	var acl []aclEntry
+	for field := range strings.SplitSeq(s, ",") {
+		parts := strings.Split(strings.TrimSpace(field), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%q: want tag:qualifier:perms", field)
+		}
+		e := aclEntry{}
+		tag, qual, perms := parts[0], parts[1], parts[2]
+		switch tag {
+		case "u", "user":
+			e.tag = aclUserObj
+			if qual != "" {
+				e.tag = aclUser
+			}
+		case "g", "group":
+			e.tag = aclGroupObj
+			if qual != "" {
+				e.tag = aclGroup
+			}
+		case "m", "mask":
+			e.tag = aclMask
+		case "o", "other":
+			e.tag = aclOther
+		default:
+			return nil, fmt.Errorf("%q: unknown tag %q", field, tag)
+		}
+		if qual != "" {
+			if e.tag != aclUser && e.tag != aclGroup {
+				return nil, fmt.Errorf("%q: only user and group entries take a qualifier", field)
+			}
+			id, err := aclID(qual, e.tag == aclUser)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", field, err)
+			}
+			e.id = id
+		}
+		if len(perms) != 3 {
+			return nil, fmt.Errorf("%q: want perms like rw-", field)
+		}
+		for i, c := range perms {
+			switch {
+			case c == rune("rwx"[i]):
+				e.perm |= 4 >> i
+			case c != '-':
+				return nil, fmt.Errorf("%q: want perms like rw-", field)
+			}
+		}
+		if slices.ContainsFunc(acl, func(o aclEntry) bool { return o.tag == e.tag && o.id == e.id }) {
+			return nil, fmt.Errorf("%q: duplicate entry", field)
+		}
+		acl = append(acl, e)
+	}
+	has := func(tag uint16) bool {
+		return slices.ContainsFunc(acl, func(e aclEntry) bool { return e.tag == tag })
+	}
+	if !has(aclUserObj) || !has(aclGroupObj) || !has(aclOther) {
+		return nil, fmt.Errorf("the u::, g:: and o:: entries are required")
+	}
+	if !has(aclMask) && (has(aclUser) || has(aclGroup)) {
+		m := aclEntry{tag: aclMask}
+		for _, e := range acl {
+			if e.tag == aclUser || e.tag == aclGroupObj || e.tag == aclGroup {
+				m.perm |= e.perm
+			}
+		}
+		acl = append(acl, m)
+	}
+	slices.SortFunc(acl, func(a, b aclEntry) int {
+		if a.tag != b.tag {
+			return int(a.tag) - int(b.tag)
+		}
+		return int(int64(a.id) - int64(b.id))
+	})
+	return acl, nil
+}
+
+// aclID resolves a user or group qualifier.
+func aclID(s string, isUser bool) (uint32, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	var id string
+	if isUser {
+		u, err := user.Lookup(s)
+		if err != nil {
+			return 0, err
+		}
+		id = u.Uid
+	} else {
+		g, err := user.LookupGroup(s)
+		if err != nil {
+			return 0, err
+		}
+		id = g.Gid
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	return uint32(n), err
+}
+
+// encodeACL renders acl as the value of aclXattr.
+func encodeACL(acl []aclEntry) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, aclVersion)
+	for _, e := range acl {
+		id := e.id
+		if e.tag != aclUser && e.tag != aclGroup {
+			id = ^uint32(0) // ACL_UNDEFINED_ID
+		}
+		b = binary.LittleEndian.AppendUint16(b, e.tag)
+		b = binary.LittleEndian.AppendUint16(b, e.perm)
+		b = binary.LittleEndian.AppendUint32(b, id)
+	}
+	return b
+}
+
+// aclMode returns the permission bits matching an aclXattr value, as
+// chmod and stat see them: the group bits are the mask's if there is one.
+// ok is false if b is not a valid ACL.
+func aclMode(b []byte) (mode uint32, ok bool) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 || binary.LittleEndian.Uint32(b) != aclVersion {
+		return 0, false
+	}
+	var user, group, mask, other uint32
+	hasMask := false
+	for e := b[4:]; len(e) > 0; e = e[8:] {
+		perm := uint32(binary.LittleEndian.Uint16(e[2:]) & 7)
+		switch binary.LittleEndian.Uint16(e) {
+		case aclUserObj:
+			user = perm
+		case aclGroupObj:
+			group = perm
+		case aclMask:
+			mask, hasMask = perm, true
+		case aclOther:
+			other = perm
+		}
+	}
+	if hasMask {
+		group = mask
+	}
+	return user<<6 | group<<3 | other, true
+}
+
+// aclChmod returns a copy of the aclXattr value b with the permission
+// bits of mode, as chmod changes them: the owner, other, and the mask if
+// there is one or else the owning group.
+func aclChmod(b []byte, mode uint32) []byte {
+	b = slices.Clone(b)
+	hasMask := false
+	for e := b[4:]; len(e) > 0; e = e[8:] {
+		hasMask = hasMask || binary.LittleEndian.Uint16(e) == aclMask
+	}
+	for e := b[4:]; len(e) > 0; e = e[8:] {
+		var perm uint32
+		switch tag := binary.LittleEndian.Uint16(e); {
+		case tag == aclUserObj:
+			perm = mode >> 6
+		case tag == aclMask, tag == aclGroupObj && !hasMask:
+			perm = mode >> 3
+		case tag == aclOther:
+			perm = mode
+		default:
+			continue
+		}
+		binary.LittleEndian.PutUint16(e[2:], uint16(perm&7))
+	}
+	return b
+}
@@ -0,0 +1,120 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseACL(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []aclEntry
+		wantErr bool
+	}{
+		{
+			name: "base entries",
+			in:   "o::r--, g::r-x,u::rw-",
+			want: []aclEntry{{aclUserObj, 6, 0}, {aclGroupObj, 5, 0}, {aclOther, 4, 0}},
+		},
+		{
+			name: "long tags, sorted by tag and id",
+			in:   "user::rwx,user:1001:r--,user:1000:-w-,group::r--,group:50:--x,mask::rwx,other::---",
+			want: []aclEntry{{aclUserObj, 7, 0}, {aclUser, 2, 1000}, {aclUser, 4, 1001}, {aclGroupObj, 4, 0}, {aclGroup, 1, 50}, {aclMask, 7, 0}, {aclOther, 0, 0}},
+		},
+		{
+			name: "mask computed from named and group entries",
+			in:   "u::rwx,u:1000:-w-,g::r--,o::---",
+			want: []aclEntry{{aclUserObj, 7, 0}, {aclUser, 2, 1000}, {aclGroupObj, 4, 0}, {aclMask, 6, 0}, {aclOther, 0, 0}},
+		},
+		{
+			name: "names",
+			in:   "u::rw-,u:root:r--,g::r--,g:root:r--,o::r--",
+			want: []aclEntry{{aclUserObj, 6, 0}, {aclUser, 4, 0}, {aclGroupObj, 4, 0}, {aclGroup, 4, 0}, {aclMask, 4, 0}, {aclOther, 4, 0}},
+		},
+		{name: "missing other", in: "u::rw-,g::r--", wantErr: true},
+		{name: "unknown tag", in: "u::rw-,g::r--,o::r--,x::r--", wantErr: true},
+		{name: "too few fields", in: "u:rw-,g::r--,o::r--", wantErr: true},
+		{name: "qualified mask", in: "u::rw-,g::r--,m:1:r--,o::r--", wantErr: true},
+		{name: "unknown user", in: "u::rw-,u:no-such-user-here:r--,g::r--,o::r--", wantErr: true},
+		{name: "perms out of order", in: "u::wr-,g::r--,o::r--", wantErr: true},
+		{name: "short perms", in: "u::rw,g::r--,o::r--", wantErr: true},
+		{name: "duplicate", in: "u::rw-,g::r--,o::r--,o::---", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseACL(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseACL(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseACL(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDefaultACL mounts file.txt with a -defaultAcl: under -enableAcl
+// getxattr returns it, the group bits of the mode are its mask, and the
+// kernel enforces it, letting the named user read but not others. Without
+// -enableAcl there is no ACL to get.
+func TestDefaultACL(t *testing.T) {
+	acl, err := parseACL("u::rw-,u:1234:rw-,g::r--,m::r--,o::---")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		enable   bool
+		wantMode os.FileMode
+	}{
+		{"enabled", true, 0o640},
+		{"disabled", false, 0o644},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+			root.enableAcl, root.defaultACL = tt.enable, encodeACL(acl)
+			dir := mountTest(t, Config{Root: root, Options: &fs.Options{
+				MountOptions: fuse.MountOptions{AllowOther: true, EnableAcl: tt.enable},
+			}})
+			path := filepath.Join(dir, "file.txt")
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != tt.wantMode {
+				t.Errorf("mode = %v, want %v", fi.Mode().Perm(), tt.wantMode)
+			}
+			buf := make([]byte, 256)
+			n, err := unix.Getxattr(path, aclXattr, buf)
+			if !tt.enable {
+				if !errors.Is(err, unix.ENODATA) {
+					t.Errorf("getxattr %s: %d, %v, want ENODATA", aclXattr, n, err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(buf[:max(n, 0)], encodeACL(acl)) {
+				t.Errorf("getxattr %s = %x, %v, want %x", aclXattr, buf[:max(n, 0)], err, encodeACL(acl))
+			}
+			if out, err := asUser(t, &syscall.Credential{Uid: 1234, Gid: 1234}, "read", path); err != nil || out != "hello\n" {
+				t.Errorf("read as the named user: %q, %v, want %q", out, err, "hello\n")
+			}
+			if out, err := asUser(t, &syscall.Credential{Uid: 4321, Gid: 4321}, "read", path); err == nil || !strings.Contains(out, "permission denied") {
+				t.Errorf("read as another user: %q, %v, want permission denied", out, err)
+			}
+		})
+	}
+}
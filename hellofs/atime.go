@@ -0,0 +1,57 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// AtimeModes are how reads of a HelloFile update its atime, named after
+// the mount options: never, when it is not newer than the mtime or ctime
+// or is a day old, or on every read.
+var AtimeModes = []string{"noatime", "relatime", "strictatime"}
+
+// atimeFromOptions returns the atime mode the last atime option in
+// options asks for, or relatime, the kernel's default.
+func atimeFromOptions(options []string) string {
+	mode := "relatime"
+	for _, o := range options {
+		switch o {
+		case "noatime", "relatime", "strictatime":
+			mode = o
+		case "atime", "norelatime":
+			mode = "strictatime"
+		}
+	}
+	return mode
+}
+
+// now is the clock reads stamp atime with, swapped out by tests.
+var now = time.Now
+
+// probes counts probeFile reads in progress. The kernel only tells which
+// thread reads, not which process, so this is how the check that the
+// mount is ready keeps its read from counting as an access.
+var probes atomic.Int32
+
+// accessed records a read as the root's atime mode asks.
+func (f *HelloFile) accessed() {
+	if f.root.atime == "noatime" || probes.Load() > 0 {
+		return
+	}
+	if f.root.atime == "relatime" {
+		start := f.root.mtime.UnixNano()
+		orStart := func(ns int64) int64 {
+			if ns == 0 {
+				return start
+			}
+			return ns
+		}
+		atime := orStart(f.atime.Load())
+		if atime > orStart(f.mtime.Load()) && atime > orStart(f.ctime.Load()) && now().Sub(time.Unix(0, atime)) < 24*time.Hour {
+			return
+		}
+	}
+	f.atime.Store(now().UnixNano())
+}
@@ -0,0 +1,45 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessed(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) int64 { return start.Add(d).UnixNano() }
+	tests := []struct {
+		name                string
+		mode                string
+		atime, mtime, ctime int64 // 0 is the root's start
+		now                 time.Duration
+		want                bool // atime moves to now
+	}{
+		{name: "noatime", mode: "noatime", now: time.Hour},
+		{name: "strictatime", mode: "strictatime", atime: at(30 * time.Minute), now: time.Hour, want: true},
+		{name: "relatime first read", mode: "relatime", now: time.Hour, want: true},
+		{name: "relatime newer than both", mode: "relatime", atime: at(30 * time.Minute), mtime: at(10 * time.Minute), ctime: at(10 * time.Minute), now: time.Hour},
+		{name: "relatime older than mtime", mode: "relatime", atime: at(30 * time.Minute), mtime: at(40 * time.Minute), now: time.Hour, want: true},
+		{name: "relatime older than ctime", mode: "relatime", atime: at(30 * time.Minute), mtime: at(10 * time.Minute), ctime: at(40 * time.Minute), now: time.Hour, want: true},
+		{name: "relatime same as mtime", mode: "relatime", atime: at(30 * time.Minute), mtime: at(30 * time.Minute), now: time.Hour, want: true},
+		{name: "relatime a day old", mode: "relatime", atime: at(30 * time.Minute), mtime: at(10 * time.Minute), now: 30*time.Minute + 24*time.Hour, want: true},
+		{name: "relatime just under a day", mode: "relatime", atime: at(30 * time.Minute), mtime: at(10 * time.Minute), now: 30*time.Minute + 23*time.Hour},
+	}
+	defer func(orig func() time.Time) { now = orig }(now)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := start.Add(tt.now)
+			now = func() time.Time { return clock }
+			f := &HelloFile{root: &HelloRoot{atime: tt.mode, mtime: start}}
+			f.atime.Store(tt.atime)
+			f.mtime.Store(tt.mtime)
+			f.ctime.Store(tt.ctime)
+			f.accessed()
+			if got := f.atime.Load() == clock.UnixNano(); got != tt.want {
+				t.Errorf("accessed() moved atime to now = %v, want %v (atime %v)", got, tt.want, time.Unix(0, f.atime.Load()).UTC())
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// attrOverride replaces individual attributes of a node. Unset fields keep
+// the node's own value.
+type attrOverride struct {
+	Mode   string     `json:"mode"` // octal permission bits, e.g. "0600"
+	Uid    *uint32    `json:"uid"`
+	Gid    *uint32    `json:"gid"`
+	Nlink  *uint32    `json:"nlink"`
+	Size   *uint64    `json:"size"`
+	Blocks *uint64    `json:"blocks"`
+	Rdev   *uint32    `json:"rdev"`
+	Atime  *time.Time `json:"atime"`
+	Mtime  *time.Time `json:"mtime"`
+	Ctime  *time.Time `json:"ctime"`
+
+	perm *uint32
+}
+
+// loadAttrOverrides reads overrides from a JSON object keyed by path, e.g.
+//
+//	{"/file.txt": {"mode": "0600", "nlink": 0, "size": 1099511627776}}
+//
+// Times are RFC 3339. The file type can't be overridden, only the
+// permission bits of the mode.
+func loadAttrOverrides(path string) (map[string]*attrOverride, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]*attrOverride
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for p, o := range m {
+		if len(p) == 0 || p[0] != '/' {
+			return nil, fmt.Errorf("%s: path %q is not absolute", path, p)
+		}
+		if o == nil || o.Mode == "" {
+			continue
+		}
+		perm, err := strconv.ParseUint(o.Mode, 8, 32)
+		if err != nil || perm&^07777 != 0 {
+			return nil, fmt.Errorf("%s: %s: invalid mode %q", path, p, o.Mode)
+		}
+		v := uint32(perm)
+		o.perm = &v
+	}
+	return m, nil
+}
+
+// fillCounts sets the link and block counts a node left unset. A directory
+// has a link from its parent, one from its own "." and one from each
+// subdirectory's "..", a file one from its parent. Blocks are counted in
+// 512-byte units, the size rounded up to whole 4K blocks like go-fuse does
+// on Linux; it does nothing on macOS.
+func fillCounts(n *fs.Inode, a *fuse.Attr) {
+	if a.Nlink == 0 {
+		a.Nlink = 1
+		if n.IsDir() {
+			a.Nlink = 2
+			for _, ch := range n.Children() {
+				if ch.IsDir() {
+					a.Nlink++
+				}
+			}
+		}
+	}
+	if a.Blksize == 0 {
+		a.Blksize = 4096
+		a.Blocks = (a.Size + 4095) / 4096 * 8
+	}
+}
+
+// overrideAttr applies the override for n in overrides, which are keyed
+// by absolute path in the mount, to a.
+func overrideAttr(overrides map[string]*attrOverride, n *fs.Inode, a *fuse.Attr) {
+	if len(overrides) == 0 {
+		return
+	}
+	applyOverride(overrides["/"+n.Path(nil)], a)
+}
+
+// overrideEntry is overrideAttr for the child name of parent, for a
+// Lookup that fills in the entry before the child is in the tree. The
+// kernel takes a device's rdev from the entry only.
+func overrideEntry(overrides map[string]*attrOverride, parent *fs.Inode, name string, a *fuse.Attr) {
+	if len(overrides) == 0 {
+		return
+	}
+	applyOverride(overrides[path.Join("/", parent.Path(nil), name)], a)
+}
+
+func applyOverride(o *attrOverride, a *fuse.Attr) {
+	if o == nil {
+		return
+	}
+	if o.perm != nil {
+		a.Mode = a.Mode&^07777 | *o.perm
+	}
+	set := func(dst *uint32, v *uint32) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	set(&a.Uid, o.Uid)
+	set(&a.Gid, o.Gid)
+	set(&a.Nlink, o.Nlink)
+	set(&a.Rdev, o.Rdev)
+	if o.Size != nil {
+		a.Size = *o.Size
+	}
+	if o.Blocks != nil {
+		// go-fuse derives blocks from the size unless a block size is set
+		a.Blocks = *o.Blocks
+		if a.Blksize == 0 {
+			a.Blksize = 4096
+		}
+	}
+	if o.Atime != nil {
+		a.SetTimes(o.Atime, nil, nil)
+	}
+	if o.Mtime != nil {
+		a.SetTimes(nil, o.Mtime, nil)
+	}
+	if o.Ctime != nil {
+		a.SetTimes(nil, nil, o.Ctime)
+	}
+}
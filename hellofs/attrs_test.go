@@ -0,0 +1,95 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestAttrOverrides loads an override of every field but rdev for
+// file.txt and checks each one in stat of the mounted file, and that the
+// file's own attributes show through where nothing is overridden.
+func TestAttrOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attrs.json")
+	err := os.WriteFile(path, []byte(`{"/file.txt": {
+		"mode": "0640", "uid": 1234, "gid": 5678, "nlink": 3,
+		"size": 1099511627776, "blocks": 42, "rdev": 259,
+		"atime": "2001-02-03T04:05:06.000000007Z",
+		"mtime": "2002-03-04T05:06:07.000000008Z",
+		"ctime": "2003-04-05T06:07:08.000000009Z"}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := loadAttrOverrides(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n"), "other.txt": []byte("other\n")}, RootOptions{})
+	dir := mountTest(t, Config{Root: root, attrOverrides: overrides})
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(filepath.Join(dir, "file.txt"), &st); err != nil {
+		t.Fatal(err)
+	}
+	ts := func(s string) syscall.Timespec {
+		tm, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return syscall.NsecToTimespec(tm.UnixNano())
+	}
+	atime, mtime, ctime := statTimes(&st)
+	for _, c := range []struct {
+		field     string
+		got, want any
+	}{
+		{"mode", uint32(st.Mode), uint32(syscall.S_IFREG | 0o640)},
+		{"uid", st.Uid, uint32(1234)},
+		{"gid", st.Gid, uint32(5678)},
+		{"nlink", uint64(st.Nlink), uint64(3)},
+		{"size", st.Size, int64(1 << 40)},
+		{"blocks", st.Blocks, int64(42)},
+		{"atime", atime, ts("2001-02-03T04:05:06.000000007Z")},
+		{"mtime", mtime, ts("2002-03-04T05:06:07.000000008Z")},
+		{"ctime", ctime, ts("2003-04-05T06:07:08.000000009Z")},
+	} {
+		if c.got != c.want {
+			t.Errorf("file.txt %s = %v, want %v", c.field, c.got, c.want)
+		}
+	}
+
+	if err := syscall.Stat(filepath.Join(dir, "other.txt"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Size != int64(len("other\n")) || st.Nlink != 1 || st.Mode&0o7777 == 0o640 {
+		t.Errorf("other.txt: size %d, nlink %d, mode %o, want its own attributes", st.Size, st.Nlink, st.Mode)
+	}
+}
+
+// TestAttrOverrideRdev overrides the device number of a char device
+// served from a source tree; the kernel keeps rdev for device nodes only.
+func TestAttrOverrideRdev(t *testing.T) {
+	skipUnlessMountable(t)
+	host := t.TempDir()
+	if err := syscall.Mknod(filepath.Join(host, "dev"), syscall.S_IFCHR|0o600, 0x0103); err != nil {
+		t.Skipf("mknod: %v", err)
+	}
+	src, err := newSourceRoot(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.root.Close() })
+	rdev := uint32(0x0801)
+	dir := mountTest(t, Config{Root: src, attrOverrides: map[string]*attrOverride{"/dev": {Rdev: &rdev}}})
+	var st syscall.Stat_t
+	if err := syscall.Stat(filepath.Join(dir, "dev"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(st.Rdev) != uint64(rdev) {
+		t.Errorf("dev rdev = %#x, want %#x", st.Rdev, rdev)
+	}
+}
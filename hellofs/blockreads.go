@@ -0,0 +1,89 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// releaseReadsSignal releases the reads stalled by -blockReads.
+var releaseReadsSignal = syscall.SIGUSR2
+
+// readGate makes reads of the in-memory files wait until release, to
+// stand in for a hung backend, for -blockReads. The mounts of a process
+// share one.
+type readGate struct {
+	mu      sync.Mutex
+	gate    chan struct{}
+	open    bool // shutting down, reads no longer stall
+	stalled atomic.Int64
+}
+
+func newReadGate() *readGate {
+	return &readGate{gate: make(chan struct{})}
+}
+
+// wait blocks a read until the next release, or until the kernel
+// interrupts the request. The read that checks the mount is ready is let
+// through, or the mount would never be. A nil g never blocks.
+func (g *readGate) wait(ctx context.Context) syscall.Errno {
+	if g == nil || probes.Load() > 0 {
+		return 0
+	}
+	g.mu.Lock()
+	gate, open := g.gate, g.open
+	g.mu.Unlock()
+	if open {
+		return 0
+	}
+	g.stalled.Add(1)
+	defer g.stalled.Add(-1)
+	select {
+	case <-gate:
+		return 0
+	case <-ctx.Done():
+		return syscall.EINTR
+	}
+}
+
+// release lets the reads stalled so far continue; later ones stall
+// again. It returns how many were released.
+func (g *readGate) release() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.open {
+		return 0
+	}
+	n := g.stalled.Load()
+	close(g.gate)
+	g.gate = make(chan struct{})
+	logEvent("release_reads", fmt.Sprintf("Released %d stalled reads", n), "reads", n)
+	return n
+}
+
+// watch calls release on each releaseReadsSignal until ctx is cancelled.
+// Reads stop stalling then, as a stalled one keeps the mount busy and
+// would fail the unmount.
+func (g *readGate) watch(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, releaseReadsSignal)
+	defer signal.Stop(sigCh)
+	for {
+		select {
+		case <-sigCh:
+			g.release()
+		case <-ctx.Done():
+			g.release()
+			g.mu.Lock()
+			g.open = true
+			g.mu.Unlock()
+			return
+		}
+	}
+}
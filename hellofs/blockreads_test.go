@@ -0,0 +1,112 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// TestBlockReads starts reads with -blockReads: each stalls, /status
+// counts it, and it completes with the file's content once released by
+// POST /release-reads or by the signal.
+func TestBlockReads(t *testing.T) {
+	reads := newReadGate()
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	// so the second read reaches the tree rather than the page cache
+	root.cachePolicy = "direct"
+	dir := mountTest(t, Config{Root: root, reads: reads})
+	ctx, cancel := context.WithCancel(context.Background())
+	go reads.watch(ctx)
+	// before the unmount, which a stalled read would fail
+	t.Cleanup(cancel)
+	opts := &fs.Options{EntryTimeout: fuseTimeout(-1, time.Second), AttrTimeout: fuseTimeout(-1, time.Second), NegativeTimeout: fuseTimeout(-1, 0)}
+	h := controlSet{{mountpoint: dir, opts: opts, reads: reads}}.handler(func() {}, true)
+
+	tests := []struct {
+		name    string
+		release func() error
+	}{
+		{"POST /release-reads", func() error {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/release-reads", nil))
+			if rec.Code != http.StatusOK {
+				return fmt.Errorf("POST /release-reads = %d %s", rec.Code, rec.Body)
+			}
+			return nil
+		}},
+		{"signal", func() error { return syscall.Kill(os.Getpid(), releaseReadsSignal) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type result struct {
+				b   []byte
+				err error
+			}
+			done := make(chan result, 1)
+			// the read released last may not have left wait yet
+			for reads.stalled.Load() != 0 {
+				time.Sleep(time.Millisecond)
+			}
+			f, err := os.Open(filepath.Join(dir, "file.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			// Close waits for the read, so release it first if we fail
+			defer reads.release()
+			// one read: another, at EOF, would stall in turn
+			go func() {
+				b := make([]byte, len("hello\n"))
+				n, err := f.ReadAt(b, 0)
+				done <- result{b[:n], err}
+			}()
+			for deadline := time.Now().Add(2 * time.Second); reads.stalled.Load() == 0; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("the read never stalled")
+				}
+			}
+			select {
+			case r := <-done:
+				t.Fatalf("the read completed while stalled: %q, %v", r.b, r.err)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+			var status struct{ StalledReads int64 }
+			if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || status.StalledReads != 1 {
+				t.Errorf("/status = %s, %v, want stalledReads 1", rec.Body, err)
+			}
+
+			// the signal interrupts the read too, which is retried and
+			// may stall again after the release, so keep releasing
+			for deadline := time.Now().Add(2 * time.Second); ; {
+				if err := tt.release(); err != nil {
+					t.Fatal(err)
+				}
+				select {
+				case r := <-done:
+					if r.err != nil || string(r.b) != "hello\n" {
+						t.Errorf("released read: %q, %v, want %q", r.b, r.err, "hello\n")
+					}
+					return
+				case <-time.After(50 * time.Millisecond):
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("the read is still stalled after the release")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// CallerFile renders the credentials of the process reading it, so
+// different callers reading the same path see different content. Reads
+// use direct I/O and attributes are never cached, so one caller's view
+// is never served to another from the page cache.
+type CallerFile struct {
+	fs.Inode
+	birth
+}
+
+func (f *CallerFile) content(ctx context.Context) []byte {
+	caller, ok := fuse.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return fmt.Appendf(nil, "uid=%d\ngid=%d\npid=%d\n", caller.Uid, caller.Gid, caller.Pid)
+}
+
+func (f *CallerFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	return nil, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *CallerFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	data := f.content(ctx)
+	if off >= int64(len(data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(data)))
+	return fuse.ReadResultData(data[off:end]), 0
+}
+
+func (f *CallerFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = 0444
+	out.Size = uint64(len(f.content(ctx)))
+	out.SetTimeout(0)
+	return 0
+}
+
+func (f *CallerFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*CallerFile)(nil))
+	_ = (fs.NodeOpener)((*CallerFile)(nil))
+	_ = (fs.NodeReader)((*CallerFile)(nil))
+	_ = (fs.NodeGetattrer)((*CallerFile)(nil))
+)
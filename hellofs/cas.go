@@ -0,0 +1,414 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// casStore keeps blobs in a host directory, each named by the hex SHA-256
+// of its content. Identical content is stored once.
+type casStore struct {
+	dir      string
+	readOnly bool // -readOnly: ingest/ refuses changes with EROFS
+}
+
+func (s *casStore) path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+// put stores data and returns its hash. If a blob with the same hash is
+// already present it is reused.
+func (s *casStore) put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	dst := s.path(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return hash, nil
+	}
+	tmp, err := os.CreateTemp(s.dir, ".ingest-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp.Name(), dst)
+}
+
+// attr reports a blob as a read-only file of its stored size, for a
+// request on n.
+func (s *casStore) attr(n *fs.Inode, hash string, out *fuse.Attr) syscall.Errno {
+	st, err := os.Stat(s.path(hash))
+	if err != nil {
+		return toErrno(n, err)
+	}
+	out.Mode = 0444
+	out.Size = uint64(st.Size())
+	mtime := st.ModTime()
+	out.SetTimes(nil, &mtime, &mtime)
+	return 0
+}
+
+func (s *casStore) read(hash string) ([]byte, error) {
+	return os.ReadFile(s.path(hash))
+}
+
+// list returns the hashes of all blobs in the store, sorted.
+func (s *casStore) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && isHash(e.Name()) {
+			hashes = append(hashes, e.Name())
+		}
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+func isHash(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// hashIno derives a stable inode number from a blob hash, so every name
+// resolving to the same blob reports the same inode.
+func hashIno(hash string) uint64 {
+	b, _ := hex.DecodeString(hash[:16])
+	return binary.BigEndian.Uint64(b) | 1<<63
+}
+
+// CASDir presents the blobs of a casStore as a flat directory of
+// hash-named, read-only files, plus an "ingest" directory where written
+// files are stored under their hash.
+type CASDir struct {
+	fs.Inode
+	birth
+
+	store *casStore
+	inos  *inoAllocator
+}
+
+func (d *CASDir) OnAdd(ctx context.Context) {
+	ingest := d.NewPersistentInode(ctx, &CASIngestDir{birth: born(), store: d.store, inos: d.inos}, fs.StableAttr{Mode: syscall.S_IFDIR})
+	d.AddChild("ingest", ingest, false)
+}
+
+func (d *CASDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0755
+	return 0
+}
+
+func (d *CASDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "lookup", &d.Inode)(&errno)
+	if ch := d.GetChild(name); ch != nil {
+		out.Mode = fuse.S_IFDIR | 0755
+		return ch, 0
+	}
+	if !isHash(name) {
+		return nil, syscall.ENOENT
+	}
+	if errno := d.store.attr(&d.Inode, name, &out.Attr); errno != 0 {
+		return nil, errno
+	}
+	// blobs are immutable, so the time they were stored is their birth
+	blob := &CASBlob{birth: birth{btime: out.Attr.ModTime()}, store: d.store, hash: name}
+	return d.NewInode(ctx, blob, fs.StableAttr{Mode: syscall.S_IFREG, Ino: hashIno(name)}), 0
+}
+
+func (d *CASDir) Readdir(ctx context.Context) (ds fs.DirStream, errno syscall.Errno) {
+	defer startOp(ctx, "readdir", &d.Inode)(&errno)
+	hashes, err := d.store.list()
+	if err != nil {
+		return nil, toErrno(&d.Inode, err)
+	}
+	entries := []fuse.DirEntry{{Name: "ingest", Mode: fuse.S_IFDIR}}
+	for _, h := range hashes {
+		entries = append(entries, fuse.DirEntry{Name: h, Mode: fuse.S_IFREG, Ino: hashIno(h)})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (d *CASDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*CASDir)(nil))
+	_ = (fs.NodeOnAdder)((*CASDir)(nil))
+	_ = (fs.NodeGetattrer)((*CASDir)(nil))
+	_ = (fs.NodeLookuper)((*CASDir)(nil))
+	_ = (fs.NodeReaddirer)((*CASDir)(nil))
+)
+
+// CASBlob is a read-only file served straight from the store.
+type CASBlob struct {
+	fs.Inode
+	birth
+
+	store *casStore
+	hash  string
+}
+
+func (b *CASBlob) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &b.Inode)(out, &errno)
+	return b.store.attr(&b.Inode, b.hash, &out.Attr)
+}
+
+func (b *CASBlob) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &b.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EROFS
+	}
+	fd, err := syscall.Open(b.store.path(b.hash), syscall.O_RDONLY, 0)
+	if err != nil {
+		return nil, 0, toErrno(&b.Inode, err)
+	}
+	// blobs never change, so the page cache can be kept
+	return fs.NewLoopbackFile(fd), fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (b *CASBlob) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, b, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*CASBlob)(nil))
+	_ = (fs.NodeGetattrer)((*CASBlob)(nil))
+	_ = (fs.NodeOpener)((*CASBlob)(nil))
+)
+
+// CASIngestDir accepts new files. Content written to a file is stored in
+// the CAS when the file is flushed, and the name resolves to that blob
+// from then on.
+type CASIngestDir struct {
+	fs.Inode
+	birth
+
+	store *casStore
+	inos  *inoAllocator
+}
+
+func (d *CASIngestDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0755
+	return 0
+}
+
+func (d *CASIngestDir) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "create", &d.Inode)(&errno)
+	if d.store.readOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+	if d.GetChild(name) != nil {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	if errno := dirFull(&d.Inode, false); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	f := &CASIngestFile{birth: born(), store: d.store, inos: d.inos, data: []byte{}}
+	ch := d.NewPersistentInode(ctx, f, fs.StableAttr{Mode: syscall.S_IFREG, Ino: d.inos.alloc()})
+	d.AddChild(name, ch, false)
+	out.Mode = fuse.S_IFREG | 0644
+	return ch, nil, 0, 0
+}
+
+func (d *CASIngestDir) Unlink(ctx context.Context, name string) (errno syscall.Errno) {
+	defer startOp(ctx, "unlink", &d.Inode)(&errno)
+	if d.store.readOnly {
+		return syscall.EROFS
+	}
+	// the blob stays in the store; only the name goes away, and the
+	// inode with it once the kernel forgets it
+	if ch := d.GetChild(name); ch != nil {
+		ch.ForgetPersistent()
+	}
+	return 0
+}
+
+func (d *CASIngestDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*CASIngestDir)(nil))
+	_ = (fs.NodeGetattrer)((*CASIngestDir)(nil))
+	_ = (fs.NodeCreater)((*CASIngestDir)(nil))
+	_ = (fs.NodeUnlinker)((*CASIngestDir)(nil))
+)
+
+// CASIngestFile buffers writes in memory until flushed. Once committed,
+// the buffer is dropped and reads are served from the blob by hash; a
+// later write loads the blob back into the buffer.
+type CASIngestFile struct {
+	fs.Inode
+	birth
+
+	store *casStore
+	inos  *inoAllocator
+
+	mu    sync.Mutex
+	data  []byte // pending content, nil once committed
+	dirty bool   // data changed since the last commit
+	hash  string // blob the name resolves to, empty until first commit
+}
+
+// loadLocked makes the content available in the write buffer.
+func (f *CASIngestFile) loadLocked() syscall.Errno {
+	if f.data != nil {
+		return 0
+	}
+	data, err := f.store.read(f.hash)
+	if err != nil {
+		return toErrno(&f.Inode, err)
+	}
+	f.data = data
+	return 0
+}
+
+func (f *CASIngestFile) OnForget() {
+	f.inos.release(f.StableAttr().Ino)
+}
+
+func (f *CASIngestFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if f.store.readOnly && (flags&syscall.O_ACCMODE != syscall.O_RDONLY || flags&syscall.O_TRUNC != 0) {
+		return nil, 0, syscall.EROFS
+	}
+	return nil, 0, 0
+}
+
+func (f *CASIngestFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data := f.data
+	if data == nil {
+		var err error
+		if data, err = f.store.read(f.hash); err != nil {
+			return nil, toErrno(&f.Inode, err)
+		}
+	}
+	if off >= int64(len(data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(data)))
+	return fuse.ReadResultData(data[off:end]), 0
+}
+
+func (f *CASIngestFile) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	defer startOp(ctx, "write", &f.Inode)(&errno)
+	if f.store.readOnly {
+		return 0, syscall.EROFS
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if errno := f.loadLocked(); errno != 0 {
+		return 0, errno
+	}
+	end := off + int64(len(data))
+	if end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[off:], data)
+	f.dirty = true
+	return uint32(len(data)), 0
+}
+
+func (f *CASIngestFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startOp(ctx, "setattr", &f.Inode)(&errno)
+	if f.store.readOnly {
+		return syscall.EROFS
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sz, ok := in.GetSize(); ok {
+		if errno := f.loadLocked(); errno != 0 {
+			return errno
+		}
+		if sz > uint64(len(f.data)) {
+			f.data = append(f.data, make([]byte, sz-uint64(len(f.data)))...)
+		}
+		f.data = f.data[:sz]
+		f.dirty = true
+	}
+	return f.getattrLocked(out)
+}
+
+func (f *CASIngestFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.getattrLocked(out)
+}
+
+func (f *CASIngestFile) getattrLocked(out *fuse.AttrOut) syscall.Errno {
+	if f.data == nil {
+		errno := f.store.attr(&f.Inode, f.hash, &out.Attr)
+		out.Mode = 0644
+		return errno
+	}
+	out.Mode = 0644
+	out.Size = uint64(len(f.data))
+	return 0
+}
+
+// Flush commits the buffered content to the store.
+func (f *CASIngestFile) Flush(ctx context.Context, fh fs.FileHandle) (errno syscall.Errno) {
+	defer startOp(ctx, "flush", &f.Inode)(&errno)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dirty {
+		return 0
+	}
+	hash, err := f.store.put(f.data)
+	if err != nil {
+		return toErrno(&f.Inode, err)
+	}
+	f.hash = hash
+	f.data = nil
+	f.dirty = false
+	return 0
+}
+
+func (f *CASIngestFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*CASIngestFile)(nil))
+	_ = (fs.NodeOnForgetter)((*CASIngestFile)(nil))
+	_ = (fs.NodeOpener)((*CASIngestFile)(nil))
+	_ = (fs.NodeReader)((*CASIngestFile)(nil))
+	_ = (fs.NodeWriter)((*CASIngestFile)(nil))
+	_ = (fs.NodeSetattrer)((*CASIngestFile)(nil))
+	_ = (fs.NodeGetattrer)((*CASIngestFile)(nil))
+	_ = (fs.NodeFlusher)((*CASIngestFile)(nil))
+)
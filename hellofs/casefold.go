@@ -0,0 +1,57 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// lookupFold finds the root's child whose name matches name ignoring
+// case, for -caseInsensitive. go-fuse adds the child under the name
+// looked up too, which is remembered so Readdir lists only the stored
+// name.
+func (r *HelloRoot) lookupFold(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	for stored := range r.Children() {
+		if strings.EqualFold(stored, name) {
+			r.folded.Store(name, struct{}{})
+			return lookupChild(ctx, &r.Inode, stored, out)
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+// isFolded reports whether name is only a differently cased name a
+// lookup added for an existing child.
+func (r *HelloRoot) isFolded(name string) bool {
+	_, ok := r.folded.Load(name)
+	return ok
+}
+
+// caseCollision returns an error for the first two nodes whose paths
+// differ only by case, which -caseInsensitive can't tell apart.
+func caseCollision(nodes []manifestNode) error {
+	seen := map[string]string{}
+	var walk func(dir string, nodes []manifestNode) error
+	walk = func(dir string, nodes []manifestNode) error {
+		for _, n := range nodes {
+			p := path.Join(dir, n.name)
+			key := strings.ToLower(p)
+			if other, ok := seen[key]; ok && other != p {
+				return fmt.Errorf("%s and %s differ only by case", other, p)
+			}
+			seen[key] = p
+			if err := walk(p, n.children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk("", nodes)
+}
@@ -0,0 +1,73 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCaseInsensitive(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.caseInsensitive = true
+	dir := mountRoot(t, root)
+	for _, name := range []string{"FILE.TXT", "File.txt"} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != "hello\n" {
+			t.Errorf("read %s: %q, %v, want file.txt's content", name, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "FILE.TXTX")); !os.IsNotExist(err) {
+		t.Errorf("stat FILE.TXTX: %v, want it missing", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"file.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listing after case-folded lookups = %q, want %q", names, want)
+	}
+}
+
+func TestCaseCollision(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   []manifestNode
+		wantErr bool
+	}{
+		{name: "distinct", nodes: []manifestNode{{name: "a.txt"}, {name: "b.txt"}}},
+		{name: "same name twice", nodes: []manifestNode{{name: "a.txt"}, {name: "a.txt"}}},
+		{name: "case only", nodes: []manifestNode{{name: "a.txt"}, {name: "A.TXT"}}, wantErr: true},
+		{name: "in a directory", nodes: []manifestNode{{name: "d", dir: true, children: []manifestNode{{name: "x"}, {name: "X"}}}}, wantErr: true},
+		{name: "different directories", nodes: []manifestNode{
+			{name: "d", dir: true, children: []manifestNode{{name: "x"}}},
+			{name: "e", dir: true, children: []manifestNode{{name: "X"}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := caseCollision(tt.nodes); (err != nil) != tt.wantErr {
+				t.Errorf("caseCollision() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCaseCollisionManifest(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(file, []byte("- name: a.txt\n  content: a\n- name: A.TXT\n  content: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := loadManifest(file, DefaultMaxNameLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := caseCollision(nodes); err == nil {
+		t.Error("caseCollision accepted a manifest with a.txt and A.TXT")
+	}
+}
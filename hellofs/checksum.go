@@ -0,0 +1,32 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// parseSHA256 checks that s is a hex SHA-256 digest and returns it in
+// lower case.
+func parseSHA256(s string) (string, error) {
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 %q: must be %d hex digits", s, sha256.Size*2)
+	}
+	return strings.ToLower(s), nil
+}
+
+// checkSHA256 returns an error naming both digests unless data hashes to
+// want. An empty want matches anything.
+func checkSHA256(data []byte, want string) error {
+	if want == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", want, got)
+	}
+	return nil
+}
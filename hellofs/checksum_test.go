@@ -0,0 +1,114 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// helloSum is the sha256 of "hello\n".
+const helloSum = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+func TestParseSHA256(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "lower", in: helloSum, want: helloSum},
+		{name: "upper", in: strings.ToUpper(helloSum), want: helloSum},
+		{name: "short", in: helloSum[:62], wantErr: true},
+		{name: "long", in: helloSum + "00", wantErr: true},
+		{name: "not hex", in: "z" + helloSum[1:], wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSHA256(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSHA256(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSHA256(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSHA256(t *testing.T) {
+	if err := checkSHA256([]byte("hello\n"), helloSum); err != nil {
+		t.Errorf("checkSHA256 of a match = %v, want nil", err)
+	}
+	if err := checkSHA256([]byte("anything"), ""); err != nil {
+		t.Errorf("checkSHA256 with no digest = %v, want nil", err)
+	}
+	other := strings.Repeat("0", 64)
+	err := checkSHA256([]byte("hello\n"), other)
+	if err == nil || !strings.Contains(err.Error(), other) || !strings.Contains(err.Error(), helloSum) {
+		t.Errorf("checkSHA256 of a mismatch = %v, want an error naming %s and %s", err, other, helloSum)
+	}
+}
+
+func TestManifestSHA256(t *testing.T) {
+	other := strings.Repeat("0", 64)
+	tests := []struct {
+		name    string
+		sum     string
+		wantErr []string // in the error
+	}{
+		{name: "match", sum: helloSum},
+		{name: "mismatch", sum: other, wantErr: []string{":1:", other, helloSum}},
+		{name: "malformed", sum: "xyz", wantErr: []string{":1:", "xyz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "manifest.yaml")
+			doc := "- name: a.txt\n  content: \"hello\\n\"\n  sha256: " + tt.sum + "\n"
+			if err := os.WriteFile(file, []byte(doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadManifest(file, DefaultMaxNameLen)
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("loadManifest() error = %v, want one: %v", err, tt.wantErr != nil)
+			}
+			for _, s := range tt.wantErr {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("loadManifest() error = %v, want it to name %s", err, s)
+				}
+			}
+		})
+	}
+}
+
+// TestReloadContentSHA256 reloads file.txt from content that doesn't
+// match the digest: the reload fails and the old content stays.
+func TestReloadContentSHA256(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "content")
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	fs.NewNodeFS(root, &fs.Options{})
+	reload := root.reloadContent(src, false, helloSum)
+	f := root.GetChild("file.txt").Operations().(*HelloFile)
+
+	if err := os.WriteFile(src, []byte("tampered\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(context.Background()); err == nil {
+		t.Error("reload of mismatched content succeeded")
+	}
+	if got := string(f.contents()); got != "hello\n" {
+		t.Errorf("after a refused reload, file.txt = %q, want the old content", got)
+	}
+	if err := os.WriteFile(src, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(context.Background()); err != nil {
+		t.Errorf("reload of matching content: %v", err)
+	}
+}
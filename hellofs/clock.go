@@ -0,0 +1,81 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// ClockFile shows the current time in a time.Format layout. Like
+// CallerFile it uses direct I/O and uncached attributes, so every read
+// from the start sees a fresh time.
+type ClockFile struct {
+	fs.Inode
+	birth
+
+	format string
+}
+
+func (f *ClockFile) content() []byte {
+	return append(time.Now().AppendFormat(nil, f.format), '\n')
+}
+
+// clockHandle keeps the time rendered by the last read from offset 0, so
+// the rest of the same read sequence continues the same text.
+type clockHandle struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (f *ClockFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	return &clockHandle{}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *ClockFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	h, ok := fh.(*clockHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if off == 0 || h.data == nil {
+		h.data = f.content()
+	}
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(h.data)))
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+func (f *ClockFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	now := time.Now()
+	out.Mode = 0444
+	out.Size = uint64(len(f.content()))
+	out.SetTimes(&now, &now, &now)
+	out.SetTimeout(0)
+	return 0
+}
+
+func (f *ClockFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*ClockFile)(nil))
+	_ = (fs.NodeOpener)((*ClockFile)(nil))
+	_ = (fs.NodeReader)((*ClockFile)(nil))
+	_ = (fs.NodeGetattrer)((*ClockFile)(nil))
+)
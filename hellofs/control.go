@@ -0,0 +1,227 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// controlState is what the -httpAddr endpoints and the -readySocket
+// report about one mount.
+type controlState struct {
+	mountpoint string
+	opts       *fs.Options
+	start      time.Time
+
+	ready     atomic.Bool  // the mount was verified
+	serving   atomic.Bool  // server.Wait has not returned
+	mountedAt atomic.Int64 // unix nanoseconds the mount came up, 0 before
+	stopping  atomic.Bool
+
+	requests *inFlight   // nil unless -httpAddr or -statusInterval is set
+	drain    *drainState // nil without -lingerOnSignal
+	reads    *readGate   // nil without -blockReads
+}
+
+// controlSet is every mount of the process, in command line order.
+type controlSet []*controlState
+
+// all reports whether ok holds for every mount.
+func (cs controlSet) all(ok func(*controlState) bool) bool {
+	for _, c := range cs {
+		if !ok(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cs controlSet) ready() bool {
+	return cs.all(func(c *controlState) bool { return c.ready.Load() })
+}
+
+// handler serves
+//
+//	/healthz   200 once every mount was verified, 503 before
+//	/readyz    as /healthz, but 503 again once shutdown started
+//	/status    JSON description of the mount, or with several mounts
+//	           {"mounts": [...]} describing each
+//	/shutdown  POST to unmount, like SIGTERM
+//	/release-reads
+//	           POST to release the reads stalled by -blockReads
+//
+// The POST endpoints have no authentication, so unless allowPost is set
+// they answer 403: serve only passes it for a loopback address, or with
+// -httpAllowRemote.
+func (cs controlSet) handler(shutdown func(), allowPost bool) http.Handler {
+	mux := http.NewServeMux()
+	probe := func(ok func() bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !ok() {
+				http.Error(w, "not ready", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok\n"))
+		}
+	}
+	mux.Handle("GET /healthz", probe(cs.ready))
+	mux.Handle("GET /readyz", probe(func() bool {
+		return cs.all(func(c *controlState) bool { return c.ready.Load() && !c.stopping.Load() })
+	}))
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		var status any = cs[0].status()
+		if len(cs) > 1 {
+			mounts := make([]map[string]any, len(cs))
+			for i, c := range cs {
+				mounts[i] = c.status()
+			}
+			status = map[string]any{"mounts": mounts}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+	local := func(h http.HandlerFunc) http.HandlerFunc {
+		if allowPost {
+			return h
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "refused on a non-loopback -httpAddr without -httpAllowRemote", http.StatusForbidden)
+		}
+	}
+	mux.HandleFunc("POST /shutdown", local(func(w http.ResponseWriter, r *http.Request) {
+		logEvent("shutdown_requested", "Shutdown requested over HTTP, Closing gracefully", "remote", r.RemoteAddr)
+		shutdown()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("POST /release-reads", local(func(w http.ResponseWriter, r *http.Request) {
+		// the mounts share the gate
+		if cs[0].reads == nil {
+			http.Error(w, "-blockReads is not set", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "released %d\n", cs[0].reads.release())
+	}))
+	return mux
+}
+
+// status describes the mount for /status.
+func (c *controlState) status() map[string]any {
+	mo := &c.opts.MountOptions
+	status := map[string]any{
+		"mountpoint": c.mountpoint,
+		"uptime":     time.Since(c.start).Round(time.Millisecond).String(),
+		"mountedFor": nil,
+		"ready":      c.ready.Load(),
+		"serving":    c.serving.Load(),
+		"stopping":   c.stopping.Load(),
+		"options": map[string]any{
+			"uid":             c.opts.UID,
+			"gid":             c.opts.GID,
+			"entryTimeout":    c.opts.EntryTimeout.String(),
+			"attrTimeout":     c.opts.AttrTimeout.String(),
+			"negativeTimeout": c.opts.NegativeTimeout.String(),
+			"allowOther":      mo.AllowOther,
+			"options":         mo.Options,
+			"maxBackground":   mo.MaxBackground,
+			"maxWrite":        mo.MaxWrite,
+			"maxReadAhead":    mo.MaxReadAhead,
+			"fsName":          mo.FsName,
+			"name":            mo.Name,
+			"directMount":     mo.DirectMount,
+			"debug":           mo.Debug,
+		},
+	}
+	if at := c.mountedAt.Load(); at != 0 && c.serving.Load() {
+		status["mountedFor"] = time.Since(time.Unix(0, at)).Round(time.Millisecond).String()
+	}
+	if c.reads != nil {
+		status["stalledReads"] = c.reads.stalled.Load()
+	}
+	c.requestStatus(status)
+	return status
+}
+
+// serveReadySocket answers each connection on ln with a single line,
+// READY once every mount was verified and PENDING before, until ln is
+// closed.
+func (cs controlSet) serveReadySocket(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		state := "PENDING\n"
+		if cs.ready() {
+			state = "READY\n"
+		}
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(state))
+		conn.Close()
+	}
+}
+
+// listenReadySocket listens on the Unix socket path, replacing a socket
+// left there by a run that didn't clean up. Closing the listener removes
+// the file.
+func listenReadySocket(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// serve starts the -httpAddr endpoints and the -readySocket, whichever
+// is set, and returns a function that stops them. An httpAddr without a
+// host, such as :8080, listens on the loopback address only; the POST
+// endpoints are only served there, or anywhere with allowRemote.
+func (cs controlSet) serve(httpAddr, readySocket string, allowRemote bool, shutdown func()) (func(), error) {
+	var stops []func()
+	stop := func() {
+		for _, f := range stops {
+			f()
+		}
+	}
+	if httpAddr != "" {
+		ln, err := net.Listen("tcp", loopbackDefault(httpAddr))
+		if err != nil {
+			return nil, fmt.Errorf("control endpoint: %w", err)
+		}
+		allowPost := allowRemote || ln.Addr().(*net.TCPAddr).IP.IsLoopback()
+		srv := &http.Server{Handler: cs.handler(shutdown, allowPost)}
+		go srv.Serve(ln)
+		stops = append(stops, func() {
+			sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer scancel()
+			srv.Shutdown(sctx)
+		})
+	}
+	if readySocket != "" {
+		ln, err := listenReadySocket(readySocket)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("ready socket: %w", err)
+		}
+		go cs.serveReadySocket(ln)
+		stops = append(stops, func() { ln.Close() })
+	}
+	return stop, nil
+}
+
+// loopbackDefault fills in the loopback address for an addr without a
+// host, so the control endpoints are not exposed by accident.
+func loopbackDefault(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
@@ -0,0 +1,49 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReadySocket dials the -readySocket before and after Run verifies
+// the mount: PENDING, then READY. Stopping it removes the socket file.
+func TestReadySocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "ready.sock")
+	cfg := runConfig(t.TempDir())
+	cfg.Control = &controlState{}
+	stopSocket, err := controlSet{cfg.Control}.serve("", sock, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopSocket()
+	dial := func() string {
+		t.Helper()
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	if got := dial(); got != "PENDING\n" {
+		t.Errorf("before the mount: %q, want PENDING", got)
+	}
+	startRun(t, cfg)
+	if got := dial(); got != "READY\n" {
+		t.Errorf("once ready: %q, want READY", got)
+	}
+	stopSocket()
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("after stopping: stat %s = %v, want it removed", sock, err)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"math"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// helloFile returns f, also for the node types embedding a HelloFile.
+func (f *HelloFile) helloFile() *HelloFile { return f }
+
+// CopyFileRange copies between two in-memory files without the data
+// passing through the caller, growing the destination as needed. Other
+// destinations get ENOTSUP, which has the kernel fall back to copying
+// through the page cache; ENOSYS would turn copy_file_range off for the
+// whole mount.
+func (f *HelloFile) CopyFileRange(ctx context.Context, fhIn fs.FileHandle, offIn uint64, out *fs.Inode, fhOut fs.FileHandle, offOut uint64, size uint64, flags uint64) (written uint32, errno syscall.Errno) {
+	defer startOp(ctx, "copy_file_range", &f.Inode)(&errno)
+	if flags != 0 {
+		return 0, syscall.EINVAL
+	}
+	h, ok := out.Operations().(interface{ helloFile() *HelloFile })
+	if !ok {
+		return 0, syscall.ENOTSUP
+	}
+	dst := h.helloFile()
+	if dst.root.readOnly {
+		return 0, syscall.EROFS
+	}
+	if offIn > math.MaxInt64 || offOut > math.MaxInt64 {
+		return 0, syscall.EINVAL
+	}
+	src := f.contents() // a copy, so the source may be the destination
+	if offIn >= uint64(len(src)) {
+		return 0, 0
+	}
+	// the reply can only count up to 4G
+	data := src[offIn:][:min(size, uint64(len(src))-offIn, math.MaxUint32)]
+	dst.data.Lock()
+	dst.extents().writeAt(data, int64(offOut))
+	dst.data.Unlock()
+	dst.touch()
+	dst.invalidateSoon()
+	return uint32(len(data)), 0
+}
+
+var _ = (fs.NodeCopyFileRanger)((*HelloFile)(nil))
@@ -0,0 +1,61 @@
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestCopyFileRange copies between two served files with
+// copy_file_range: the bytes land without a read or write reaching the
+// mount, the destination grows to cover them, and a range running past
+// the source's end is cut short there.
+func TestCopyFileRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		offIn, offOut int64
+		size          int
+		wantN         int
+		wantDst       string
+		wantRequests  int // the kernel answers copies from the end itself
+	}{
+		{name: "within", offIn: 2, offOut: 0, size: 2, wantN: 2, wantDst: "23", wantRequests: 1},
+		{name: "grows the destination", offIn: 0, offOut: 4, size: 3, wantN: 3, wantDst: "ab\x00\x00012", wantRequests: 1},
+		{name: "past the source's end", offIn: 8, offOut: 2, size: 10, wantN: 2, wantDst: "ab89", wantRequests: 1},
+		{name: "at the source's end", offIn: 10, offOut: 0, size: 4, wantN: 0, wantDst: "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := NewRoot(map[string][]byte{"src.txt": []byte("0123456789"), "dst.txt": []byte("ab")}, RootOptions{})
+			var ops opCounter
+			dir := mountTest(t, Config{Root: root, hooks: []opHook{ops.hook}})
+			src, err := os.Open(filepath.Join(dir, "src.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			dst, err := os.OpenFile(filepath.Join(dir, "dst.txt"), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+			offIn, offOut := tt.offIn, tt.offOut
+			n, err := unix.CopyFileRange(int(src.Fd()), &offIn, int(dst.Fd()), &offOut, tt.size, 0)
+			if err != nil || n != tt.wantN {
+				t.Fatalf("CopyFileRange() = %d, %v, want %d", n, err, tt.wantN)
+			}
+			if got := ops.count("copy_file_range"); got != tt.wantRequests {
+				t.Errorf("%d copy_file_range requests, want %d", got, tt.wantRequests)
+			}
+			if r, w := ops.count("read"), ops.count("write"); r+w != 0 {
+				t.Errorf("the copy made %d reads and %d writes, want none", r, w)
+			}
+			got := string(root.GetChild("dst.txt").Operations().(*HelloFile).contents())
+			if got != tt.wantDst {
+				t.Errorf("dst.txt = %q, want %q", got, tt.wantDst)
+			}
+		})
+	}
+}
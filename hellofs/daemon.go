@@ -0,0 +1,111 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// daemonReadyEnv tells a process started by daemonize which fd to report
+// readiness on.
+const daemonReadyEnv = "HELLO_FUSE_READY_FD"
+
+// daemonize re-runs the program detached from the terminal, with its
+// output going to logFile, or nowhere if that is empty. It waits until the
+// child reports the mount ready and returns the status for the parent to
+// exit with: ExitOK, or if the child exits first, the child's. The child
+// gets standard input if stdin is set.
+func daemonize(logFile string, stdin bool) int {
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if logFile != "" {
+		out, err = os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening log file: %v\n", err)
+		return ExitFailure
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error daemonizing: %v\n", err)
+		return ExitFailure
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error daemonizing: %v\n", err)
+		return ExitFailure
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = out, out
+	if stdin {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.ExtraFiles = []*os.File{w} // fd 3
+	cmd.Env = append(os.Environ(), daemonReadyEnv+"=3")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error daemonizing: %v\n", err)
+		return ExitFailure
+	}
+	w.Close()
+	out.Close()
+	// the child writes a byte once ready; EOF means it gave up
+	if n, _ := io.ReadFull(r, make([]byte, 1)); n == 0 {
+		err := cmd.Wait()
+		fmt.Fprintf(os.Stderr, "Background process exited before the mount was ready: %v\n", err)
+		if logFile != "" {
+			fmt.Fprintf(os.Stderr, "See %s for details\n", logFile)
+		}
+		// pass on why it gave up
+		if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() > 0 {
+			return ee.ExitCode()
+		}
+		return ExitFailure
+	}
+	logEvent("background", fmt.Sprintf("Running in the background as pid %d", cmd.Process.Pid), "pid", cmd.Process.Pid)
+	cmd.Process.Release()
+	return ExitOK
+}
+
+// isDaemon reports whether this process was started by daemonize.
+func isDaemon() bool {
+	return os.Getenv(daemonReadyEnv) != ""
+}
+
+// signalDaemonReady tells the parent waiting in daemonize that the mount
+// is ready, if there is one.
+func signalDaemonReady() {
+	fd, err := strconv.Atoi(os.Getenv(daemonReadyEnv))
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// writePidFile writes the process id to path, through a temporary file
+// that is renamed into place.
+func writePidFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintf(tmp, "%d\n", os.Getpid()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,223 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+// aclDefaultXattr holds a directory's default ACL, which the files and
+// directories created in it inherit. Like aclXattr, it is only kept with
+// -enableAcl.
+const aclDefaultXattr = "system.posix_acl_default"
+
+// aclInherit returns the access ACL that an entry created with mode gets
+// from the default ACL def, masked by mode as posix_acl_create does, and
+// the permission bits it ends up with. access is nil when the ACL says no
+// more than the bits, as the kernel doesn't keep one then either.
+func aclInherit(def []byte, mode uint32) (access []byte, perm uint32) {
+	access = slices.Clone(def)
+	hasMask := false
+	for e := access[4:]; len(e) > 0; e = e[8:] {
+		hasMask = hasMask || binary.LittleEndian.Uint16(e) == aclMask
+	}
+	for e := access[4:]; len(e) > 0; e = e[8:] {
+		var bits uint32
+		switch tag := binary.LittleEndian.Uint16(e); {
+		case tag == aclUserObj:
+			bits = mode >> 6
+		case tag == aclMask, tag == aclGroupObj && !hasMask:
+			bits = mode >> 3
+		case tag == aclOther:
+			bits = mode
+		default:
+			continue
+		}
+		binary.LittleEndian.PutUint16(e[2:], binary.LittleEndian.Uint16(e[2:])&uint16(bits&7))
+	}
+	perm, _ = aclMode(access)
+	if len(access) == 4+3*8 {
+		return nil, perm
+	}
+	return access, perm
+}
+
+// inheritACL returns the mode of an entry created in dir with mode, and
+// the ACLs it starts with. Without a default ACL on dir that is mode less
+// the umask and none; with one, the umask doesn't apply and the default
+// ACL decides the permission bits instead. Directories inherit the default
+// ACL itself too.
+func inheritACL(root *HelloRoot, dir *fs.Inode, mode uint32, isDir bool) (uint32, map[string][]byte) {
+	mode &= 07777
+	x := dirXattrs(dir)
+	if !root.enableAcl || x == nil {
+		return mode &^ root.umask, nil
+	}
+	x.mu.Lock()
+	def, ok := x.attrs[aclDefaultXattr]
+	x.mu.Unlock()
+	if !ok {
+		return mode &^ root.umask, nil
+	}
+	access, perm := aclInherit(def, mode)
+	attrs := map[string][]byte{}
+	if access != nil {
+		attrs[aclXattr] = access
+	}
+	if isDir {
+		attrs[aclDefaultXattr] = def
+	}
+	return mode&^0777 | perm, attrs
+}
+
+// dirXattrs returns the ACLs of an in-memory directory, nil for the ones
+// that keep none.
+func dirXattrs(dir *fs.Inode) *xattrStore {
+	switch d := dir.Operations().(type) {
+	case *MemDir:
+		return &d.xattrs
+	case *HelloRoot:
+		return &d.xattrs
+	}
+	return nil
+}
+
+// isACLXattr reports whether attr is one of the ACLs directories keep.
+func isACLXattr(attr string) bool {
+	return attr == aclXattr || attr == aclDefaultXattr
+}
+
+// dirACLMode returns the permission bits of a directory's access ACL, if
+// it has one.
+func dirACLMode(root *HelloRoot, x *xattrStore) (uint32, bool) {
+	if !root.enableAcl {
+		return 0, false
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b, ok := x.attrs[aclXattr]
+	if !ok {
+		return 0, false
+	}
+	return aclMode(b)
+}
+
+// dirGetxattr answers getxattr of the ACLs of a directory, kept in x.
+func dirGetxattr(root *HelloRoot, x *xattrStore, attr string, dest []byte) (uint32, syscall.Errno) {
+	if !isACLXattr(attr) {
+		return 0, fs.ENOATTR
+	}
+	if !root.enableAcl {
+		return 0, syscall.ENOTSUP
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	val, ok := x.attrs[attr]
+	if !ok {
+		return 0, fs.ENOATTR
+	}
+	return xattrReply(dest, val)
+}
+
+// dirSetxattr stores the ACLs a directory can have. There are no other
+// attributes on directories.
+func dirSetxattr(root *HelloRoot, x *xattrStore, attr string, data []byte, flags uint32) syscall.Errno {
+	if errno := dirCheckXattr(root, attr); errno != 0 {
+		return errno
+	}
+	if _, ok := aclMode(data); !ok {
+		return syscall.EINVAL
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	_, ok := x.attrs[attr]
+	switch {
+	case flags&unix.XATTR_CREATE != 0 && ok:
+		return syscall.EEXIST
+	case flags&unix.XATTR_REPLACE != 0 && !ok:
+		return fs.ENOATTR
+	}
+	if x.attrs == nil {
+		x.attrs = map[string][]byte{}
+	}
+	x.attrs[attr] = bytes.Clone(data)
+	return 0
+}
+
+func dirRemovexattr(root *HelloRoot, x *xattrStore, attr string) syscall.Errno {
+	if errno := dirCheckXattr(root, attr); errno != 0 {
+		return errno
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.attrs[attr]; !ok {
+		return fs.ENOATTR
+	}
+	delete(x.attrs, attr)
+	return 0
+}
+
+// dirCheckXattr reports why attr can't be changed on a directory, if it
+// can't.
+func dirCheckXattr(root *HelloRoot, attr string) syscall.Errno {
+	switch {
+	case root.disableXAttrs, !isACLXattr(attr), !root.enableAcl:
+		return syscall.ENOTSUP
+	case root.readOnly:
+		return syscall.EROFS
+	}
+	return 0
+}
+
+func dirXattrNames(x *xattrStore) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return slices.Sorted(maps.Keys(x.attrs))
+}
+
+func (d *MemDir) Getxattr(ctx context.Context, attr string, dest []byte) (sz uint32, errno syscall.Errno) {
+	defer startOp(ctx, "getxattr", &d.Inode)(&errno)
+	return dirGetxattr(d.root, &d.xattrs, attr, dest)
+}
+
+func (d *MemDir) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "setxattr", &d.Inode)(&errno)
+	return dirSetxattr(d.root, &d.xattrs, attr, data, flags)
+}
+
+func (d *MemDir) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer startOp(ctx, "removexattr", &d.Inode)(&errno)
+	return dirRemovexattr(d.root, &d.xattrs, attr)
+}
+
+func (d *MemDir) Listxattr(ctx context.Context, dest []byte) (sz uint32, errno syscall.Errno) {
+	defer startOp(ctx, "listxattr", &d.Inode)(&errno)
+	return xattrList(dest, dirXattrNames(&d.xattrs)...)
+}
+
+func (r *HelloRoot) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "setxattr", &r.Inode)(&errno)
+	return dirSetxattr(r, &r.xattrs, attr, data, flags)
+}
+
+func (r *HelloRoot) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer startOp(ctx, "removexattr", &r.Inode)(&errno)
+	return dirRemovexattr(r, &r.xattrs, attr)
+}
+
+var (
+	_ = (fs.NodeGetxattrer)((*MemDir)(nil))
+	_ = (fs.NodeSetxattrer)((*MemDir)(nil))
+	_ = (fs.NodeRemovexattrer)((*MemDir)(nil))
+	_ = (fs.NodeListxattrer)((*MemDir)(nil))
+	_ = (fs.NodeSetxattrer)((*HelloRoot)(nil))
+	_ = (fs.NodeRemovexattrer)((*HelloRoot)(nil))
+)
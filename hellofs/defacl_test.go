@@ -0,0 +1,61 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestACLInherit(t *testing.T) {
+	tests := []struct {
+		name     string
+		def      string
+		mode     uint32
+		want     string // empty for no access ACL
+		wantPerm uint32
+	}{
+		{
+			name:     "named entries keep theirs, the mask takes the group bits",
+			def:      "u::rwx,u:1000:rwx,g::r-x,m::rwx,o::r-x",
+			mode:     0640,
+			want:     "u::rw-,u:1000:rwx,g::r-x,m::r--,o::---",
+			wantPerm: 0640,
+		},
+		{
+			name:     "without a mask the group entry is masked",
+			def:      "u::rwx,g::rwx,o::rwx",
+			mode:     0750,
+			wantPerm: 0750,
+		},
+		{
+			name:     "the default can only take bits away",
+			def:      "u::rw-,g::r--,o::---",
+			mode:     0777,
+			wantPerm: 0640,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def, err := parseACL(tt.def)
+			if err != nil {
+				t.Fatal(err)
+			}
+			access, perm := aclInherit(encodeACL(def), tt.mode)
+			if perm != tt.wantPerm {
+				t.Errorf("perm = %#o, want %#o", perm, tt.wantPerm)
+			}
+			var want []byte
+			if tt.want != "" {
+				acl, err := parseACL(tt.want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = encodeACL(acl)
+			}
+			if !bytes.Equal(access, want) {
+				t.Errorf("access = %x, want %x", access, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// rootDirStream lists the root's stored children merged, in name order,
+// with the -syntheticEntries files, whose entries are made as they are
+// reached rather than all at once. Offsets count entries from 1.
+//
+// With readdirplus go-fuse looks up every entry it sends, which creates
+// the synthetic files' inodes after all until the kernel forgets them;
+// -disableReadDirPlus avoids that.
+type rootDirStream struct {
+	root   *HelloRoot
+	stored []fuse.DirEntry // sorted by name
+	i      int             // next stored entry
+	k      int             // next synthetic entry, from 1
+	off    uint64
+}
+
+func (s *rootDirStream) HasNext() bool {
+	return s.i < len(s.stored) || s.k <= s.root.syntheticEntries
+}
+
+func (s *rootDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	var e fuse.DirEntry
+	if s.k <= s.root.syntheticEntries {
+		// a stored entry of the same name comes first
+		if name := s.root.syntheticName(s.k); s.i == len(s.stored) || name < s.stored[s.i].Name {
+			e = fuse.DirEntry{Name: name, Mode: syscall.S_IFREG, Ino: syntheticIno + uint64(s.k)}
+			s.k++
+		}
+	}
+	if e.Name == "" {
+		e = s.stored[s.i]
+		s.i++
+	}
+	s.off++
+	e.Off = s.off
+	return e, 0
+}
+
+// Seekdir starts over and skips to off, so a rewound listing is read
+// again from the start.
+func (s *rootDirStream) Seekdir(ctx context.Context, off uint64) syscall.Errno {
+	s.i, s.k, s.off = 0, 1, 0
+	for s.off < off {
+		if !s.HasNext() {
+			return syscall.EINVAL
+		}
+		s.Next()
+	}
+	return 0
+}
+
+func (s *rootDirStream) Close() {}
+
+// sourceDirBatch is how many host directory entries sourceDirStream reads
+// at a time.
+const sourceDirBatch = 256
+
+// sourceDirStream lists a host directory a batch at a time, holding it
+// open until the stream is closed. Offsets count entries from 1.
+type sourceDirStream struct {
+	dir *os.File
+	buf []os.DirEntry // read but not listed yet
+	err error         // ended the last batch, reported once buf is drained
+	off uint64
+
+	strict bool // Config.StrictErrno of the mount
+}
+
+func (s *sourceDirStream) fill() {
+	if len(s.buf) == 0 && s.err == nil {
+		s.buf, s.err = s.dir.ReadDir(sourceDirBatch)
+	}
+}
+
+func (s *sourceDirStream) HasNext() bool {
+	s.fill()
+	return len(s.buf) > 0 || (s.err != nil && s.err != io.EOF)
+}
+
+func (s *sourceDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	if len(s.buf) == 0 {
+		err := s.err
+		s.err = io.EOF
+		return fuse.DirEntry{}, errnoOf(err, s.strict)
+	}
+	e := s.buf[0]
+	s.buf = s.buf[1:]
+	s.off++
+	return fuse.DirEntry{Name: e.Name(), Mode: sourceType(e.Type()), Off: s.off}, 0
+}
+
+// Seekdir rewinds the host directory and skips to off.
+func (s *sourceDirStream) Seekdir(ctx context.Context, off uint64) syscall.Errno {
+	if _, err := s.dir.Seek(0, io.SeekStart); err != nil {
+		return errnoOf(err, s.strict)
+	}
+	s.buf, s.err, s.off = nil, nil, 0
+	for s.off < off {
+		if !s.HasNext() {
+			return syscall.EINVAL
+		}
+		if _, errno := s.Next(); errno != 0 {
+			return errno
+		}
+	}
+	return 0
+}
+
+func (s *sourceDirStream) Close() {
+	s.dir.Close()
+}
+
+var (
+	_ = (fs.FileSeekdirer)((*rootDirStream)(nil))
+	_ = (fs.FileSeekdirer)((*sourceDirStream)(nil))
+)
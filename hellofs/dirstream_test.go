@@ -0,0 +1,98 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// syntheticRoot is an unmounted tree with file.txt and n synthetic
+// entries.
+func syntheticRoot(n int) *HelloRoot {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.syntheticEntries = n
+	fs.NewNodeFS(root, &fs.Options{})
+	return root
+}
+
+// sliceReaddir is the naive listing rootDirStream replaces: every entry
+// built into a slice up front.
+func sliceReaddir(r *HelloRoot) fs.DirStream {
+	var entries []fuse.DirEntry
+	for name, ch := range r.Children() {
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: ch.StableAttr().Mode, Ino: ch.StableAttr().Ino})
+	}
+	for k := 1; k <= r.syntheticEntries; k++ {
+		entries = append(entries, fuse.DirEntry{Name: r.syntheticName(k), Mode: syscall.S_IFREG, Ino: syntheticIno + uint64(k)})
+	}
+	return fs.NewListDirStream(entries)
+}
+
+// drain reads ds to the end, returning the names it listed.
+func drain(t testing.TB, ds fs.DirStream) []string {
+	var names []string
+	for ds.HasNext() {
+		e, errno := ds.Next()
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestRootDirStream(t *testing.T) {
+	root := syntheticRoot(3)
+	ds, errno := root.Readdir(context.Background())
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	want := []string{"file-00001", "file-00002", "file-00003", "file.txt"}
+	if got := drain(t, ds); !reflect.DeepEqual(got, want) {
+		t.Errorf("listing = %q, want %q", got, want)
+	}
+	// rewinddir, then a read from the middle
+	seeker := ds.(fs.FileSeekdirer)
+	if errno := seeker.Seekdir(context.Background(), 0); errno != 0 {
+		t.Fatal(errno)
+	}
+	if got := drain(t, ds); !reflect.DeepEqual(got, want) {
+		t.Errorf("listing after a rewind = %q, want %q", got, want)
+	}
+	if errno := seeker.Seekdir(context.Background(), 2); errno != 0 {
+		t.Fatal(errno)
+	}
+	if got := drain(t, ds); !reflect.DeepEqual(got, want[2:]) {
+		t.Errorf("listing from offset 2 = %q, want %q", got, want[2:])
+	}
+}
+
+// BenchmarkReaddir lists 100k synthetic entries through rootDirStream and
+// through a slice of them all; compare the allocations of the two.
+func BenchmarkReaddir(b *testing.B) {
+	root := syntheticRoot(100000)
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			ds, _ := root.Readdir(context.Background())
+			for ds.HasNext() {
+				ds.Next()
+			}
+		}
+	})
+	b.Run("slice", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			ds := sliceReaddir(root)
+			for ds.HasNext() {
+				ds.Next()
+			}
+		}
+	})
+}
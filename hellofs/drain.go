@@ -0,0 +1,111 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// drainState is -lingerOnSignal, shared by the mounts of the process:
+// how long the first shutdown signal waits for open files and directories
+// to be closed before unmounting, and the handles open meanwhile.
+type drainState struct {
+	linger      time.Duration
+	openHandles atomic.Int64 // across all mounts
+	draining    atomic.Bool
+}
+
+// drainer counts open handles and, once draining, refuses new lookups with
+// ENOENT and new opens with EAGAIN, so that clients can finish with the
+// files they have open but start nothing new.
+type drainer struct {
+	fuse.RawFileSystem
+
+	state *drainState
+}
+
+func (d *drainer) Lookup(cancel <-chan struct{}, header *fuse.InHeader, name string, out *fuse.EntryOut) fuse.Status {
+	if d.state.draining.Load() {
+		return fuse.ENOENT
+	}
+	return d.RawFileSystem.Lookup(cancel, header, name, out)
+}
+
+func (d *drainer) Open(cancel <-chan struct{}, in *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	if d.state.draining.Load() {
+		return fuse.Status(syscall.EAGAIN)
+	}
+	st := d.RawFileSystem.Open(cancel, in, out)
+	if st.Ok() {
+		d.state.openHandles.Add(1)
+	}
+	return st
+}
+
+func (d *drainer) Create(cancel <-chan struct{}, in *fuse.CreateIn, name string, out *fuse.CreateOut) fuse.Status {
+	if d.state.draining.Load() {
+		return fuse.Status(syscall.EAGAIN)
+	}
+	st := d.RawFileSystem.Create(cancel, in, name, out)
+	if st.Ok() {
+		d.state.openHandles.Add(1)
+	}
+	return st
+}
+
+func (d *drainer) OpenDir(cancel <-chan struct{}, in *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	if d.state.draining.Load() {
+		return fuse.Status(syscall.EAGAIN)
+	}
+	st := d.RawFileSystem.OpenDir(cancel, in, out)
+	if st.Ok() {
+		d.state.openHandles.Add(1)
+	}
+	return st
+}
+
+func (d *drainer) Release(cancel <-chan struct{}, in *fuse.ReleaseIn) {
+	d.RawFileSystem.Release(cancel, in)
+	d.state.openHandles.Add(-1)
+}
+
+func (d *drainer) ReleaseDir(in *fuse.ReleaseIn) {
+	d.RawFileSystem.ReleaseDir(in)
+	d.state.openHandles.Add(-1)
+}
+
+// wait starts draining and waits until no handle is open, for up to
+// d.linger or until another signal arrives on sigCh. The count of open
+// handles is logged whenever it changes.
+func (d *drainState) wait(sigCh <-chan os.Signal, mountpoint string) {
+	d.draining.Store(true)
+	deadline := time.After(d.linger)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	last := int64(-1)
+	for {
+		n := d.openHandles.Load()
+		if n == 0 {
+			return
+		}
+		if n != last {
+			logEvent("draining", fmt.Sprintf("Draining: %d handles still open", n), "mountpoint", mountpoint, "handles", n)
+			last = n
+		}
+		select {
+		case <-tick.C:
+		case <-deadline:
+			logEvent("drain_timeout", fmt.Sprintf("Unmounting with %d handles still open after %v", d.openHandles.Load(), d.linger), "mountpoint", mountpoint, "handles", d.openHandles.Load())
+			return
+		case sig := <-sigCh:
+			logEvent("signal", fmt.Sprintf("Received signal %v again, unmounting now", sig), "mountpoint", mountpoint, "signal", sig.String())
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// EchoDir makes up a file for any name looked up in it, holding the name,
+// so cat echo/hello prints hello. Nothing is kept: the inodes are not
+// persistent and go away once the kernel forgets them, and listing the
+// directory shows nothing.
+type EchoDir struct {
+	fs.Inode
+	birth
+}
+
+func (d *EchoDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "lookup", &d.Inode)(&errno)
+	if !validName(name) {
+		return nil, syscall.ENOENT
+	}
+	f := newStaticFile([]byte(name + "\n"))
+	out.Mode = 0444
+	out.Size = uint64(len(name) + 1)
+	return d.NewInode(ctx, f, fs.StableAttr{}), 0
+}
+
+func (d *EchoDir) Readdir(ctx context.Context) (ds fs.DirStream, errno syscall.Errno) {
+	defer startOp(ctx, "readdir", &d.Inode)(&errno)
+	return fs.NewListDirStream(nil), 0
+}
+
+func (d *EchoDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0555
+	return 0
+}
+
+func (d *EchoDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*EchoDir)(nil))
+	_ = (fs.NodeLookuper)((*EchoDir)(nil))
+	_ = (fs.NodeReaddirer)((*EchoDir)(nil))
+	_ = (fs.NodeGetattrer)((*EchoDir)(nil))
+)
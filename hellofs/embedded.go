@@ -0,0 +1,78 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"embed"
+	iofs "io/fs"
+	"path"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// embeddedAssets is served at the root with -embedded.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+// EmbedFile is a file compiled into the binary. Its content never
+// changes, so the kernel may keep it cached across opens.
+type EmbedFile struct {
+	StaticFile
+}
+
+func (f *EmbedFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	fh, fuseFlags, errno = f.StaticFile.Open(ctx, flags)
+	return fh, fuseFlags | fuse.FOPEN_KEEP_CACHE, errno
+}
+
+var _ = (fs.NodeOpener)((*EmbedFile)(nil))
+
+// addEmbedded mirrors the tree of fsys below root, with read-only files
+// and directories. Entries whose name is already taken are skipped, with
+// everything below them.
+func addEmbedded(ctx context.Context, root *HelloRoot, fsys iofs.FS) {
+	dirs := map[string]*fs.Inode{".": &root.Inode}
+	err := iofs.WalkDir(fsys, ".", func(p string, d iofs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		parent := dirs[path.Dir(p)]
+		var ch *fs.Inode
+		if d.IsDir() {
+			ch = parent.NewPersistentInode(ctx, &SpecDir{birth: born(), mode: 0555}, fs.StableAttr{Mode: syscall.S_IFDIR})
+		} else {
+			data, err := iofs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			ch = parent.NewPersistentInode(ctx, &EmbedFile{StaticFile{birth: born(), data: data}}, fs.StableAttr{})
+		}
+		if !parent.AddChild(d.Name(), ch, false) {
+			root.logger.Printf("embedded: /%s already exists, skipping", p)
+			if d.IsDir() {
+				return iofs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			dirs[p] = ch
+		}
+		return nil
+	})
+	if err != nil {
+		root.logger.Printf("embedded: %v", err)
+	}
+}
+
+// embeddedTree returns the assets below their assets/ directory.
+func embeddedTree() iofs.FS {
+	sub, err := iofs.Sub(embeddedAssets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
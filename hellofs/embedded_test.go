@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEmbedded mounts with -embedded and reads every embedded file back
+// through the mount, nested ones included, comparing it byte for byte.
+func TestEmbedded(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.embedded = true
+	dir := mountRoot(t, root)
+	files := 0
+	err := iofs.WalkDir(embeddedTree(), ".", func(p string, d iofs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		files++
+		want, err := iofs.ReadFile(embeddedTree(), p)
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(filepath.Join(dir, p))
+		if err != nil {
+			t.Errorf("read %s through the mount: %v", p, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s through the mount = %q, want the embedded %q", p, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files == 0 {
+		t.Error("no embedded files to read")
+	}
+}
@@ -0,0 +1,58 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"os"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// toErrno maps an error from a backend (the CAS store, a generator
+// plugin) to the errno returned to the client for a request on n, as
+// errnoOf does under the Config.StrictErrno of n's mount.
+func toErrno(n *fs.Inode, err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	return errnoOf(err, stateOf(n).strictErrno)
+}
+
+// errnoOf maps err to an errno. OS errors keep their errno. With strict,
+// missing files, permission problems, timeouts and cancellation are told
+// apart, so clients can e.g. retry ETIMEDOUT but give up on EACCES;
+// everything else is EIO.
+func errnoOf(err error, strict bool) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	if !strict {
+		return syscall.EIO
+	}
+	var timeout interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, iofs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, iofs.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, iofs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, iofs.ErrInvalid):
+		return syscall.EINVAL
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return syscall.ETIMEDOUT
+	case errors.As(err, &timeout) && timeout.Timeout():
+		return syscall.ETIMEDOUT
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	}
+	return syscall.EIO
+}
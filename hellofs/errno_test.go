@@ -0,0 +1,90 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/nopcoder/hello-fuse/generator"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timed out" }
+func (timeoutError) Timeout() bool { return true }
+
+func TestErrnoOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		strict bool
+		want   syscall.Errno
+	}{
+		{name: "nil", err: nil, want: 0},
+		{name: "errno", err: fmt.Errorf("cas: %w", syscall.ENOSPC), want: syscall.ENOSPC},
+		{name: "path error", err: &os.PathError{Op: "open", Path: "x", Err: syscall.EROFS}, strict: true, want: syscall.EROFS},
+		{name: "not strict", err: fs.ErrNotExist, want: syscall.EIO},
+		{name: "not exist", err: fmt.Errorf("plugin: %w", fs.ErrNotExist), strict: true, want: syscall.ENOENT},
+		{name: "exist", err: fs.ErrExist, strict: true, want: syscall.EEXIST},
+		{name: "permission", err: fs.ErrPermission, strict: true, want: syscall.EACCES},
+		{name: "invalid", err: fs.ErrInvalid, strict: true, want: syscall.EINVAL},
+		{name: "deadline", err: context.DeadlineExceeded, strict: true, want: syscall.ETIMEDOUT},
+		{name: "os deadline", err: os.ErrDeadlineExceeded, strict: true, want: syscall.ETIMEDOUT},
+		{name: "timeout method", err: fmt.Errorf("dial: %w", timeoutError{}), strict: true, want: syscall.ETIMEDOUT},
+		{name: "canceled", err: context.Canceled, strict: true, want: syscall.EINTR},
+		{name: "other", err: errors.New("boom"), strict: true, want: syscall.EIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errnoOf(tt.err, tt.strict); got != tt.want {
+				t.Errorf("errnoOf(%v, %v) = %v, want %v", tt.err, tt.strict, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStrictErrno opens generated files whose generators fail through a
+// mount with and without -strictErrno: an errno is kept either way, the
+// rest are EIO unless strict maps them.
+func TestStrictErrno(t *testing.T) {
+	gens := map[string]generator.Func{}
+	for name, err := range map[string]error{
+		"missing": fmt.Errorf("fetch: %w", fs.ErrNotExist),
+		"denied":  fs.ErrPermission,
+		"slow":    context.DeadlineExceeded,
+		"full":    fmt.Errorf("store: %w", syscall.ENOSPC),
+		"broken":  errors.New("boom"),
+	} {
+		gens[name] = func(context.Context) ([]byte, error) { return nil, err }
+	}
+	tests := []struct {
+		name   string
+		strict bool
+		want   map[string]syscall.Errno
+	}{
+		{"default", false, map[string]syscall.Errno{"missing": syscall.EIO, "denied": syscall.EIO, "slow": syscall.EIO, "full": syscall.ENOSPC, "broken": syscall.EIO}},
+		{"strict", true, map[string]syscall.Errno{"missing": syscall.ENOENT, "denied": syscall.EACCES, "slow": syscall.ETIMEDOUT, "full": syscall.ENOSPC, "broken": syscall.EIO}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{Logger: log.New(io.Discard, "", 0)})
+			root.gens = gens
+			dir := mountTest(t, Config{Root: root, StrictErrno: tt.strict})
+			for name, want := range tt.want {
+				_, err := os.ReadFile(filepath.Join(dir, "gen", name))
+				if !errors.Is(err, want) {
+					t.Errorf("read gen/%s: %v, want %v", name, err, want)
+				}
+			}
+		})
+	}
+}
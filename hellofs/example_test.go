@@ -0,0 +1,40 @@
+//go:build linux || darwin
+
+package hellofs_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+
+	"github.com/nopcoder/hello-fuse/hellofs"
+)
+
+// Two files mounted on a directory and read back through it. Mounting
+// needs the fuse module and, for fs.Mount to find fusermount, its setuid
+// helper; TestNewRoot runs the same where it can.
+func ExampleNewRoot() {
+	dir, err := os.MkdirTemp("", "hellofs-example-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(dir)
+
+	root := hellofs.NewRoot(map[string][]byte{"a.txt": []byte("a\n"), "b.txt": []byte("b\n")}, hellofs.RootOptions{})
+	server, err := fs.Mount(dir, root, &fs.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer server.Unmount()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %s", name, b)
+	}
+}
@@ -0,0 +1,39 @@
+package hellofs
+
+import "errors"
+
+// Exit statuses, for supervisors and scripts to tell failures apart.
+const (
+	ExitOK              = 0
+	ExitFailure         = 1 // invalid configuration and other errors
+	ExitUsage           = 2 // bad command line, as the flag package uses
+	ExitMount           = 3 // the mount failed
+	ExitMountTimeout    = 4 // the mount didn't complete within -mountTimeout
+	ExitUnmount         = 5 // unmounting on shutdown failed
+	ExitNotReady        = 6 // the mount came up but failed verification or its -onReadyFatal hook
+	ExitShutdownTimeout = 7 // the server didn't stop within -shutdownTimeout
+	ExitDetached        = 8 // the mount went away without being asked to
+	ExitWritesPending   = 9 // -shutdownDrainWrites gave up on writes in progress
+)
+
+// codedError is an error with the exit status it should end the process
+// with.
+type codedError struct {
+	code int
+	err  error
+}
+
+func withCode(code int, err error) error { return &codedError{code: code, err: err} }
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// ExitCode returns the exit status for err, ExitFailure unless it carries
+// one.
+func ExitCode(err error) int {
+	var c *codedError
+	if errors.As(err, &c) {
+		return c.code
+	}
+	return ExitFailure
+}
@@ -0,0 +1,124 @@
+package hellofs
+
+import (
+	"slices"
+	"sort"
+)
+
+// extentBuf is file data kept as the ranges actually written, so that a
+// write far past the end leaves a hole instead of allocating everything up
+// to it. Holes read as zeros. It does no locking of its own.
+type extentBuf struct {
+	size    int64
+	extents []extent // sorted by offset, neither overlapping nor adjacent
+}
+
+type extent struct {
+	off  int64
+	data []byte
+}
+
+func (e extent) end() int64 { return e.off + int64(len(e.data)) }
+
+// readAt fills dest from off, up to the end of the data, and returns how
+// many bytes that is.
+func (b *extentBuf) readAt(dest []byte, off int64) int {
+	if off >= b.size {
+		return 0
+	}
+	dest = dest[:min(int64(len(dest)), b.size-off)]
+	clear(dest)
+	end := off + int64(len(dest))
+	for i := b.search(off); i < len(b.extents) && b.extents[i].off < end; i++ {
+		e := b.extents[i]
+		if e.off >= off {
+			copy(dest[e.off-off:], e.data)
+		} else {
+			copy(dest, e.data[off-e.off:])
+		}
+	}
+	return len(dest)
+}
+
+// writeAt stores data at off, growing the size to cover it. An empty
+// write changes nothing.
+func (b *extentBuf) writeAt(data []byte, off int64) {
+	if len(data) == 0 {
+		return
+	}
+	end := off + int64(len(data))
+	b.size = max(b.size, end)
+	// the extents that overlap or touch the range become one
+	i := b.search(off - 1)
+	j := i
+	for j < len(b.extents) && b.extents[j].off <= end {
+		j++
+	}
+	if j == i+1 && b.extents[i].off <= off {
+		// one extent starting before the write, like an append: grow it
+		// in place
+		e := &b.extents[i]
+		if end > e.end() {
+			e.data = slices.Grow(e.data, int(end-e.end()))[:end-e.off]
+		}
+		copy(e.data[off-e.off:], data)
+		return
+	}
+	start, stop := off, end
+	if i < j {
+		start, stop = min(start, b.extents[i].off), max(stop, b.extents[j-1].end())
+	}
+	merged := make([]byte, stop-start)
+	for _, e := range b.extents[i:j] {
+		copy(merged[e.off-start:], e.data)
+	}
+	copy(merged[off-start:], data)
+	b.extents = slices.Replace(b.extents, i, j, extent{off: start, data: merged})
+}
+
+// truncate sets the size. Growing adds a hole; shrinking drops the data
+// past the new end.
+func (b *extentBuf) truncate(size int64) {
+	if size < b.size {
+		i := b.search(size)
+		if i < len(b.extents) && b.extents[i].off < size {
+			b.extents[i].data = b.extents[i].data[:size-b.extents[i].off]
+			i++
+		}
+		b.extents = b.extents[:i]
+	}
+	b.size = size
+}
+
+// search returns the index of the first extent ending after off.
+func (b *extentBuf) search(off int64) int {
+	return sort.Search(len(b.extents), func(i int) bool { return b.extents[i].end() > off })
+}
+
+// allocated returns how many bytes of data are stored.
+func (b *extentBuf) allocated() uint64 {
+	var n uint64
+	for _, e := range b.extents {
+		n += uint64(len(e.data))
+	}
+	return n
+}
+
+// blocks returns the 512-byte blocks the stored data takes up, counting
+// whole 4K pages as go-fuse does for files without holes.
+func (b *extentBuf) blocks() uint64 {
+	var blocks, last int64
+	for _, e := range b.extents {
+		start, end := max(e.off&^4095, last), (e.end()+4095)&^4095
+		blocks += (end - start) / 512
+		last = end
+	}
+	return uint64(blocks)
+}
+
+// bytes returns a copy of the data, holes filled in.
+func (b *extentBuf) bytes() []byte {
+	buf := make([]byte, b.size)
+	b.readAt(buf, 0)
+	return buf
+}
@@ -0,0 +1,104 @@
+package hellofs
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestExtentBuf(t *testing.T) {
+	type op struct {
+		off      int64
+		data     string // written at off
+		truncate bool   // truncate to off instead
+	}
+	type span struct{ off, len int64 }
+	write := func(off int64, data string) op { return op{off: off, data: data} }
+	truncate := func(size int64) op { return op{off: size, truncate: true} }
+	tests := []struct {
+		name       string
+		ops        []op
+		wantSize   int64
+		wantSpans  []span
+		wantBlocks uint64
+	}{
+		{name: "empty"},
+		{name: "empty write", ops: []op{write(100, "")}},
+		{name: "appends grow one extent", ops: []op{write(0, "ab"), write(2, "cd"), write(4, "e")}, wantSize: 5, wantSpans: []span{{0, 5}}, wantBlocks: 8},
+		{name: "far write leaves a hole", ops: []op{write(0, "a"), write(1<<20, "b")}, wantSize: 1<<20 + 1, wantSpans: []span{{0, 1}, {1 << 20, 1}}, wantBlocks: 16},
+		{name: "write bridging two extents", ops: []op{write(0, "aa"), write(10, "bb"), write(1, "xxxxxxxxxx")}, wantSize: 12, wantSpans: []span{{0, 12}}, wantBlocks: 8},
+		{name: "write touching the next extent", ops: []op{write(5, "bb"), write(2, "aaa")}, wantSize: 7, wantSpans: []span{{2, 5}}, wantBlocks: 8},
+		{name: "write inside an extent", ops: []op{write(0, "aaaaaa"), write(2, "x")}, wantSize: 6, wantSpans: []span{{0, 6}}, wantBlocks: 8},
+		{name: "truncate grows a hole", ops: []op{write(0, "abc"), truncate(8192)}, wantSize: 8192, wantSpans: []span{{0, 3}}, wantBlocks: 8},
+		{name: "truncate cuts an extent", ops: []op{write(0, "abc"), write(10, "def"), truncate(11)}, wantSize: 11, wantSpans: []span{{0, 3}, {10, 1}}, wantBlocks: 8},
+		{name: "truncate drops extents", ops: []op{write(0, "abc"), write(10, "def"), truncate(5)}, wantSize: 5, wantSpans: []span{{0, 3}}, wantBlocks: 8},
+		{name: "two extents in one page", ops: []op{write(0, "a"), write(100, "b")}, wantSize: 101, wantSpans: []span{{0, 1}, {100, 1}}, wantBlocks: 8},
+		{name: "extent across pages", ops: []op{write(4000, string(make([]byte, 200)))}, wantSize: 4200, wantSpans: []span{{4000, 200}}, wantBlocks: 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b extentBuf
+			for _, o := range tt.ops {
+				if o.truncate {
+					b.truncate(o.off)
+				} else {
+					b.writeAt([]byte(o.data), o.off)
+				}
+			}
+			var spans []span
+			for _, e := range b.extents {
+				spans = append(spans, span{e.off, int64(len(e.data))})
+			}
+			if b.size != tt.wantSize || !reflect.DeepEqual(spans, tt.wantSpans) {
+				t.Errorf("size %d, extents %v, want %d, %v", b.size, spans, tt.wantSize, tt.wantSpans)
+			}
+			if got := b.blocks(); got != tt.wantBlocks {
+				t.Errorf("blocks = %d, want %d", got, tt.wantBlocks)
+			}
+		})
+	}
+}
+
+// TestExtentBufRandom checks an extentBuf against a flat slice taking the
+// same writes and truncates.
+func TestExtentBufRandom(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for run := range 200 {
+		var b extentBuf
+		var flat []byte
+		for step := range 50 {
+			off := r.Int64N(1 << 14)
+			if r.IntN(5) == 0 {
+				b.truncate(off)
+				flat = append(flat[:min(off, int64(len(flat)))], make([]byte, max(0, off-int64(len(flat))))...)
+			} else {
+				data := make([]byte, r.IntN(3000))
+				for i := range data {
+					data[i] = byte(r.IntN(255) + 1)
+				}
+				b.writeAt(data, off)
+				if end := off + int64(len(data)); len(data) > 0 && end > int64(len(flat)) {
+					flat = append(flat, make([]byte, end-int64(len(flat)))...)
+				}
+				copy(flat[off:], data)
+			}
+			if !bytes.Equal(b.bytes(), flat) {
+				t.Fatalf("run %d step %d: content differs from the flat slice", run, step)
+			}
+			for i, e := range b.extents {
+				if len(e.data) == 0 || e.end() > b.size || i > 0 && e.off <= b.extents[i-1].end() {
+					t.Fatalf("run %d step %d: extent %d at %d+%d out of order, adjacent or past size %d", run, step, i, e.off, len(e.data), b.size)
+				}
+			}
+			off = r.Int64N(int64(len(flat)) + 100)
+			got := make([]byte, r.IntN(5000))
+			n := b.readAt(got, off)
+			want := flat[min(off, int64(len(flat))):]
+			want = want[:min(len(want), len(got))]
+			if !bytes.Equal(got[:n], want) {
+				t.Fatalf("run %d step %d: readAt(%d, %d) differs from the flat slice", run, step, len(got), off)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package hellofs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestFallocate preallocates file.txt with fallocate: a plain allocation
+// grows it with zeros, FALLOC_FL_KEEP_SIZE leaves its size alone and
+// other modes aren't supported.
+func TestFallocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    uint32
+		wantErr error
+		want    string
+	}{
+		{name: "plain", want: "hello\n\x00\x00\x00\x00"},
+		{name: "keep size", mode: unix.FALLOC_FL_KEEP_SIZE, want: "hello\n"},
+		{name: "punch hole", mode: unix.FALLOC_FL_PUNCH_HOLE | unix.FALLOC_FL_KEEP_SIZE, wantErr: syscall.EOPNOTSUPP, want: "hello\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := mountRoot(t, NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}))
+			path := filepath.Join(dir, "file.txt")
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if err := unix.Fallocate(int(f.Fd()), tt.mode, 0, 10); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Fallocate(mode %#x) = %v, want %v", tt.mode, err, tt.wantErr)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != int64(len(tt.want)) {
+				t.Errorf("size = %d, want %d", fi.Size(), len(tt.want))
+			}
+			if b, err := os.ReadFile(path); err != nil || string(b) != tt.want {
+				t.Errorf("content = %q, %v, want %q", b, err, tt.want)
+			}
+		})
+	}
+}
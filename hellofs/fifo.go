@@ -0,0 +1,48 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// FifoNode is a named pipe declared in a manifest. Only its metadata is
+// served: the kernel handles opening and I/O on a FIFO itself, as for any
+// special file, so those don't reach it.
+type FifoNode struct {
+	fs.Inode
+	birth
+	cacheTimeouts
+
+	mode  uint32
+	owner fuse.Owner
+}
+
+func (f *FifoNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = f.mode
+	out.Owner = f.owner
+	f.setAttrTimeout(out)
+	return 0
+}
+
+// Open is only reached if the kernel ever passes a FIFO open on; there is
+// no pipe behind the node.
+func (f *FifoNode) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	return nil, 0, syscall.ENOSYS
+}
+
+func (f *FifoNode) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*FifoNode)(nil))
+	_ = (fs.NodeOpener)((*FifoNode)(nil))
+	_ = (fs.NodeGetattrer)((*FifoNode)(nil))
+)
@@ -0,0 +1,72 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// writeTracker is the file handle HelloFile hands out when
+// -logWriteFragments is set. The kernel splits writes larger than the
+// negotiated MaxWrite into several requests; the tracker joins contiguous
+// requests back into the logical write they came from and logs when one
+// arrived in more than one piece.
+type writeTracker struct {
+	path     string
+	maxWrite int
+	logger   *log.Logger
+
+	mu     sync.Mutex
+	start  int64
+	size   int64
+	chunks int
+}
+
+// effectiveMaxWrite mirrors the defaulting and clamping go-fuse applies to
+// MountOptions.MaxWrite before handing it to the kernel.
+func effectiveMaxWrite(maxWrite int) int {
+	if maxWrite <= 0 {
+		return 128 * 1024
+	}
+	return min(maxWrite, fuse.MAX_KERNEL_WRITE)
+}
+
+func (t *writeTracker) track(off int64, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.chunks > 0 && off != t.start+t.size {
+		t.endRunLocked()
+	}
+	if t.chunks == 0 {
+		t.start = off
+	}
+	t.size += int64(n)
+	t.chunks++
+	// a short chunk is the tail of the logical write
+	if n < t.maxWrite {
+		t.endRunLocked()
+	}
+}
+
+func (t *writeTracker) endRunLocked() {
+	if t.chunks > 1 {
+		t.logger.Printf("%s: write of %d bytes at offset %d arrived in %d fragments (max write %d)",
+			t.path, t.size, t.start, t.chunks, t.maxWrite)
+	}
+	t.size, t.chunks = 0, 0
+}
+
+func (t *writeTracker) Release(ctx context.Context) syscall.Errno {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endRunLocked()
+	return 0
+}
+
+var _ = (fs.FileReleaser)((*writeTracker)(nil))
@@ -0,0 +1,28 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// device number of /dev/fuse
+const (
+	fuseDevMajor = 10
+	fuseDevMinor = 229
+)
+
+// checkFuseFd verifies that fd is an open /dev/fuse connection, as passed
+// down by a privileged helper that already did the mount.
+func checkFuseFd(fd int) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("fd %d: %w", fd, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || unix.Major(uint64(st.Rdev)) != fuseDevMajor || unix.Minor(uint64(st.Rdev)) != fuseDevMinor {
+		return fmt.Errorf("fd %d is not a FUSE device", fd)
+	}
+	return nil
+}
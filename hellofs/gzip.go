@@ -0,0 +1,49 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdin is where a -contentFile of - is read from, swapped out by tests.
+var stdin io.Reader = os.Stdin
+
+// readContentFile reads the initial content of file.txt from path, or
+// from standard input until EOF if path is "-", decompressing it if
+// gzipped is set. A corrupt or truncated gzip file is an error, so no
+// partial content is ever served.
+func readContentFile(path string, gzipped bool) ([]byte, error) {
+	if path != "-" && !gzipped {
+		return os.ReadFile(path)
+	}
+	r := stdin
+	name := "stdin"
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r, name = f, path
+	}
+	if !gzipped {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return data, nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: decompressing: %w", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,88 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReadContentStdin feeds -contentFile - through a pipe, which must be
+// read to EOF however the writes are split.
+func TestReadContentStdin(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{name: "one write", writes: []string{"hello\n"}, want: "hello\n"},
+		{name: "several writes", writes: []string{"hel", "lo", "\n"}, want: "hello\n"},
+		{name: "empty", want: ""},
+	}
+	defer func(orig io.Reader) { stdin = orig }(stdin)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w := io.Pipe()
+			stdin = r
+			go func() {
+				for _, s := range tt.writes {
+					w.Write([]byte(s))
+				}
+				w.Close()
+			}()
+			got, err := readContentFile("-", false)
+			if err != nil {
+				t.Fatalf("readContentFile(-) error = %v", err)
+			}
+			if !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("readContentFile(-) = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGzipContentFile gzips a known string and serves it decompressed;
+// corrupt and truncated files are refused at load.
+func TestGzipContentFile(t *testing.T) {
+	const want = "hello, compressed world\n"
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(strings.Repeat(want, 100)))
+	zw.Close()
+	gz := buf.Bytes()
+	dir := t.TempDir()
+	write := func(name string, b []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	data, err := readContentFile(write("good.gz", gz), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt := mountRoot(t, NewRoot(map[string][]byte{"file.txt": data}, RootOptions{}))
+	path := filepath.Join(mnt, "file.txt")
+	if b, err := os.ReadFile(path); err != nil || string(b) != strings.Repeat(want, 100) {
+		t.Errorf("read through the mount: %d bytes, %v, want the original %d", len(b), err, 100*len(want))
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != int64(100*len(want)) {
+		t.Errorf("stat: %v, want the decompressed size %d", err, 100*len(want))
+	}
+
+	for name, b := range map[string][]byte{
+		"not gzip":  []byte(want),
+		"truncated": gz[:len(gz)-10],
+	} {
+		if _, err := readContentFile(write("bad.gz", b), true); err == nil {
+			t.Errorf("readContentFile of a %s file: no error", name)
+		}
+	}
+}
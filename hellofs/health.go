@@ -0,0 +1,105 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// healthFile is -healthFile: a file whose mtime is bumped every interval
+// for as long as probing paths in the mount succeeds, for watchdogs that
+// can't reach the HTTP probes. A failing or stuck probe leaves it
+// untouched until one succeeds again.
+type healthFile struct {
+	path     string
+	interval time.Duration
+	paths    []string
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// startHealthFile touches path until ctx is cancelled, which shutdown
+// does before unmounting, or close is called.
+func startHealthFile(ctx context.Context, path string, interval time.Duration, paths []string) *healthFile {
+	ctx, cancel := context.WithCancel(ctx)
+	h := &healthFile{path: path, interval: interval, paths: paths, cancel: cancel}
+	h.done.Go(func() { h.run(ctx) })
+	return h
+}
+
+func (h *healthFile) run(ctx context.Context) {
+	t := time.NewTicker(h.interval)
+	defer t.Stop()
+	healthy := true
+	for {
+		err := h.probe(ctx)
+		if err == nil {
+			err = touch(h.path)
+		}
+		switch {
+		case err != nil && healthy:
+			logError("health_failed", fmt.Sprintf("Health check failed, no longer touching %s: %v", h.path, err), "path", h.path, "error", err)
+		case err == nil && !healthy:
+			logEvent("health_recovered", fmt.Sprintf("Health check passes again, touching %s", h.path), "path", h.path)
+		}
+		healthy = err == nil
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// probe checks every path, giving up after an interval: a request stuck
+// in the mount would otherwise hang the check for good.
+func (h *healthFile) probe(ctx context.Context) error {
+	timeout := time.NewTimer(h.interval)
+	defer timeout.Stop()
+	done := make(chan error, 1)
+	go func() {
+		for _, p := range h.paths {
+			if err := probeFile(p); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-timeout.C:
+		return fmt.Errorf("no answer within %v", h.interval)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops touching the file and removes it.
+func (h *healthFile) close() {
+	h.cancel()
+	h.done.Wait()
+	if err := os.Remove(h.path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(logStderr, "Failed to remove health file: %v\n", err)
+	}
+}
+
+// touch sets path's times to now, creating it if needed.
+func touch(path string) error {
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHealthFile touches a -healthFile for a mount: its mtime advances
+// while file.txt answers, stops once it is gone, and close removes it.
+func TestHealthFile(t *testing.T) {
+	dir := mountRoot(t, NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}))
+	path := filepath.Join(t.TempDir(), "health")
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	const interval = 20 * time.Millisecond
+	h := startHealthFile(context.Background(), path, interval, []string{filepath.Join(dir, "file.txt")})
+	defer h.close()
+	mtime := func() time.Time {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.ModTime()
+	}
+
+	// past each check of the interval
+	wait := func() { time.Sleep(5 * interval) }
+	wait()
+	first := mtime()
+	if !first.After(old) {
+		t.Fatalf("mtime %v while healthy, want it touched", first)
+	}
+	wait()
+	if second := mtime(); !second.After(first) {
+		t.Errorf("mtime %v after %v while healthy, want it to advance", second, first)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "file.txt")); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	failed := mtime()
+	wait()
+	if got := mtime(); !got.Equal(failed) {
+		t.Errorf("mtime %v after %v with file.txt gone, want it untouched", got, failed)
+	}
+
+	h.close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("stat after close: %v, want it removed", err)
+	}
+}
@@ -0,0 +1,581 @@
+//go:build linux || darwin
+
+// Package hellofs is the hello-fuse filesystem: a tree serving file.txt
+// and whatever the options add to it. NewRoot builds a plain tree and Run
+// mounts and serves one; Main is the hello-fuse command itself.
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/time/rate"
+
+	"github.com/nopcoder/hello-fuse/generator"
+)
+
+// HelloRoot is the top directory of the tree, holding the settings its
+// nodes share.
+type HelloRoot struct {
+	fs.Inode
+	birth
+
+	logger            *log.Logger
+	state             *mountState     // set by Run, see stateOf
+	mounted           atomic.Bool     // set once notifications can be sent
+	done              context.Context // cancelled at shutdown, ending background work
+	supplementaryGids []uint32
+	logWriteFragments bool
+	maxWrite          int
+	callerFile        bool
+	readOnly          bool
+	disableXAttrs     bool              // go-fuse only refuses getxattr and listxattr
+	entries           map[string][]byte // regular files at the root by name, file.txt among them
+	writebackFile     string            // -writeback, also saved on each fsync of file.txt
+	writebackMu       sync.Mutex
+	clockFormat       string // serve clock.txt if set
+	casDir            string
+	closeToOpen       bool
+	enableLocks       bool
+	enableAcl         bool
+	defaultACL        []byte        // aclXattr of the files that have none of their own
+	xattrs            xattrStore    // the ACLs of the top directory
+	readLimit         *rate.Limiter // -readBps, nil for none
+	cachePolicy       string        // default of the files' cachePolicy
+	atime             string        // one of AtimeModes
+	inos              *inoAllocator
+	sqlDir            *SQLDir
+	procs             *procTable
+	readStats         *readStats
+	gens              map[string]generator.Func
+	kubeDirs          map[string]*KubeDir
+	spec              []specEntry
+	mtime             time.Time // reported by files without timestamps
+	manifest          []manifestNode
+	manifestInodes    map[string]*fs.Inode // added by the manifest, for reloads
+	files             []manifestNode       // from -file and -symlink
+	tarEntries        []tarEntry           // from -tarFile
+	aliases           []string             // more names of file.txt
+	randomSize        int64
+	sparseSize        int64
+	benchFileSize     int64
+	syntheticEntries  int
+	syntheticSize     int64 // 0 serves each file's name
+	nullFile          bool
+	echoDir           bool
+	fsSize            uint64 // reported by statfs
+	fsFree            int64  // negative derives it from the content size
+	randomSeed        uint64
+	seed              uint64 // the -syntheticSize files derive theirs from it
+	tmpTTL            time.Duration
+	tmpIdle           bool
+	umask             uint32     // cleared from the modes of created and built-in entries
+	mode              uint32     // of the top directory, from -rootMode
+	owner             fuse.Owner // of the top directory; zero ids are the -uid and -gid
+	embedded          bool
+	writeCountFile    bool
+	caseInsensitive   bool          // lookups at the root ignore case
+	folded            sync.Map      // names added by those lookups, see lookupFold
+	writes            atomic.Uint64 // write requests served, for writes.count
+}
+
+func (r *HelloRoot) OnAdd(ctx context.Context) {
+	for _, name := range slices.Sorted(maps.Keys(r.entries)) {
+		f := &HelloFile{
+			birth: born(),
+			root:  r,
+			MemRegularFile: fs.MemRegularFile{
+				Data: r.entries[name],
+				Attr: fuse.Attr{
+					Mode: 0644 &^ r.umask,
+				},
+			},
+		}
+		if name != "file.txt" {
+			f.links.Store(1)
+			r.AddChild(name, r.NewPersistentInode(ctx, f, fs.StableAttr{Ino: r.inos.alloc()}), false)
+			continue
+		}
+		f.links.Store(uint32(1 + len(r.aliases)))
+		ch := r.NewPersistentInode(ctx, f, fs.StableAttr{Ino: 2})
+		r.AddChild(name, ch, false)
+		// the same inode under more names, like hard links
+		for _, name := range r.aliases {
+			r.AddChild(name, ch, false)
+		}
+	}
+	if r.callerFile {
+		r.AddChild("caller.txt", r.NewPersistentInode(ctx, &CallerFile{birth: born()}, fs.StableAttr{}), false)
+	}
+	if r.clockFormat != "" {
+		r.AddChild("clock.txt", r.NewPersistentInode(ctx, &ClockFile{birth: born(), format: r.clockFormat}, fs.StableAttr{}), false)
+	}
+	if r.writeCountFile {
+		r.AddChild("writes.count", r.NewPersistentInode(ctx, &WriteCountFile{birth: born(), root: r}, fs.StableAttr{}), false)
+	}
+	if r.casDir != "" {
+		cas := &CASDir{birth: born(), store: &casStore{dir: r.casDir, readOnly: r.readOnly}, inos: r.inos}
+		r.AddChild("cas", r.NewPersistentInode(ctx, cas, fs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+	if r.sqlDir != nil {
+		r.AddChild("db", r.NewPersistentInode(ctx, r.sqlDir, fs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+	addSpec(ctx, r, r.spec)
+	if r.embedded {
+		addEmbedded(ctx, r, embeddedTree())
+	}
+	r.manifestInodes = addManifest(ctx, r, &r.Inode, r.manifest)
+	addManifest(ctx, r, &r.Inode, r.files)
+	addTar(ctx, r, r.tarEntries)
+	for name, d := range r.kubeDirs {
+		r.AddChild(name, r.NewPersistentInode(ctx, d, fs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+	if len(r.gens) > 0 {
+		gen := &GenDir{birth: born(), gens: r.gens, logger: r.logger}
+		r.AddChild("gen", r.NewPersistentInode(ctx, gen, fs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+	if r.randomSize > 0 {
+		rnd := &RandomFile{birth: born(), seed: r.randomSeed, size: r.randomSize}
+		r.AddChild("random.bin", r.NewPersistentInode(ctx, rnd, fs.StableAttr{}), false)
+	}
+	if r.benchFileSize > 0 {
+		r.AddChild("zeros.bin", r.NewPersistentInode(ctx, &ZeroFile{SparseFile{birth: born(), size: r.benchFileSize}}, fs.StableAttr{}), false)
+	}
+	if r.nullFile {
+		// null.txt has mode 0. go-fuse reports that as 0644 unless
+		// -nullPermissions is set. Either way the kernel only enforces
+		// it with -options default_permissions, and then not for root.
+		// Without it, whoever may use the mount, the mounting user or
+		// everyone with -allowOther, can read the file whatever its mode.
+		f := &HelloFile{birth: born(), root: r, MemRegularFile: fs.MemRegularFile{Data: []byte("null\n")}}
+		r.AddChild("null.txt", r.NewPersistentInode(ctx, f, fs.StableAttr{}), false)
+	}
+	if r.echoDir {
+		r.AddChild("echo", r.NewPersistentInode(ctx, &EchoDir{birth: born()}, fs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+	if r.sparseSize > 0 {
+		r.AddChild("sparse.bin", r.NewPersistentInode(ctx, &SparseFile{birth: born(), size: r.sparseSize}, fs.StableAttr{}), false)
+	}
+	if r.tmpTTL > 0 {
+		tmp := &TmpDir{birth: born(), root: r, ttl: r.tmpTTL, idle: r.tmpIdle}
+		r.AddChild("tmp", r.NewPersistentInode(ctx, tmp, fs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+	if r.readStats != nil {
+		r.AddChild(statsName, r.NewPersistentInode(ctx, &StatsFile{birth: born(), stats: r.readStats}, fs.StableAttr{}), false)
+	}
+	if r.procs != nil {
+		r.AddChild("proc", r.NewPersistentInode(ctx, &ProcDir{birth: born(), table: r.procs}, fs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+}
+
+func (r *HelloRoot) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &r.Inode)(out, &errno)
+	out.Mode = r.mode
+	if mode, ok := dirACLMode(r, &r.xattrs); ok {
+		out.Mode = out.Mode&^0777 | mode
+	}
+	out.Owner = r.owner
+	return 0
+}
+
+func (r *HelloRoot) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, r, fh, out)
+}
+
+var (
+	_ = (fs.NodeGetattrer)((*HelloRoot)(nil))
+	_ = (fs.NodeStatxer)((*HelloRoot)(nil))
+	_ = (fs.NodeOnAdder)((*HelloRoot)(nil))
+	_ = (fs.NodeStatfser)((*HelloRoot)(nil))
+)
+
+// invalidate makes the kernel drop what it caches for n, so the next stat
+// or read asks us again instead of waiting out the attribute timeout. It
+// uses Inode.NotifyContent(0, 0), which sends FUSE_NOTIFY_INVAL_INODE
+// through server.InodeNotify: attributes are invalidated and, with the
+// whole range given, all cached pages too. Before the mount is up there
+// is no server to send it through, and it does nothing.
+func (r *HelloRoot) invalidate(n *fs.Inode) {
+	if !r.mounted.Load() {
+		return
+	}
+	// ENOENT: the kernel has nothing cached for the inode
+	if errno := n.NotifyContent(0, 0); errno != 0 && errno != syscall.ENOENT {
+		r.logger.Printf("/%s: invalidating cache: %v", n.Path(nil), errno)
+	}
+}
+
+// HelloFile is an in-memory regular file whose operations are reported
+// to the registered op hooks.
+type HelloFile struct {
+	fs.MemRegularFile
+	birth
+	cacheTimeouts
+
+	root   *HelloRoot
+	data   sync.RWMutex // held around changes to buf, so reads see them whole
+	xattrs xattrStore
+	locks  lockTable
+	cache  string        // cachePolicy, empty for the root's
+	links  atomic.Uint32 // names it has, reported as nlink; 0 means 1
+	inval  atomic.Bool   // an invalidateSoon is yet to start
+
+	// unix nanoseconds of the last change to the data, and to the data
+	// or attributes; 0 until the first. Reads update atime as -atime
+	// says, utimes sets it.
+	atime, mtime, ctime atomic.Int64
+
+	// the data. MemRegularFile.Data only holds the initial content,
+	// until the first access moves it here.
+	buf     extentBuf
+	bufInit sync.Once
+}
+
+// invalidateSoon invalidates f from another goroutine, since the kernel
+// holds the inode lock until the write returns. Writes that arrive before
+// that goroutine starts are covered by it rather than each starting one.
+func (f *HelloFile) invalidateSoon() {
+	if !f.inval.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		f.inval.Store(false)
+		f.root.invalidate(&f.Inode)
+	}()
+}
+
+// extents returns the file's data. f.data must be held.
+func (f *HelloFile) extents() *extentBuf {
+	f.bufInit.Do(func() {
+		if len(f.Data) > 0 {
+			f.buf = extentBuf{size: int64(len(f.Data)), extents: []extent{{data: f.Data}}}
+		}
+		f.Data = nil
+	})
+	return &f.buf
+}
+
+// touch records a change to the file's data now.
+func (f *HelloFile) touch() {
+	now := time.Now().UnixNano()
+	f.mtime.Store(now)
+	f.ctime.Store(now)
+}
+
+// cachePolicies are how a HelloFile's open uses the page cache: keep what
+// is cached, drop it, or bypass it with direct I/O.
+var cachePolicies = []string{"cache", "nocache", "direct"}
+
+func (f *HelloFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if f.root.readOnly && (flags&syscall.O_ACCMODE != syscall.O_RDONLY || flags&syscall.O_TRUNC != 0) {
+		return nil, 0, syscall.EROFS
+	}
+	fh, fuseFlags, errno = f.MemRegularFile.Open(ctx, flags)
+	if flags&syscall.O_TRUNC != 0 && flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		// usually the kernel follows up with a setattr to size 0, but
+		// with atomic O_TRUNC it leaves the truncation to the open
+		f.data.Lock()
+		f.extents().truncate(0)
+		f.data.Unlock()
+		f.touch()
+		fuseFlags &^= fuse.FOPEN_KEEP_CACHE
+	}
+	policy := f.cache
+	if policy == "" {
+		policy = f.root.cachePolicy
+	}
+	switch policy {
+	case "nocache":
+		fuseFlags &^= fuse.FOPEN_KEEP_CACHE
+	case "direct":
+		fuseFlags = fuseFlags&^fuse.FOPEN_KEEP_CACHE | fuse.FOPEN_DIRECT_IO
+	}
+	if f.root.closeToOpen {
+		// drop the page cache on open so data written through handles
+		// closed earlier is always read back
+		fuseFlags &^= fuse.FOPEN_KEEP_CACHE
+	}
+	if errno == 0 && f.root.logWriteFragments {
+		fh = &writeTracker{
+			path:     f.Path(nil),
+			maxWrite: effectiveMaxWrite(f.root.maxWrite),
+			logger:   f.root.logger,
+		}
+	}
+	return fh, fuseFlags, errno
+}
+
+func (f *HelloFile) Release(ctx context.Context, fh fs.FileHandle) (errno syscall.Errno) {
+	defer startOp(ctx, "release", &f.Inode)(&errno)
+	if r, ok := fh.(fs.FileReleaser); ok {
+		errno = r.Release(ctx)
+	}
+	if f.root.closeToOpen {
+		// the data lives in memory, so it is durable once the write
+		// returned; only the kernel's cached pages need to go
+		if e := f.NotifyContent(0, 0); e != 0 && e != syscall.ENOSYS {
+			f.root.logger.Printf("%s: invalidating cache on release: %v", f.Path(nil), e)
+		}
+	}
+	return errno
+}
+
+// Read copies out what there is of the range: a short read at the end of
+// the file and none past it, with zeros for holes. Unlike
+// MemRegularFile's version, it copies, so a write can't change the reply
+// while it is sent.
+func (f *HelloFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	if errno := stateOf(&f.Inode).reads.wait(ctx); errno != 0 {
+		return nil, errno
+	}
+	f.data.RLock()
+	n := f.extents().readAt(dest, off)
+	f.data.RUnlock()
+	f.accessed()
+	return fuse.ReadResultData(dest[:n]), f.root.throttleRead(ctx, n)
+}
+
+func (f *HelloFile) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	defer startOp(ctx, "write", &f.Inode)(&errno)
+	if f.root.readOnly {
+		return 0, syscall.EROFS
+	}
+	f.data.Lock()
+	f.extents().writeAt(data, off)
+	f.data.Unlock()
+	written = uint32(len(data))
+	if errno == 0 {
+		f.root.writes.Add(1)
+		f.touch()
+		f.invalidateSoon()
+	}
+	if t, ok := fh.(*writeTracker); ok && errno == 0 {
+		t.track(off, int(written))
+	}
+	return written, errno
+}
+
+func (f *HelloFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	f.data.RLock()
+	errno = f.MemRegularFile.Getattr(ctx, fh, out)
+	f.fillSize(out)
+	f.data.RUnlock()
+	f.fillAttr(out)
+	f.setAttrTimeout(out)
+	return errno
+}
+
+// fillSize reports the size and the blocks actually holding data, fewer
+// than the size takes if the file has holes. f.data must be held.
+func (f *HelloFile) fillSize(out *fuse.AttrOut) {
+	b := f.extents()
+	out.Size = uint64(b.size)
+	out.Blksize = 4096
+	out.Blocks = b.blocks()
+}
+
+// fillAttr adds what HelloFile tracks itself to the attributes
+// MemRegularFile reports.
+func (f *HelloFile) fillAttr(out *fuse.AttrOut) {
+	// files without timestamps of their own show the start time rather
+	// than the epoch, until they are changed
+	if out.Mtime == 0 && out.Mtimensec == 0 {
+		t := f.root.mtime
+		out.SetTimes(&t, &t, &t)
+	}
+	if ns := f.mtime.Load(); ns != 0 {
+		mtime, ctime := time.Unix(0, ns), time.Unix(0, f.ctime.Load())
+		out.SetTimes(nil, &mtime, &ctime)
+	}
+	if ns := f.atime.Load(); ns != 0 {
+		atime := time.Unix(0, ns)
+		out.SetTimes(&atime, nil, nil)
+	}
+	if n := f.links.Load(); n > 1 {
+		out.Nlink = n
+	}
+	// the ACL has the final say on the permission bits; Setattr updates
+	// it on chmod
+	if mode, ok := f.aclMode(); ok {
+		out.Mode = out.Mode&^0777 | mode
+	}
+}
+
+func (f *HelloFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startOp(ctx, "setattr", &f.Inode)(&errno)
+	if f.root.readOnly {
+		return syscall.EROFS
+	}
+	f.data.Lock()
+	defer f.data.Unlock()
+	if sz, ok := in.GetSize(); ok {
+		f.extents().truncate(int64(sz))
+	}
+	if mode, ok := in.GetMode(); ok {
+		f.aclChmod(mode)
+	}
+	if atime, ok := in.GetATime(); ok {
+		f.atime.Store(atime.UnixNano())
+	}
+	switch mtime, ok := in.GetMTime(); {
+	case ok:
+		f.mtime.Store(mtime.UnixNano())
+		f.ctime.Store(time.Now().UnixNano())
+	case in.Valid&fuse.FATTR_SIZE != 0:
+		f.touch()
+	}
+	// the size is ours; MemRegularFile only reports its attributes
+	attrIn := *in
+	attrIn.Valid &^= fuse.FATTR_SIZE
+	errno = f.MemRegularFile.Setattr(ctx, fh, &attrIn, out)
+	f.fillSize(out)
+	f.fillAttr(out)
+	return errno
+}
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, the only fallocate mode
+// supported. FUSE passes Linux's flags on every platform.
+const fallocKeepSize = 0x1
+
+// Allocate grows the file to cover the range, with a hole. There is
+// nothing to reserve in memory, so with FALLOC_FL_KEEP_SIZE it does
+// nothing. MemRegularFile's own version would expose data left past the
+// end by an earlier truncate, and accept modes like punching holes.
+func (f *HelloFile) Allocate(ctx context.Context, fh fs.FileHandle, off uint64, size uint64, mode uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "fallocate", &f.Inode)(&errno)
+	if f.root.readOnly {
+		return syscall.EROFS
+	}
+	if mode&^fallocKeepSize != 0 {
+		return syscall.ENOTSUP
+	}
+	f.data.Lock()
+	defer f.data.Unlock()
+	if b, end := f.extents(), int64(off+size); mode == 0 && end > b.size {
+		b.truncate(end)
+		f.touch()
+	}
+	return 0
+}
+
+func (f *HelloFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeOpener)((*HelloFile)(nil))
+	_ = (fs.NodeStatxer)((*HelloFile)(nil))
+	_ = (fs.NodeReleaser)((*HelloFile)(nil))
+	_ = (fs.NodeReader)((*HelloFile)(nil))
+	_ = (fs.NodeWriter)((*HelloFile)(nil))
+	_ = (fs.NodeGetattrer)((*HelloFile)(nil))
+	_ = (fs.NodeSetattrer)((*HelloFile)(nil))
+	_ = (fs.NodeFlusher)((*HelloFile)(nil))
+	_ = (fs.NodeFsyncer)((*HelloFile)(nil))
+	_ = (fs.NodeAllocater)((*HelloFile)(nil))
+)
+
+func resolveUIDGID(uid int64, gid int64) (uint32, uint32, error) {
+	currentUID, currentGID, err := getCurrentUIDGID()
+	if err != nil {
+		return 0, 0, err
+	}
+	if uid == -1 {
+		uid = int64(currentUID)
+	}
+	if gid == -1 {
+		gid = int64(currentGID)
+	}
+	return uint32(uid), uint32(gid), nil //nolint:gosec
+}
+
+// lookupIDs resolves user and group names to numeric ids. A name wins
+// over a numeric id given for the same field.
+func lookupIDs(userName, groupName string, uid, gid int64) (int64, int64, error) {
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return 0, 0, err
+		}
+		id, err := strconv.ParseInt(u.Uid, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if uid != -1 && uid != id {
+			fmt.Fprintf(os.Stderr, "Warning: -user %s overrides -uid %d\n", userName, uid)
+		}
+		uid = id
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, err
+		}
+		id, err := strconv.ParseInt(g.Gid, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if gid != -1 && gid != id {
+			fmt.Fprintf(os.Stderr, "Warning: -group %s overrides -gid %d\n", groupName, gid)
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
+
+// resolveGids resolves group names or numeric ids to a sorted list.
+// Duplicates are rejected.
+func resolveGids(groups []string) ([]uint32, error) {
+	gids := make([]uint32, 0, len(groups))
+	for _, g := range groups {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			grp, lerr := user.LookupGroup(g)
+			if lerr != nil {
+				return nil, lerr
+			}
+			if id, err = strconv.ParseUint(grp.Gid, 10, 32); err != nil {
+				return nil, err
+			}
+		}
+		if slices.Contains(gids, uint32(id)) {
+			return nil, fmt.Errorf("group %s (%d) given more than once", g, id)
+		}
+		gids = append(gids, uint32(id))
+	}
+	slices.Sort(gids)
+	return gids, nil
+}
+
+func getCurrentUIDGID() (uid, gid uint32, err error) {
+	var currentUser *user.User
+	currentUser, err = user.Current()
+	if err != nil {
+		return
+	}
+	var uidInt, gidInt int
+	uidInt, err = strconv.Atoi(currentUser.Uid)
+	if err != nil {
+		return
+	}
+	gidInt, err = strconv.Atoi(currentUser.Gid)
+	if err != nil {
+		return
+	}
+	return uint32(uidInt), uint32(gidInt), nil //nolint:gosec
+}
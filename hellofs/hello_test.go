@@ -0,0 +1,432 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// skipUnlessMountable skips tests that mount, which needs root for
+// mount(2) and the fuse module.
+func skipUnlessMountable(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("mounting directly needs root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("no /dev/fuse")
+	}
+}
+
+// mountTest mounts cfg.Root on a temporary directory as Run would, with
+// the state and wrappers cfg asks for, and returns the mountpoint. It is
+// unmounted when the test ends. A nil cfg.Options mounts with defaults.
+func mountTest(t *testing.T, cfg Config) string {
+	t.Helper()
+	skipUnlessMountable(t)
+	cfg.Mountpoint = t.TempDir()
+	if cfg.Options == nil {
+		cfg.Options = &fs.Options{}
+	}
+	cfg.Options.DirectMount = true
+	if cfg.Control == nil {
+		cfg.Control = &controlState{}
+	}
+	attachState(&cfg)
+	server, err := mountWithRetry(context.Background(), cfg.Mountpoint, &cfg, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatalf("mount: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := server.Unmount(); err != nil {
+			t.Errorf("unmount: %v", err)
+		}
+	})
+	return cfg.Mountpoint
+}
+
+// mountRoot is mountTest of root with the default settings.
+func mountRoot(t *testing.T, root fs.InodeEmbedder) string {
+	t.Helper()
+	return mountTest(t, Config{Root: root})
+}
+
+// runConfig is a Config for Run serving the two-file tree on dir, with
+// timeouts short enough for tests.
+func runConfig(dir string) Config {
+	return Config{
+		Mountpoint:      dir,
+		Root:            NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}),
+		VerifyFile:      "file.txt",
+		ReadyTimeout:    5 * time.Second,
+		MountTimeout:    10 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+		Options:         &fs.Options{MountOptions: fuse.MountOptions{DirectMount: true}},
+	}
+}
+
+// startRun starts Run on cfg and waits until the mount is ready. The
+// returned stop cancels Run and returns its error.
+func startRun(t *testing.T, cfg Config) (stop func() error) {
+	t.Helper()
+	skipUnlessMountable(t)
+	ready := make(chan struct{})
+	onReady := cfg.OnReady
+	cfg.OnReady = func() error {
+		close(ready)
+		if onReady != nil {
+			return onReady()
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() { ran <- Run(ctx, cfg) }()
+	select {
+	case <-ready:
+	case err := <-ran:
+		cancel()
+		t.Fatalf("Run returned before the mount was ready: %v", err)
+	}
+	var once sync.Once
+	var err error
+	stop = func() error {
+		once.Do(func() {
+			cancel()
+			err = <-ran
+		})
+		return err
+	}
+	t.Cleanup(func() { stop() })
+	return stop
+}
+
+// mountinfo returns the /proc/self/mountinfo fields of the mount on dir,
+// or nil if nothing is mounted there.
+func mountinfo(t *testing.T, dir string) []string {
+	t.Helper()
+	b, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line := range strings.Lines(string(b)) {
+		if f := strings.Fields(line); len(f) > 4 && f[4] == dir {
+			return f
+		}
+	}
+	return nil
+}
+
+// asUser runs op on path in a process of its own with cred, and returns
+// what it printed: the content for "read", the value for "getxattr NAME".
+// The process is a copy of the test binary running TestAsUser, placed
+// where cred can run it, and the temporary directories above path are
+// made searchable for it.
+func asUser(t *testing.T, cred *syscall.Credential, op, path string) (string, error) {
+	t.Helper()
+	b, err := os.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(t.TempDir(), "hellofs.test")
+	if err := os.WriteFile(bin, b, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Dir(bin), filepath.Dir(path)} {
+		for ; strings.HasPrefix(p, os.TempDir()+"/"); p = filepath.Dir(p) {
+			if fi, err := os.Lstat(p); err == nil && !isMountpoint(p, fi) {
+				os.Chmod(p, 0o755)
+			}
+		}
+	}
+	cmd := exec.Command(bin, "-test.run=^TestAsUser$")
+	cmd.Env = append(os.Environ(), "HELLOFS_AS_USER="+op, "HELLOFS_AS_USER_PATH="+path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// isMountpoint reports whether p, described by fi, is the top of a mount,
+// whose mode belongs to the mounted tree.
+func isMountpoint(p string, fi os.FileInfo) bool {
+	parent, err := os.Stat(filepath.Dir(p))
+	return err == nil && parent.Sys().(*syscall.Stat_t).Dev != fi.Sys().(*syscall.Stat_t).Dev
+}
+
+// TestAsUser is the process asUser starts; it does nothing otherwise.
+func TestAsUser(t *testing.T) {
+	op, path := os.Getenv("HELLOFS_AS_USER"), os.Getenv("HELLOFS_AS_USER_PATH")
+	if op == "" {
+		t.Skip("run by asUser")
+	}
+	var out []byte
+	var err error
+	switch name, attr, _ := strings.Cut(op, " "); name {
+	case "read":
+		out, err = os.ReadFile(path)
+	case "getxattr":
+		out = make([]byte, 256)
+		var n int
+		n, err = unix.Getxattr(path, attr, out)
+		out = out[:max(n, 0)]
+	default:
+		err = fmt.Errorf("unknown op %q", op)
+	}
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(out)
+	os.Exit(0)
+}
+
+// TestNullPermissions mounts null.txt, whose mode is 0, with and without
+// NullPermissions, under default_permissions and allow_other: with it,
+// stat reports mode 0 and another user is refused; without it, go-fuse
+// reports 0644 and the file is readable.
+func TestNullPermissions(t *testing.T) {
+	nobody := &syscall.Credential{Uid: 65534, Gid: 65534}
+	tests := []struct {
+		name     string
+		null     bool
+		wantMode os.FileMode
+		wantErr  bool
+	}{
+		{name: "off", wantMode: 0o644},
+		{name: "on", null: true, wantMode: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+			root.nullFile = true
+			dir := mountTest(t, Config{Root: root, Options: &fs.Options{
+				NullPermissions: tt.null,
+				MountOptions:    fuse.MountOptions{AllowOther: true, Options: []string{"default_permissions"}},
+			}})
+			path := filepath.Join(dir, "null.txt")
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != tt.wantMode {
+				t.Errorf("null.txt mode = %v, want %v", fi.Mode().Perm(), tt.wantMode)
+			}
+			out, err := asUser(t, nobody, "read", path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("read as nobody: %q, %v, want an error: %v", out, err, tt.wantErr)
+			}
+			switch {
+			case tt.wantErr && !strings.Contains(out, "permission denied"):
+				t.Errorf("read as nobody: %q, want permission denied", out)
+			case !tt.wantErr && out != "null\n":
+				t.Errorf("read as nobody: %q, want %q", out, "null\n")
+			}
+		})
+	}
+}
+
+func TestResolveGids(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    []uint32
+		wantErr bool
+	}{
+		{name: "none", want: []uint32{}},
+		{name: "sorted", in: []string{"1001", "27", "100"}, want: []uint32{27, 100, 1001}},
+		{name: "name", in: []string{"5", "root"}, want: []uint32{0, 5}},
+		{name: "duplicate", in: []string{"27", "27"}, wantErr: true},
+		{name: "name and id of one group", in: []string{"root", "0"}, wantErr: true},
+		{name: "unknown name", in: []string{"no-such-group-here"}, wantErr: true},
+		{name: "out of range", in: []string{"4294967296"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveGids(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveGids(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveGids(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSupplementaryGids resolves repeated -supplementaryGid flags with
+// buildOptions and mounts with the result under -allowOther: a process of
+// another user with extra groups reads the sorted set from the xattr. A
+// group given twice fails before mounting.
+func TestSupplementaryGids(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "sorted", flags: []string{"300, root", "100"}, want: "0,100,300"},
+		{name: "duplicate", flags: []string{"27", "100,27"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &command{o: flagDefaults()}
+			c.o.AllowOther = true
+			for _, f := range tt.flags {
+				if err := c.o.AddSupplementaryGid(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := c.buildOptions()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildOptions() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+			root.supplementaryGids = c.supplementaryGids
+			dir := mountTest(t, Config{Root: root, Options: c.opts})
+			cred := &syscall.Credential{Uid: 65534, Gid: 65534, Groups: []uint32{100, 300}}
+			out, err := asUser(t, cred, "getxattr "+gidsXattr, filepath.Join(dir, "file.txt"))
+			if err != nil || out != tt.want {
+				t.Errorf("getxattr %s as nobody = %q, %v, want %q", gidsXattr, out, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestHelloFileTruncate resizes file.txt the ways the kernel asks to:
+// growing reads back zeros, and shrinking drops the tail for good.
+func TestHelloFileTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []uint64 // setattr sizes, in order
+		trunc bool     // open with O_TRUNC first
+		want  string
+	}{
+		{name: "grow", sizes: []uint64{9}, want: "hello\n\x00\x00\x00"},
+		{name: "shrink", sizes: []uint64{3}, want: "hel"},
+		{name: "shrink then grow", sizes: []uint64{3, 6}, want: "hel\x00\x00\x00"},
+		{name: "to zero", sizes: []uint64{0}, want: ""},
+		{name: "open O_TRUNC", trunc: true, want: ""},
+		{name: "open O_TRUNC then grow", trunc: true, sizes: []uint64{2}, want: "\x00\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := memTree(t)
+			f := root.GetChild("file.txt").Operations().(*HelloFile)
+			ctx := context.Background()
+			if tt.trunc {
+				if _, _, errno := f.Open(ctx, syscall.O_WRONLY|syscall.O_TRUNC); errno != 0 {
+					t.Fatalf("Open(O_TRUNC) = %v", errno)
+				}
+			}
+			for _, size := range tt.sizes {
+				in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_SIZE, Size: size}}
+				var out fuse.AttrOut
+				if errno := f.Setattr(ctx, nil, in, &out); errno != 0 {
+					t.Fatalf("Setattr(size %d) = %v", size, errno)
+				}
+				if out.Size != size {
+					t.Errorf("Setattr(size %d) reports size %d", size, out.Size)
+				}
+			}
+			if got := string(f.contents()); got != tt.want {
+				t.Errorf("contents = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCachePolicy reads file.txt, changes it behind the kernel's back
+// without changing its size, and reads it again: only "cache" keeps
+// serving the old bytes from the page cache.
+func TestCachePolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"cache", "hello\n"},
+		{"nocache", "HELLO\n"},
+		{"direct", "HELLO\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+			root.cachePolicy = tt.policy
+			hour := time.Hour
+			dir := mountTest(t, Config{Root: root, Options: &fs.Options{AttrTimeout: &hour, EntryTimeout: &hour}})
+			path := filepath.Join(dir, "file.txt")
+			if b, err := os.ReadFile(path); err != nil || string(b) != "hello\n" {
+				t.Fatalf("first read: %q, %v", b, err)
+			}
+			f := root.GetChild("file.txt").Operations().(*HelloFile)
+			f.data.Lock()
+			f.extents().writeAt([]byte("HELLO\n"), 0)
+			f.data.Unlock()
+			if b, err := os.ReadFile(path); err != nil || string(b) != tt.want {
+				t.Errorf("read after the change: %q, %v, want %q", b, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestFallocateReadOnly allocates in a read-only tree. No client gets
+// that far, as opens for writing are refused, so the node is asked.
+func TestFallocateReadOnly(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{ReadOnly: true})
+	fs.NewNodeFS(root, &fs.Options{})
+	f := root.GetChild("file.txt").Operations().(*HelloFile)
+	if errno := f.Allocate(context.Background(), nil, 0, 10, 0); errno != syscall.EROFS {
+		t.Errorf("Allocate() = %v, want EROFS", errno)
+	}
+}
+
+// TestPread reads file.txt with pread at several offsets, with direct I/O
+// so each read reaches the node as asked: a short read at the end, and
+// nothing from the end on.
+func TestPread(t *testing.T) {
+	tests := []struct {
+		name string
+		off  int64
+		n    int
+		want string
+	}{
+		{"start", 0, 4, "0123"},
+		{"middle", 3, 4, "3456"},
+		{"short at the end", 8, 4, "89"},
+		{"at the end", 10, 4, ""},
+		{"past the end", 100, 4, ""},
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("0123456789")}, RootOptions{})
+	root.cachePolicy = "direct"
+	dir := mountRoot(t, root)
+	f, err := os.Open(filepath.Join(dir, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, tt.n)
+			n, err := syscall.Pread(int(f.Fd()), buf, tt.off)
+			if err != nil || string(buf[:n]) != tt.want {
+				t.Errorf("pread(%d bytes at %d) = %q, %v, want %q", tt.n, tt.off, buf[:max(n, 0)], err, tt.want)
+			}
+		})
+	}
+}
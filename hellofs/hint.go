@@ -0,0 +1,49 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// failureHint returns a hint for a mount, or with mounting unset an
+// unmount, that failed with err for a common reason, or "" if there is
+// none to give. The errno is the best clue; the helpers' output is used
+// where they only report an exit status. An unmount error holds every
+// method's failure, so the causes that matter most come first.
+func failureHint(err error, mountpoint string, mounting bool, mo *fuse.MountOptions) string {
+	var pathErr *iofs.PathError
+	noHelper := errors.Is(err, exec.ErrNotFound) || errors.As(err, &pathErr) && strings.Contains(pathErr.Path, "fusermount")
+	msg := err.Error()
+	switch {
+	case errors.Is(err, syscall.ENOTCONN):
+		return staleMountHint(mountpoint)
+	case mounting && errors.Is(err, syscall.EBUSY):
+		return "Hint: is " + mountpoint + " still mounted? try running 'umount " + mountpoint + "'"
+	case errors.Is(err, syscall.EBUSY), strings.Contains(msg, "busy"):
+		return "Hint: files on " + mountpoint + " are still open or a process has its directory there; 'fuser -m " + mountpoint + "' lists them"
+	case noHelper && mounting:
+		return "Hint: fusermount is not installed; install fuse3, or pass -directMount when running as root"
+	case noHelper:
+		return "Hint: fusermount is not installed; install fuse3, or unmount as root"
+	case mounting && (mo.AllowOther || slices.Contains(mo.Options, "allow_root")) && (errors.Is(err, syscall.EPERM) || strings.Contains(msg, "user_allow_other")):
+		return "Hint: unless mounting as root, -allowOther and -allowRoot need user_allow_other in /etc/fuse.conf"
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES):
+		if mounting && mo.DirectMount {
+			return "Hint: -directMount needs root; without it fusermount mounts for other users"
+		}
+		return "Hint: need root, or the user that mounted " + mountpoint
+	case mounting && errors.Is(err, syscall.ENOENT):
+		return "Hint: /dev/fuse is missing; is the fuse module loaded? try 'modprobe fuse'"
+	case errors.Is(err, syscall.EINVAL) && mounting && mo.DirectMount:
+		return "Hint: the kernel refused an option; allow_root, auto_unmount and the atime options only work through fusermount"
+	}
+	return ""
+}
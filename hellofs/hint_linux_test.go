@@ -0,0 +1,63 @@
+package hellofs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// TestFailureHintMount fails a real mount and unmount: the kernel refusing
+// an option under -directMount logs the fusermount hint with the attempt,
+// and unmounting with a file still open returns the fuser hint.
+func TestFailureHintMount(t *testing.T) {
+	skipUnlessMountable(t)
+	t.Run("mount", func(t *testing.T) {
+		var log bytes.Buffer
+		defer func(w io.Writer) { logStderr = w }(logStderr)
+		logStderr = &log
+		dir := t.TempDir()
+		// strict, or go-fuse would retry through the missing fusermount
+		cfg := Config{Root: NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}), Options: &fs.Options{
+			MountOptions: fuse.MountOptions{DirectMount: true, DirectMountStrict: true, Options: []string{"allow_root"}},
+		}}
+		attachState(&cfg)
+		server, err := mountWithRetry(context.Background(), dir, &cfg, time.Now().Add(time.Second))
+		if err == nil {
+			server.Unmount()
+			t.Fatal("mount with allow_root succeeded, want EINVAL")
+		}
+		if !errors.Is(err, syscall.EINVAL) || !strings.Contains(log.String(), "only work through fusermount") {
+			t.Errorf("mount: %v, logged %q, want EINVAL with the fusermount hint", err, log.String())
+		}
+	})
+	t.Run("unmount", func(t *testing.T) {
+		// the server method's EBUSY is hidden behind its fusermount fallback
+		if _, err := exec.LookPath("umount"); err != nil {
+			t.Skip("umount not found")
+		}
+		dir := t.TempDir()
+		cfg := runConfig(dir)
+		cfg.UnmountCmd, cfg.ShutdownTimeout = "umount", time.Second
+		stop := startRun(t, cfg)
+		f, err := os.Open(filepath.Join(dir, "file.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { syscall.Unmount(dir, syscall.MNT_DETACH) })
+		defer f.Close()
+		if err := stop(); err == nil || !strings.Contains(err.Error(), "fuser -m "+dir) {
+			t.Errorf("Run() with a file open = %v, want the fuser hint", err)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestFailureHint(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		mounting bool
+		mo       fuse.MountOptions
+		want     string // a part of the hint, or "" for none
+	}{
+		{name: "stale mount", err: syscall.ENOTCONN, mounting: true, want: "-recoverStaleMount"},
+		{name: "still mounted", err: fmt.Errorf("mount: %w", syscall.EBUSY), mounting: true, want: "still mounted?"},
+		{name: "busy unmount", err: syscall.EBUSY, want: "fuser -m /mnt"},
+		{name: "busy in helper output", err: errors.New("fusermount: failed to unmount /mnt: Device or resource busy"), want: "fuser -m"},
+		{name: "no fusermount", err: &exec.Error{Name: "fusermount3", Err: exec.ErrNotFound}, mounting: true, want: "-directMount"},
+		{name: "no fusermount path", err: &iofs.PathError{Op: "fork/exec", Path: "/bin/fusermount", Err: syscall.ENOENT}, want: "unmount as root"},
+		{name: "allow_other", err: syscall.EPERM, mounting: true, mo: fuse.MountOptions{AllowOther: true}, want: "user_allow_other"},
+		{name: "allow_root", err: errors.New("fusermount: option allow_other only allowed if 'user_allow_other' is set"), mounting: true, mo: fuse.MountOptions{Options: []string{"allow_root"}}, want: "user_allow_other"},
+		{name: "direct mount", err: syscall.EPERM, mounting: true, mo: fuse.MountOptions{DirectMount: true}, want: "-directMount needs root"},
+		{name: "permission", err: syscall.EACCES, want: "need root"},
+		{name: "no /dev/fuse", err: syscall.ENOENT, mounting: true, want: "modprobe fuse"},
+		{name: "kernel option", err: syscall.EINVAL, mounting: true, mo: fuse.MountOptions{DirectMount: true}, want: "only work through fusermount"},
+		{name: "fusermount option", err: syscall.EINVAL, mounting: true},
+		{name: "unknown", err: errors.New("boom"), mounting: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := failureHint(tt.err, "/mnt", tt.mounting, &tt.mo)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("failureHint(%v) = %q, want one with %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// runHook runs command through sh -c with HELLOFUSE_MOUNTPOINT set to
+// mountpoint, for -onReady and -onUnmount, which name the hook. Each line
+// the command prints is logged, stdout as events and stderr as errors.
+func runHook(name, command, mountpoint string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "HELLOFUSE_MOUNTPOINT="+mountpoint)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("-%s: %w", name, err)
+	}
+	var wg sync.WaitGroup
+	logLines := func(r io.Reader, log func(event, text string, attrs ...any)) {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			log("hook_output", fmt.Sprintf("-%s: %s", name, sc.Text()), "hook", name, "mountpoint", mountpoint)
+		}
+	}
+	wg.Go(func() { logLines(stdout, logEvent) })
+	wg.Go(func() { logLines(stderr, logError) })
+	// the pipes must be drained before Wait closes them
+	wg.Wait()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("-%s: %w", name, err)
+	}
+	return nil
+}
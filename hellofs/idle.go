@@ -0,0 +1,66 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// idleTracker follows node operations for -idleTimeout: how many are
+// running, and when the last one finished.
+type idleTracker struct {
+	mu     sync.Mutex
+	active int
+	last   time.Time
+}
+
+func newIdleTracker() *idleTracker {
+	return &idleTracker{last: time.Now()}
+}
+
+func (t *idleTracker) hook(ctx context.Context, op, path string) func(opResult) {
+	t.mu.Lock()
+	t.active++
+	t.mu.Unlock()
+	return func(opResult) {
+		t.mu.Lock()
+		t.active--
+		t.last = time.Now()
+		t.mu.Unlock()
+	}
+}
+
+// remaining returns how much longer the mount has to stay idle for
+// timeout to pass; an op still running keeps the whole timeout ahead.
+func (t *idleTracker) remaining(timeout time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active > 0 {
+		return timeout
+	}
+	return timeout - time.Since(t.last)
+}
+
+// watch calls shutdown once no operation ran for timeout, unless ctx is
+// cancelled first.
+func (t *idleTracker) watch(ctx context.Context, timeout time.Duration, shutdown func()) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+		if d := t.remaining(timeout); d > 0 {
+			timer.Reset(d)
+			continue
+		}
+		logEvent("idle_timeout", fmt.Sprintf("Unmounting after idle timeout of %v", timeout), "timeout", timeout.String())
+		shutdown()
+		return
+	}
+}
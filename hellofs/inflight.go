@@ -0,0 +1,131 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inFlight tracks the node operations in progress on a mount, for
+// /status and -statusInterval: a request that never returns shows up
+// there, as does whatever keeps an unmount busy. Operations go-fuse
+// answers itself, like forget, aren't seen.
+type inFlight struct {
+	mu  sync.Mutex
+	ops map[*flightOp]struct{}
+}
+
+type flightOp struct {
+	op, path string
+	start    time.Time
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{ops: map[*flightOp]struct{}{}}
+}
+
+func (t *inFlight) hook(ctx context.Context, op, path string) func(opResult) {
+	o := &flightOp{op: op, path: path, start: time.Now()}
+	t.mu.Lock()
+	t.ops[o] = struct{}{}
+	t.mu.Unlock()
+	return func(opResult) {
+		t.mu.Lock()
+		delete(t.ops, o)
+		t.mu.Unlock()
+	}
+}
+
+// snapshot returns how many operations of each kind are in progress, and
+// the one that has been running longest, nil if none is.
+func (t *inFlight) snapshot() (byOp map[string]int, oldest *flightOp) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byOp = map[string]int{}
+	for o := range t.ops {
+		byOp[o.op]++
+		if oldest == nil || o.start.Before(oldest.start) {
+			oldest = o
+		}
+	}
+	return byOp, oldest
+}
+
+// requestStatus adds the operations in progress and, with -lingerOnSignal,
+// the open handles and drain state to status.
+func (c *controlState) requestStatus(status map[string]any) {
+	if c.requests != nil {
+		byOp, oldest := c.requests.snapshot()
+		total := 0
+		for _, n := range byOp {
+			total += n
+		}
+		status["activeRequests"] = total
+		status["activeByOp"] = byOp
+		if oldest != nil {
+			status["oldestRequest"] = map[string]any{
+				"op":   oldest.op,
+				"path": oldest.path,
+				"for":  time.Since(oldest.start).Round(time.Millisecond).String(),
+			}
+		}
+	}
+	if c.drain != nil {
+		status["openHandles"] = c.drain.openHandles.Load()
+		status["draining"] = c.drain.draining.Load()
+	}
+}
+
+// logStatus logs a line for each mount every interval until ctx is
+// cancelled.
+func logStatus(ctx context.Context, cs controlSet, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, c := range cs {
+			logEvent("status", c.statusLine(), "mountpoint", c.mountpoint)
+		}
+	}
+}
+
+// statusLine describes the mount in a line for -statusInterval.
+func (c *controlState) statusLine() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Status of %s: ", c.mountpoint)
+	if at := c.mountedAt.Load(); at != 0 && c.serving.Load() {
+		fmt.Fprintf(&b, "mounted for %v", time.Since(time.Unix(0, at)).Round(time.Second))
+	} else {
+		b.WriteString("not mounted")
+	}
+	if c.requests != nil {
+		byOp, oldest := c.requests.snapshot()
+		var parts []string
+		total := 0
+		for _, op := range slices.Sorted(maps.Keys(byOp)) {
+			parts = append(parts, fmt.Sprintf("%s %d", op, byOp[op]))
+			total += byOp[op]
+		}
+		fmt.Fprintf(&b, "; %d requests in progress", total)
+		if oldest != nil {
+			fmt.Fprintf(&b, " (%s), oldest %s /%s for %v", strings.Join(parts, ", "), oldest.op, strings.TrimPrefix(oldest.path, "/"), time.Since(oldest.start).Round(time.Millisecond))
+		}
+	}
+	if c.drain != nil {
+		fmt.Fprintf(&b, "; %d open handles", c.drain.openHandles.Load())
+		if c.drain.draining.Load() {
+			b.WriteString(", draining")
+		}
+	}
+	return b.String()
+}
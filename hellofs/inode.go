@@ -0,0 +1,81 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// defaultFirstIno is where inoAllocator starts without -firstAutomaticIno,
+// well clear of the fixed inodes and the -syntheticEntries range. go-fuse
+// numbers the remaining nodes from 1<<63 up.
+const defaultFirstIno = 1 << 48
+
+// lastFixedIno is the highest fixed inode number: 1 is the root and 2
+// file.txt. The allocator never hands these out.
+const lastFixedIno = 2
+
+// inoAllocator hands out inode numbers for files and directories created
+// through the mount or from a manifest or spec, and for -tmpDir and -casDir
+// files. With reuse enabled, numbers released after their inode was
+// forgotten are handed out again, lowest first, like many disk
+// filesystems do.
+type inoAllocator struct {
+	mu    sync.Mutex
+	next  uint64
+	reuse bool
+	free  []uint64
+}
+
+// newInoAllocator returns an allocator starting at first, or at
+// defaultFirstIno if first is 0.
+func newInoAllocator(first uint64, reuse bool) *inoAllocator {
+	if first == 0 {
+		first = defaultFirstIno
+	}
+	return &inoAllocator{next: max(first, lastFixedIno+1), reuse: reuse}
+}
+
+func (a *inoAllocator) alloc() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.free) > 0 {
+		ino := a.free[0]
+		a.free = a.free[1:]
+		return ino
+	}
+	ino := a.next
+	a.next++
+	return ino
+}
+
+// release returns ino to the allocator. It must only be called once the
+// kernel no longer references the inode.
+func (a *inoAllocator) release(ino uint64) {
+	if !a.reuse || ino <= lastFixedIno {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i, _ := slices.BinarySearch(a.free, ino)
+	a.free = slices.Insert(a.free, i, ino)
+}
+
+// checkFirstIno reports whether the allocator can start at first without
+// running into the fixed inodes, the -syntheticEntries files or the
+// numbers go-fuse hands out itself.
+func checkFirstIno(first uint64, syntheticEntries int) error {
+	switch {
+	case first == 0:
+		return nil
+	case first <= lastFixedIno:
+		return fmt.Errorf("-firstAutomaticIno %d: 1 to %d are reserved for the root and file.txt", first, lastFixedIno)
+	case first >= 1<<63:
+		return fmt.Errorf("-firstAutomaticIno %d: must be below %d, where go-fuse numbers its own nodes", first, uint64(1)<<63)
+	case first >= syntheticIno && first <= syntheticIno+uint64(syntheticEntries):
+		return fmt.Errorf("-firstAutomaticIno %d: %d to %d are used by -syntheticEntries", first, uint64(syntheticIno), syntheticIno+uint64(syntheticEntries))
+	}
+	return nil
+}
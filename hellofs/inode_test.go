@@ -0,0 +1,97 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestInodeNumbers creates files and directories through a mount whose
+// allocator starts at 1000: every inode number must be unique, and all
+// but the fixed ones at or above 1000.
+func TestInodeNumbers(t *testing.T) {
+	const first = 1000
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n"), "a.txt": nil, "b.txt": nil}, RootOptions{})
+	root.inos = newInoAllocator(first, false)
+	dir := mountRoot(t, root)
+	for _, d := range []string{"d", "d/e"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"c.txt", "d/f.txt", "d/e/g.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[uint64]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		ino := fi.Sys().(*syscall.Stat_t).Ino
+		rel, _ := filepath.Rel(dir, path)
+		if other, ok := seen[ino]; ok {
+			t.Errorf("%s and %s share inode %d", other, rel, ino)
+		}
+		seen[ino] = rel
+		if ino > lastFixedIno && ino < first {
+			t.Errorf("%s has inode %d, below the first automatic one, %d", rel, ino, first)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 9 {
+		t.Errorf("walked %d entries, want 9: %v", len(seen), seen)
+	}
+}
+
+func TestInoAllocatorReuse(t *testing.T) {
+	a := newInoAllocator(10, true)
+	for want := uint64(10); want < 13; want++ {
+		if got := a.alloc(); got != want {
+			t.Fatalf("alloc() = %d, want %d", got, want)
+		}
+	}
+	a.release(11)
+	a.release(10)
+	a.release(2) // fixed, never handed out
+	for _, want := range []uint64{10, 11, 13} {
+		if got := a.alloc(); got != want {
+			t.Errorf("alloc() after releases = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestCheckFirstIno(t *testing.T) {
+	tests := []struct {
+		name             string
+		first            uint64
+		syntheticEntries int
+		wantErr          bool
+	}{
+		{name: "default", first: 0},
+		{name: "low", first: 3},
+		{name: "fixed", first: 2, wantErr: true},
+		{name: "go-fuse range", first: 1 << 63, wantErr: true},
+		{name: "synthetic range", first: syntheticIno + 5, syntheticEntries: 10, wantErr: true},
+		{name: "past synthetic range", first: syntheticIno + 11, syntheticEntries: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkFirstIno(tt.first, tt.syntheticEntries); (err != nil) != tt.wantErr {
+				t.Errorf("checkFirstIno(%d, %d) = %v, wantErr %v", tt.first, tt.syntheticEntries, err, tt.wantErr)
+			}
+		})
+	}
+}
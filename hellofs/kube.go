@@ -0,0 +1,264 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// kubeDataLink is the symlink that points at the current version of the
+// data, as in a Kubernetes ConfigMap or Secret volume.
+const kubeDataLink = "..data"
+
+// kubeObject is the part of a ConfigMap or Secret manifest that makes up
+// the volume content.
+type kubeObject struct {
+	Kind       string            `json:"kind"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string]string `json:"binaryData"`
+	StringData map[string]string `json:"stringData"`
+}
+
+// loadKubeObject reads a ConfigMap or Secret in JSON form. Secret data and
+// ConfigMap binaryData are base64 encoded.
+func loadKubeObject(path string, secret bool) (map[string][]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var obj kubeObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	want := "ConfigMap"
+	if secret {
+		want = "Secret"
+	}
+	if obj.Kind != "" && obj.Kind != want {
+		return nil, fmt.Errorf("%s: kind is %s, want %s", path, obj.Kind, want)
+	}
+	plain, encoded := obj.Data, obj.BinaryData
+	if secret {
+		plain, encoded = obj.StringData, obj.Data
+	}
+	files := map[string][]byte{}
+	for key, val := range encoded {
+		b, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, key, err)
+		}
+		files[key] = b
+	}
+	for key, val := range plain {
+		if _, ok := files[key]; ok {
+			return nil, fmt.Errorf("%s: duplicate key %q", path, key)
+		}
+		files[key] = []byte(val)
+	}
+	for key := range files {
+		if !validName(key) || strings.HasPrefix(key, "..") {
+			return nil, fmt.Errorf("%s: invalid key %q", path, key)
+		}
+	}
+	return files, nil
+}
+
+// KubeDir mirrors the keys of a ConfigMap or Secret the way the kubelet
+// lays out its volumes: the content lives in a hidden, timestamped
+// directory, ..data links to it, and each key is a symlink into ..data.
+// An update writes a new directory and then swaps the ..data link, so a
+// reader sees either all of the old keys or all of the new ones.
+type KubeDir struct {
+	fs.Inode
+	birth
+
+	path    string
+	secret  bool
+	files   map[string][]byte // initial content, applied in OnAdd
+	version string            // name of the current data directory
+}
+
+func openKubeDir(path string, secret bool) (*KubeDir, error) {
+	files, err := loadKubeObject(path, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &KubeDir{birth: born(), path: path, secret: secret, files: files}, nil
+}
+
+func (d *KubeDir) OnAdd(ctx context.Context) {
+	d.apply(ctx, d.files, false)
+	d.files = nil
+}
+
+// apply publishes files as a new version. If notify is set, the kernel is
+// told about changed entries; this must only be done once the server is
+// running.
+func (d *KubeDir) apply(ctx context.Context, files map[string][]byte, notify bool) {
+	mode := uint32(0444)
+	if d.secret {
+		mode = 0400
+	}
+	version := ".." + time.Now().Format("2006_01_02_15_04_05.000000000")
+	data := d.NewPersistentInode(ctx, &KubeDataDir{birth: born()}, fs.StableAttr{Mode: syscall.S_IFDIR})
+	for name, content := range files {
+		f := &StaticFile{birth: born(), data: content, mode: mode}
+		data.AddChild(name, data.NewPersistentInode(ctx, f, fs.StableAttr{}), false)
+	}
+	d.AddChild(version, data, false)
+
+	// the swap: replacing the link is a single tree update
+	if old := d.GetChild(kubeDataLink); old != nil {
+		old.ForgetPersistent()
+	}
+	link := &Symlink{birth: born(), target: []byte(version)}
+	d.AddChild(kubeDataLink, d.NewPersistentInode(ctx, link, fs.StableAttr{Mode: syscall.S_IFLNK}), true)
+	if notify {
+		d.NotifyEntry(kubeDataLink)
+	}
+
+	for name, ch := range d.Children() {
+		if strings.HasPrefix(name, "..") {
+			continue
+		}
+		if _, ok := files[name]; !ok {
+			ch.ForgetPersistent()
+			d.RmChild(name)
+			if notify {
+				d.NotifyDelete(name, ch)
+			}
+		}
+	}
+	for name := range files {
+		if d.GetChild(name) != nil {
+			continue
+		}
+		link := &Symlink{birth: born(), target: []byte(kubeDataLink + "/" + name)}
+		d.AddChild(name, d.NewPersistentInode(ctx, link, fs.StableAttr{Mode: syscall.S_IFLNK}), false)
+		if notify {
+			d.NotifyEntry(name)
+		}
+	}
+
+	if old := d.GetChild(d.version); old != nil {
+		// handles on keys that are gone go stale; the others keep
+		// reading the version they opened
+		for name, ch := range old.Children() {
+			if _, ok := files[name]; !ok {
+				ch.Operations().(*StaticFile).markStale()
+				if notify {
+					ch.NotifyContent(0, 0)
+				}
+			}
+			ch.ForgetPersistent()
+		}
+		old.ForgetPersistent()
+		d.RmChild(d.version)
+		if notify {
+			d.NotifyDelete(d.version, old)
+		}
+	}
+	d.version = version
+}
+
+// reload re-reads the manifest and publishes it if it changed. On error
+// the previous content is left in place.
+func (d *KubeDir) reload(ctx context.Context) error {
+	files, err := loadKubeObject(d.path, d.secret)
+	if err != nil {
+		return fmt.Errorf("kube reload: %w", err)
+	}
+	current := map[string][]byte{}
+	if data := d.GetChild(d.version); data != nil {
+		for name, ch := range data.Children() {
+			current[name] = ch.Operations().(*StaticFile).content()
+		}
+	}
+	if maps.EqualFunc(files, current, func(a, b []byte) bool { return string(a) == string(b) }) {
+		return nil
+	}
+	d.apply(ctx, files, true)
+	return nil
+}
+
+func (d *KubeDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0555
+	return 0
+}
+
+func (d *KubeDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*KubeDir)(nil))
+	_ = (fs.NodeOnAdder)((*KubeDir)(nil))
+	_ = (fs.NodeGetattrer)((*KubeDir)(nil))
+)
+
+// KubeDataDir is one version of the data. Its content never changes.
+type KubeDataDir struct {
+	fs.Inode
+	birth
+}
+
+func (d *KubeDataDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0555
+	return 0
+}
+
+func (d *KubeDataDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*KubeDataDir)(nil))
+	_ = (fs.NodeGetattrer)((*KubeDataDir)(nil))
+)
+
+// Symlink is a symlink with a fixed target.
+type Symlink struct {
+	fs.Inode
+	birth
+	cacheTimeouts
+
+	target []byte
+	owner  fuse.Owner
+}
+
+func (l *Symlink) Readlink(ctx context.Context) (target []byte, errno syscall.Errno) {
+	defer startOp(ctx, "readlink", &l.Inode)(&errno)
+	return l.target, 0
+}
+
+func (l *Symlink) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &l.Inode)(out, &errno)
+	out.Mode = 0777
+	out.Size = uint64(len(l.target))
+	out.Owner = l.owner
+	l.setAttrTimeout(out)
+	return 0
+}
+
+func (l *Symlink) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, l, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*Symlink)(nil))
+	_ = (fs.NodeReadlinker)((*Symlink)(nil))
+	_ = (fs.NodeGetattrer)((*Symlink)(nil))
+)
@@ -0,0 +1,117 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// z99 is the 99th percentile of the standard normal distribution.
+const z99 = 2.3263478740408408
+
+// latencyDist samples the delay injected before an operation.
+type latencyDist func() time.Duration
+
+// latencyModel maps operation names to the distribution their delay is
+// drawn from. The entry for "" applies to operations without their own.
+type latencyModel map[string]latencyDist
+
+// parseLatencyModel parses a semicolon separated list of
+// [op=]kind:params entries, for example
+//
+//	lognormal:mean=5ms,p99=50ms;read=uniform:min=1ms,max=3ms
+//
+// Supported kinds are constant:DURATION, uniform:min=D,max=D and
+// lognormal:mean=D,p99=D.
+func parseLatencyModel(s string) (latencyModel, error) {
+	m := latencyModel{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var op string
+		if i := strings.Index(entry, "="); i >= 0 && i < strings.Index(entry, ":") {
+			op, entry = entry[:i], entry[i+1:]
+		}
+		kind, params, _ := strings.Cut(entry, ":")
+		dist, err := parseLatencyDist(kind, params)
+		if err != nil {
+			return nil, fmt.Errorf("latency model %q: %w", entry, err)
+		}
+		if _, ok := m[op]; ok {
+			return nil, fmt.Errorf("latency model: duplicate entry for %q", op)
+		}
+		m[op] = dist
+	}
+	return m, nil
+}
+
+func parseLatencyDist(kind, params string) (latencyDist, error) {
+	if kind == "constant" {
+		d, err := time.ParseDuration(params)
+		if err != nil {
+			return nil, err
+		}
+		return func() time.Duration { return d }, nil
+	}
+	args := map[string]time.Duration{}
+	for _, kv := range strings.Split(params, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=duration, got %q", kv)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		args[k] = d
+	}
+	switch kind {
+	case "uniform":
+		lo, hi := args["min"], args["max"]
+		if hi < lo {
+			return nil, fmt.Errorf("max %v is below min %v", hi, lo)
+		}
+		return func() time.Duration { return lo + time.Duration(rand.Int64N(int64(hi-lo)+1)) }, nil
+	case "lognormal":
+		mean, p99 := args["mean"], args["p99"]
+		if mean <= 0 || p99 <= mean {
+			return nil, fmt.Errorf("need 0 < mean < p99")
+		}
+		// mean = exp(mu + sigma^2/2) and p99 = exp(mu + z99*sigma); solve
+		// for sigma, taking the root with the lighter tail
+		r := math.Log(float64(p99) / float64(mean))
+		disc := z99*z99 - 2*r
+		if disc < 0 {
+			return nil, fmt.Errorf("p99 can be at most %.1fx the mean", math.Exp(z99*z99/2))
+		}
+		sigma := z99 - math.Sqrt(disc)
+		mu := math.Log(float64(mean)) - sigma*sigma/2
+		return func() time.Duration { return time.Duration(math.Exp(mu + sigma*rand.NormFloat64())) }, nil
+	}
+	return nil, fmt.Errorf("unknown distribution %q", kind)
+}
+
+// hook delays each operation by a sample from its distribution. The delay
+// ends early if the request is interrupted.
+func (m latencyModel) hook(ctx context.Context, op, path string) func(opResult) {
+	dist, ok := m[op]
+	if !ok {
+		dist, ok = m[""]
+	}
+	if ok {
+		t := time.NewTimer(dist())
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	return func(opResult) {}
+}
@@ -0,0 +1,127 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestParseLatencyModel(t *testing.T) {
+	type bounds struct{ lo, hi time.Duration }
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]bounds // the range each op's samples fall in
+		wantErr bool
+	}{
+		{name: "empty", in: " ; ", want: map[string]bounds{}},
+		{name: "constant", in: "constant:2ms", want: map[string]bounds{"": {2 * time.Millisecond, 2 * time.Millisecond}}},
+		{
+			name: "per op",
+			in:   "constant:1ms; read=uniform:min=3ms,max=4ms;write=constant:0s",
+			want: map[string]bounds{
+				"":      {time.Millisecond, time.Millisecond},
+				"read":  {3 * time.Millisecond, 4 * time.Millisecond},
+				"write": {0, 0},
+			},
+		},
+		{name: "lognormal", in: "lognormal:mean=5ms,p99=50ms", want: map[string]bounds{"": {1, time.Hour}}},
+		{name: "unknown kind", in: "normal:mean=1ms", wantErr: true},
+		{name: "bad constant", in: "constant:fast", wantErr: true},
+		{name: "bad param", in: "uniform:min=1ms,max", wantErr: true},
+		{name: "max below min", in: "uniform:min=2ms,max=1ms", wantErr: true},
+		{name: "p99 below mean", in: "lognormal:mean=5ms,p99=4ms", wantErr: true},
+		{name: "p99 too far out", in: "lognormal:mean=1ms,p99=1s", wantErr: true},
+		{name: "duplicate op", in: "read=constant:1ms;read=constant:2ms", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseLatencyModel(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLatencyModel(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(m) != len(tt.want) {
+				t.Fatalf("parseLatencyModel(%q) has %d entries, want %d", tt.in, len(m), len(tt.want))
+			}
+			for op, b := range tt.want {
+				dist, ok := m[op]
+				if !ok {
+					t.Fatalf("parseLatencyModel(%q) has no entry for %q", tt.in, op)
+				}
+				for range 1000 {
+					if d := dist(); d < b.lo || d > b.hi {
+						t.Fatalf("%q sample %v outside [%v, %v]", op, d, b.lo, b.hi)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLatencyLognormalShape(t *testing.T) {
+	m, err := parseLatencyModel("lognormal:mean=5ms,p99=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([]time.Duration, 100000)
+	var sum time.Duration
+	for i := range samples {
+		samples[i] = m[""]()
+		sum += samples[i]
+	}
+	slices.Sort(samples)
+	mean := sum / time.Duration(len(samples))
+	p99 := samples[len(samples)*99/100]
+	if math.Abs(float64(mean-5*time.Millisecond)) > 0.15*float64(5*time.Millisecond) {
+		t.Errorf("mean = %v, want about 5ms", mean)
+	}
+	if math.Abs(float64(p99-50*time.Millisecond)) > 0.15*float64(50*time.Millisecond) {
+		t.Errorf("p99 = %v, want about 50ms", p99)
+	}
+}
+
+// TestLatencyModelMount stats file.txt through a mount whose getattrs are
+// delayed by a -latencyModel, with attributes never cached so each stat
+// waits for one: the latencies seen have about the configured mean and
+// p99.
+func TestLatencyModelMount(t *testing.T) {
+	m, err := parseLatencyModel("getattr=lognormal:mean=2ms,p99=10ms;constant:0s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := mountTest(t, Config{
+		Root:    NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}),
+		Options: &fs.Options{AttrTimeout: fuseTimeout(0, time.Second), EntryTimeout: fuseTimeout(-1, time.Second)},
+		hooks:   []opHook{m.hook},
+	})
+	path := filepath.Join(dir, "file.txt")
+	samples := make([]time.Duration, 500)
+	var sum time.Duration
+	for i := range samples {
+		start := time.Now()
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+		samples[i] = time.Since(start)
+		sum += samples[i]
+	}
+	slices.Sort(samples)
+	// loose: the round trip adds to each, and 500 samples give a rough p99
+	mean, p99 := sum/time.Duration(len(samples)), samples[len(samples)*99/100]
+	if mean < 1500*time.Microsecond || mean > 3500*time.Microsecond {
+		t.Errorf("mean = %v, want about 2ms", mean)
+	}
+	if p99 < 5*time.Millisecond || p99 > 20*time.Millisecond {
+		t.Errorf("p99 = %v, want about 10ms", p99)
+	}
+}
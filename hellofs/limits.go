@@ -0,0 +1,103 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/time/rate"
+)
+
+// dirFull reports the errno for creating another entry in d if it is at
+// the Config.MaxDirEntries of its mount, like a filesystem with a fixed directory size: ENOSPC for
+// files, EMLINK for subdirectories.
+func dirFull(d *fs.Inode, isDir bool) syscall.Errno {
+	if limit := stateOf(d).maxDirEntries; limit <= 0 || len(d.Children()) < limit {
+		return 0
+	}
+	if isDir {
+		return syscall.EMLINK
+	}
+	return syscall.ENOSPC
+}
+
+// handleLimiter refuses opens and creates with EMFILE while max handles
+// are open, for Config.MaxOpenFiles. It sits below the node API, so every node type is
+// covered whether or not it implements Release.
+type handleLimiter struct {
+	fuse.RawFileSystem
+
+	max  int64
+	open atomic.Int64
+}
+
+// acquire takes a handle, reporting false if none is left.
+func (l *handleLimiter) acquire() bool {
+	if l.open.Add(1) > l.max {
+		l.open.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (l *handleLimiter) Open(cancel <-chan struct{}, in *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	if !l.acquire() {
+		return fuse.Status(syscall.EMFILE)
+	}
+	st := l.RawFileSystem.Open(cancel, in, out)
+	if !st.Ok() {
+		l.open.Add(-1)
+	}
+	return st
+}
+
+func (l *handleLimiter) Create(cancel <-chan struct{}, in *fuse.CreateIn, name string, out *fuse.CreateOut) fuse.Status {
+	if !l.acquire() {
+		return fuse.Status(syscall.EMFILE)
+	}
+	st := l.RawFileSystem.Create(cancel, in, name, out)
+	if !st.Ok() {
+		l.open.Add(-1)
+	}
+	return st
+}
+
+func (l *handleLimiter) Release(cancel <-chan struct{}, in *fuse.ReleaseIn) {
+	l.RawFileSystem.Release(cancel, in)
+	l.open.Add(-1)
+}
+
+// newReadLimiter returns the token bucket for -readBps, or nil for no
+// limit. Its burst is a tenth of a second's worth, so throughput is close
+// to bps from the first read on rather than after an initial free second.
+func newReadLimiter(bps int) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bps), max(bps/10, 1))
+}
+
+// throttleRead waits until n bytes may be read under -readBps, in bursts
+// at most the limiter's size. A client giving up cancels ctx, and gets
+// EINTR, as do reads still waiting at shutdown.
+func (r *HelloRoot) throttleRead(ctx context.Context, n int) syscall.Errno {
+	lim := r.readLimit
+	if lim == nil {
+		return 0
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(r.done, cancel)()
+	for n > 0 {
+		k := min(n, lim.Burst())
+		if lim.WaitN(ctx, k) != nil {
+			return syscall.EINTR
+		}
+		n -= k
+	}
+	return 0
+}
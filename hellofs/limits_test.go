@@ -0,0 +1,113 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMaxOpenFiles opens handles up to MaxOpenFiles: the next open gets
+// EMFILE, and once one is closed a create gets the freed handle.
+func TestMaxOpenFiles(t *testing.T) {
+	const limit = 3
+	dir := mountTest(t, Config{Root: NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}), MaxOpenFiles: limit})
+	path := filepath.Join(dir, "file.txt")
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for range limit {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	if f, err := os.Open(path); !errors.Is(err, syscall.EMFILE) {
+		f.Close()
+		t.Fatalf("open %d of %d: error = %v, want EMFILE", limit+1, limit, err)
+	}
+	files[0].Close()
+	files = files[1:]
+	// the kernel releases a closed handle in the background
+	var f *os.File
+	var err error
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if f, err = os.Create(filepath.Join(dir, "new.txt")); !errors.Is(err, syscall.EMFILE) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("create after a close: %v", err)
+	}
+	files = append(files, f)
+}
+
+// TestMaxDirEntries fills the root up to MaxDirEntries: the next file gets
+// ENOSPC and the next directory EMLINK, while the full root still lists
+// and a new subdirectory has room of its own.
+func TestMaxDirEntries(t *testing.T) {
+	const limit = 3
+	dir := mountTest(t, Config{Root: NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}), MaxDirEntries: limit})
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644); err != nil {
+		t.Fatalf("create entry 2 of %d: %v", limit, err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir entry 3 of %d: %v", limit, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), nil, 0o644); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("create entry %d of %d: error = %v, want ENOSPC", limit+1, limit, err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub2"), 0o755); !errors.Is(err, syscall.EMLINK) {
+		t.Errorf("mkdir entry %d of %d: error = %v, want EMLINK", limit+1, limit, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != limit {
+		t.Errorf("listing the full root: %d entries, %v, want %d", len(entries), err, limit)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "c.txt"), nil, 0o644); err != nil {
+		t.Errorf("create in the new subdirectory: %v", err)
+	}
+}
+
+// TestReadBps reads a file at a low -readBps: after the first burst, the
+// bytes come at the set rate.
+func TestReadBps(t *testing.T) {
+	const bps, size = 100_000, 50_000
+	root := NewRoot(map[string][]byte{"file.txt": make([]byte, size)}, RootOptions{})
+	root.readLimit = newReadLimiter(bps)
+	dir := mountRoot(t, root)
+	start := time.Now()
+	b, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+	if err != nil || len(b) != size {
+		t.Fatalf("read %d bytes, %v, want %d", len(b), err, size)
+	}
+	want := time.Duration(size-root.readLimit.Burst()) * time.Second / bps
+	if took := time.Since(start); took < want*9/10 || took > want*3 {
+		t.Errorf("reading %d bytes at %d bytes/s took %v, want about %v", size, bps, took, want)
+	}
+}
+
+// TestReadBpsCancel gives up on a throttled read: it must return EINTR at
+// once rather than wait for its tokens.
+func TestReadBpsCancel(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.readLimit = newReadLimiter(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if errno := root.throttleRead(ctx, 1000); errno != syscall.EINTR {
+		t.Errorf("throttleRead() = %v, want EINTR", errno)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("cancelled read returned after %v", took)
+	}
+}
@@ -0,0 +1,190 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"math"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// FUSE lock and release flags, which go-fuse doesn't name
+const (
+	fuseLkFlock            = 1 << 0 // the lock request comes from flock(2)
+	fuseReleaseFlockUnlock = 1 << 1 // drop the handle's flock locks
+)
+
+// fileLock is a lock held by owner: the process, for fcntl locks, or the
+// open file, for flock ones. The range is inclusive.
+type fileLock struct {
+	owner uint64
+	flock bool
+	lk    fuse.FileLock
+}
+
+// lockTable is a file's byte-range locks. fcntl and flock locks don't
+// conflict with each other, as on Linux.
+type lockTable struct {
+	mu      sync.Mutex
+	locks   []fileLock
+	changed chan struct{} // closed and replaced when locks are set or dropped
+}
+
+func overlaps(a, b *fuse.FileLock) bool {
+	return a.Start <= b.End && b.Start <= a.End
+}
+
+// conflict returns a lock held by someone else that keeps lk from being
+// taken. Called with mu held.
+func (t *lockTable) conflict(owner uint64, flock bool, lk *fuse.FileLock) *fileLock {
+	for i := range t.locks {
+		l := &t.locks[i]
+		if l.owner == owner || l.flock != flock || !overlaps(&l.lk, lk) {
+			continue
+		}
+		if l.lk.Typ == syscall.F_WRLCK || lk.Typ == syscall.F_WRLCK {
+			return l
+		}
+	}
+	return nil
+}
+
+// set replaces the owner's locks in lk's range with lk, or drops them for
+// F_UNLCK, splitting locks that stick out of the range. Called with mu
+// held.
+func (t *lockTable) set(owner uint64, flock bool, lk *fuse.FileLock) {
+	kept := t.locks[:0:0]
+	for _, l := range t.locks {
+		if l.owner != owner || l.flock != flock || !overlaps(&l.lk, lk) {
+			kept = append(kept, l)
+			continue
+		}
+		if l.lk.Start < lk.Start {
+			head := l
+			head.lk.End = lk.Start - 1
+			kept = append(kept, head)
+		}
+		if l.lk.End > lk.End {
+			tail := l
+			tail.lk.Start = lk.End + 1
+			kept = append(kept, tail)
+		}
+	}
+	if lk.Typ != syscall.F_UNLCK {
+		kept = append(kept, fileLock{owner: owner, flock: flock, lk: *lk})
+	}
+	t.locks = kept
+	if t.changed != nil {
+		close(t.changed)
+		t.changed = nil
+	}
+}
+
+func (t *lockTable) getlk(owner uint64, flags uint32, lk *fuse.FileLock, out *fuse.FileLock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.conflict(owner, flags&fuseLkFlock != 0, lk); c != nil {
+		*out = c.lk
+		return
+	}
+	*out = *lk
+	out.Typ = syscall.F_UNLCK
+}
+
+// setlk takes or drops a lock, failing with EAGAIN on a conflict unless
+// wait is set. Waiting ends with EINTR when the request is interrupted.
+func (t *lockTable) setlk(ctx context.Context, owner uint64, flags uint32, lk *fuse.FileLock, wait bool) syscall.Errno {
+	flock := flags&fuseLkFlock != 0
+	for {
+		t.mu.Lock()
+		if lk.Typ == syscall.F_UNLCK || t.conflict(owner, flock, lk) == nil {
+			t.set(owner, flock, lk)
+			t.mu.Unlock()
+			return 0
+		}
+		if !wait {
+			t.mu.Unlock()
+			return syscall.EAGAIN
+		}
+		if t.changed == nil {
+			t.changed = make(chan struct{})
+		}
+		changed := t.changed
+		t.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return syscall.EINTR
+		}
+	}
+}
+
+// Getlk, Setlk and Setlkw keep the locks in memory. The kernel only sends
+// them with -enableLocks; without it it keeps the locks itself.
+func (f *HelloFile) Getlk(ctx context.Context, fh fs.FileHandle, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (errno syscall.Errno) {
+	defer startOp(ctx, "getlk", &f.Inode)(&errno)
+	if !f.root.enableLocks {
+		return syscall.ENOSYS
+	}
+	f.locks.getlk(owner, flags, lk, out)
+	return 0
+}
+
+func (f *HelloFile) Setlk(ctx context.Context, fh fs.FileHandle, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "setlk", &f.Inode)(&errno)
+	if !f.root.enableLocks {
+		return syscall.ENOSYS
+	}
+	return f.locks.setlk(ctx, owner, flags, lk, false)
+}
+
+func (f *HelloFile) Setlkw(ctx context.Context, fh fs.FileHandle, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "setlkw", &f.Inode)(&errno)
+	if !f.root.enableLocks {
+		return syscall.ENOSYS
+	}
+	return f.locks.setlk(ctx, owner, flags, lk, true)
+}
+
+var (
+	_ = (fs.NodeGetlker)((*HelloFile)(nil))
+	_ = (fs.NodeSetlker)((*HelloFile)(nil))
+	_ = (fs.NodeSetlkwer)((*HelloFile)(nil))
+)
+
+// lockReleaser drops the locks of a closing owner. The kernel leaves that
+// to the filesystem: fcntl locks go with the flush of any of the owner's
+// descriptors, flock ones with the release of the open file. go-fuse
+// doesn't pass the owner on to Flush and Release, so it is turned into an
+// unlock of the whole file here.
+type lockReleaser struct {
+	fuse.RawFileSystem
+}
+
+func (l *lockReleaser) unlock(hdr fuse.InHeader, fh, owner uint64, flags uint32) {
+	in := &fuse.LkIn{
+		InHeader: hdr,
+		Fh:       fh,
+		Owner:    owner,
+		Lk:       fuse.FileLock{End: math.MaxUint64, Typ: syscall.F_UNLCK},
+		LkFlags:  flags,
+	}
+	// nodes without locks answer ENOSYS
+	l.RawFileSystem.SetLk(nil, in)
+}
+
+func (l *lockReleaser) Flush(cancel <-chan struct{}, in *fuse.FlushIn) fuse.Status {
+	l.unlock(in.InHeader, in.Fh, in.LockOwner, 0)
+	return l.RawFileSystem.Flush(cancel, in)
+}
+
+func (l *lockReleaser) Release(cancel <-chan struct{}, in *fuse.ReleaseIn) {
+	if in.ReleaseFlags&fuseReleaseFlockUnlock != 0 {
+		l.unlock(in.InHeader, in.Fh, in.LockOwner, fuseLkFlock)
+	}
+	l.RawFileSystem.Release(cancel, in)
+}
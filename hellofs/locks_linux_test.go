@@ -0,0 +1,88 @@
+package hellofs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// TestLocksMount contends for file.txt through two handles of a mount with
+// -enableLocks. Open file description locks and flocks, unlike fcntl
+// locks, are owned by the handle rather than the process, so the second
+// is denied, told who holds the lock, and waits for it when asked to.
+func TestLocksMount(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.enableLocks = true
+	dir := mountTest(t, Config{Root: root, Options: &fs.Options{MountOptions: fuse.MountOptions{EnableLocks: true}}})
+	open := func() *os.File {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join(dir, "file.txt"), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	lock := func(f *os.File, cmd int, typ int16) error {
+		return unix.FcntlFlock(f.Fd(), cmd, &unix.Flock_t{Type: typ})
+	}
+
+	t.Run("ofd", func(t *testing.T) {
+		a, b := open(), open()
+		if err := lock(a, unix.F_OFD_SETLK, unix.F_WRLCK); err != nil {
+			t.Fatalf("first write lock: %v", err)
+		}
+		if err := lock(b, unix.F_OFD_SETLK, unix.F_WRLCK); !errors.Is(err, unix.EAGAIN) {
+			t.Errorf("second write lock: %v, want EAGAIN", err)
+		}
+		if err := lock(b, unix.F_OFD_SETLK, unix.F_RDLCK); !errors.Is(err, unix.EAGAIN) {
+			t.Errorf("read lock over the write lock: %v, want EAGAIN", err)
+		}
+		lk := unix.Flock_t{Type: unix.F_WRLCK}
+		if err := unix.FcntlFlock(b.Fd(), unix.F_OFD_GETLK, &lk); err != nil || lk.Type != unix.F_WRLCK {
+			t.Errorf("getlk: type %d, %v, want the write lock", lk.Type, err)
+		}
+
+		waited := make(chan error, 1)
+		go func() { waited <- lock(b, unix.F_OFD_SETLKW, unix.F_WRLCK) }()
+		select {
+		case err := <-waited:
+			t.Fatalf("waiting lock returned while held: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if err := lock(a, unix.F_OFD_SETLK, unix.F_UNLCK); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-waited:
+			if err != nil {
+				t.Errorf("waiting lock: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("waiting lock still blocked after the unlock")
+		}
+		if err := lock(b, unix.F_OFD_SETLK, unix.F_UNLCK); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("flock", func(t *testing.T) {
+		a, b := open(), open()
+		if err := unix.Flock(int(a.Fd()), unix.LOCK_EX); err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Flock(int(b.Fd()), unix.LOCK_EX|unix.LOCK_NB); !errors.Is(err, unix.EWOULDBLOCK) {
+			t.Errorf("second flock: %v, want EWOULDBLOCK", err)
+		}
+		// closing the holder releases it
+		a.Close()
+		if err := unix.Flock(int(b.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+			t.Errorf("flock after the holder closed: %v", err)
+		}
+	})
+}
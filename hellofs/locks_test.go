@@ -0,0 +1,107 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func testLock(owner uint64, typ uint32, start, end uint64) fileLock {
+	return fileLock{owner: owner, lk: fuse.FileLock{Start: start, End: end, Typ: typ}}
+}
+
+func TestLockTableSet(t *testing.T) {
+	const rd, wr, un = syscall.F_RDLCK, syscall.F_WRLCK, syscall.F_UNLCK
+	tests := []struct {
+		name string
+		held []fileLock
+		set  fileLock
+		want []fileLock
+	}{
+		{
+			name: "first lock",
+			set:  testLock(1, wr, 0, 9),
+			want: []fileLock{testLock(1, wr, 0, 9)},
+		},
+		{
+			name: "unlock the middle splits",
+			held: []fileLock{testLock(1, wr, 0, 99)},
+			set:  testLock(1, un, 10, 19),
+			want: []fileLock{testLock(1, wr, 0, 9), testLock(1, wr, 20, 99)},
+		},
+		{
+			name: "relock the head",
+			held: []fileLock{testLock(1, wr, 10, 29)},
+			set:  testLock(1, rd, 0, 19),
+			want: []fileLock{testLock(1, wr, 20, 29), testLock(1, rd, 0, 19)},
+		},
+		{
+			name: "relock the tail",
+			held: []fileLock{testLock(1, rd, 0, 19)},
+			set:  testLock(1, wr, 10, 29),
+			want: []fileLock{testLock(1, rd, 0, 9), testLock(1, wr, 10, 29)},
+		},
+		{
+			name: "covering replaces several",
+			held: []fileLock{testLock(1, rd, 0, 4), testLock(1, wr, 10, 14)},
+			set:  testLock(1, wr, 0, 19),
+			want: []fileLock{testLock(1, wr, 0, 19)},
+		},
+		{
+			name: "other owners are left alone",
+			held: []fileLock{testLock(2, rd, 0, 99), {owner: 1, flock: true, lk: fuse.FileLock{End: 99, Typ: rd}}},
+			set:  testLock(1, un, 0, 99),
+			want: []fileLock{testLock(2, rd, 0, 99), {owner: 1, flock: true, lk: fuse.FileLock{End: 99, Typ: rd}}},
+		},
+		{
+			name: "unlock of nothing",
+			held: []fileLock{testLock(1, rd, 50, 59)},
+			set:  testLock(1, un, 0, 9),
+			want: []fileLock{testLock(1, rd, 50, 59)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lt := &lockTable{locks: append([]fileLock(nil), tt.held...)}
+			lt.set(tt.set.owner, tt.set.flock, &tt.set.lk)
+			if !reflect.DeepEqual(lt.locks, tt.want) {
+				t.Errorf("locks = %+v, want %+v", lt.locks, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockTableConflict(t *testing.T) {
+	const rd, wr = syscall.F_RDLCK, syscall.F_WRLCK
+	held := []fileLock{testLock(1, rd, 0, 9), testLock(2, wr, 20, 29), {owner: 3, flock: true, lk: fuse.FileLock{Start: 40, End: 49, Typ: wr}}}
+	tests := []struct {
+		name     string
+		req      fileLock
+		conflict int // index in held of the lock in the way, or -1
+	}{
+		{"shared read", testLock(4, rd, 5, 15), -1},
+		{"write over a read", testLock(4, wr, 5, 15), 0},
+		{"read over a write", testLock(4, rd, 25, 25), 1},
+		{"own lock", testLock(2, wr, 20, 29), -1},
+		{"between locks", testLock(4, wr, 10, 19), -1},
+		{"flock ignores fcntl", fileLock{owner: 4, flock: true, lk: fuse.FileLock{End: 29, Typ: wr}}, -1},
+		{"fcntl ignores flock", testLock(4, wr, 40, 49), -1},
+		{"flock against flock", fileLock{owner: 4, flock: true, lk: fuse.FileLock{End: 100, Typ: rd}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lt := &lockTable{locks: held}
+			got := lt.conflict(tt.req.owner, tt.req.flock, &tt.req.lk)
+			switch {
+			case tt.conflict < 0 && got != nil:
+				t.Errorf("conflict = %+v, want none", *got)
+			case tt.conflict >= 0 && (got == nil || *got != held[tt.conflict]):
+				t.Errorf("conflict = %v, want %+v", got, held[tt.conflict])
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// rotatingFile is the -logFile: appended to until a write would take it
+// past max bytes, when it is renamed to PATH.1, replacing the previous
+// one, and a new one started. If rotating fails, output goes to fallback
+// from then on rather than being lost. max 0 never rotates.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	max      int64
+	f        *os.File // nil once fallen back
+	size     int64
+	fallback io.Writer
+}
+
+func openRotatingFile(path string, max int64, fallback io.Writer) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, max: max, fallback: fallback}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil && r.max > 0 && r.size > 0 && r.size+int64(len(p)) > r.max {
+		if err := r.rotate(); err != nil {
+			r.f = nil
+			fmt.Fprintf(r.fallback, "Failed to rotate %s, logging here instead: %v\n", r.path, err)
+		}
+	}
+	if r.f == nil {
+		return r.fallback.Write(p)
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
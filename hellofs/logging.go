@@ -0,0 +1,66 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logStdout and logStderr are where lifecycle messages, diagnostics and
+// the go-fuse log go: the terminal, or the -logFile.
+var logStdout, logStderr io.Writer = os.Stdout, os.Stderr
+
+// jsonLog is set by -logFormat json. Lifecycle messages then become
+// records on it, with an event field and the message's values as
+// attributes, instead of text lines.
+var jsonLog *slog.Logger
+
+// quiet is set by -quiet, which leaves out the lifecycle messages but
+// not the errors, in either format.
+var quiet bool
+
+// logEvent prints a lifecycle message. In text format that is text on
+// stdout, exactly as before structured logging existed.
+func logEvent(event, text string, attrs ...any) {
+	if quiet {
+		return
+	}
+	if jsonLog == nil {
+		fmt.Fprintln(logStdout, text)
+		return
+	}
+	jsonLog.Info(text, append([]any{"event", event}, attrs...)...)
+}
+
+// logError is logEvent for failures, which go to stderr in text format.
+func logError(event, text string, attrs ...any) {
+	if jsonLog == nil {
+		fmt.Fprintln(logStderr, text)
+		return
+	}
+	jsonLog.Error(text, append([]any{"event", event}, attrs...)...)
+}
+
+// newJSONLog returns the jsonLog writing to w.
+func newJSONLog(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// slogWriter turns lines written by a log.Logger, such as the go-fuse
+// debug output, into records at level.
+type slogWriter struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+func (w slogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.logger.Log(context.Background(), w.level, line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,1212 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/time/rate"
+
+	"github.com/nopcoder/hello-fuse/generator"
+)
+
+// Options are the command line flags of hello-fuse, each field the flag
+// of the same name, for Main. Their help says what each does.
+type Options struct {
+	Debug        bool
+	PidFile      string
+	Background   bool
+	LogFile      string
+	LogMaxSize   ByteSize
+	LogFormat    string
+	Quiet        bool
+	PrintOptions bool
+	AllowRemount bool
+	DryRun       bool
+
+	// fs.Options
+	EntryTimeout      time.Duration
+	AttrTimeout       time.Duration
+	NegativeTimeout   time.Duration
+	FirstAutomaticIno uint64
+	NullPermissions   bool
+	EchoDir           bool
+	NullFile          bool
+	Uid               int64
+	Gid               int64
+	User              string
+	Group             string
+
+	// fuse.MountOptions
+	AllowOther               bool
+	AllowOtherBestEffort     bool
+	AllowRoot                bool
+	MaxBackground            int
+	MaxWrite                 int
+	MaxReadAhead             int
+	IgnoreSecurityLabels     bool
+	RememberInodes           bool
+	FsName                   string
+	Name                     string
+	SingleThreaded           bool
+	DisableXAttrs            bool
+	EnableLocks              bool
+	EnableSymlinkCaching     bool
+	ExplicitDataCacheControl bool
+	SyncRead                 bool
+	DirectMount              bool
+	DirectMountStrict        bool
+	DirectMountFlags         uint
+	EnableAcl                bool
+	DefaultAcl               string
+	DisableReadDirPlus       bool
+	DisableSplice            bool
+	MaxStackDepth            int
+	IdMappedMount            bool
+	Options                  string
+	MountFlags               string
+	AllowUnknownOptions      bool
+	MountTimeout             time.Duration
+	MountRetries             int
+	MountRetryBackoff        time.Duration
+	WaitForFile              string
+	WaitForFileTimeout       time.Duration
+	SelfTest                 bool
+	LogWriteFragments        bool
+	Content                  string
+	ContentFile              string
+	ExpectSha256             string
+	GzipContentFile          string
+	Writeback                string
+	ReadOnly                 bool
+	ClockFile                bool
+	ClockFormat              string
+	CaseInsensitive          bool
+	WriteCountFile           bool
+	CallerFile               bool
+	HttpAddr                 string
+	HttpAllowRemote          bool
+	StatusInterval           time.Duration
+	ShutdownDrainWrites      bool
+	ShutdownTimeout          time.Duration
+	OnReady                  string
+	OnReadyFatal             bool
+	OnUnmount                string
+	ReadyTimeout             time.Duration
+	HealthFile               string
+	HealthInterval           time.Duration
+	ReadyTcp                 string
+	FailFast                 bool
+	ReadySocket              string
+	CasDir                   string
+	Atime                    string
+	CachePolicy              string
+	CloseToOpen              bool
+	ReuseInodes              bool
+	SqliteDB                 string
+	SqliteQuery              string
+	RootMode                 string
+	RootUser                 string
+	RootGroup                string
+	Umask                    string
+	Mtime                    string
+	Embedded                 bool
+	Source                   string
+	CacheSize                ByteSize
+	Manifest                 string
+	TarFile                  string
+	Spec                     string
+	ConfigMap                string
+	Secret                   string
+	UnmountCmd               string
+	FuseFd                   int
+	Force                    bool
+	CreateMountpoint         bool
+	RecoverStaleMount        bool
+	ReloadDebounce           time.Duration
+	WatchConfig              bool
+	AttrOverrides            string
+	IdleTimeout              time.Duration
+	ReadBps                  int
+	MaxNameLen               int
+	MaxOpenFiles             int
+	BlockReads               bool
+	LingerOnSignal           time.Duration
+	MaxDirEntries            int
+	StrictErrno              bool
+	RecoverPanics            bool
+	Plugin                   string
+	RandomSize               int64
+	RandomSeed               int64
+	Seed                     int64
+	FsSize                   ByteSize
+	FsFree                   *ByteSize // nil derives it from FsSize less the content
+	SparseSize               int64
+	SyntheticEntries         int
+	SyntheticSize            int64
+	BenchFileSize            ByteSize
+	TmpTTL                   time.Duration
+	TmpIdle                  bool
+	ProcDir                  bool
+	ProcIdle                 time.Duration
+	ReadStats                bool
+	SnapshotFile             string
+	SnapshotInterval         time.Duration
+	LatencyModel             string
+	MetricsAddr              string
+	TraceOps                 bool
+	OtlpEndpoint             string
+
+	files               []manifestNode // from -file and -symlink
+	aliases             []string
+	supplementaryGroups []string
+
+	// Version is reported in the start message.
+	Version string
+}
+
+// AddFile adds a -file, described as
+// name=NAME[:mode=0644][:contentFile=PATH | :content=TEXT].
+func (o *Options) AddFile(s string) error { return o.addFile(parseFileFlag, s) }
+
+// AddSymlink adds a -symlink, described as NAME=TARGET.
+func (o *Options) AddSymlink(s string) error { return o.addFile(parseSymlinkFlag, s) }
+
+func (o *Options) addFile(parse func(string) (manifestNode, error), s string) error {
+	n, err := parse(s)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(o.files, func(f manifestNode) bool { return f.name == n.name }) {
+		return fmt.Errorf("duplicate name %q", n.name)
+	}
+	o.files = append(o.files, n)
+	return nil
+}
+
+// AddAlias adds an -alias, another name of file.txt.
+func (o *Options) AddAlias(s string) error {
+	if !validName(s) || s == "file.txt" {
+		return fmt.Errorf("invalid name %q", s)
+	}
+	if slices.Contains(o.aliases, s) {
+		return fmt.Errorf("duplicate name %q", s)
+	}
+	o.aliases = append(o.aliases, s)
+	return nil
+}
+
+// AddSupplementaryGid adds the groups of a -supplementaryGid, ids or
+// names separated by commas.
+func (o *Options) AddSupplementaryGid(s string) error {
+	for _, g := range strings.Split(s, ",") {
+		if g = strings.TrimSpace(g); g == "" {
+			return fmt.Errorf("empty group")
+		}
+		o.supplementaryGroups = append(o.supplementaryGroups, g)
+	}
+	return nil
+}
+
+// Main runs hello-fuse with the flags in o on mountpoints, as the command
+// does, and returns the exit status. Errors are reported on stderr or in
+// the log.
+func Main(o *Options, mountpoints []string) int {
+	if err := o.validate(mountpoints); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitFailure
+	}
+	quiet = o.Quiet
+	if o.LogFormat == "json" {
+		jsonLog = newJSONLog(os.Stdout)
+	}
+	if o.Background && !isDaemon() && !o.DryRun {
+		// the child reads a -contentFile of - instead
+		return daemonize(o.LogFile, o.ContentFile == "-" || o.GzipContentFile == "-")
+	}
+	if o.LogFile != "" && !o.DryRun {
+		f, err := openRotatingFile(o.LogFile, int64(o.LogMaxSize), os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening log file: %v\n", err)
+			return ExitFailure
+		}
+		logStdout, logStderr = f, f
+		if jsonLog != nil {
+			jsonLog = newJSONLog(f)
+		}
+	}
+	c, err := newCommand(o, mountpoints)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitFailure
+	}
+	err = c.run()
+	c.close()
+	if err == nil {
+		return ExitOK
+	}
+	switch {
+	case errors.Is(err, errShutdownTimeout):
+		logError("shutdown_timeout", fmt.Sprintf("Warning: %v, exiting anyway", err), "mountpoint", c.mountpoint, "error", err)
+	case errors.Is(err, errDetached):
+		logError("detached", fmt.Sprintf("Warning: %v", err), "mountpoint", c.mountpoint, "error", err, "exit_code", ExitCode(err))
+	default:
+		logError("error", fmt.Sprintf("ERROR: %v", err), "mountpoint", c.mountpoint, "error", err, "exit_code", ExitCode(err))
+	}
+	return ExitCode(err)
+}
+
+// command is what Main builds from validated Options: the settings the
+// mounts share, the Config of each and the background work serving them.
+type command struct {
+	o           Options // a copy, with the defaults filled in
+	mountpoints []string
+	mountpoint  string // the first, which the messages name
+
+	opts              *fs.Options
+	rootOwners        bool // some entry may be owned by root, see rootOwners
+	umask             uint32
+	rootMode          uint32
+	rootOwner         fuse.Owner
+	supplementaryGids []uint32
+
+	hooks           []opHook // shared by the mounts; each adds its own requests tracker
+	drain           *drainState
+	reads           *readGate
+	attrOverrides   map[string]*attrOverride
+	metrics         *fuseMetrics
+	closeMetrics    func()
+	shutdownTracing func(context.Context) error
+	procs           *procTable
+	stats           *readStats
+	readLimit       *rate.Limiter
+
+	// the tree, cloned for each mount
+	content        []byte
+	contentGzipped bool
+	expectSum      string
+	sqlDir         *SQLDir
+	reloaders      []func(context.Context) error
+	watchPaths     []string
+	spec           []specEntry
+	clockLayout    string
+	fsFree         int64
+	mtime          time.Time
+	manifest       []manifestNode
+	tarEntries     []tarEntry
+	kubeDirs       map[string]*KubeDir
+	seed           uint64
+	randomBinSeed  uint64
+	gens           map[string]generator.Func
+	defaultACL     []byte
+
+	ctx        context.Context // cancelled by a shutdown signal
+	cancel     context.CancelFunc
+	remounting atomic.Bool
+	cfgs       []Config
+	controls   controlSet
+
+	pidWritten  atomic.Bool
+	health      atomic.Pointer[healthFile]
+	selfTestErr error
+}
+
+// newCommand builds the mounts of o on mountpoints, ready for run. o must
+// have been validated. Until run, nothing is mounted or listened on.
+func newCommand(o *Options, mountpoints []string) (*command, error) {
+	c := &command{
+		o:               *o,
+		mountpoints:     mountpoints,
+		closeMetrics:    func() {},
+		shutdownTracing: func(context.Context) error { return nil },
+	}
+	if len(mountpoints) > 0 {
+		c.mountpoint = mountpoints[0]
+	}
+	steps := []func() error{c.buildOptions, c.buildHooks, c.loadTree, c.prepareMountpoints, c.start, c.buildConfigs}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// buildOptions resolves the owners and mount options into the fs.Options
+// all the mounts use.
+func (c *command) buildOptions() error {
+	o := &c.o
+	c.umask, _ = parseUmask(o.Umask)
+	c.rootMode, _ = parseRootMode(o.RootMode, 0755&^c.umask)
+	if o.RootUser != "" {
+		id, err := lookupUser(o.RootUser)
+		if err != nil {
+			return fmt.Errorf("invalid -rootUser %q: %w", o.RootUser, err)
+		}
+		c.rootOwner.Uid = ownerID(id)
+	}
+	if o.RootGroup != "" {
+		id, err := lookupGroup(o.RootGroup)
+		if err != nil {
+			return fmt.Errorf("invalid -rootGroup %q: %w", o.RootGroup, err)
+		}
+		c.rootOwner.Gid = ownerID(id)
+	}
+	c.rootOwners = c.rootOwner.Uid == rootID || c.rootOwner.Gid == rootID
+	nuid, ngid, err := lookupIDs(o.User, o.Group, o.Uid, o.Gid)
+	if err != nil {
+		return fmt.Errorf("looking up user/group: %w", err)
+	}
+	ruid, rgid, err := resolveUIDGID(nuid, ngid)
+	if err != nil {
+		return fmt.Errorf("resolving UID/GID: %w", err)
+	}
+	logEvent("start", fmt.Sprintf("Using UID: '%d', GID: '%d' (%s)", ruid, rgid, o.Version),
+		"mountpoint", strings.Join(c.mountpoints, " "), "uid", ruid, "gid", rgid, "version", o.Version)
+	if c.supplementaryGids, err = resolveGids(o.supplementaryGroups); err != nil {
+		return fmt.Errorf("resolving supplementary GIDs: %w", err)
+	}
+	var sizeWarnings []string
+	o.MaxWrite, o.MaxReadAhead, sizeWarnings, _ = checkIOSizes(o.MaxWrite, o.MaxReadAhead)
+	for _, w := range sizeWarnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	options, warnings, _ := parseMountOptions(o.Options, o.AllowUnknownOptions)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: -options: %s\n", w)
+	}
+	options, _ = addMountFlags(o.MountFlags, options)
+	// go-fuse passes fsname= and subtype= itself, from -fsName and -name;
+	// left in -options too they would reach the kernel twice, and fsname
+	// fails a -directMount
+	options = slices.DeleteFunc(options, func(opt string) bool {
+		switch key, val, _ := strings.Cut(opt, "="); key {
+		case "fsname":
+			o.FsName = cmp.Or(o.FsName, val)
+		case "subtype":
+			o.Name = cmp.Or(o.Name, val)
+		default:
+			return false
+		}
+		return true
+	})
+	if o.AllowRoot && !slices.Contains(options, "allow_root") {
+		options = append(options, "allow_root")
+	}
+	if o.ReadOnly {
+		// the kernel then refuses writes before they reach us
+		if i := slices.Index(options, "rw"); i >= 0 {
+			fmt.Fprintf(os.Stderr, "Warning: -readOnly overrides -options rw\n")
+			options = slices.Delete(options, i, i+1)
+		}
+		if !slices.Contains(options, "ro") {
+			options = append(options, "ro")
+		}
+	}
+	if o.Atime == "" {
+		o.Atime = atimeFromOptions(options)
+	}
+
+	// interrupted requests are routine under signal load, only show them
+	// when debugging
+	var logOut io.Writer = logStdout
+	logFlags := log.LstdFlags
+	fuseLogger := log.New(logStderr, "", log.LstdFlags) // as go-fuse's default
+	if jsonLog != nil {
+		logOut, logFlags = slogWriter{jsonLog.With("event", "diagnostic"), slog.LevelWarn}, 0
+		fuseLogger = log.New(slogWriter{jsonLog.With("event", "fuse"), slog.LevelDebug}, "", 0)
+	}
+	if !o.Debug {
+		logOut = &transientFilter{w: logOut}
+	}
+	// go-fuse would name the mount after its own Go type, rawBridge
+	if o.FsName == "" {
+		o.FsName = "hello-fuse"
+		if o.Source != "" {
+			o.FsName, _ = filepath.Abs(o.Source)
+		}
+	}
+	if o.Name == "" {
+		o.Name = "fuse"
+	}
+	c.opts = &fs.Options{
+		Logger:          log.New(logOut, "", logFlags),
+		EntryTimeout:    fuseTimeout(o.EntryTimeout, time.Second),
+		AttrTimeout:     fuseTimeout(o.AttrTimeout, time.Second),
+		NegativeTimeout: fuseTimeout(o.NegativeTimeout, 0),
+		NullPermissions: o.NullPermissions,
+		UID:             ruid,
+		GID:             rgid,
+		MountOptions: fuse.MountOptions{
+			Debug:                    o.Debug,
+			Logger:                   fuseLogger,
+			AllowOther:               o.AllowOther,
+			Options:                  options,
+			MaxBackground:            o.MaxBackground,
+			MaxWrite:                 o.MaxWrite,
+			MaxReadAhead:             o.MaxReadAhead,
+			IgnoreSecurityLabels:     o.IgnoreSecurityLabels,
+			RememberInodes:           o.RememberInodes,
+			FsName:                   o.FsName,
+			Name:                     o.Name,
+			SingleThreaded:           o.SingleThreaded,
+			DisableXAttrs:            o.DisableXAttrs,
+			EnableLocks:              o.EnableLocks,
+			EnableSymlinkCaching:     o.EnableSymlinkCaching,
+			ExplicitDataCacheControl: o.ExplicitDataCacheControl,
+			SyncRead:                 o.SyncRead,
+			DirectMount:              o.DirectMount,
+			DirectMountStrict:        o.DirectMountStrict,
+			DirectMountFlags:         uintptr(o.DirectMountFlags),
+			EnableAcl:                o.EnableAcl,
+			DisableReadDirPlus:       o.DisableReadDirPlus,
+			DisableSplice:            o.DisableSplice,
+			MaxStackDepth:            o.MaxStackDepth,
+			IDMappedMount:            o.IdMappedMount,
+		},
+	}
+	return nil
+}
+
+// buildHooks sets up the drain and read gate the mounts share and the op
+// hooks that don't need the mounts up: latency, tracing and metrics.
+func (c *command) buildHooks() error {
+	o := &c.o
+	if o.LingerOnSignal > 0 {
+		c.drain = &drainState{linger: o.LingerOnSignal}
+	}
+	if o.BlockReads {
+		c.reads = newReadGate()
+	}
+	if o.AttrOverrides != "" {
+		var err error
+		if c.attrOverrides, err = loadAttrOverrides(o.AttrOverrides); err != nil {
+			return fmt.Errorf("loading attribute overrides: %w", err)
+		}
+	}
+	if o.LatencyModel != "" {
+		model, _ := parseLatencyModel(o.LatencyModel)
+		c.hooks = append(c.hooks, model.hook)
+	}
+	if o.TraceOps {
+		c.hooks = append(c.hooks, traceOps(c.opts.Logger))
+	}
+	if o.OtlpEndpoint != "" {
+		hook, shutdown, err := setupTracing(o.OtlpEndpoint)
+		if err != nil {
+			return fmt.Errorf("setting up tracing: %w", err)
+		}
+		c.hooks, c.shutdownTracing = append(c.hooks, hook), shutdown
+	}
+	// a dry run doesn't listen, which would also clash with the process
+	// that runs one to vet a remount
+	if o.MetricsAddr != "" && !o.DryRun {
+		metrics, closeMetrics, err := setupMetrics(o.MetricsAddr)
+		if err != nil {
+			return fmt.Errorf("setting up metrics: %w", err)
+		}
+		c.metrics, c.closeMetrics = metrics, closeMetrics
+		c.hooks = append(c.hooks, metrics.hook)
+	}
+	return nil
+}
+
+// loadTree reads what the served tree is made of: file.txt's content, the
+// manifest, tar file, database and the other sources the flags name.
+func (c *command) loadTree() error {
+	o := &c.o
+	var err error
+	// a dry run creates nothing, and nothing is written to it
+	if o.CasDir != "" && !o.DryRun {
+		if err := os.MkdirAll(o.CasDir, 0755); err != nil {
+			return fmt.Errorf("creating CAS directory: %w", err)
+		}
+	}
+	if o.SqliteDB != "" {
+		if c.sqlDir, err = openSQLDir(o.SqliteDB, o.SqliteQuery, c.opts.Logger); err != nil {
+			return fmt.Errorf("loading SQLite database: %w", err)
+		}
+		c.reloaders = append(c.reloaders, c.sqlDir.reload)
+		c.watchPaths = append(c.watchPaths, o.SqliteDB)
+	}
+	if o.Spec != "" {
+		if c.spec, err = parseSpec(o.Spec); err != nil {
+			return fmt.Errorf("parsing spec: %w", err)
+		}
+	}
+	if o.ClockFile {
+		c.clockLayout = o.ClockFormat
+	}
+
+	c.content = []byte(o.Content)
+	if o.GzipContentFile != "" {
+		o.ContentFile, c.contentGzipped = o.GzipContentFile, true
+	}
+	if o.ContentFile != "" {
+		// before mounting, so the mount can't be ready without it
+		if c.content, err = readContentFile(o.ContentFile, c.contentGzipped); err != nil {
+			return fmt.Errorf("reading content file: %w", err)
+		}
+	}
+	if o.ExpectSha256 != "" {
+		c.expectSum, _ = parseSHA256(o.ExpectSha256)
+		if err := checkSHA256(c.content, c.expectSum); err != nil {
+			return fmt.Errorf("refusing to mount, file.txt content doesn't match -expectSha256: %w", err)
+		}
+	}
+	c.fsFree = -1
+	if o.FsFree != nil {
+		c.fsFree = int64(min(uint64(*o.FsFree), uint64(o.FsSize)))
+	}
+	c.mtime = time.Now()
+	if o.Mtime != "" {
+		c.mtime, _ = time.Parse(time.RFC3339Nano, o.Mtime)
+	}
+
+	if o.Manifest != "" {
+		if c.manifest, err = loadManifest(o.Manifest, o.MaxNameLen); err != nil {
+			return fmt.Errorf("loading manifest: %w", err)
+		}
+		c.rootOwners = true
+		if hasRootOwner(c.manifest) {
+			c.opts.DisableReadDirPlus = true
+		}
+	}
+	if o.TarFile != "" {
+		if c.tarEntries, err = loadTar(o.TarFile, o.MaxNameLen); err != nil {
+			return fmt.Errorf("loading tar file: %w", err)
+		}
+		if slices.ContainsFunc(c.tarEntries, func(e tarEntry) bool { return e.owner.Uid == rootID || e.owner.Gid == rootID }) {
+			c.rootOwners = true
+			c.opts.DisableReadDirPlus = true
+		}
+	}
+	if o.CaseInsensitive {
+		if err := caseCollision(append(slices.Clone(c.manifest), o.files...)); err != nil {
+			return fmt.Errorf("-caseInsensitive: %w", err)
+		}
+	}
+
+	c.kubeDirs = map[string]*KubeDir{}
+	for name, path := range map[string]string{"configmap": o.ConfigMap, "secret": o.Secret} {
+		if path == "" {
+			continue
+		}
+		d, err := openKubeDir(path, name == "secret")
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", name, err)
+		}
+		c.kubeDirs[name] = d
+		c.reloaders = append(c.reloaders, d.reload)
+		c.watchPaths = append(c.watchPaths, path)
+	}
+
+	c.seed = uint64(o.Seed)
+	if o.Seed < 0 && (o.RandomSize > 0 && o.RandomSeed < 0 || o.SyntheticSize > 0) {
+		c.seed = rand.Uint64() >> 1
+		logEvent("random_seed", fmt.Sprintf("Random seed: %d, pass -seed to serve the same content again", c.seed), "seed", c.seed)
+	}
+	c.randomBinSeed = pathSeed(c.seed, "random.bin")
+	if o.RandomSeed >= 0 {
+		c.randomBinSeed = uint64(o.RandomSeed)
+	}
+	if o.Plugin != "" {
+		if c.gens, err = loadPlugin(o.Plugin); err != nil {
+			return fmt.Errorf("loading plugin: %w", err)
+		}
+	}
+	if o.WaitForFile != "" {
+		if err := waitForFile(o.WaitForFile, o.WaitForFileTimeout); err != nil {
+			return fmt.Errorf("waiting for file: %w", err)
+		}
+	}
+	if o.DefaultAcl != "" {
+		acl, _ := parseACL(o.DefaultAcl)
+		c.defaultACL = encodeACL(acl)
+	}
+	return nil
+}
+
+// prepareMountpoints checks each mountpoint can be mounted on, creating
+// or cleaning it up as the flags ask. -selfTest makes one of its own.
+func (c *command) prepareMountpoints() error {
+	o := &c.o
+	if o.SelfTest {
+		mountpoint, err := os.MkdirTemp("", "hello-fuse-selftest-")
+		if err != nil {
+			return fmt.Errorf("self-test failed creating the mountpoint: %w", err)
+		}
+		c.mountpoint, c.mountpoints = mountpoint, []string{mountpoint}
+	}
+	if o.FuseFd > 0 {
+		if err := checkFuseFd(o.FuseFd); err != nil {
+			return fmt.Errorf("invalid -fuseFd: %w", err)
+		}
+	}
+	for _, mountpoint := range c.mountpoints {
+		if o.RecoverStaleMount && !o.DryRun {
+			recovered, err := recoverStaleMount(mountpoint)
+			if err != nil {
+				return fmt.Errorf("failed to clean up stale mount: %w", err)
+			}
+			if recovered {
+				logEvent("stale_mount", fmt.Sprintf("Cleaned up stale mount at %s", mountpoint), "mountpoint", mountpoint)
+			}
+		}
+		// a -fuseFd mount was set up by whoever passed the fd, and stat
+		// would block on it until we serve
+		// a dry run creates nothing, so a mountpoint -createMountpoint would
+		// make may be missing
+		_, statErr := os.Stat(mountpoint)
+		if o.FuseFd > 0 || o.DryRun && o.CreateMountpoint && errors.Is(statErr, os.ErrNotExist) {
+			continue
+		}
+		if err := checkMountpoint(mountpoint, o.CreateMountpoint); err != nil {
+			if errors.Is(err, syscall.ENOTCONN) {
+				return fmt.Errorf("invalid mountpoint: %w\n%s", err, staleMountHint(mountpoint))
+			}
+			return fmt.Errorf("invalid mountpoint: %w", err)
+		}
+		switch err := preflightMountpoint(mountpoint, o.Force); {
+		case errors.Is(err, errMounted) && o.DryRun && os.Getenv(remountCheckEnv) != "":
+			// vetting a remount: the mount there is our caller's
+		case errors.Is(err, errMounted):
+			return fmt.Errorf("refusing to mount: %w; unmount it first", err)
+		case err != nil:
+			return fmt.Errorf("refusing to mount: %w", err)
+		}
+	}
+	return nil
+}
+
+// start sets up the shutdown context and the background work hooked to
+// the mounts that stops with it.
+func (c *command) start() error {
+	o := &c.o
+	// Ctrl+C or shell close unmounts; everything started from here on
+	// stops with ctx
+	c.ctx, c.cancel = shutdownContext(strings.Join(c.mountpoints, " "), c.drain)
+	if o.AllowRemount {
+		go watchRemount(c.ctx, &c.remounting, c.cancel)
+	}
+	if c.reads != nil {
+		go c.reads.watch(c.ctx)
+	}
+	if o.ProcDir {
+		abs, err := filepath.Abs(c.mountpoint)
+		if err != nil {
+			return fmt.Errorf("resolving mountpoint: %w", err)
+		}
+		c.procs = newProcTable(abs)
+		c.hooks = append(c.hooks, c.procs.hook)
+		go c.procs.reap(c.ctx, o.ProcIdle)
+	}
+	if o.ReadStats {
+		c.stats = newReadStats()
+		c.hooks = append(c.hooks, c.stats.hook)
+	}
+	if o.IdleTimeout > 0 {
+		idle := newIdleTracker()
+		c.hooks = append(c.hooks, idle.hook)
+		go idle.watch(c.ctx, o.IdleTimeout, c.cancel)
+	}
+	c.readLimit = newReadLimiter(o.ReadBps)
+	return nil
+}
+
+// buildConfigs makes the tree and Config of each mount.
+func (c *command) buildConfigs() error {
+	for i, mountpoint := range c.mountpoints {
+		cfg, err := c.config(i, mountpoint)
+		if err != nil {
+			return err
+		}
+		c.cfgs = append(c.cfgs, cfg)
+	}
+	// the process is ready once every mount is
+	var pending atomic.Int32
+	pending.Store(int32(len(c.cfgs)))
+	onReady := func() {
+		if pending.Add(-1) == 0 {
+			c.ready()
+		}
+	}
+	if c.o.SelfTest {
+		ready := onReady
+		onReady = func() {
+			ready()
+			if c.selfTestErr = selfTestRead(c.mountpoint, c.content); c.selfTestErr == nil {
+				logEvent("self_test", "Self-test read file.txt, unmounting", "mountpoint", c.mountpoint)
+			}
+			c.cancel()
+		}
+	}
+	for i := range c.cfgs {
+		mp, _ := filepath.Abs(c.cfgs[i].Mountpoint)
+		c.cfgs[i].OnReady = func() error {
+			if c.o.OnReady != "" {
+				// runs before the process counts as ready, which a fatal
+				// failure keeps it from being
+				if err := runHook("onReady", c.o.OnReady, mp); err != nil {
+					if c.o.OnReadyFatal {
+						return err
+					}
+					logError("hook_failed", fmt.Sprintf("Hook failed: %v", err), "hook", "onReady", "mountpoint", mp, "error", err)
+				}
+			}
+			onReady()
+			return nil
+		}
+		if c.o.OnUnmount != "" {
+			c.cfgs[i].AfterUnmount = func() {
+				if err := runHook("onUnmount", c.o.OnUnmount, mp); err != nil {
+					logError("hook_failed", fmt.Sprintf("Hook failed: %v", err), "hook", "onUnmount", "mountpoint", mp, "error", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newRoot builds the tree of the i-th mount. Every mount gets a tree of
+// its own, and MemRegularFile writes into its data in place.
+func (c *command) newRoot(i int) *HelloRoot {
+	o := &c.o
+	root := &HelloRoot{
+		birth:             born(),
+		logger:            c.opts.Logger,
+		done:              c.ctx,
+		supplementaryGids: c.supplementaryGids,
+		logWriteFragments: o.LogWriteFragments,
+		maxWrite:          o.MaxWrite,
+		callerFile:        o.CallerFile,
+		readOnly:          o.ReadOnly,
+		disableXAttrs:     o.DisableXAttrs,
+		entries:           map[string][]byte{"file.txt": c.content},
+		aliases:           o.aliases,
+		writebackFile:     o.Writeback,
+		clockFormat:       c.clockLayout,
+		casDir:            o.CasDir,
+		closeToOpen:       o.CloseToOpen,
+		enableLocks:       o.EnableLocks,
+		enableAcl:         o.EnableAcl,
+		defaultACL:        c.defaultACL,
+		readLimit:         c.readLimit,
+		cachePolicy:       o.CachePolicy,
+		atime:             o.Atime,
+		inos:              newInoAllocator(o.FirstAutomaticIno, o.ReuseInodes),
+		sqlDir:            c.sqlDir,
+		procs:             c.procs,
+		readStats:         c.stats,
+		gens:              c.gens,
+		kubeDirs:          c.kubeDirs,
+		spec:              c.spec,
+		mtime:             c.mtime,
+		manifest:          c.manifest,
+		files:             o.files,
+		tarEntries:        c.tarEntries,
+		randomSize:        o.RandomSize,
+		randomSeed:        c.randomBinSeed,
+		seed:              c.seed,
+		sparseSize:        o.SparseSize,
+		benchFileSize:     int64(o.BenchFileSize),
+		syntheticEntries:  o.SyntheticEntries,
+		syntheticSize:     o.SyntheticSize,
+		nullFile:          o.NullFile,
+		echoDir:           o.EchoDir,
+		fsSize:            uint64(o.FsSize),
+		fsFree:            c.fsFree,
+		tmpTTL:            o.TmpTTL,
+		tmpIdle:           o.TmpIdle,
+		umask:             c.umask,
+		mode:              c.rootMode,
+		owner:             c.rootOwner,
+		embedded:          o.Embedded,
+		writeCountFile:    o.WriteCountFile,
+		caseInsensitive:   o.CaseInsensitive,
+	}
+	if i > 0 {
+		root.entries["file.txt"] = slices.Clone(c.content)
+		root.manifest, root.files = cloneManifest(c.manifest), cloneManifest(o.files)
+		root.tarEntries = cloneTar(c.tarEntries)
+		root.spec = cloneSpec(c.spec)
+	}
+	return root
+}
+
+// config is the Config of the i-th mount, on mountpoint.
+func (c *command) config(i int, mountpoint string) (Config, error) {
+	o := &c.o
+	root := c.newRoot(i)
+	reloaders, watchPaths := slices.Clone(c.reloaders), slices.Clone(c.watchPaths)
+	if o.Manifest != "" {
+		reloaders = append(reloaders, root.reloadManifest(o.Manifest))
+		watchPaths = append(watchPaths, o.Manifest)
+	}
+	if o.ContentFile != "" && o.ContentFile != "-" {
+		reloaders = append(reloaders, root.reloadContent(o.ContentFile, c.contentGzipped, c.expectSum))
+		watchPaths = append(watchPaths, o.ContentFile)
+	}
+	onMount := func() {
+		root.mounted.Store(true)
+		if c.metrics != nil {
+			c.metrics.mounted.Inc()
+		}
+	}
+	control := &controlState{mountpoint: mountpoint, opts: c.opts, start: time.Now(), drain: c.drain, reads: c.reads}
+	c.controls = append(c.controls, control)
+	hooks := slices.Clone(c.hooks)
+	if o.HttpAddr != "" || o.StatusInterval > 0 || o.ShutdownDrainWrites {
+		control.requests = newInFlight()
+		// ahead of -latencyModel, so the requests it delays count as in
+		// progress
+		hooks = append([]opHook{control.requests.hook}, c.hooks...)
+	}
+	cfg := Config{
+		Mountpoint:        mountpoint,
+		FuseFd:            o.FuseFd,
+		Root:              root,
+		VerifyFile:        "file.txt",
+		ReadyTimeout:      o.ReadyTimeout,
+		OnMount:           onMount,
+		Options:           c.opts,
+		MountTimeout:      o.MountTimeout,
+		ShutdownTimeout:   o.ShutdownTimeout,
+		MountRetries:      o.MountRetries,
+		MountRetryBackoff: o.MountRetryBackoff,
+		UnmountCmd:        o.UnmountCmd,
+		Reloaders:         reloaders,
+		WatchPaths:        watchPaths,
+		WatchConfig:       o.WatchConfig,
+		ReloadDebounce:    o.ReloadDebounce,
+		SnapshotFile:      o.SnapshotFile,
+		SnapshotInterval:  o.SnapshotInterval,
+		DrainWrites:       o.ShutdownDrainWrites,
+		Control:           control,
+
+		RecoverPanics:        o.RecoverPanics,
+		StrictErrno:          o.StrictErrno,
+		MaxNameLen:           o.MaxNameLen,
+		MaxOpenFiles:         o.MaxOpenFiles,
+		MaxDirEntries:        o.MaxDirEntries,
+		AllowOtherBestEffort: o.AllowOtherBestEffort,
+
+		hooks:         hooks,
+		attrOverrides: c.attrOverrides,
+		rootOwners:    c.rootOwners,
+		drain:         c.drain,
+		reads:         c.reads,
+	}
+	if o.Writeback != "" {
+		cfg.BeforeUnmount = func() error {
+			if err := root.writeBack(o.Writeback); err != nil {
+				return fmt.Errorf("writing back file.txt: %w", err)
+			}
+			logEvent("writeback", fmt.Sprintf("Wrote file.txt to %s", o.Writeback), "path", o.Writeback)
+			return nil
+		}
+	}
+	if o.Source != "" {
+		src, err := newSourceRoot(o.Source)
+		if err != nil {
+			return Config{}, fmt.Errorf("opening source directory: %w", err)
+		}
+		if o.CacheSize > 0 {
+			src.cache = newSourceCache(int64(o.CacheSize))
+		}
+		cfg.Root, cfg.VerifyFile = src, ""
+	}
+	return cfg, nil
+}
+
+// ready runs once every mount is ready: the pid file, the daemon parent
+// and the readiness signals hear of it.
+func (c *command) ready() {
+	o := &c.o
+	if o.PidFile != "" {
+		if err := writePidFile(o.PidFile); err != nil {
+			fmt.Fprintf(logStderr, "Failed to write pid file: %v\n", err)
+		} else {
+			c.pidWritten.Store(true)
+		}
+	}
+	signalDaemonReady()
+	if o.HealthFile != "" {
+		var paths []string
+		for _, cfg := range c.cfgs {
+			paths = append(paths, filepath.Join(cfg.Mountpoint, cfg.VerifyFile))
+		}
+		c.health.Store(startHealthFile(c.ctx, o.HealthFile, o.HealthInterval, paths))
+	}
+	if o.ReadyTcp != "" {
+		go func() {
+			if err := signalReadyTCP(o.ReadyTcp); err != nil {
+				fmt.Fprintf(logStderr, "Failed to signal readiness to %s: %v\n", o.ReadyTcp, err)
+			}
+		}()
+	}
+}
+
+// run serves the mounts until they are shut down, or with -dryRun only
+// builds their trees, and returns why they stopped.
+func (c *command) run() error {
+	o := &c.o
+	if o.PrintOptions || o.DryRun {
+		b, err := optionsJSON(c.opts)
+		if err != nil {
+			return fmt.Errorf("printing options: %w", err)
+		}
+		fmt.Printf("%s\n", b)
+	}
+	var err error
+	if o.DryRun {
+		for _, cfg := range c.cfgs {
+			if err = DryRun(cfg); err != nil {
+				break
+			}
+		}
+	} else {
+		if o.StatusInterval > 0 {
+			go logStatus(c.ctx, c.controls, o.StatusInterval)
+		}
+		stopControl, serr := c.controls.serve(o.HttpAddr, o.ReadySocket, o.HttpAllowRemote, c.cancel)
+		if serr != nil {
+			return fmt.Errorf("starting control endpoints: %w", serr)
+		}
+		err = RunAll(c.ctx, c.cfgs, o.FailFast)
+		stopControl()
+	}
+	if o.SelfTest {
+		if rerr := os.Remove(c.mountpoint); rerr != nil && err == nil {
+			err = fmt.Errorf("removing the mountpoint: %w", rerr)
+		}
+		switch {
+		case err != nil:
+			err = fmt.Errorf("self-test failed: %w", err)
+		case c.selfTestErr != nil:
+			err = fmt.Errorf("self-test failed reading: %w", c.selfTestErr)
+		default:
+			logEvent("self_test", "Self-test passed", "mountpoint", c.mountpoint)
+		}
+	}
+	if err == nil && c.remounting.Load() {
+		c.close()
+		logEvent("remount", "Unmounted, mounting again", "mountpoint", c.mountpoint)
+		err = fmt.Errorf("remount: %w", reexec())
+	}
+	return err
+}
+
+// close stops what the command started and removes what it left on the
+// host. It may be called more than once.
+func (c *command) close() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.closeMetrics()
+	c.closeMetrics = func() {}
+	if h := c.health.Swap(nil); h != nil {
+		h.close()
+	}
+	if c.pidWritten.Swap(false) {
+		os.Remove(c.o.PidFile)
+	}
+	flushTraces(c.shutdownTracing)
+	c.shutdownTracing = func(context.Context) error { return nil }
+}
+
+// fuseTimeout is the fs.Options value for a timeout flag. go-fuse treats
+// a nil or zero timeout as zero, so an explicit 0 disables caching; a
+// negative d stands for def, what go-fuse uses when it gets no options.
+func fuseTimeout(d, def time.Duration) *time.Duration {
+	if d < 0 {
+		d = def
+	}
+	return &d
+}
+
+// shutdownContext returns a context cancelled by the first of
+// shutdownSignals. Later signals are ignored while the shutdown runs.
+// With drain, the first signal waits for the open handles to be closed
+// first, see drainState.wait.
+func shutdownContext(mountpoint string, drain *drainState) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, shutdownSignals...)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			logEvent("signal", fmt.Sprintf("Received signal %v, Closing gracefully", sig), "mountpoint", mountpoint, "signal", sig.String())
+			if drain != nil {
+				drain.wait(sigCh, mountpoint)
+			}
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// logStackDepth reports the passthrough stacking depth in effect. The
+// kernel only honors MaxStackDepth when it negotiated passthrough support.
+func logStackDepth(server *fuse.Server, depth int) {
+	if server.KernelSettings().Flags64()&fuse.CAP_PASSTHROUGH == 0 {
+		logEvent("stack_depth", fmt.Sprintf("Max stack depth: %d (ignored, kernel has no passthrough support)", depth), "depth", depth, "passthrough", false)
+		return
+	}
+	logEvent("stack_depth", fmt.Sprintf("Max stack depth: %d", depth), "depth", depth, "passthrough", true)
+}
+
+// signalReadyTCP tells a remote controller listening on addr that the mount
+// is ready by sending a single line and closing the connection.
+func signalReadyTCP(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("READY\n"))
+	return err
+}
+
+// recoverStaleMount detects a FUSE mount at mountpoint whose server is gone
+// (stat fails with ENOTCONN) and detaches it. It reports whether a stale
+// mount was found.
+func recoverStaleMount(mountpoint string) (bool, error) {
+	_, err := os.Stat(mountpoint)
+	if !errors.Is(err, syscall.ENOTCONN) {
+		return false, nil
+	}
+	return true, forceUnmount(mountpoint)
+}
+
+// staleMountHint explains how to clear the stale mount that makes stat of
+// mountpoint fail with ENOTCONN.
+func staleMountHint(mountpoint string) string {
+	return fmt.Sprintf("Hint: %s is left mounted by a run that is gone; pass -recoverStaleMount to detach it first, or run '%s %s'", mountpoint, forceUnmountCmd, mountpoint)
+}
+
+// unmount detaches the mount with the first method that works, or only
+// with method if it is set. Output of failed attempts is only shown if
+// every method fails.
+func unmount(server *fuse.Server, mountpoint, method string) error {
+	methods := UnmountMethods
+	if method != "" {
+		methods = []string{method}
+	}
+	var failures unmountErrors
+	for _, m := range methods {
+		var err error
+		var stderr bytes.Buffer
+		switch m {
+		case "server":
+			err = server.Unmount()
+		case "umount":
+			cmd := exec.Command("umount", mountpoint)
+			cmd.Stderr = &stderr
+			err = cmd.Run()
+		default:
+			cmd := exec.Command(m, "-u", mountpoint)
+			cmd.Stderr = &stderr
+			err = cmd.Run()
+		}
+		if err == nil {
+			logEvent("unmount", fmt.Sprintf("Unmounted with %s", m), "mountpoint", mountpoint, "method", m)
+			return nil
+		}
+		if out := strings.TrimSpace(stderr.String()); out != "" {
+			err = fmt.Errorf("%w: %s", err, out)
+		}
+		failures = append(failures, fmt.Errorf("%s: %w", m, err))
+	}
+	return failures
+}
+
+// unmountErrors is how each unmount method failed.
+type unmountErrors []error
+
+func (e unmountErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e unmountErrors) Unwrap() []error { return e }
+
+// waitForFile blocks until path exists on the host or timeout elapses
+func waitForFile(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := os.Stat(path)
+		if err == nil {
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not appear after %v", path, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// tryStatFile checks that the mount answers: it stats path and, for a
+// regular file, reads its first bytes so the data path is exercised too.
+// Failures are retried with a doubling delay until timeout has passed.
+func tryStatFile(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := 10 * time.Millisecond
+	for {
+		err := probeFile(path)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func probeFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	probes.Add(1)
+	defer probes.Add(-1)
+	if _, err := f.Read(make([]byte, 64)); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestFuseTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		want time.Duration
+	}{
+		{"zero", 0, 0},
+		{"unset", -1, time.Second},
+		{"set", 5 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fuseTimeout(tt.d, time.Second); got == nil || *got != tt.want {
+				t.Errorf("fuseTimeout(%v, 1s) = %v, want %v", tt.d, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAttrTimeoutZero mounts with -attrTimeout 0 and grows file.txt behind
+// the kernel's back: the next stat must show the new size.
+func TestAttrTimeoutZero(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	dir := mountTest(t, Config{Root: root, Options: &fs.Options{
+		AttrTimeout:  fuseTimeout(0, time.Second),
+		EntryTimeout: fuseTimeout(-1, time.Second),
+	}})
+	path := filepath.Join(dir, "file.txt")
+	for _, size := range []uint64{10, 20, 3} {
+		f := root.GetChild("file.txt").Operations().(*HelloFile)
+		in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_SIZE, Size: size}}
+		if errno := f.Setattr(context.Background(), nil, in, &fuse.AttrOut{}); errno != 0 {
+			t.Fatalf("Setattr(size %d) = %v", size, errno)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != int64(size) {
+			t.Errorf("stat after resizing to %d: size %d", size, fi.Size())
+		}
+	}
+}
+
+// TestMountNames mounts with the options buildOptions makes and finds the
+// -fsName and -name in /proc/self/mountinfo, as the source and the
+// fuse.NAME type.
+func TestMountNames(t *testing.T) {
+	source := t.TempDir()
+	tests := []struct {
+		name       string
+		set        func(o *Options)
+		wantSource string
+		wantType   string
+	}{
+		{"defaults", func(o *Options) {}, "hello-fuse", "fuse.fuse"},
+		{"source", func(o *Options) { o.Source = source }, source, "fuse.fuse"},
+		{"flags", func(o *Options) { o.Source, o.FsName, o.Name = source, "demo-src", "demo" }, "demo-src", "fuse.demo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &command{o: flagDefaults()}
+			tt.set(&c.o)
+			if err := c.buildOptions(); err != nil {
+				t.Fatal(err)
+			}
+			dir := mountTest(t, Config{Root: NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}), Options: c.opts})
+			f := mountinfo(t, dir)
+			i := slices.Index(f, "-")
+			if i < 0 || len(f) < i+3 {
+				t.Fatalf("mountinfo of %s: %q", dir, f)
+			}
+			if f[i+1] != tt.wantType || f[i+2] != tt.wantSource {
+				t.Errorf("mountinfo type %q, source %q, want %q, %q", f[i+1], f[i+2], tt.wantType, tt.wantSource)
+			}
+		})
+	}
+}
+
+// TestMountOptions validates -options, then mounts with the options
+// buildOptions makes from them: the kernel reports the flags on the mount
+// and the last fsname as its source, and read-only refuses writes. A typo
+// fails validation, naming the offending option.
+func TestMountOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    string
+		wantFlags  []string // in the mount's options in mountinfo
+		wantSource string
+		wantErr    string // a part of the validate error, or "" for none
+	}{
+		{name: "spaces trimmed", options: " ro , nosuid ,nodev", wantFlags: []string{"ro", "nosuid", "nodev"}, wantSource: "hello-fuse"},
+		{name: "last fsname", options: "fsname=first,noexec,fsname=second", wantFlags: []string{"rw", "noexec"}, wantSource: "second"},
+		{name: "typo", options: "ro,nosiud", wantErr: `"nosiud"`},
+		{name: "empty", options: "ro,,nodev", wantErr: "option 2 is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &command{o: flagDefaults()}
+			c.o.Options = tt.options
+			err := c.o.validate([]string{"/mnt"})
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() = %v, want an error with %q", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := c.buildOptions(); err != nil {
+				t.Fatal(err)
+			}
+			dir := mountTest(t, Config{Root: NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}), Options: c.opts})
+			f := mountinfo(t, dir)
+			i := slices.Index(f, "-")
+			if i < 0 || len(f) < i+4 {
+				t.Fatalf("mountinfo of %s: %q", dir, f)
+			}
+			// ro is on the superblock under -directMount, the rest on the mount
+			flags := slices.Concat(strings.Split(f[5], ","), strings.Split(f[i+3], ","))
+			for _, want := range tt.wantFlags {
+				if !slices.Contains(flags, want) {
+					t.Errorf("options %q and %q, want %s among them", f[5], f[i+3], want)
+				}
+			}
+			if f[i+2] != tt.wantSource {
+				t.Errorf("source %q, want %q", f[i+2], tt.wantSource)
+			}
+			err = os.WriteFile(filepath.Join(dir, "file.txt"), []byte("x"), 0)
+			if ro := slices.Contains(tt.wantFlags, "ro"); ro != errors.Is(err, syscall.EROFS) {
+				t.Errorf("write: %v, want EROFS: %v", err, ro)
+			}
+		})
+	}
+}
+
+// TestMountFlags mounts with the -mountFlags buildOptions turns into
+// options: noexec makes a script not executable, rdonly refuses writes, and
+// either shows on the mount. An unknown name or one contradicting
+// -options fails validation.
+func TestMountFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		flags     string
+		options   string
+		wantFlag  string // in mountinfo, or "" for the none case
+		wantErr   string // a part of the validate error, or "" for none
+		wantExec  bool
+		wantWrite bool
+	}{
+		{name: "none", wantExec: true, wantWrite: true},
+		{name: "hardening", flags: "nosuid, nodev, noexec", wantFlag: "noexec", wantWrite: true},
+		{name: "rdonly", flags: "rdonly", wantFlag: "ro", wantExec: true},
+		{name: "unknown", flags: "nosetuid", wantErr: `unknown flag "nosetuid"`},
+		{name: "contradicts", flags: "noexec", options: "exec", wantErr: "contradicts -options exec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &command{o: flagDefaults()}
+			c.o.MountFlags, c.o.Options = tt.flags, tt.options
+			err := c.o.validate([]string{"/mnt"})
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() = %v, want an error with %q", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := c.buildOptions(); err != nil {
+				t.Fatal(err)
+			}
+			script := []byte("#!/bin/sh\nexit 0\n")
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n"), "run.sh": script}, RootOptions{})
+			dir := mountTest(t, Config{Root: root, Options: c.opts})
+			f := mountinfo(t, dir)
+			i := slices.Index(f, "-")
+			if i < 0 || len(f) < i+4 {
+				t.Fatalf("mountinfo of %s: %q", dir, f)
+			}
+			flags := slices.Concat(strings.Split(f[5], ","), strings.Split(f[i+3], ","))
+			if tt.wantFlag != "" && !slices.Contains(flags, tt.wantFlag) {
+				t.Errorf("options %q and %q, want %s among them", f[5], f[i+3], tt.wantFlag)
+			}
+
+			// the mode is set behind the kernel's back, as chmod would fail
+			// on a read-only mount
+			sh := root.GetChild("run.sh").Operations().(*HelloFile)
+			in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_MODE, Mode: 0o755}}
+			if errno := sh.Setattr(context.Background(), nil, in, &fuse.AttrOut{}); errno != 0 {
+				t.Fatalf("Setattr(mode 0755) = %v", errno)
+			}
+			// access rather than exec: the child would wait on this process
+			// to serve run.sh while still forking from it
+			if err := syscall.Access(filepath.Join(dir, "run.sh"), unix.X_OK); (err == nil) != tt.wantExec {
+				t.Errorf("access run.sh X_OK: %v, want it executable: %v", err, tt.wantExec)
+			}
+			err = os.WriteFile(filepath.Join(dir, "file.txt"), []byte("x"), 0)
+			if (err == nil) != tt.wantWrite {
+				t.Errorf("write: %v, want it to succeed: %v", err, tt.wantWrite)
+			}
+		})
+	}
+}
+
+// TestQuiet runs -selfTest, a full mount, verify and unmount, with and
+// without -quiet in both log formats, capturing stdout: -quiet leaves it
+// empty.
+func TestQuiet(t *testing.T) {
+	skipUnlessMountable(t)
+	tests := []struct {
+		name      string
+		format    string
+		quiet     bool
+		wantEmpty bool
+	}{
+		{"text", "text", false, false},
+		{"text quiet", "text", true, true},
+		{"json", "json", false, false},
+		{"json quiet", "json", true, true},
+	}
+	defer func(stdout *os.File, logOut io.Writer) {
+		os.Stdout, logStdout, quiet, jsonLog = stdout, logOut, false, nil
+	}(os.Stdout, logStdout)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := flagDefaults()
+			o.SelfTest, o.DirectMount, o.Quiet, o.LogFormat = true, true, tt.quiet, tt.format
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.Stdout, logStdout = w, w
+			out := make(chan []byte)
+			go func() {
+				b, _ := io.ReadAll(r)
+				out <- b
+			}()
+			code := Main(&o, nil)
+			w.Close()
+			got := <-out
+			if code != ExitOK {
+				t.Fatalf("Main() = %d, want %d; stdout: %s", code, ExitOK, got)
+			}
+			if (len(got) == 0) != tt.wantEmpty {
+				t.Errorf("stdout = %q, want it empty: %v", got, tt.wantEmpty)
+			}
+		})
+	}
+}
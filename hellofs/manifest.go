@@ -0,0 +1,490 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"go.yaml.in/yaml/v3"
+)
+
+// manifestEntry is a file or directory declared in a manifest. An entry
+// with children, even an empty list, is a directory, one with a target a
+// symlink and one with fifo set a named pipe. A name can be a
+// slash-separated path, whose missing directories are created.
+type manifestEntry struct {
+	Name        string           `yaml:"name"`
+	Mode        string           `yaml:"mode"` // octal, e.g. "0644"
+	UID         *uint32          `yaml:"uid"`
+	GID         *uint32          `yaml:"gid"`
+	User        string           `yaml:"user"`  // name or id, instead of uid
+	Group       string           `yaml:"group"` // name or id, instead of gid
+	Content     *string          `yaml:"content"`
+	ContentFile string           `yaml:"contentFile"`
+	Target      *string          `yaml:"target"` // makes it a symlink
+	Fifo        bool             `yaml:"fifo"`   // makes it a named pipe
+	Children    *[]manifestEntry `yaml:"children"`
+
+	// override -attrTimeout and -entryTimeout for this entry, e.g. "0s"
+	// for a file whose content changes behind the kernel's back
+	AttrTimeout  string `yaml:"attrTimeout"`
+	EntryTimeout string `yaml:"entryTimeout"`
+	CachePolicy  string `yaml:"cachePolicy"` // files only, see cachePolicies
+	SHA256       string `yaml:"sha256"`      // files only, checked against the content
+}
+
+// manifestNode is a checked manifest entry, ready to be built.
+type manifestNode struct {
+	name     string
+	dir      bool
+	mode     uint32
+	owner    fuse.Owner // zero fields fall back to -uid and -gid, see rootID
+	content  []byte
+	target   *string // symlink target
+	fifo     bool
+	timeouts cacheTimeouts
+	cache    string // cachePolicy
+	children []manifestNode
+}
+
+// loadManifest reads a YAML or JSON manifest: a list of entries such as
+//
+//   - name: docs
+//     mode: "0755"
+//     uid: 1000
+//     group: staff
+//     children:
+//   - name: readme.txt
+//     mode: "0644"
+//     content: "hello\n"
+//   - name: logo.png
+//     contentFile: logo.png
+//   - name: latest
+//     target: docs/readme.txt
+//   - name: events
+//     fifo: true
+//   - name: status
+//     content: "up\n"
+//     attrTimeout: 0s
+//     entryTimeout: 1m
+//     cachePolicy: direct
+//
+// contentFile is read at load time, relative to the manifest's directory.
+// Entries without uid, gid, user or group get -uid and -gid. A file.txt
+// entry without content sets the mode and owner of the built-in file.
+// Symlink targets are not checked, they may dangle. Names over maxNameLen
+// bytes are refused, unless it is 0.
+func loadManifest(file string, maxNameLen int) ([]manifestNode, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return manifestList(file, doc.Content[0], maxNameLen)
+}
+
+// manifestList checks a list of entries, using the yaml nodes for the line
+// numbers in errors.
+func manifestList(file string, list *yaml.Node, maxNameLen int) ([]manifestNode, error) {
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s:%d: want a list of entries", file, list.Line)
+	}
+	var nodes []manifestNode
+	seen := map[string]int{}
+	for _, item := range list.Content {
+		var e manifestEntry
+		if err := item.Decode(&e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, item.Line, err)
+		}
+		if !validPath(e.Name) {
+			return nil, fmt.Errorf("%s:%d: invalid name %q", file, item.Line, e.Name)
+		}
+		if name := longName(e.Name, maxNameLen); name != "" {
+			return nil, fmt.Errorf("%s:%d: name %q is longer than -maxNameLen %d", file, item.Line, name, maxNameLen)
+		}
+		if line, ok := seen[e.Name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate name %q, first defined on line %d", file, item.Line, e.Name, line)
+		}
+		seen[e.Name] = item.Line
+		n := manifestNode{name: e.Name, dir: e.Children != nil, mode: 0644}
+		if n.dir {
+			n.mode = 0755
+		}
+		if e.Mode != "" {
+			m, err := strconv.ParseUint(e.Mode, 8, 32)
+			if err != nil || m&^07777 != 0 {
+				return nil, fmt.Errorf("%s:%d: invalid mode %q", file, item.Line, e.Mode)
+			}
+			n.mode = uint32(m)
+		}
+		switch {
+		case e.UID != nil && e.User != "":
+			return nil, fmt.Errorf("%s:%d: %s has both uid and user", file, item.Line, e.Name)
+		case e.UID != nil:
+			n.owner.Uid = ownerID(*e.UID)
+		case e.User != "":
+			id, err := lookupUser(e.User)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", file, item.Line, e.Name, err)
+			}
+			n.owner.Uid = ownerID(id)
+		}
+		switch {
+		case e.GID != nil && e.Group != "":
+			return nil, fmt.Errorf("%s:%d: %s has both gid and group", file, item.Line, e.Name)
+		case e.GID != nil:
+			n.owner.Gid = ownerID(*e.GID)
+		case e.Group != "":
+			id, err := lookupGroup(e.Group)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", file, item.Line, e.Name, err)
+			}
+			n.owner.Gid = ownerID(id)
+		}
+		for _, t := range []struct {
+			val string
+			dst **time.Duration
+		}{{e.AttrTimeout, &n.timeouts.attr}, {e.EntryTimeout, &n.timeouts.entry}} {
+			if t.val == "" {
+				continue
+			}
+			d, err := time.ParseDuration(t.val)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid timeout %q", file, item.Line, t.val)
+			}
+			*t.dst = &d
+		}
+		if e.CachePolicy != "" {
+			if n.dir || e.Target != nil || e.Fifo {
+				return nil, fmt.Errorf("%s:%d: cachePolicy only applies to files", file, item.Line)
+			}
+			if !slices.Contains(cachePolicies, e.CachePolicy) {
+				return nil, fmt.Errorf("%s:%d: invalid cachePolicy %q: must be one of %s", file, item.Line, e.CachePolicy, strings.Join(cachePolicies, ", "))
+			}
+			n.cache = e.CachePolicy
+		}
+		switch {
+		case e.Fifo && (n.dir || e.Target != nil || e.Content != nil || e.ContentFile != ""):
+			return nil, fmt.Errorf("%s:%d: fifo %s can't have children, a target or content", file, item.Line, e.Name)
+		case e.Fifo:
+			n.fifo = true
+		case e.Target != nil && (n.dir || e.Content != nil || e.ContentFile != ""):
+			return nil, fmt.Errorf("%s:%d: symlink %s can't have children or content", file, item.Line, e.Name)
+		case e.Target != nil && *e.Target == "":
+			return nil, fmt.Errorf("%s:%d: symlink %s has an empty target", file, item.Line, e.Name)
+		case e.Target != nil:
+			n.target = e.Target
+		case n.dir && (e.Content != nil || e.ContentFile != ""):
+			return nil, fmt.Errorf("%s:%d: %s has both children and content", file, item.Line, e.Name)
+		case e.Content != nil && e.ContentFile != "":
+			return nil, fmt.Errorf("%s:%d: %s has both content and contentFile", file, item.Line, e.Name)
+		case e.Content != nil:
+			n.content = []byte(*e.Content)
+		case e.ContentFile != "":
+			p := e.ContentFile
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(filepath.Dir(file), p)
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", file, item.Line, err)
+			}
+			n.content = b
+		}
+		if e.SHA256 != "" {
+			if n.dir || e.Target != nil || e.Fifo {
+				return nil, fmt.Errorf("%s:%d: sha256 only applies to files", file, item.Line)
+			}
+			want, err := parseSHA256(e.SHA256)
+			if err == nil {
+				err = checkSHA256(n.content, want)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", file, item.Line, e.Name, err)
+			}
+		}
+		if n.dir {
+			for i := 0; i+1 < len(item.Content); i += 2 {
+				if item.Content[i].Value == "children" {
+					children, err := manifestList(file, item.Content[i+1], maxNameLen)
+					if err != nil {
+						return nil, err
+					}
+					n.children = children
+				}
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// validPath reports whether p is a relative path of valid names.
+func validPath(p string) bool {
+	for name := range strings.SplitSeq(p, "/") {
+		if !validName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// addManifest builds the nodes of a manifest below parent and returns the
+// ones added directly to it. A directory that already exists is merged
+// into rather than skipped.
+func addManifest(ctx context.Context, root *HelloRoot, parent *fs.Inode, nodes []manifestNode) map[string]*fs.Inode {
+	added := map[string]*fs.Inode{}
+	for _, n := range nodes {
+		dir := parent
+		if i := strings.LastIndexByte(n.name, '/'); i >= 0 {
+			if dir = mkdirAll(ctx, root, parent, n.name[:i], added); dir == nil {
+				root.logger.Printf("manifest: a parent of /%s is not a directory, skipping", path.Join(parent.Path(nil), n.name))
+				continue
+			}
+			n.name = n.name[i+1:]
+		}
+		if dir == &root.Inode && n.name == "file.txt" && !n.dir && n.target == nil && !n.fifo && n.content == nil {
+			// an entry without content only sets the built-in file's
+			// mode and owner
+			if ch := dir.GetChild(n.name); ch != nil {
+				if f, ok := ch.Operations().(*HelloFile); ok {
+					f.data.Lock()
+					f.Attr.Mode, f.Attr.Owner = n.mode&^root.umask, n.owner
+					f.data.Unlock()
+					continue
+				}
+			}
+		}
+		if n.dir {
+			if ch := dir.GetChild(n.name); ch != nil && ch.IsDir() {
+				addManifest(ctx, root, ch, n.children)
+				continue
+			}
+		}
+		var ch *fs.Inode
+		switch {
+		case n.target != nil:
+			l := &Symlink{birth: born(), cacheTimeouts: n.timeouts, target: []byte(*n.target), owner: n.owner}
+			ch = parent.NewPersistentInode(ctx, l, fs.StableAttr{Mode: syscall.S_IFLNK, Ino: root.inos.alloc()})
+		case n.fifo:
+			f := &FifoNode{birth: born(), cacheTimeouts: n.timeouts, mode: n.mode &^ root.umask, owner: n.owner}
+			ch = parent.NewPersistentInode(ctx, f, fs.StableAttr{Mode: syscall.S_IFIFO, Ino: root.inos.alloc()})
+		case n.dir:
+			d := &SpecDir{birth: born(), cacheTimeouts: n.timeouts, mode: n.mode &^ root.umask, owner: n.owner}
+			ch = parent.NewPersistentInode(ctx, d, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: root.inos.alloc()})
+		default:
+			f := &HelloFile{
+				birth:          born(),
+				cacheTimeouts:  n.timeouts,
+				root:           root,
+				cache:          n.cache,
+				MemRegularFile: fs.MemRegularFile{Data: n.content, Attr: fuse.Attr{Mode: n.mode &^ root.umask, Owner: n.owner}},
+			}
+			ch = parent.NewPersistentInode(ctx, f, fs.StableAttr{Ino: root.inos.alloc()})
+		}
+		if !dir.AddChild(n.name, ch, false) {
+			root.logger.Printf("manifest: /%s already exists, skipping", path.Join(dir.Path(nil), n.name))
+			continue
+		}
+		if dir == parent {
+			added[n.name] = ch
+		}
+		addManifest(ctx, root, ch, n.children)
+	}
+	return added
+}
+
+// cloneManifest returns a copy of nodes with content of its own, to build
+// another tree from.
+func cloneManifest(nodes []manifestNode) []manifestNode {
+	nodes = slices.Clone(nodes)
+	for i := range nodes {
+		nodes[i].content = slices.Clone(nodes[i].content)
+		nodes[i].children = cloneManifest(nodes[i].children)
+	}
+	return nodes
+}
+
+// mkdirAll returns the directory rel below parent, creating what is
+// missing, or nil if part of it is not a directory. Directories created
+// directly in parent are recorded in added.
+func mkdirAll(ctx context.Context, root *HelloRoot, parent *fs.Inode, rel string, added map[string]*fs.Inode) *fs.Inode {
+	dir := parent
+	for name := range strings.SplitSeq(rel, "/") {
+		ch := dir.GetChild(name)
+		if ch == nil {
+			ch = dir.NewPersistentInode(ctx, &SpecDir{birth: born(), mode: 0755 &^ root.umask}, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: root.inos.alloc()})
+			dir.AddChild(name, ch, false)
+			if dir == parent {
+				added[name] = ch
+			}
+		} else if !ch.IsDir() {
+			return nil
+		}
+		dir = ch
+	}
+	return dir
+}
+
+// cacheTimeouts are a node's own attribute and entry timeouts; nil ones
+// keep the mount's.
+type cacheTimeouts struct {
+	attr, entry *time.Duration
+}
+
+func (t cacheTimeouts) cacheTimeouts() cacheTimeouts { return t }
+
+// kernelTimeout returns d as sent to the kernel. go-fuse replaces a zero
+// timeout with the mount's, so zero goes out as the shortest nonzero one.
+func kernelTimeout(d time.Duration) time.Duration {
+	if d == 0 {
+		return time.Nanosecond
+	}
+	return d
+}
+
+func (t cacheTimeouts) setAttrTimeout(out *fuse.AttrOut) {
+	if t.attr != nil {
+		out.SetTimeout(kernelTimeout(*t.attr))
+	}
+}
+
+// lookupChild answers a lookup of an existing child like go-fuse does, but
+// with the child's own timeouts.
+func lookupChild(ctx context.Context, parent *fs.Inode, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	ch := parent.GetChild(name)
+	if ch == nil {
+		return nil, syscall.ENOENT
+	}
+	if ga, ok := ch.Operations().(fs.NodeGetattrer); ok {
+		var a fuse.AttrOut
+		if ga.Getattr(ctx, nil, &a) == 0 {
+			out.Attr = a.Attr
+			out.SetAttrTimeout(a.Timeout())
+		}
+	}
+	if n, ok := ch.Operations().(interface{ cacheTimeouts() cacheTimeouts }); ok {
+		if t := n.cacheTimeouts(); t.entry != nil {
+			out.SetEntryTimeout(kernelTimeout(*t.entry))
+		}
+	}
+	return ch, 0
+}
+
+// reloadManifest re-reads the manifest and replaces the entries it added
+// to the root. Handles opened on the old entries keep reading the old
+// content. On error the previous entries are left in place.
+func (r *HelloRoot) reloadManifest(file string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		nodes, err := loadManifest(file, stateOf(&r.Inode).maxNameLen)
+		if err == nil && r.caseInsensitive {
+			err = caseCollision(nodes)
+		}
+		if err != nil {
+			return fmt.Errorf("manifest reload: %w", err)
+		}
+		for name, ch := range r.manifestInodes {
+			if r.GetChild(name) != ch {
+				continue
+			}
+			r.RmChild(name)
+			forgetTree(ch)
+			r.NotifyDelete(name, ch)
+		}
+		r.manifestInodes = addManifest(ctx, r, &r.Inode, nodes)
+		for name := range r.manifestInodes {
+			r.NotifyEntry(name)
+		}
+		return nil
+	}
+}
+
+// forgetTree drops the persistent references of n and everything below it.
+func forgetTree(n *fs.Inode) {
+	for _, ch := range n.Children() {
+		forgetTree(ch)
+	}
+	n.ForgetPersistent()
+}
+
+// parseFileFlag reads a -file value such as
+//
+//	name=greeting.txt:mode=0600:content=hello
+//
+// into a manifest file. content takes the rest of the value, colons
+// included, so it must come last; contentFile is read right away.
+func parseFileFlag(s string) (manifestNode, error) {
+	n := manifestNode{mode: 0644}
+	var hasContent bool
+	for s != "" {
+		var field string
+		if strings.HasPrefix(s, "content=") {
+			field, s = s, ""
+		} else {
+			field, s, _ = strings.Cut(s, ":")
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return n, fmt.Errorf("%q: want key=value", field)
+		}
+		switch key {
+		case "name":
+			n.name = val
+		case "mode":
+			m, err := strconv.ParseUint(val, 8, 32)
+			if err != nil || m&^07777 != 0 {
+				return n, fmt.Errorf("invalid mode %q", val)
+			}
+			n.mode = uint32(m)
+		case "content", "contentFile":
+			if hasContent {
+				return n, fmt.Errorf("content given more than once")
+			}
+			hasContent = true
+			if key == "content" {
+				n.content = []byte(val)
+				break
+			}
+			b, err := os.ReadFile(val)
+			if err != nil {
+				return n, err
+			}
+			n.content = b
+		default:
+			return n, fmt.Errorf("unknown key %q: want name, mode, content or contentFile", key)
+		}
+	}
+	if !validName(n.name) {
+		return n, fmt.Errorf("invalid name %q", n.name)
+	}
+	return n, nil
+}
+
+// parseSymlinkFlag reads a -symlink value, NAME=TARGET.
+func parseSymlinkFlag(s string) (manifestNode, error) {
+	name, target, ok := strings.Cut(s, "=")
+	if !ok || target == "" {
+		return manifestNode{}, fmt.Errorf("want NAME=TARGET")
+	}
+	if !validName(name) {
+		return manifestNode{}, fmt.Errorf("invalid name %q", name)
+	}
+	return manifestNode{name: name, target: &target}, nil
+}
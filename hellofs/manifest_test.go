@@ -0,0 +1,133 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// TestManifestTimeouts grows two manifest files behind the kernel's back
+// on a mount caching attributes for an hour: the one with attrTimeout 0s
+// shows its new size at once, the one with 1h keeps the cached size.
+func TestManifestTimeouts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	manifest := "- name: fresh.txt\n  content: a\n  attrTimeout: 0s\n- name: cached.txt\n  content: a\n  attrTimeout: 1h\n"
+	if err := os.WriteFile(file, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := loadManifest(file, DefaultMaxNameLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.manifest = nodes
+	hour := time.Hour
+	dir := mountTest(t, Config{Root: root, Options: &fs.Options{AttrTimeout: &hour, EntryTimeout: &hour}})
+
+	tests := []struct {
+		name string
+		want int64
+	}{
+		{"fresh.txt", 5},
+		{"cached.txt", 1},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if fi, err := os.Stat(path); err != nil || fi.Size() != 1 {
+			t.Fatalf("stat %s: %v, want size 1", tt.name, err)
+		}
+		f := root.GetChild(tt.name).Operations().(*HelloFile)
+		in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_SIZE, Size: 5}}
+		if errno := f.Setattr(context.Background(), nil, in, &fuse.AttrOut{}); errno != 0 {
+			t.Fatalf("Setattr(%s) = %v", tt.name, errno)
+		}
+		// 0s goes out as 1ns, which the kernel rounds up to a jiffy
+		time.Sleep(50 * time.Millisecond)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != tt.want {
+			t.Errorf("stat %s after growing it: size %d, want %d", tt.name, fi.Size(), tt.want)
+		}
+	}
+}
+
+// TestManifestFifo mounts a manifest named pipe: stat reports it as one,
+// with its mode.
+func TestManifestFifo(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(file, []byte("- name: events\n  fifo: true\n  mode: \"0620\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := loadManifest(file, DefaultMaxNameLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.manifest = nodes
+	dir := mountRoot(t, root)
+	fi, err := os.Stat(filepath.Join(dir, "events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != os.ModeNamedPipe || fi.Mode().Perm() != 0o620 {
+		t.Errorf("events mode = %v, want a named pipe with 0620", fi.Mode())
+	}
+}
+
+// TestManifestOwners mounts entries owned by root by name, by another
+// user by number and by nobody in particular: each stats with its own
+// owner and mode, the last with the mount's -uid and -gid.
+func TestManifestOwners(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	manifest := `- name: root.txt
+  user: root
+  group: root
+  mode: "0600"
+- name: user.txt
+  uid: 1234
+  gid: 5678
+  mode: "0640"
+- name: default.txt
+  mode: "0644"
+`
+	if err := os.WriteFile(file, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := loadManifest(file, DefaultMaxNameLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.manifest = nodes
+	dir := mountTest(t, Config{Root: root, Options: &fs.Options{UID: 4242, GID: 4343}, rootOwners: hasRootOwner(nodes)})
+
+	tests := []struct {
+		name     string
+		uid, gid uint32
+		mode     os.FileMode
+	}{
+		{"root.txt", 0, 0, 0o600},
+		{"user.txt", 1234, 5678, 0o640},
+		{"default.txt", 4242, 4343, 0o644},
+	}
+	for _, tt := range tests {
+		fi, err := os.Stat(filepath.Join(dir, tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != tt.uid || st.Gid != tt.gid || fi.Mode().Perm() != tt.mode {
+			t.Errorf("%s: %d:%d %v, want %d:%d %v", tt.name, st.Uid, st.Gid, fi.Mode().Perm(), tt.uid, tt.gid, tt.mode)
+		}
+	}
+}
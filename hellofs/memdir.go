@@ -0,0 +1,280 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// MemDir is a directory made through the mount. It and the root hold
+// in-memory files and directories that can be created and removed like on
+// any filesystem, unless -readOnly is set. New entries get automatic inode
+// numbers from root.inos.
+type MemDir struct {
+	fs.Inode
+	birth
+
+	root   *HelloRoot
+	mode   uint32
+	owner  fuse.Owner // restored by -tarFile; zero ids are the -uid and -gid
+	xattrs xattrStore // with -enableAcl, its ACLs
+}
+
+func (d *MemDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = d.mode
+	if mode, ok := dirACLMode(d.root, &d.xattrs); ok {
+		out.Mode = out.Mode&^0777 | mode
+	}
+	out.Owner = d.owner
+	return 0
+}
+
+func (d *MemDir) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "create", &d.Inode)(&errno)
+	return memCreate(ctx, d.root, &d.Inode, name, flags, mode, out)
+}
+
+func (d *MemDir) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "mkdir", &d.Inode)(&errno)
+	return memMkdir(ctx, d.root, &d.Inode, name, mode, out)
+}
+
+func (d *MemDir) Unlink(ctx context.Context, name string) (errno syscall.Errno) {
+	defer startOp(ctx, "unlink", &d.Inode)(&errno)
+	return memUnlink(d.root, &d.Inode, name)
+}
+
+func (d *MemDir) Rmdir(ctx context.Context, name string) (errno syscall.Errno) {
+	defer startOp(ctx, "rmdir", &d.Inode)(&errno)
+	return memRmdir(d.root, &d.Inode, name)
+}
+
+func (d *MemDir) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "rename", &d.Inode)(&errno)
+	return memRename(d.root, &d.Inode, name, newParent, newName, flags)
+}
+
+func (d *MemDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*MemDir)(nil))
+	_ = (fs.NodeGetattrer)((*MemDir)(nil))
+	_ = (fs.NodeCreater)((*MemDir)(nil))
+	_ = (fs.NodeMkdirer)((*MemDir)(nil))
+	_ = (fs.NodeUnlinker)((*MemDir)(nil))
+	_ = (fs.NodeRmdirer)((*MemDir)(nil))
+	_ = (fs.NodeRenamer)((*MemDir)(nil))
+)
+
+func (r *HelloRoot) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "create", &r.Inode)(&errno)
+	return memCreate(ctx, r, &r.Inode, name, flags, mode, out)
+}
+
+func (r *HelloRoot) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "mkdir", &r.Inode)(&errno)
+	return memMkdir(ctx, r, &r.Inode, name, mode, out)
+}
+
+func (r *HelloRoot) Unlink(ctx context.Context, name string) (errno syscall.Errno) {
+	defer startOp(ctx, "unlink", &r.Inode)(&errno)
+	return memUnlink(r, &r.Inode, name)
+}
+
+func (r *HelloRoot) Rmdir(ctx context.Context, name string) (errno syscall.Errno) {
+	defer startOp(ctx, "rmdir", &r.Inode)(&errno)
+	return memRmdir(r, &r.Inode, name)
+}
+
+func (r *HelloRoot) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "rename", &r.Inode)(&errno)
+	return memRename(r, &r.Inode, name, newParent, newName, flags)
+}
+
+var (
+	_ = (fs.NodeCreater)((*HelloRoot)(nil))
+	_ = (fs.NodeMkdirer)((*HelloRoot)(nil))
+	_ = (fs.NodeUnlinker)((*HelloRoot)(nil))
+	_ = (fs.NodeRmdirer)((*HelloRoot)(nil))
+	_ = (fs.NodeRenamer)((*HelloRoot)(nil))
+)
+
+func memCreate(ctx context.Context, root *HelloRoot, dir *fs.Inode, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	if root.readOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+	if dir.GetChild(name) != nil {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	if errno := dirFull(dir, false); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	mode, acls := inheritACL(root, dir, mode, false)
+	f := &HelloFile{
+		birth:          born(),
+		root:           root,
+		MemRegularFile: fs.MemRegularFile{Attr: fuse.Attr{Mode: mode}},
+	}
+	if acls != nil {
+		// the inherited ACL takes the place of -defaultAcl
+		f.xattrs.attrs, f.xattrs.seeded = acls, true
+	}
+	ch := dir.NewPersistentInode(ctx, f, fs.StableAttr{Mode: syscall.S_IFREG, Ino: root.inos.alloc()})
+	dir.AddChild(name, ch, false)
+	out.Mode = fuse.S_IFREG | f.Attr.Mode
+	return ch, nil, 0, 0
+}
+
+func memMkdir(ctx context.Context, root *HelloRoot, dir *fs.Inode, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if root.readOnly {
+		return nil, syscall.EROFS
+	}
+	if dir.GetChild(name) != nil {
+		return nil, syscall.EEXIST
+	}
+	if errno := dirFull(dir, true); errno != 0 {
+		return nil, errno
+	}
+	mode, acls := inheritACL(root, dir, mode, true)
+	d := &MemDir{birth: born(), root: root, mode: mode}
+	d.xattrs.attrs = acls
+	ch := dir.NewPersistentInode(ctx, d, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: root.inos.alloc()})
+	dir.AddChild(name, ch, false)
+	out.Mode = fuse.S_IFDIR | d.mode
+	return ch, 0
+}
+
+// memUnlink removes a file, symlink or other non-directory. go-fuse drops
+// the name once it returns; the node itself goes when its last name does
+// and nothing has it open.
+func memUnlink(root *HelloRoot, dir *fs.Inode, name string) syscall.Errno {
+	if root.readOnly {
+		return syscall.EROFS
+	}
+	ch := dir.GetChild(name)
+	switch {
+	case ch == nil:
+		return syscall.ENOENT
+	case ch.IsDir():
+		return syscall.EISDIR
+	}
+	dropName(ch)
+	return 0
+}
+
+// dropName is called when one of the names of ch goes away.
+func dropName(ch *fs.Inode) {
+	if f, ok := ch.Operations().(*HelloFile); ok && f.links.Load() > 1 {
+		f.links.Add(^uint32(0))
+		return
+	}
+	ch.ForgetPersistent()
+}
+
+// memRmdir removes an empty directory.
+func memRmdir(root *HelloRoot, dir *fs.Inode, name string) syscall.Errno {
+	if root.readOnly {
+		return syscall.EROFS
+	}
+	ch := dir.GetChild(name)
+	if ch == nil {
+		return syscall.ENOENT
+	}
+	if errno := removableDir(ch); errno != 0 {
+		return errno
+	}
+	ch.ForgetPersistent()
+	return 0
+}
+
+// removableDir checks that ch is an empty directory that may be removed.
+// Only directories whose entries all exist as children can be told apart
+// from full ones that generate their entries on lookup, so others, like
+// cas/ or proc/, can't be removed.
+func removableDir(ch *fs.Inode) syscall.Errno {
+	switch ch.Operations().(type) {
+	case *MemDir, *SpecDir:
+	default:
+		if !ch.IsDir() {
+			return syscall.ENOTDIR
+		}
+		return syscall.EPERM
+	}
+	if len(ch.Children()) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	return 0
+}
+
+// renameNoReplace is the FUSE rename flag; go-fuse only names
+// RENAME_EXCHANGE.
+const renameNoReplace = 0x1
+
+// memRename checks a rename and drops a replaced target; go-fuse moves or
+// swaps the names once it returns. The node keeps its inode number, so
+// open handles stay valid. Only the root and MemDirs take new entries.
+func memRename(root *HelloRoot, dir *fs.Inode, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if root.readOnly {
+		return syscall.EROFS
+	}
+	switch newParent.(type) {
+	case *HelloRoot, *MemDir:
+	default:
+		return syscall.EPERM
+	}
+	if flags&^(renameNoReplace|fs.RENAME_EXCHANGE) != 0 || flags == renameNoReplace|fs.RENAME_EXCHANGE {
+		return syscall.EINVAL
+	}
+	newDir := newParent.EmbeddedInode()
+	src := dir.GetChild(name)
+	if src == nil {
+		return syscall.ENOENT
+	}
+	if src.IsDir() {
+		// a directory can't move below itself
+		for p := newDir; p != nil; _, p = p.Parent() {
+			if p == src {
+				return syscall.EINVAL
+			}
+		}
+	}
+	dst := newDir.GetChild(newName)
+	switch {
+	case flags&fs.RENAME_EXCHANGE != 0:
+		if dst == nil {
+			return syscall.ENOENT
+		}
+		if dst.IsDir() {
+			for p := dir; p != nil; _, p = p.Parent() {
+				if p == dst {
+					return syscall.EINVAL
+				}
+			}
+		}
+		return 0
+	case dst == nil:
+		if errno := dirFull(newDir, src.IsDir()); dir != newDir && errno != 0 {
+			return errno
+		}
+		return 0
+	case flags&renameNoReplace != 0:
+		return syscall.EEXIST
+	case src.IsDir() && !dst.IsDir():
+		return syscall.ENOTDIR
+	case !src.IsDir() && dst.IsDir():
+		return syscall.EISDIR
+	case dst.IsDir():
+		if errno := removableDir(dst); errno != 0 {
+			return errno
+		}
+	}
+	dropName(dst)
+	return 0
+}
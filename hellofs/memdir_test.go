@@ -0,0 +1,96 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// memTree builds a root without mounting it, holding file.txt with the
+// hard link link.txt, other.txt, and the directories full/sub, full/f and
+// empty.
+func memTree(t *testing.T) *HelloRoot {
+	t.Helper()
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n"), "other.txt": []byte("other\n")}, RootOptions{})
+	root.aliases = []string{"link.txt"}
+	fs.NewNodeFS(root, &fs.Options{})
+	ctx := context.Background()
+	var out fuse.EntryOut
+	full, errno := memMkdir(ctx, root, &root.Inode, "full", 0755, &out)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := memMkdir(ctx, root, full, "sub", 0755, &out); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, _, _, errno := memCreate(ctx, root, full, "f", 0, 0644, &out); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := memMkdir(ctx, root, &root.Inode, "empty", 0755, &out); errno != 0 {
+		t.Fatal(errno)
+	}
+	return root
+}
+
+func TestMemRename(t *testing.T) {
+	tests := []struct {
+		name  string
+		from  string // in the root
+		toDir string // the root if empty
+		to    string
+		flags uint32
+		want  syscall.Errno
+	}{
+		{name: "new name", from: "other.txt", to: "new.txt"},
+		{name: "replace file", from: "other.txt", to: "file.txt"},
+		{name: "into a directory", from: "other.txt", toDir: "full", to: "g"},
+		{name: "replace empty directory", from: "full", to: "empty"},
+		{name: "missing", from: "nope", to: "new.txt", want: syscall.ENOENT},
+		{name: "file over directory", from: "other.txt", to: "empty", want: syscall.EISDIR},
+		{name: "directory over file", from: "empty", to: "other.txt", want: syscall.ENOTDIR},
+		{name: "over full directory", from: "empty", to: "full", want: syscall.ENOTEMPTY},
+		{name: "below itself", from: "full", toDir: "full/sub", to: "x", want: syscall.EINVAL},
+		{name: "noreplace new", from: "other.txt", to: "new.txt", flags: renameNoReplace},
+		{name: "noreplace existing", from: "other.txt", to: "file.txt", flags: renameNoReplace, want: syscall.EEXIST},
+		{name: "exchange", from: "other.txt", to: "empty", flags: fs.RENAME_EXCHANGE},
+		{name: "exchange missing", from: "other.txt", to: "new.txt", flags: fs.RENAME_EXCHANGE, want: syscall.ENOENT},
+		{name: "exchange and noreplace", from: "other.txt", to: "file.txt", flags: fs.RENAME_EXCHANGE | renameNoReplace, want: syscall.EINVAL},
+		{name: "unknown flag", from: "other.txt", to: "new.txt", flags: 0x8, want: syscall.EINVAL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := memTree(t)
+			newDir := &root.Inode
+			if tt.toDir != "" {
+				for _, name := range strings.Split(tt.toDir, "/") {
+					newDir = newDir.GetChild(name)
+				}
+			}
+			if got := memRename(root, &root.Inode, tt.from, newDir.Operations(), tt.to, tt.flags); got != tt.want {
+				t.Errorf("memRename(%q, %q, %q, %#x) = %v, want %v", tt.from, tt.toDir, tt.to, tt.flags, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMemRenameHardLink replaces one name of a hard-linked file: the file
+// keeps its other name and inode, with one link fewer.
+func TestMemRenameHardLink(t *testing.T) {
+	root := memTree(t)
+	f := root.GetChild("file.txt").Operations().(*HelloFile)
+	if errno := memRename(root, &root.Inode, "other.txt", root, "link.txt", 0); errno != 0 {
+		t.Fatalf("memRename over link.txt = %v", errno)
+	}
+	if n := f.links.Load(); n != 1 {
+		t.Errorf("file.txt has %d links after its other name was replaced, want 1", n)
+	}
+	if ch := root.GetChild("file.txt"); ch == nil || ch.StableAttr().Ino != 2 {
+		t.Errorf("file.txt = %v after the rename, want inode 2", ch)
+	}
+}
@@ -0,0 +1,71 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// fuseMetrics counts node operations and their latencies for Prometheus.
+// It is only set up with -metricsAddr, so without it the ops pay nothing.
+type fuseMetrics struct {
+	ops     *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+	mounted prometheus.Gauge
+}
+
+// setupMetrics registers the metrics, which m.hook feeds, and serves them
+// at /metrics on addr. The returned function closes the listener.
+func setupMetrics(addr string) (*fuseMetrics, func(), error) {
+	start := time.Now()
+	reg := prometheus.NewRegistry()
+	m := &fuseMetrics{
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "hellofuse_ops_total",
+			Help: "Node operations by operation name and result.",
+		}, []string{"op", "errno"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "hellofuse_op_duration_seconds",
+			Help:    "Latency of node operations by operation name.",
+			Buckets: prometheus.ExponentialBuckets(1e-5, 4, 10),
+		}, []string{"op"}),
+		mounted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "hellofuse_mounted",
+			Help: "Number of filesystems mounted by the process.",
+		}),
+	}
+	reg.MustRegister(m.ops, m.latency, m.mounted,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "hellofuse_uptime_seconds",
+			Help: "Seconds since the process started.",
+		}, func() float64 { return time.Since(start).Seconds() }),
+	)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return m, func() { srv.Close() }, nil
+}
+
+func (m *fuseMetrics) hook(ctx context.Context, op, path string) func(opResult) {
+	start := time.Now()
+	return func(r opResult) {
+		m.latency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		errno := "OK"
+		if r.errno != 0 {
+			errno = r.errno.Error()
+		}
+		m.ops.WithLabelValues(op, errno).Inc()
+	}
+}
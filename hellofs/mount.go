@@ -0,0 +1,360 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// transientMountErr reports whether a failed mount is worth retrying, e.g.
+// because a previous instance is still detaching from the mountpoint.
+func transientMountErr(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR)
+}
+
+// allowOtherRefused reports whether a mount with allow_other failed for
+// lack of permission to use it.
+func allowOtherRefused(err error, mo *fuse.MountOptions) bool {
+	if !mo.AllowOther && !slices.Contains(mo.Options, "allow_other") {
+		return false
+	}
+	return errors.Is(err, syscall.EPERM) || strings.Contains(err.Error(), "user_allow_other")
+}
+
+// withoutAllowOther returns a copy of mo with allow_other dropped.
+func withoutAllowOther(mo *fuse.MountOptions) *fuse.MountOptions {
+	c := *mo
+	c.AllowOther = false
+	c.Options = slices.DeleteFunc(slices.Clone(mo.Options), func(o string) bool { return o == "allow_other" })
+	return &c
+}
+
+// newServer is fuse.NewServer, swapped out by tests to fake mount errors.
+var newServer = fuse.NewServer
+
+// mountWithRetry is fs.Mount of cfg.Root on dir, retrying transient
+// failures up to cfg.MountRetries times with exponential backoff starting
+// at cfg.MountRetryBackoff. No retry is started that would end after
+// deadline, or once ctx is cancelled. The tree is built once and reused,
+// so OnAdd only runs for the first attempt. With
+// cfg.AllowOtherBestEffort, a refused allow_other is dropped and the
+// mount tried again at once, without counting as a retry.
+func mountWithRetry(ctx context.Context, dir string, cfg *Config, deadline time.Time) (*fuse.Server, error) {
+	opts := cfg.Options
+	rawFS := fs.NewNodeFS(cfg.Root, opts)
+	if opts.EnableLocks {
+		rawFS = &lockReleaser{RawFileSystem: rawFS}
+	}
+	if cfg.rootOwners {
+		rawFS = &rootOwners{RawFileSystem: rawFS}
+	}
+	if cfg.MaxOpenFiles > 0 {
+		rawFS = &handleLimiter{RawFileSystem: rawFS, max: int64(cfg.MaxOpenFiles)}
+	}
+	if cfg.MaxNameLen > 0 {
+		rawFS = &nameLimiter{RawFileSystem: rawFS, max: cfg.MaxNameLen}
+	}
+	if cfg.drain != nil {
+		rawFS = &drainer{RawFileSystem: rawFS, state: cfg.drain}
+	}
+	if cfg.DrainWrites {
+		rawFS = &writeRefuser{RawFileSystem: rawFS, stopping: &cfg.Control.stopping}
+	}
+	retries, backoff := cfg.MountRetries, cfg.MountRetryBackoff
+	mo := &opts.MountOptions
+	for attempt := 1; ; attempt++ {
+		server, err := newServer(rawFS, dir, mo)
+		if err == nil {
+			go server.Serve()
+			if err := server.WaitMount(); err != nil {
+				return nil, err
+			}
+			return server, nil
+		}
+		if cfg.AllowOtherBestEffort && allowOtherRefused(err, mo) {
+			logError("allow_other_dropped", fmt.Sprintf("Warning: mount with -allowOther refused (%v), mounting without it; only the mounting user gets in", err), "mountpoint", dir, "error", err)
+			mo = withoutAllowOther(mo)
+			attempt--
+			continue
+		}
+		text := fmt.Sprintf("Mount attempt %d: %v", attempt, err)
+		if hint := failureHint(err, dir, true, mo); hint != "" {
+			text += "\n" + hint
+		}
+		logError("mount_attempt_failed", text, "mountpoint", dir, "attempt", attempt, "error", err)
+		if !transientMountErr(err) || attempt > retries || time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// checkMountpoint verifies that mountpoint is a directory, creating it
+// first if create is set.
+func checkMountpoint(mountpoint string, create bool) error {
+	fi, err := os.Stat(mountpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return fmt.Errorf("directory %s does not exist; create it or pass -createMountpoint", mountpoint)
+		}
+		return os.MkdirAll(mountpoint, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s exists and is not a directory", mountpoint)
+	}
+	return nil
+}
+
+// errMounted is returned by preflightMountpoint when something is already
+// mounted at the mountpoint.
+var errMounted = errors.New("is already a mountpoint")
+
+// preflightMountpoint refuses to mount where it would hide something: on
+// top of another mount, or, unless force is set, over a directory that
+// has entries.
+func preflightMountpoint(mountpoint string, force bool) error {
+	abs, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	parent, err := os.Stat(filepath.Dir(abs))
+	if err != nil {
+		return err
+	}
+	// the root of a mount is on a different device than its parent
+	if abs == "/" || fi.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev {
+		return fmt.Errorf("%s %w", mountpoint, errMounted)
+	}
+	if force {
+		return nil
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if names, _ := f.Readdirnames(1); len(names) > 0 {
+		return fmt.Errorf("%s is not empty; pass -force to mount over it", mountpoint)
+	}
+	return nil
+}
+
+// liveMounts holds the mountpoints Run is serving, for detachMount.
+var liveMounts sync.Map
+
+// unmountOnPanic is deferred by goroutines that run while the mount is
+// up, and called by node methods without Config.RecoverPanics. A panic
+// there kills the process without going through Run's cleanup, leaving a
+// dangling mount that fails the next run with EBUSY, so the mount is
+// detached first and the panic resumed.
+func unmountOnPanic() {
+	if p := recover(); p != nil {
+		detachMount()
+		panic(p)
+	}
+}
+
+// detachMount lazily unmounts the live mounts. Unlike unmount it doesn't
+// wait for the servers, one of which may be the goroutine that panicked.
+func detachMount() {
+	liveMounts.Range(func(k, _ any) bool {
+		if _, ok := liveMounts.LoadAndDelete(k); !ok {
+			return true
+		}
+		mp := k.(string)
+		if err := forceUnmount(mp); err != nil {
+			logError("unmount_failed", fmt.Sprintf("Failed to detach %s: %v", mp, err), "mountpoint", mp, "error", err)
+		}
+		return true
+	})
+}
+
+// mountOptions lists the -options fusermount accepts, with whether they
+// take a value and, if so, whether it must be a number. A direct mount
+// passes them to the kernel as they are, which takes fewer: the atime
+// options, allow_root and auto_unmount fail there with EINVAL. fsname and
+// subtype never get there, buildOptions makes them -fsName and -name.
+var mountOptions = map[string]string{
+	"ro": "", "rw": "",
+	"nosuid": "", "suid": "", "nodev": "", "dev": "", "noexec": "", "exec": "",
+	"sync": "", "async": "", "dirsync": "",
+	"atime": "", "noatime": "", "diratime": "", "nodiratime": "",
+	"relatime": "", "norelatime": "", "strictatime": "", "nostrictatime": "",
+	"lazytime": "", "nolazytime": "",
+	"default_permissions": "", "allow_other": "", "allow_root": "", "auto_unmount": "",
+	"max_read": "number", "blksize": "number",
+	"fsname": "string", "subtype": "string",
+	"context": "string", "fscontext": "string", "defcontext": "string", "rootcontext": "string",
+}
+
+// parseMountOptions splits a comma-separated -options value, trimming
+// spaces. Empty and, unless allowUnknown is set, unknown options are
+// errors. An option given twice is reported in warnings and only its last
+// occurrence kept.
+func parseMountOptions(s string, allowUnknown bool) (options, warnings []string, err error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil, nil
+	}
+	var toks []string
+	last := map[string]int{}
+	for i, tok := range strings.Split(s, ",") {
+		tok = strings.TrimSpace(tok)
+		if tok == "" {
+			return nil, nil, fmt.Errorf("option %d is empty", i+1)
+		}
+		key, val, hasVal := strings.Cut(tok, "=")
+		if kind, ok := mountOptions[key]; ok {
+			switch {
+			case kind == "" && hasVal:
+				return nil, nil, fmt.Errorf("%q: %s takes no value", tok, key)
+			case kind != "" && (!hasVal || val == ""):
+				return nil, nil, fmt.Errorf("%q: %s needs a value", tok, key)
+			case kind == "number":
+				if _, err := strconv.ParseUint(val, 10, 32); err != nil {
+					return nil, nil, fmt.Errorf("%q: %s must be a number", tok, key)
+				}
+			}
+		} else if !allowUnknown {
+			return nil, nil, fmt.Errorf("%q: unknown option %s; pass -allowUnknownOptions to use it anyway", tok, key)
+		}
+		if _, ok := last[key]; ok {
+			warnings = append(warnings, fmt.Sprintf("%s given more than once, using %q", key, tok))
+		}
+		last[key] = len(toks)
+		toks = append(toks, tok)
+	}
+	for i, tok := range toks {
+		if key, _, _ := strings.Cut(tok, "="); last[key] == i {
+			options = append(options, tok)
+		}
+	}
+	return options, warnings, nil
+}
+
+// MountFlagNames maps the -mountFlags names to the -options they stand
+// for and the option undoing each, which may not be combined with it.
+var MountFlagNames = map[string]struct{ option, opposite string }{
+	"ro":         {"ro", "rw"},
+	"rdonly":     {"ro", "rw"},
+	"nosuid":     {"nosuid", "suid"},
+	"nodev":      {"nodev", "dev"},
+	"noexec":     {"noexec", "exec"},
+	"sync":       {"sync", "async"},
+	"dirsync":    {"dirsync", ""},
+	"noatime":    {"noatime", "atime"},
+	"nodiratime": {"nodiratime", "diratime"},
+}
+
+// addMountFlags adds the options named by a comma-separated -mountFlags
+// value to options, the parsed -options, unless already there. Unknown
+// names and flags contradicting an option are errors.
+func addMountFlags(s string, options []string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return options, nil
+	}
+	for tok := range strings.SplitSeq(s, ",") {
+		tok = strings.TrimSpace(tok)
+		f, ok := MountFlagNames[tok]
+		if !ok {
+			names := slices.Sorted(maps.Keys(MountFlagNames))
+			return nil, fmt.Errorf("unknown flag %q; known flags are %s", tok, strings.Join(names, ", "))
+		}
+		if f.opposite != "" && slices.Contains(options, f.opposite) {
+			return nil, fmt.Errorf("%s contradicts -options %s", tok, f.opposite)
+		}
+		if !slices.Contains(options, f.option) {
+			options = append(options, f.option)
+		}
+	}
+	return options, nil
+}
+
+// maxReadAheadLimit is the most readahead a FUSE mount gets from the
+// Linux kernel; go-fuse sends at most what the kernel offers.
+const maxReadAheadLimit = 128 * 1024
+
+// kernelMaxWrite is the largest request the kernel accepts: go-fuse's
+// MAX_KERNEL_WRITE, lowered to what /proc/sys/fs/fuse/max_pages_limit
+// allows on kernels that have it.
+func kernelMaxWrite() int {
+	limit := fuse.MAX_KERNEL_WRITE
+	b, err := os.ReadFile("/proc/sys/fs/fuse/max_pages_limit")
+	if err != nil {
+		return limit
+	}
+	if pages, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pages > 0 {
+		limit = min(limit, pages*os.Getpagesize())
+	}
+	return limit
+}
+
+// checkIOSizes vets -maxWrite and -maxReadAhead before they reach the
+// kernel, which would clamp or reject them without saying so. It returns
+// the sizes to mount with and warnings for each adjustment. Zero keeps
+// the default, 128K for maxWrite and the kernel's for readahead.
+func checkIOSizes(maxWrite, maxReadAhead int) (int, int, []string, error) {
+	if maxWrite < 0 {
+		return 0, 0, nil, fmt.Errorf("-maxWrite %d: must not be negative; 0 uses the default of %d", maxWrite, effectiveMaxWrite(0))
+	}
+	if maxReadAhead < 0 {
+		return 0, 0, nil, fmt.Errorf("-maxReadAhead %d: must not be negative; 0 uses the kernel's default", maxReadAhead)
+	}
+	var warnings []string
+	page := os.Getpagesize()
+	if maxWrite > 0 {
+		w := maxWrite
+		switch limit := kernelMaxWrite(); {
+		case w > limit:
+			w = limit
+			warnings = append(warnings, fmt.Sprintf("-maxWrite %d is more than the kernel takes, using %d", maxWrite, w))
+		case w < page:
+			w = page
+			warnings = append(warnings, fmt.Sprintf("-maxWrite %d is less than a page, using %d", maxWrite, w))
+		case w%page != 0:
+			w -= w % page
+			warnings = append(warnings, fmt.Sprintf("-maxWrite %d is not a multiple of the %d byte page size, using %d", maxWrite, page, w))
+		}
+		maxWrite = w
+	}
+	if maxReadAhead > 0 {
+		ra := maxReadAhead
+		switch limit := min(maxReadAheadLimit, effectiveMaxWrite(maxWrite)); {
+		case ra > limit:
+			ra = limit
+			warnings = append(warnings, fmt.Sprintf("-maxReadAhead %d is more than the readahead limit of %d, the lesser of 128K and -maxWrite, using %d", maxReadAhead, limit, ra))
+		case ra < page:
+			warnings = append(warnings, fmt.Sprintf("-maxReadAhead %d is less than a page, which turns readahead off", maxReadAhead))
+		case ra%page != 0:
+			ra -= ra % page
+			warnings = append(warnings, fmt.Sprintf("-maxReadAhead %d is not a multiple of the %d byte page size, using %d", maxReadAhead, page, ra))
+		}
+		maxReadAhead = ra
+	}
+	return maxWrite, maxReadAhead, warnings, nil
+}
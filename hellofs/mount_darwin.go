@@ -0,0 +1,32 @@
+package hellofs
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// shutdownSignals unmount and exit.
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// UnmountMethods are tried in order on shutdown. macFUSE has no fusermount;
+// its mounts are taken down by the server or by umount, which works for
+// the user who mounted.
+var UnmountMethods = []string{"server", "umount"}
+
+// forceUnmountCmd is the command suggested for detaching a stale mount by
+// hand.
+const forceUnmountCmd = "umount -f"
+
+// forceUnmount detaches the mount at mountpoint, even if it is busy or its
+// server is gone. There is no lazy unmount, so open files lose their
+// mount.
+func forceUnmount(mountpoint string) error {
+	err := unix.Unmount(mountpoint, unix.MNT_FORCE)
+	if err != nil {
+		err = exec.Command("umount", "-f", mountpoint).Run()
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package hellofs
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// shutdownSignals unmount and exit.
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// UnmountMethods are tried in order on shutdown. The server can usually
+// unmount itself, which needs no external binary. The fusermount helpers
+// work without privileges, umount is the last resort. The server can't
+// unmount a -fuseFd connection, as it doesn't know the mountpoint.
+var UnmountMethods = []string{"server", "fusermount3", "fusermount", "umount"}
+
+// forceUnmountCmd is the command suggested for detaching a stale mount by
+// hand.
+const forceUnmountCmd = "fusermount -u -z"
+
+// forceUnmount lazily detaches the mount at mountpoint, even if it is busy
+// or its server is gone.
+func forceUnmount(mountpoint string) error {
+	err := syscall.Unmount(mountpoint, syscall.MNT_DETACH)
+	// without privileges only the fusermount helpers can unmount
+	for _, helper := range []string{"fusermount3", "fusermount"} {
+		if err == nil {
+			break
+		}
+		err = exec.Command(helper, "-u", "-z", mountpoint).Run()
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package hellofs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// staleMount leaves a FUSE mount on a temporary directory whose server
+// is gone, as a crash does: mounted on a connection that is then closed
+// without serving, so stat fails with ENOTCONN.
+func staleMount(t *testing.T) string {
+	t.Helper()
+	skipUnlessMountable(t)
+	dir := t.TempDir()
+	fd, err := syscall.Open("/dev/fuse", syscall.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=0,group_id=0", fd)
+	err = syscall.Mount("hello-fuse", dir, "fuse.hello-fuse", 0, data)
+	syscall.Close(fd)
+	if err != nil {
+		t.Fatalf("mount: %v", err)
+	}
+	t.Cleanup(func() { syscall.Unmount(dir, syscall.MNT_DETACH) })
+	if _, err := os.Stat(dir); !errors.Is(err, syscall.ENOTCONN) {
+		t.Fatalf("stat of the stale mount: %v, want ENOTCONN", err)
+	}
+	return dir
+}
+
+// TestStaleMount prepares a stale mountpoint for a run: without
+// -recoverStaleMount it is refused with the hint, with it the stale mount
+// is detached and the mountpoint usable.
+func TestStaleMount(t *testing.T) {
+	tests := []struct {
+		name    string
+		recover bool
+		wantErr string // a part of the error, or "" for none
+	}{
+		{name: "refused with a hint", wantErr: "pass -recoverStaleMount"},
+		{name: "recovered", recover: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := staleMount(t)
+			c := &command{o: flagDefaults(), mountpoints: []string{dir}}
+			c.o.RecoverStaleMount = tt.recover
+			err := c.prepareMountpoints()
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("prepareMountpoints() = %v, want an error with %q", err, tt.wantErr)
+			}
+			if tt.recover {
+				if f := mountinfo(t, dir); f != nil {
+					t.Errorf("%s still mounted after recovering: %q", dir, f)
+				}
+				if _, err := os.Stat(dir); err != nil {
+					t.Errorf("stat after recovering: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverStaleMount(t *testing.T) {
+	if recovered, err := recoverStaleMount(t.TempDir()); recovered || err != nil {
+		t.Errorf("recoverStaleMount(a plain directory) = %v, %v, want false, nil", recovered, err)
+	}
+	dir := staleMount(t)
+	if recovered, err := recoverStaleMount(dir); !recovered || err != nil {
+		t.Errorf("recoverStaleMount(a stale mount) = %v, %v, want true, nil", recovered, err)
+	}
+	if f := mountinfo(t, dir); f != nil {
+		t.Errorf("%s still mounted: %q", dir, f)
+	}
+}
@@ -0,0 +1,287 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		in           string
+		allowUnknown bool
+		want         []string
+		wantWarnings int
+		wantErr      bool
+	}{
+		{name: "empty", in: "  "},
+		{name: "trimmed", in: " ro , nosuid,max_read=4096", want: []string{"ro", "nosuid", "max_read=4096"}},
+		{name: "last occurrence wins", in: "fsname=a,ro,fsname=b", want: []string{"ro", "fsname=b"}, wantWarnings: 1},
+		{name: "empty option", in: "ro,,nodev", wantErr: true},
+		{name: "value on a flag", in: "ro=1", wantErr: true},
+		{name: "missing value", in: "fsname", wantErr: true},
+		{name: "empty value", in: "subtype=", wantErr: true},
+		{name: "not a number", in: "blksize=4k", wantErr: true},
+		{name: "unknown", in: "ro,foo=bar", wantErr: true},
+		{name: "unknown allowed", in: "ro,foo=bar", allowUnknown: true, want: []string{"ro", "foo=bar"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings, err := parseMountOptions(tt.in, tt.allowUnknown)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMountOptions(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMountOptions(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("parseMountOptions(%q) warnings = %q, want %d", tt.in, warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestAddMountFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		options []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", in: "", options: []string{"ro"}, want: []string{"ro"}},
+		{name: "added", in: "nosuid, nodev", options: []string{"fsname=x"}, want: []string{"fsname=x", "nosuid", "nodev"}},
+		{name: "alias", in: "rdonly", want: []string{"ro"}},
+		{name: "already there", in: "ro,noexec", options: []string{"noexec"}, want: []string{"noexec", "ro"}},
+		{name: "unknown", in: "nosetuid", wantErr: true},
+		{name: "contradicts", in: "noatime", options: []string{"atime"}, wantErr: true},
+		{name: "no opposite", in: "dirsync", options: []string{"sync"}, want: []string{"sync", "dirsync"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := addMountFlags(tt.in, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("addMountFlags(%q, %q) error = %v, wantErr %v", tt.in, tt.options, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("addMountFlags(%q, %q) = %q, want %q", tt.in, tt.options, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIOSizes(t *testing.T) {
+	page, limit := os.Getpagesize(), kernelMaxWrite()
+	tests := []struct {
+		name                   string
+		maxWrite, maxReadAhead int
+		wantWrite, wantRA      int
+		wantWarnings           int
+		wantErr                bool
+	}{
+		{name: "defaults"},
+		{name: "kept", maxWrite: 16 * page, maxReadAhead: 8 * page, wantWrite: 16 * page, wantRA: 8 * page},
+		{name: "write over the kernel limit", maxWrite: limit + page, wantWrite: limit, wantWarnings: 1},
+		{name: "write under a page", maxWrite: page / 2, wantWrite: page, wantWarnings: 1},
+		{name: "write rounded down", maxWrite: 3*page + 1, wantWrite: 3 * page, wantWarnings: 1},
+		{name: "readahead over 128K", maxReadAhead: maxReadAheadLimit + page, wantRA: maxReadAheadLimit, wantWarnings: 1},
+		{name: "readahead over maxWrite", maxWrite: 4 * page, maxReadAhead: 8 * page, wantWrite: 4 * page, wantRA: 4 * page, wantWarnings: 1},
+		{name: "readahead under a page", maxReadAhead: 1, wantRA: 1, wantWarnings: 1},
+		{name: "readahead rounded down", maxReadAhead: 2*page + 1, wantRA: 2 * page, wantWarnings: 1},
+		{name: "negative write", maxWrite: -1, wantErr: true},
+		{name: "negative readahead", maxReadAhead: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, ra, warnings, err := checkIOSizes(tt.maxWrite, tt.maxReadAhead)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkIOSizes(%d, %d) error = %v, wantErr %v", tt.maxWrite, tt.maxReadAhead, err, tt.wantErr)
+			}
+			if w != tt.wantWrite || ra != tt.wantRA {
+				t.Errorf("checkIOSizes(%d, %d) = %d, %d, want %d, %d", tt.maxWrite, tt.maxReadAhead, w, ra, tt.wantWrite, tt.wantRA)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("checkIOSizes(%d, %d) warnings = %q, want %d", tt.maxWrite, tt.maxReadAhead, warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+// TestIOSizesMount mounts with the -maxWrite buildOptions makes after
+// checkIOSizes and writes 64K at once: the kernel splits it into requests
+// of at most the checked size, a page at the least. A negative size fails
+// validation.
+func TestIOSizesMount(t *testing.T) {
+	page := os.Getpagesize()
+	const size = 64 * 1024
+	tests := []struct {
+		name      string
+		maxWrite  int
+		wantWrite int // the size of each request, 0 for a failed validation
+	}{
+		{"kept", 8 * page, 8 * page},
+		{"rounded down", 3*page + 1, 3 * page},
+		{"under a page", page / 2, page},
+		{"negative", -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &command{o: flagDefaults()}
+			c.o.MaxWrite = tt.maxWrite
+			err := c.o.validate([]string{"/mnt"})
+			if (err != nil) != (tt.wantWrite == 0) {
+				t.Fatalf("validate() = %v, want an error: %v", err, tt.wantWrite == 0)
+			}
+			if err != nil {
+				return
+			}
+			if err := c.buildOptions(); err != nil {
+				t.Fatal(err)
+			}
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+			dir := mountTest(t, Config{Root: root, Options: c.opts})
+			if err := os.WriteFile(filepath.Join(dir, "file.txt"), make([]byte, size), 0); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := root.writes.Load(), uint64((size+tt.wantWrite-1)/tt.wantWrite); got != want {
+				t.Errorf("%d write requests for 64K, want %d of at most %d bytes", got, want, tt.wantWrite)
+			}
+		})
+	}
+}
+
+func TestCheckMountpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(dir string) string // returns the mountpoint
+		create  bool
+		wantErr bool
+	}{
+		{name: "directory", setup: func(dir string) string { return dir }},
+		{name: "file", setup: func(dir string) string {
+			path := filepath.Join(dir, "file")
+			os.WriteFile(path, nil, 0644)
+			return path
+		}, wantErr: true},
+		{name: "file with create", setup: func(dir string) string {
+			path := filepath.Join(dir, "file")
+			os.WriteFile(path, nil, 0644)
+			return path
+		}, create: true, wantErr: true},
+		{name: "missing", setup: func(dir string) string { return filepath.Join(dir, "missing") }, wantErr: true},
+		{name: "missing with create", setup: func(dir string) string { return filepath.Join(dir, "a", "b") }, create: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mountpoint := tt.setup(t.TempDir())
+			err := checkMountpoint(mountpoint, tt.create)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkMountpoint(%q, %v) error = %v, wantErr %v", mountpoint, tt.create, err, tt.wantErr)
+			}
+			if fi, serr := os.Stat(mountpoint); err == nil && (serr != nil || !fi.IsDir()) {
+				t.Errorf("checkMountpoint(%q, %v) accepted it, but it is no directory: %v", mountpoint, tt.create, serr)
+			}
+		})
+	}
+}
+
+func TestPreflightMountpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(dir string) string // returns the mountpoint
+		force       bool
+		wantErr     bool
+		wantMounted bool
+	}{
+		{name: "empty", setup: func(dir string) string { return dir }},
+		{name: "not empty", setup: func(dir string) string {
+			os.WriteFile(filepath.Join(dir, "file"), nil, 0644)
+			return dir
+		}, wantErr: true},
+		{name: "not empty with force", setup: func(dir string) string {
+			os.WriteFile(filepath.Join(dir, "file"), nil, 0644)
+			return dir
+		}, force: true},
+		{name: "missing", setup: func(dir string) string { return filepath.Join(dir, "missing") }, wantErr: true},
+		{name: "mount root", setup: func(string) string { return "/" }, force: true, wantErr: true, wantMounted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mountpoint := tt.setup(t.TempDir())
+			err := preflightMountpoint(mountpoint, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("preflightMountpoint(%q, %v) error = %v, wantErr %v", mountpoint, tt.force, err, tt.wantErr)
+			}
+			if errors.Is(err, errMounted) != tt.wantMounted {
+				t.Errorf("preflightMountpoint(%q, %v) = %v, want errMounted %v", mountpoint, tt.force, err, tt.wantMounted)
+			}
+		})
+	}
+}
+
+func TestPreflightMountpointMounted(t *testing.T) {
+	dir := mountRoot(t, NewRoot(nil, RootOptions{}))
+	if err := preflightMountpoint(dir, true); !errors.Is(err, errMounted) {
+		t.Errorf("preflightMountpoint(%q, true) = %v, want errMounted", dir, err)
+	}
+}
+
+// TestAllowOtherBestEffort fakes a mount refusing allow_other: with
+// AllowOtherBestEffort the mount is tried again without it, otherwise
+// the refusal is returned.
+func TestAllowOtherBestEffort(t *testing.T) {
+	errSecond := errors.New("second attempt")
+	tests := []struct {
+		name       string
+		bestEffort bool
+		want       error
+		wantCalls  int
+	}{
+		{name: "best effort", bestEffort: true, want: errSecond, wantCalls: 2},
+		{name: "strict", want: syscall.EPERM, wantCalls: 1},
+	}
+	orig := newServer
+	defer func() { newServer = orig }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []fuse.MountOptions
+			newServer = func(rawFS fuse.RawFileSystem, dir string, mo *fuse.MountOptions) (*fuse.Server, error) {
+				calls = append(calls, *mo)
+				if mo.AllowOther {
+					return nil, syscall.EPERM
+				}
+				return nil, errSecond
+			}
+			cfg := &Config{
+				Root:                 NewRoot(nil, RootOptions{}),
+				Options:              &fs.Options{MountOptions: fuse.MountOptions{AllowOther: true, Options: []string{"ro", "allow_other"}}},
+				Control:              &controlState{},
+				AllowOtherBestEffort: tt.bestEffort,
+			}
+			_, err := mountWithRetry(context.Background(), t.TempDir(), cfg, time.Now().Add(time.Second))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("mountWithRetry() = %v, want %v", err, tt.want)
+			}
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("mounted %d times, want %d", len(calls), tt.wantCalls)
+			}
+			if last := calls[len(calls)-1]; tt.bestEffort && (last.AllowOther || !reflect.DeepEqual(last.Options, []string{"ro"})) {
+				t.Errorf("retried with AllowOther %v, options %q, want neither allow_other", last.AllowOther, last.Options)
+			}
+			if !cfg.Options.AllowOther {
+				t.Error("mountWithRetry changed the caller's options")
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// DefaultMaxNameLen is the -maxNameLen default. Config.MaxNameLen is the
+// longest name in bytes that may be looked up or created, and that statfs
+// reports; 0 means no limit. Linux refuses names over 255 bytes before
+// they get here.
+const DefaultMaxNameLen = 255
+
+// longName returns the first name in the slash-separated path p that is
+// over max bytes, or "" if there is none or max is 0.
+func longName(p string, max int) string {
+	if max == 0 {
+		return ""
+	}
+	for name := range strings.SplitSeq(p, "/") {
+		if len(name) > max {
+			return name
+		}
+	}
+	return ""
+}
+
+// nameLimiter answers ENAMETOOLONG for names over max bytes.
+type nameLimiter struct {
+	fuse.RawFileSystem
+
+	max int
+}
+
+func (l *nameLimiter) tooLong(name string) bool { return len(name) > l.max }
+
+func (l *nameLimiter) Lookup(cancel <-chan struct{}, header *fuse.InHeader, name string, out *fuse.EntryOut) fuse.Status {
+	if l.tooLong(name) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	return l.RawFileSystem.Lookup(cancel, header, name, out)
+}
+
+func (l *nameLimiter) Create(cancel <-chan struct{}, in *fuse.CreateIn, name string, out *fuse.CreateOut) fuse.Status {
+	if l.tooLong(name) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	return l.RawFileSystem.Create(cancel, in, name, out)
+}
+
+func (l *nameLimiter) Mknod(cancel <-chan struct{}, in *fuse.MknodIn, name string, out *fuse.EntryOut) fuse.Status {
+	if l.tooLong(name) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	return l.RawFileSystem.Mknod(cancel, in, name, out)
+}
+
+func (l *nameLimiter) Mkdir(cancel <-chan struct{}, in *fuse.MkdirIn, name string, out *fuse.EntryOut) fuse.Status {
+	if l.tooLong(name) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	return l.RawFileSystem.Mkdir(cancel, in, name, out)
+}
+
+func (l *nameLimiter) Rename(cancel <-chan struct{}, in *fuse.RenameIn, oldName string, newName string) fuse.Status {
+	if l.tooLong(newName) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	return l.RawFileSystem.Rename(cancel, in, oldName, newName)
+}
+
+func (l *nameLimiter) Link(cancel <-chan struct{}, in *fuse.LinkIn, name string, out *fuse.EntryOut) fuse.Status {
+	if l.tooLong(name) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	return l.RawFileSystem.Link(cancel, in, name, out)
+}
+
+func (l *nameLimiter) Symlink(cancel <-chan struct{}, header *fuse.InHeader, target string, name string, out *fuse.EntryOut) fuse.Status {
+	if l.tooLong(name) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	return l.RawFileSystem.Symlink(cancel, header, target, name, out)
+}
@@ -0,0 +1,57 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestNameLimiter(t *testing.T) {
+	l := &nameLimiter{RawFileSystem: fuse.NewDefaultRawFileSystem(), max: 8}
+	ops := map[string]func(name string) fuse.Status{
+		"lookup": func(name string) fuse.Status {
+			return l.Lookup(nil, &fuse.InHeader{}, name, &fuse.EntryOut{})
+		},
+		"create": func(name string) fuse.Status {
+			return l.Create(nil, &fuse.CreateIn{}, name, &fuse.CreateOut{})
+		},
+		"mkdir": func(name string) fuse.Status {
+			return l.Mkdir(nil, &fuse.MkdirIn{}, name, &fuse.EntryOut{})
+		},
+		"rename": func(name string) fuse.Status {
+			return l.Rename(nil, &fuse.RenameIn{}, "a", name)
+		},
+	}
+	for op, call := range ops {
+		t.Run(op, func(t *testing.T) {
+			// the default file system answers ENOSYS to what gets through
+			if got := call("12345678"); got != fuse.ENOSYS {
+				t.Errorf("%s of an 8 byte name = %v, want it passed on", op, got)
+			}
+			if got := call("123456789"); got != fuse.Status(syscall.ENAMETOOLONG) {
+				t.Errorf("%s of a 9 byte name = %v, want ENAMETOOLONG", op, got)
+			}
+		})
+	}
+}
+
+func TestManifestLongName(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	doc := "- name: a.txt\n  content: a\n- name: dir/" + strings.Repeat("x", 9) + "\n  content: b\n"
+	if err := os.WriteFile(file, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadManifest(file, 8)
+	if err == nil || !strings.Contains(err.Error(), file+":3:") {
+		t.Errorf("loadManifest() with a 9 byte name = %v, want an error at line 3", err)
+	}
+	if _, err := loadManifest(file, 0); err != nil {
+		t.Errorf("loadManifest() with no limit = %v", err)
+	}
+}
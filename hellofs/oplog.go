@@ -0,0 +1,49 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// traceOps returns a hook logging one line per node operation with its
+// duration, for example
+//
+//	op=read path=/file.txt ino=2 pid=4242 size=4096 bytes=8 errno=0 took=21µs
+//
+// With -logFormat json the same values are attributes of an "op" record.
+func traceOps(logger *log.Logger) opHook {
+	return func(ctx context.Context, op, path string) func(opResult) {
+		start := time.Now()
+		var pid uint32
+		if caller, ok := fuse.FromContext(ctx); ok {
+			pid = caller.Pid
+		}
+		return func(r opResult) {
+			took := time.Since(start)
+			attrs := []any{"op", op, "path", path, "ino", r.ino, "pid", pid}
+			if op == "read" {
+				attrs = append(attrs, "size", r.requested, "bytes", r.bytes)
+			}
+			attrs = append(attrs, "errno", int(r.errno), "took", took)
+			if jsonLog != nil {
+				jsonLog.Info("op", append([]any{"event", "op"}, attrs...)...)
+				return
+			}
+			var b strings.Builder
+			for i := 0; i < len(attrs); i += 2 {
+				if i > 0 {
+					b.WriteByte(' ')
+				}
+				fmt.Fprintf(&b, "%s=%v", attrs[i], attrs[i+1])
+			}
+			logger.Print(b.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// opResult describes how a node operation completed.
+type opResult struct {
+	errno     syscall.Errno
+	ino       uint64
+	requested int // bytes asked for, for reads
+	bytes     int // bytes returned, for reads
+}
+
+// opHook is called when a node operation starts. The returned function is
+// called with the operation result once it completes.
+type opHook func(ctx context.Context, op, path string) func(opResult)
+
+// startOp notifies the hooks of the mount that op started on node n and
+// returns a function reporting the result. Use it as
+//
+//	defer startOp(ctx, "op", &n.Inode)(&errno)
+//
+// With Config.RecoverPanics, a panic in the node method becomes an EIO
+// reply, so one bad request can't take down the mount.
+func startOp(ctx context.Context, op string, n *fs.Inode) func(*syscall.Errno) {
+	s := stateOf(n)
+	done := s.notifyStart(ctx, op, n)
+	return func(errno *syscall.Errno) {
+		if s.recoverPanics {
+			// recover only works when called directly by the deferred
+			// function
+			if p := recover(); p != nil {
+				*errno = panicked(op, n, p)
+			}
+		} else if p := recover(); p != nil {
+			detachMount()
+			panic(p)
+		}
+		done(opResult{errno: *errno})
+	}
+}
+
+// startRead is startOp for reads of size bytes, additionally reporting the
+// number of bytes returned.
+func startRead(ctx context.Context, n *fs.Inode, size int) func(*fuse.ReadResult, *syscall.Errno) {
+	s := stateOf(n)
+	done := s.notifyStart(ctx, "read", n)
+	return func(res *fuse.ReadResult, errno *syscall.Errno) {
+		if s.recoverPanics {
+			if p := recover(); p != nil {
+				*res, *errno = nil, panicked("read", n, p)
+			}
+		} else if p := recover(); p != nil {
+			detachMount()
+			panic(p)
+		}
+		r := opResult{errno: *errno, requested: size}
+		if *res != nil {
+			r.bytes = (*res).Size()
+		}
+		done(r)
+	}
+}
+
+// startGetattr is startOp for getattr, additionally filling in link and
+// block counts and applying the configured attribute overrides on top of
+// the node's own values.
+func startGetattr(ctx context.Context, n *fs.Inode) func(*fuse.AttrOut, *syscall.Errno) {
+	s := stateOf(n)
+	done := s.notifyStart(ctx, "getattr", n)
+	return func(out *fuse.AttrOut, errno *syscall.Errno) {
+		if s.recoverPanics {
+			if p := recover(); p != nil {
+				*errno = panicked("getattr", n, p)
+			}
+		} else if p := recover(); p != nil {
+			detachMount()
+			panic(p)
+		}
+		if *errno == 0 {
+			fillCounts(n, &out.Attr)
+			overrideAttr(s.attrOverrides, n, &out.Attr)
+		}
+		done(opResult{errno: *errno})
+	}
+}
+
+// panicked logs a recovered panic and returns the errno to reply with.
+func panicked(op string, n *fs.Inode, p any) syscall.Errno {
+	log.Printf("panic in %s on /%s: %v\n%s", op, n.Path(nil), p, debug.Stack())
+	return syscall.EIO
+}
+
+func (s *mountState) notifyStart(ctx context.Context, op string, n *fs.Inode) func(opResult) {
+	if len(s.hooks) == 0 {
+		return func(opResult) {}
+	}
+	path := "/" + n.Path(nil)
+	dones := make([]func(opResult), len(s.hooks))
+	for i, h := range s.hooks {
+		dones[i] = h(ctx, op, path)
+	}
+	return func(r opResult) {
+		r.ino = n.StableAttr().Ino
+		for _, done := range dones {
+			done(r)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// optionsJSON renders opts, including the embedded fuse.MountOptions, as
+// indented JSON. Fields JSON can't show are reduced to whether they are
+// set (funcs, loggers, interfaces) and durations are shown as strings,
+// so new go-fuse fields show up without changes here.
+func optionsJSON(opts *fs.Options) ([]byte, error) {
+	return json.MarshalIndent(jsonable(reflect.ValueOf(opts)), "", "  ")
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+func jsonable(v reflect.Value) any {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if t := v.Type().Elem(); t.Kind() == reflect.Struct && !slices.ContainsFunc(reflect.VisibleFields(t), func(f reflect.StructField) bool { return f.IsExported() }) {
+			return !v.IsNil() // opaque, such as a *log.Logger
+		}
+		if v.IsNil() {
+			return nil
+		}
+		return jsonable(v.Elem())
+	case reflect.Func, reflect.Interface, reflect.Chan:
+		return !v.IsNil()
+	case reflect.Struct:
+		m := map[string]any{}
+		for i := range v.NumField() {
+			if f := v.Type().Field(i); f.IsExported() {
+				m[f.Name] = jsonable(v.Field(i))
+			}
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		s := make([]any, v.Len())
+		for i := range s {
+			s[i] = jsonable(v.Index(i))
+		}
+		return s
+	case reflect.Uintptr:
+		return fmt.Sprintf("%#x", v.Uint())
+	}
+	return v.Interface()
+}
@@ -0,0 +1,109 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os/user"
+	"strconv"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// rootID stands for an explicit owner or group of 0 in the attributes a
+// node reports. go-fuse replaces a zero Uid or Gid with -uid and -gid, so
+// a manifest entry owned by root reports rootID instead, and rootOwners
+// turns it back into 0 after go-fuse is done. No file can really have it:
+// chown treats -1 as "leave unchanged".
+const rootID = ^uint32(0)
+
+// ownerID returns id as a node should report it.
+func ownerID(id uint32) uint32 {
+	if id == 0 {
+		return rootID
+	}
+	return id
+}
+
+// lookupUser resolves a user name or numeric id, like -user and -uid.
+func lookupUser(s string) (uint32, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	u, err := user.Lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(id), err
+}
+
+// lookupGroup resolves a group name or numeric id, like -group and -gid.
+func lookupGroup(s string) (uint32, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	g, err := user.LookupGroup(s)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(id), err
+}
+
+// hasRootOwner reports whether any of nodes has an explicit root owner or
+// group.
+func hasRootOwner(nodes []manifestNode) bool {
+	for _, n := range nodes {
+		if n.owner.Uid == rootID || n.owner.Gid == rootID || hasRootOwner(n.children) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootOwners replaces rootID with 0 in the attributes sent to the kernel.
+// Readdirplus replies can't be rewritten here, so Main turns readdirplus
+// off for a manifest with root-owned entries. Run puts it in with
+// Config.rootOwners, which Main sets when an entry may be owned by root.
+type rootOwners struct {
+	fuse.RawFileSystem
+}
+
+func fixOwner(uid, gid *uint32) {
+	if *uid == rootID {
+		*uid = 0
+	}
+	if *gid == rootID {
+		*gid = 0
+	}
+}
+
+func (r *rootOwners) Lookup(cancel <-chan struct{}, header *fuse.InHeader, name string, out *fuse.EntryOut) fuse.Status {
+	st := r.RawFileSystem.Lookup(cancel, header, name, out)
+	fixOwner(&out.Uid, &out.Gid)
+	return st
+}
+
+func (r *rootOwners) GetAttr(cancel <-chan struct{}, in *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	st := r.RawFileSystem.GetAttr(cancel, in, out)
+	fixOwner(&out.Uid, &out.Gid)
+	return st
+}
+
+func (r *rootOwners) SetAttr(cancel <-chan struct{}, in *fuse.SetAttrIn, out *fuse.AttrOut) fuse.Status {
+	st := r.RawFileSystem.SetAttr(cancel, in, out)
+	fixOwner(&out.Uid, &out.Gid)
+	return st
+}
+
+func (r *rootOwners) Link(cancel <-chan struct{}, in *fuse.LinkIn, name string, out *fuse.EntryOut) fuse.Status {
+	st := r.RawFileSystem.Link(cancel, in, name, out)
+	fixOwner(&out.Uid, &out.Gid)
+	return st
+}
+
+func (r *rootOwners) Statx(cancel <-chan struct{}, in *fuse.StatxIn, out *fuse.StatxOut) fuse.Status {
+	st := r.RawFileSystem.Statx(cancel, in, out)
+	fixOwner(&out.Uid, &out.Gid)
+	return st
+}
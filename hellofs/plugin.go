@@ -0,0 +1,175 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"plugin"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/nopcoder/hello-fuse/generator"
+)
+
+// validName reports whether name can be used as a directory entry.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// genRegistry collects the generators added by plugins.
+type genRegistry struct {
+	gens map[string]generator.Func
+	errs []error
+}
+
+func (r *genRegistry) Add(name string, fn generator.Func) {
+	switch {
+	case !validName(name):
+		r.errs = append(r.errs, fmt.Errorf("invalid generator name %q", name))
+	case fn == nil:
+		r.errs = append(r.errs, fmt.Errorf("generator %q is nil", name))
+	case r.gens[name] != nil:
+		r.errs = append(r.errs, fmt.Errorf("duplicate generator %q", name))
+	default:
+		r.gens[name] = fn
+	}
+}
+
+// loadPlugin opens the Go plugin at path and lets it register its
+// generators.
+func loadPlugin(path string) (map[string]generator.Func, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Register")
+	if err != nil {
+		return nil, err
+	}
+	register, ok := sym.(func(generator.Registry))
+	if !ok {
+		return nil, fmt.Errorf("%s: Register has type %T, want func(generator.Registry)", path, sym)
+	}
+	r := &genRegistry{gens: map[string]generator.Func{}}
+	register(r)
+	if len(r.errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", path, r.errs[0])
+	}
+	return r.gens, nil
+}
+
+// GenDir serves a file for each generator provided by plugins.
+type GenDir struct {
+	fs.Inode
+	birth
+
+	gens   map[string]generator.Func
+	logger *log.Logger
+}
+
+func (d *GenDir) OnAdd(ctx context.Context) {
+	names := make([]string, 0, len(d.gens))
+	for name := range d.gens {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		f := &GenFile{birth: born(), gen: d.gens[name], logger: d.logger}
+		d.AddChild(name, d.NewPersistentInode(ctx, f, fs.StableAttr{}), false)
+	}
+}
+
+func (d *GenDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0555
+	return 0
+}
+
+func (d *GenDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*GenDir)(nil))
+	_ = (fs.NodeGetattrer)((*GenDir)(nil))
+	_ = (fs.NodeOnAdder)((*GenDir)(nil))
+)
+
+// GenFile runs its generator on open; the handle serves that output until
+// it is closed. Reads use direct I/O so each open sees fresh content.
+type GenFile struct {
+	fs.Inode
+	birth
+
+	gen    generator.Func
+	logger *log.Logger
+
+	mu   sync.Mutex
+	size uint64 // size of the last generated content
+}
+
+// genHandle holds the content generated at open.
+type genHandle struct {
+	data []byte
+}
+
+func (f *GenFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	data, err := f.gen(ctx)
+	if err != nil {
+		f.logger.Printf("%s: generator failed: %v", f.Path(nil), err)
+		return nil, 0, toErrno(&f.Inode, err)
+	}
+	f.mu.Lock()
+	f.size = uint64(len(data))
+	f.mu.Unlock()
+	return &genHandle{data: data}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *GenFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	h, ok := fh.(*genHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(h.data)))
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+func (f *GenFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = 0444
+	if h, ok := fh.(*genHandle); ok {
+		out.Size = uint64(len(h.data))
+	} else {
+		f.mu.Lock()
+		out.Size = f.size
+		f.mu.Unlock()
+	}
+	out.SetTimeout(0)
+	return 0
+}
+
+func (f *GenFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*GenFile)(nil))
+	_ = (fs.NodeOpener)((*GenFile)(nil))
+	_ = (fs.NodeReader)((*GenFile)(nil))
+	_ = (fs.NodeGetattrer)((*GenFile)(nil))
+)
@@ -0,0 +1,379 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// procTable tracks how each process has used the mount. Entries are created
+// by the first operation a process makes and dropped once it has been idle
+// for a while or has exited.
+type procTable struct {
+	mountpoint string
+
+	mu   sync.Mutex
+	pids map[uint32]*procStats
+	tgid map[uint32]uint32 // thread id to process id
+}
+
+type procStats struct {
+	ops       uint64
+	reads     uint64
+	bytesRead uint64
+	first     time.Time
+	last      time.Time
+}
+
+func newProcTable(mountpoint string) *procTable {
+	return &procTable{
+		mountpoint: mountpoint,
+		pids:       map[uint32]*procStats{},
+		tgid:       map[uint32]uint32{},
+	}
+}
+
+// processOf maps the thread id the kernel reports as the caller to the id
+// of its process, so all threads of a process share one entry.
+func (t *procTable) processOf(tid uint32) uint32 {
+	t.mu.Lock()
+	pid, ok := t.tgid[tid]
+	t.mu.Unlock()
+	if ok {
+		return pid
+	}
+	pid = tid
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", tid)); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if v, ok := strings.CutPrefix(line, "Tgid:"); ok {
+				if n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32); err == nil {
+					pid = uint32(n)
+				}
+				break
+			}
+		}
+	}
+	t.mu.Lock()
+	t.tgid[tid] = pid
+	t.mu.Unlock()
+	return pid
+}
+
+// callerPid returns the process id of the caller, or 0 if it is unknown,
+// e.g. for requests the kernel makes on its own behalf.
+func (t *procTable) callerPid(ctx context.Context) uint32 {
+	caller, ok := fuse.FromContext(ctx)
+	if !ok || caller.Pid == 0 {
+		return 0
+	}
+	return t.processOf(caller.Pid)
+}
+
+func (t *procTable) hook(ctx context.Context, op, path string) func(opResult) {
+	pid := t.callerPid(ctx)
+	if pid == 0 {
+		return func(opResult) {}
+	}
+	return func(r opResult) {
+		now := time.Now()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		s := t.pids[pid]
+		if s == nil {
+			s = &procStats{first: now}
+			t.pids[pid] = s
+		}
+		s.ops++
+		s.last = now
+		if op == "read" && r.errno == 0 {
+			s.reads++
+			s.bytesRead += uint64(r.bytes)
+		}
+	}
+}
+
+func (t *procTable) stats(pid uint32) (procStats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.pids[pid]
+	if !ok {
+		return procStats{}, false
+	}
+	return *s, true
+}
+
+func (t *procTable) list() []uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pids := make([]uint32, 0, len(t.pids))
+	for pid := range t.pids {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+	return pids
+}
+
+// reap periodically drops processes that exited or were idle for longer
+// than idle, until ctx is cancelled.
+func (t *procTable) reap(ctx context.Context, idle time.Duration) {
+	tick := time.NewTicker(max(idle/4, time.Second))
+	defer tick.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		t.mu.Lock()
+		for pid, s := range t.pids {
+			if now.Sub(s.last) > idle || syscall.Kill(int(pid), 0) == syscall.ESRCH {
+				delete(t.pids, pid)
+			}
+		}
+		clear(t.tgid)
+		t.mu.Unlock()
+	}
+}
+
+// openHandles counts the file descriptors pid holds on files in the mount.
+func (t *procTable) openHandles(pid uint32) int {
+	dir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, e := range entries {
+		target, err := os.Readlink(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		if target == t.mountpoint || strings.HasPrefix(target, t.mountpoint+"/") {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *procTable) render(pid uint32) ([]byte, bool) {
+	s, ok := t.stats(pid)
+	if !ok {
+		return nil, false
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "pid=%d\n", pid)
+	fmt.Fprintf(&b, "open_handles=%d\n", t.openHandles(pid))
+	fmt.Fprintf(&b, "ops=%d\n", s.ops)
+	fmt.Fprintf(&b, "reads=%d\n", s.reads)
+	fmt.Fprintf(&b, "bytes_read=%d\n", s.bytesRead)
+	fmt.Fprintf(&b, "first_seen=%s\n", s.first.Format(time.RFC3339))
+	fmt.Fprintf(&b, "last_active=%s\n", s.last.Format(time.RFC3339))
+	return b.Bytes(), true
+}
+
+// ProcDir lists a directory for each process that recently used the mount,
+// plus a self symlink pointing at the caller's own directory.
+type ProcDir struct {
+	fs.Inode
+	birth
+
+	table *procTable
+}
+
+func (d *ProcDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0555
+	return 0
+}
+
+func (d *ProcDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "lookup", &d.Inode)(&errno)
+	if name == "self" {
+		out.Mode = syscall.S_IFLNK | 0777
+		return d.NewInode(ctx, &ProcSelf{birth: born(), table: d.table}, fs.StableAttr{Mode: syscall.S_IFLNK}), 0
+	}
+	pid, err := strconv.ParseUint(name, 10, 32)
+	if err != nil || strconv.FormatUint(pid, 10) != name {
+		return nil, syscall.ENOENT
+	}
+	if _, ok := d.table.stats(uint32(pid)); !ok {
+		return nil, syscall.ENOENT
+	}
+	// the entry disappears when the process is reaped, don't let the
+	// kernel hold on to it
+	out.SetEntryTimeout(0)
+	out.SetAttrTimeout(0)
+	out.Mode = syscall.S_IFDIR | 0555
+	return d.NewInode(ctx, &ProcPIDDir{birth: born(), table: d.table, pid: uint32(pid)}, fs.StableAttr{Mode: syscall.S_IFDIR}), 0
+}
+
+func (d *ProcDir) Readdir(ctx context.Context) (ds fs.DirStream, errno syscall.Errno) {
+	defer startOp(ctx, "readdir", &d.Inode)(&errno)
+	entries := []fuse.DirEntry{{Name: "self", Mode: syscall.S_IFLNK}}
+	for _, pid := range d.table.list() {
+		entries = append(entries, fuse.DirEntry{Name: strconv.FormatUint(uint64(pid), 10), Mode: syscall.S_IFDIR})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (d *ProcDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*ProcDir)(nil))
+	_ = (fs.NodeGetattrer)((*ProcDir)(nil))
+	_ = (fs.NodeLookuper)((*ProcDir)(nil))
+	_ = (fs.NodeReaddirer)((*ProcDir)(nil))
+)
+
+// ProcSelf resolves to the directory of the process reading the link.
+type ProcSelf struct {
+	fs.Inode
+	birth
+
+	table *procTable
+}
+
+func (l *ProcSelf) Readlink(ctx context.Context) (target []byte, errno syscall.Errno) {
+	defer startOp(ctx, "readlink", &l.Inode)(&errno)
+	return strconv.AppendUint(nil, uint64(l.table.callerPid(ctx)), 10), 0
+}
+
+func (l *ProcSelf) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &l.Inode)(out, &errno)
+	out.Mode = 0777
+	out.SetTimeout(0)
+	return 0
+}
+
+func (l *ProcSelf) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, l, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*ProcSelf)(nil))
+	_ = (fs.NodeReadlinker)((*ProcSelf)(nil))
+	_ = (fs.NodeGetattrer)((*ProcSelf)(nil))
+)
+
+// ProcPIDDir holds the files describing a single process.
+type ProcPIDDir struct {
+	fs.Inode
+	birth
+
+	table *procTable
+	pid   uint32
+}
+
+func (d *ProcPIDDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0555
+	out.SetTimeout(0)
+	return 0
+}
+
+func (d *ProcPIDDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "lookup", &d.Inode)(&errno)
+	if name != "status" {
+		return nil, syscall.ENOENT
+	}
+	out.SetEntryTimeout(0)
+	out.SetAttrTimeout(0)
+	out.Mode = syscall.S_IFREG | 0444
+	return d.NewInode(ctx, &ProcStatusFile{birth: born(), table: d.table, pid: d.pid}, fs.StableAttr{}), 0
+}
+
+func (d *ProcPIDDir) Readdir(ctx context.Context) (ds fs.DirStream, errno syscall.Errno) {
+	defer startOp(ctx, "readdir", &d.Inode)(&errno)
+	return fs.NewListDirStream([]fuse.DirEntry{{Name: "status", Mode: syscall.S_IFREG}}), 0
+}
+
+func (d *ProcPIDDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*ProcPIDDir)(nil))
+	_ = (fs.NodeGetattrer)((*ProcPIDDir)(nil))
+	_ = (fs.NodeLookuper)((*ProcPIDDir)(nil))
+	_ = (fs.NodeReaddirer)((*ProcPIDDir)(nil))
+)
+
+// ProcStatusFile renders the statistics of one process. The content is
+// captured when the file is opened, so a reader sees a consistent snapshot
+// even though its own reads update the counters.
+type ProcStatusFile struct {
+	fs.Inode
+	birth
+
+	table *procTable
+	pid   uint32
+}
+
+// procStatusHandle holds the snapshot taken at open.
+type procStatusHandle struct {
+	data []byte
+}
+
+func (f *ProcStatusFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	data, ok := f.table.render(f.pid)
+	if !ok {
+		return nil, 0, syscall.ENOENT
+	}
+	return &procStatusHandle{data: data}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *ProcStatusFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	h, ok := fh.(*procStatusHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(h.data)))
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+func (f *ProcStatusFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	data, ok := f.table.render(f.pid)
+	if !ok {
+		return syscall.ENOENT
+	}
+	out.Mode = 0444
+	out.Size = uint64(len(data))
+	out.SetTimeout(0)
+	return 0
+}
+
+func (f *ProcStatusFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*ProcStatusFile)(nil))
+	_ = (fs.NodeOpener)((*ProcStatusFile)(nil))
+	_ = (fs.NodeReader)((*ProcStatusFile)(nil))
+	_ = (fs.NodeGetattrer)((*ProcStatusFile)(nil))
+)
@@ -0,0 +1,84 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand/v2"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// RandomFile serves pseudo-random bytes derived from a seed. Every 8-byte
+// word is generated from the seed and its index alone, so any offset can
+// be read without generating what comes before it, and the same seed
+// always gives the same content.
+type RandomFile struct {
+	fs.Inode
+	birth
+
+	seed uint64
+	size int64
+}
+
+// pathSeed derives the seed of the generated file at path, relative to the
+// root, from the -seed.
+func pathSeed(seed uint64, path string) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, seed)
+	h.Write([]byte(path))
+	return h.Sum64()
+}
+
+func (f *RandomFile) word(i uint64) uint64 {
+	return rand.NewPCG(f.seed, i).Uint64()
+}
+
+func (f *RandomFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	// the content never changes
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *RandomFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	if off >= f.size {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), f.size)
+	buf := dest[:end-off]
+	var word [8]byte
+	for i := range buf {
+		pos := uint64(off) + uint64(i)
+		if i == 0 || pos%8 == 0 {
+			binary.LittleEndian.PutUint64(word[:], f.word(pos/8))
+		}
+		buf[i] = word[pos%8]
+	}
+	return fuse.ReadResultData(buf), 0
+}
+
+func (f *RandomFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = 0444
+	out.Size = uint64(f.size)
+	return 0
+}
+
+func (f *RandomFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*RandomFile)(nil))
+	_ = (fs.NodeOpener)((*RandomFile)(nil))
+	_ = (fs.NodeReader)((*RandomFile)(nil))
+	_ = (fs.NodeGetattrer)((*RandomFile)(nil))
+)
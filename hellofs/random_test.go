@@ -0,0 +1,73 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// randomBin is the random.bin of an unmounted tree with -seed seed.
+func randomBin(seed uint64, size int64) *RandomFile {
+	root := NewRoot(nil, RootOptions{})
+	root.randomSize, root.randomSeed = size, pathSeed(seed, "random.bin")
+	fs.NewNodeFS(root, &fs.Options{})
+	return root.GetChild("random.bin").Operations().(*RandomFile)
+}
+
+// randomSum reads all of a RandomFile in reads of chunk bytes and returns
+// the sha256 of what it read.
+func randomSum(t *testing.T, f *RandomFile, chunk int) string {
+	t.Helper()
+	h := sha256.New()
+	for off := int64(0); off < f.size; off += int64(chunk) {
+		res, errno := f.Read(context.Background(), nil, make([]byte, chunk), off)
+		if errno != 0 {
+			t.Fatalf("Read(%d, %d) = %v", chunk, off, errno)
+		}
+		b, _ := res.Bytes(nil)
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// TestRandomFileSeed pins the content of random.bin for -seed 42, which
+// must not change between releases, whatever the size of the reads.
+func TestRandomFileSeed(t *testing.T) {
+	const want = "2fe005d5057563c3e73ab69727d213610e4f947021af54abf82b0f862d2893e5"
+	f := randomBin(42, 10000)
+	for _, chunk := range []int{10000, 4096, 777, 1} {
+		if got := randomSum(t, f, chunk); got != want {
+			t.Errorf("sha256 of random.bin for seed 42, read %d bytes at a time = %s, want %s", chunk, got, want)
+		}
+	}
+	other := randomBin(43, 10000)
+	if randomSum(t, other, 10000) == want {
+		t.Error("seeds 42 and 43 give the same random.bin")
+	}
+}
+
+// TestRandomFileMounts mounts two trees with the same seed, which must
+// serve the same random.bin.
+func TestRandomFileMounts(t *testing.T) {
+	var sums []string
+	for range 2 {
+		root := NewRoot(nil, RootOptions{})
+		root.randomSize, root.randomSeed = 1<<20, pathSeed(42, "random.bin")
+		b, err := os.ReadFile(filepath.Join(mountRoot(t, root), "random.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(b)
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+	if sums[0] != sums[1] {
+		t.Errorf("two mounts with seed 42 serve random.bin with sha256 %s and %s", sums[0], sums[1])
+	}
+}
@@ -0,0 +1,126 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadLoop runs every reloader each time a trigger arrives. Triggers
+// carry a short description of what caused them, e.g. the signal name.
+// With a non-zero debounce, triggers arriving within that window of each
+// other are coalesced into a single reload once the window passes quietly,
+// so a burst of change notifications reloads once, with the final state.
+// It returns once ctx is cancelled, which the reload in progress also gets.
+func reloadLoop(ctx context.Context, triggers <-chan string, debounce time.Duration, reloaders []func(context.Context) error) {
+	defer unmountOnPanic()
+	reload := func() {
+		for _, r := range reloaders {
+			if err := r(ctx); err != nil {
+				logError("reload_failed", fmt.Sprintf("Reload failed: %v", err), "error", err)
+			}
+		}
+	}
+	if debounce <= 0 {
+		for {
+			select {
+			case reason := <-triggers:
+				logEvent("reload", fmt.Sprintf("Reloading: %s", reason), "reason", reason)
+				reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+	pending := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-triggers:
+			pending++
+			timer.Reset(debounce)
+		case <-timer.C:
+			logEvent("reload", fmt.Sprintf("Reloading after %d change(s)", pending), "changes", pending)
+			pending = 0
+			reload()
+		}
+	}
+}
+
+// signalTriggers forwards each signal received on sigCh as a reload
+// trigger, until ctx is cancelled.
+func signalTriggers(ctx context.Context, sigCh <-chan os.Signal, triggers chan<- string) {
+	for {
+		select {
+		case sig := <-sigCh:
+			sendTrigger(ctx, triggers, "received "+sig.String())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendTrigger sends reason on triggers unless ctx is cancelled first.
+func sendTrigger(ctx context.Context, triggers chan<- string, reason string) {
+	select {
+	case triggers <- reason:
+	case <-ctx.Done():
+	}
+}
+
+// watchTriggers sends a reload trigger whenever one of paths changes.
+// The parent directories are watched rather than the files themselves, so
+// editors that save by writing a new file and renaming it over the old
+// one keep being noticed after the watched inode is replaced. The watch
+// ends once ctx is cancelled.
+func watchTriggers(ctx context.Context, paths []string, triggers chan<- string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	watched := map[string]bool{}
+	for _, p := range paths {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		watched[p] = true
+		if err := w.Add(filepath.Dir(p)); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if watched[ev.Name] && ev.Has(fsnotify.Write|fsnotify.Create) {
+					sendTrigger(ctx, triggers, ev.Name+" changed")
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(logStderr, "Watch error: %v\n", err)
+			}
+		}
+	}()
+	return nil
+}
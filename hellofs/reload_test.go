@@ -0,0 +1,56 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadLoopStops(t *testing.T) {
+	for _, debounce := range []time.Duration{0, 10 * time.Millisecond} {
+		t.Run(debounce.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			triggers := make(chan string)
+			reloaded := make(chan struct{}, 1)
+			reloader := func(context.Context) error {
+				reloaded <- struct{}{}
+				return nil
+			}
+			done := make(chan struct{})
+			go func() {
+				reloadLoop(ctx, triggers, debounce, []func(context.Context) error{reloader})
+				close(done)
+			}()
+			triggers <- "test"
+			<-reloaded
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("reloadLoop still running after its context was cancelled")
+			}
+		})
+	}
+}
+
+func TestSignalTriggersStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		// nobody reads the trigger, which must not keep it blocked
+		signalTriggers(ctx, sigCh, make(chan string))
+		close(done)
+	}()
+	sigCh <- syscall.SIGHUP
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("signalTriggers still running after its context was cancelled")
+	}
+}
@@ -0,0 +1,83 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// remountSignal asks a mount run with -allowRemount to remount.
+var remountSignal = syscall.SIGUSR1
+
+// remountCheckEnv is set for the dry run vetting a remount: the
+// mountpoint is still mounted by the process asking.
+const remountCheckEnv = "HELLO_FUSE_REMOUNT_CHECK"
+
+// checkRemount dry-runs the program with its own command line, which
+// re-reads -config and anything else the flags point at, so a
+// configuration that would fail is caught while the mount is still up.
+func checkRemount() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, append([]string{"-dryRun"}, os.Args[1:]...)...)
+	cmd.Env = append(slices.DeleteFunc(os.Environ(), isDaemonEnv), remountCheckEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		// the last line is the error Main reported
+		lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+		return fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+	}
+	return nil
+}
+
+// reexec replaces the process with a fresh run of the same command line,
+// once the old mount is gone. A daemon's parent has already exited, so
+// there is no readiness to report this time.
+func reexec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, slices.DeleteFunc(os.Environ(), isDaemonEnv))
+}
+
+func isDaemonEnv(kv string) bool {
+	return strings.HasPrefix(kv, daemonReadyEnv+"=")
+}
+
+// watchRemount handles remountSignal until ctx is cancelled. A remount
+// that passes checkRemount is flagged in remounting, and the shutdown
+// started with shutdown; Main then calls reexec. One that fails leaves
+// the mount as it is.
+func watchRemount(ctx context.Context, remounting *atomic.Bool, shutdown func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, remountSignal)
+	defer signal.Stop(sigCh)
+	for {
+		select {
+		case <-sigCh:
+		case <-ctx.Done():
+			return
+		}
+		logEvent("remount", "Received remount signal, checking the new configuration")
+		if err := checkRemount(); err != nil {
+			logError("remount_failed", fmt.Sprintf("Not remounting, keeping the current mount: %v", err), "error", err)
+			continue
+		}
+		logEvent("remount", "New configuration is valid, unmounting to remount")
+		remounting.Store(true)
+		shutdown()
+		return
+	}
+}
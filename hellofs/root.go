@@ -0,0 +1,49 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RootOptions are the settings of a root built by NewRoot. The zero value
+// serves the entries writable, with mode 0644 and the start time as mtime.
+type RootOptions struct {
+	Logger   *log.Logger // defaults to log.Default()
+	ReadOnly bool
+	Umask    uint32
+	Mtime    time.Time
+}
+
+// NewRoot returns a root serving entries as regular files, keyed by name,
+// without any of the extras the flags add. It is meant for code that
+// mounts a tree of its own, like
+//
+//	root := NewRoot(map[string][]byte{"a.txt": []byte("a\n"), "b.txt": []byte("b\n")}, RootOptions{})
+//	server, err := fs.Mount(dir, root, &fs.Options{})
+//
+// The default tree is the one-entry map of file.txt. The entries' data is
+// written in place, so a map must not be shared between roots.
+func NewRoot(entries map[string][]byte, opts RootOptions) *HelloRoot {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	mtime := opts.Mtime
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
+	return &HelloRoot{
+		birth:    born(),
+		logger:   logger,
+		done:     context.Background(),
+		readOnly: opts.ReadOnly,
+		umask:    opts.Umask,
+		mode:     0755 &^ opts.Umask,
+		mtime:    mtime,
+		entries:  entries,
+		inos:     newInoAllocator(0, false),
+	}
+}
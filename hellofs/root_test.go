@@ -0,0 +1,245 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewRoot(t *testing.T) {
+	entries := map[string][]byte{"a.txt": []byte("a\n"), "b.txt": []byte("b\n")}
+	want := map[string]string{"a.txt": "a\n", "b.txt": "b\n"}
+	dir := mountRoot(t, NewRoot(entries, RootOptions{}))
+	for name, content := range want {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("%s = %q, want %q", name, b, content)
+		}
+	}
+}
+
+// TestStatSize stats file.txt: its size is that of its content, and its
+// times are RootOptions.Mtime, or the start time when that is unset.
+func TestStatSize(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		mtime time.Time
+	}{
+		{"start time", time.Time{}},
+		{"mtime", mtime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now().Truncate(time.Second)
+			dir := mountRoot(t, NewRoot(map[string][]byte{"file.txt": []byte("hello, world\n")}, RootOptions{Mtime: tt.mtime}))
+			fi, err := os.Stat(filepath.Join(dir, "file.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != int64(len("hello, world\n")) {
+				t.Errorf("size = %d, want %d", fi.Size(), len("hello, world\n"))
+			}
+			if tt.mtime.IsZero() {
+				if fi.ModTime().Before(start) || fi.ModTime().After(time.Now()) {
+					t.Errorf("mtime = %v, want the start time %v", fi.ModTime(), start)
+				}
+			} else if !fi.ModTime().Equal(tt.mtime) {
+				t.Errorf("mtime = %v, want %v", fi.ModTime(), tt.mtime)
+			}
+		})
+	}
+}
+
+// TestUmask creates a file and a directory through the mount with the
+// process umask cleared, so only the tree's own applies: with umask 022,
+// touch gives 0644 and mkdir 0755, and without one the modes asked for.
+func TestUmask(t *testing.T) {
+	defer syscall.Umask(syscall.Umask(0))
+	tests := []struct {
+		name              string
+		umask             uint32
+		wantFile, wantDir os.FileMode
+		wantRoot          os.FileMode
+	}{
+		{name: "none", wantFile: 0o666, wantDir: 0o777, wantRoot: 0o755},
+		{name: "022", umask: 0o022, wantFile: 0o644, wantDir: 0o755, wantRoot: 0o755},
+		{name: "077", umask: 0o077, wantFile: 0o600, wantDir: 0o700, wantRoot: 0o700},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := mountRoot(t, NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{Umask: tt.umask}))
+			f, err := os.OpenFile(filepath.Join(dir, "touched"), os.O_CREATE|os.O_WRONLY, 0o666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			if err := os.Mkdir(filepath.Join(dir, "sub"), 0o777); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]os.FileMode{"touched": tt.wantFile, "sub": tt.wantDir, ".": tt.wantRoot} {
+				fi, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Mode().Perm() != want {
+					t.Errorf("%s mode = %v, want %v", name, fi.Mode().Perm(), want)
+				}
+			}
+		})
+	}
+}
+
+// TestNewRootReadOnly changes a read-only tree in the ways a client can;
+// the mount has no ro option, so each request reaches the tree and must
+// get EROFS there.
+func TestNewRootReadOnly(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{ReadOnly: true})
+	dir := mountRoot(t, root)
+	path := filepath.Join(dir, "file.txt")
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{"open for writing", func() error {
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			f.Close()
+			return err
+		}},
+		{"create", func() error { return os.WriteFile(filepath.Join(dir, "new.txt"), nil, 0644) }},
+		{"mkdir", func() error { return os.Mkdir(filepath.Join(dir, "d"), 0755) }},
+		{"truncate", func() error { return os.Truncate(path, 0) }},
+		{"chmod", func() error { return os.Chmod(path, 0600) }},
+		{"remove", func() error { return os.Remove(path) }},
+		{"rename", func() error { return os.Rename(path, filepath.Join(dir, "moved.txt")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, syscall.EROFS) {
+				t.Errorf("%s: error = %v, want EROFS", tt.name, err)
+			}
+		})
+	}
+	// a write on a handle opened before would bypass Open
+	f := root.GetChild("file.txt").Operations().(*HelloFile)
+	if _, errno := f.Write(context.Background(), nil, []byte("x"), 0); errno != syscall.EROFS {
+		t.Errorf("Write() = %v, want EROFS", errno)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "hello\n" {
+		t.Errorf("file.txt = %q, %v, want it unchanged", b, err)
+	}
+}
+
+// TestAliases serves file.txt under a second name, which must be the same
+// inode: one number, two links, and writes through either name seen
+// through the other.
+func TestAliases(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.aliases = []string{"alias.txt"}
+	dir := mountRoot(t, root)
+	file, alias := filepath.Join(dir, "file.txt"), filepath.Join(dir, "alias.txt")
+	var fst, ast syscall.Stat_t
+	if err := syscall.Stat(file, &fst); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Stat(alias, &ast); err != nil {
+		t.Fatal(err)
+	}
+	if fst.Ino != ast.Ino {
+		t.Errorf("file.txt is inode %d, alias.txt %d, want the same", fst.Ino, ast.Ino)
+	}
+	if fst.Nlink != 2 || ast.Nlink != 2 {
+		t.Errorf("nlink = %d and %d, want 2", fst.Nlink, ast.Nlink)
+	}
+	if err := os.WriteFile(alias, []byte("through the alias\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(file); err != nil || string(b) != "through the alias\n" {
+		t.Errorf("file.txt after writing alias.txt = %q, %v", b, err)
+	}
+}
+
+// TestDiskUsage runs du on a mount with a small file, a 10000-byte one
+// and an alias: it reports each inode once, its size rounded up to 4K
+// blocks, so 16K rather than the zero of unset block counts.
+func TestDiskUsage(t *testing.T) {
+	if _, err := exec.LookPath("du"); err != nil {
+		t.Skip("du not found")
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n"), "big.bin": make([]byte, 10000)}, RootOptions{})
+	root.aliases = []string{"alias.txt"}
+	dir := mountRoot(t, root)
+	for name, want := range map[string]int64{"file.txt": 8, "big.bin": 24} {
+		var st syscall.Stat_t
+		if err := syscall.Stat(filepath.Join(dir, name), &st); err != nil {
+			t.Fatal(err)
+		}
+		if st.Blocks != want {
+			t.Errorf("%s: %d blocks, want %d", name, st.Blocks, want)
+		}
+	}
+	out, err := exec.Command("du", "-sk", dir).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := strings.Fields(string(out)); len(f) == 0 || f[0] != "16" {
+		t.Errorf("du -sk = %q, want 16", out)
+	}
+}
+
+// TestRootMode mounts with the root options buildOptions makes from
+// -rootMode, -rootUser and -rootGroup: stat of the mountpoint shows them,
+// while file.txt keeps the -uid/-gid owner.
+func TestRootMode(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode, user, grp  string
+		wantUid, wantGid uint32
+		wantMode         os.FileMode
+	}{
+		{name: "defaults", wantUid: 4242, wantGid: 4343, wantMode: 0o755},
+		{name: "0700 root", mode: "0700", user: "root", grp: "root", wantMode: 0o700},
+		{name: "ids", mode: "0750", user: "1234", grp: "5678", wantUid: 1234, wantGid: 5678, wantMode: 0o750},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &command{o: flagDefaults()}
+			c.o.Uid, c.o.Gid = 4242, 4343
+			c.o.RootMode, c.o.RootUser, c.o.RootGroup = tt.mode, tt.user, tt.grp
+			if err := c.buildOptions(); err != nil {
+				t.Fatal(err)
+			}
+			root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+			root.mode, root.owner = c.rootMode, c.rootOwner
+			dir := mountTest(t, Config{Root: root, Options: c.opts, rootOwners: c.rootOwners})
+			for _, want := range []struct {
+				name     string
+				uid, gid uint32
+				mode     os.FileMode
+			}{
+				{".", tt.wantUid, tt.wantGid, tt.wantMode},
+				{"file.txt", 4242, 4343, 0o644},
+			} {
+				fi, err := os.Stat(filepath.Join(dir, want.name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				st := fi.Sys().(*syscall.Stat_t)
+				if st.Uid != want.uid || st.Gid != want.gid || fi.Mode().Perm() != want.mode {
+					t.Errorf("%s: %d:%d %v, want %d:%d %v", want.name, st.Uid, st.Gid, fi.Mode().Perm(), want.uid, want.gid, want.mode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Config describes a mount run by Run. Main fills it in from the flags.
+type Config struct {
+	// Mountpoint is where the tree is mounted. If FuseFd is set, it must
+	// already be mounted on the connection with that fd.
+	Mountpoint string
+	FuseFd     int
+
+	Root    fs.InodeEmbedder
+	Options *fs.Options // includes UID/GID and the fuse.MountOptions
+
+	// VerifyFile is stat'ed and read, relative to the mountpoint, to
+	// check that the mount works, retrying for up to ReadyTimeout. Empty
+	// checks the mountpoint itself.
+	VerifyFile   string
+	ReadyTimeout time.Duration
+
+	// MountTimeout bounds the time to ready: mounting and verifying
+	// together. Verifying gets whatever is left of it, up to ReadyTimeout.
+	MountTimeout      time.Duration
+	ShutdownTimeout   time.Duration // bounds unmounting and waiting for the server; 0 waits forever
+	MountRetries      int
+	MountRetryBackoff time.Duration
+	UnmountCmd        string // see UnmountMethods; empty tries them all
+
+	// OnMount is called once the mount is up, when the kernel can be
+	// sent notifications.
+	OnMount func()
+
+	// OnReady is called once VerifyFile has been verified. If it returns
+	// an error, Run unmounts and returns it.
+	OnReady func() error
+
+	// DrainWrites waits, when ctx is cancelled, for the writes in
+	// progress to finish before BeforeUnmount, refusing new ones with
+	// EAGAIN meanwhile. The wait and the unmount share ShutdownTimeout;
+	// Run returns with ExitWritesPending if some writes never finished.
+	DrainWrites bool
+
+	// BeforeUnmount is called when ctx is cancelled, while the mount is
+	// still up. Its error is logged and the unmount goes ahead.
+	BeforeUnmount func() error
+
+	// AfterUnmount is called once a mount that came up was unmounted.
+	AfterUnmount func()
+
+	// Reloaders are called on SIGHUP, and on changes to WatchPaths if
+	// WatchConfig is set.
+	Reloaders      []func(context.Context) error
+	WatchPaths     []string
+	WatchConfig    bool
+	ReloadDebounce time.Duration
+
+	SnapshotFile     string
+	SnapshotInterval time.Duration
+
+	// Control, if set, is updated with the state of the mount for the
+	// control endpoints.
+	Control *controlState
+
+	// RecoverPanics replies EIO to a request whose node method panics,
+	// instead of crashing the process.
+	RecoverPanics bool
+	// StrictErrno maps backend errors to the errnos they stand for rather
+	// than EIO, see errnoOf.
+	StrictErrno bool
+	// MaxNameLen, MaxOpenFiles and MaxDirEntries are the limits set by
+	// the flags of the same names; 0 means no limit.
+	MaxNameLen    int
+	MaxOpenFiles  int
+	MaxDirEntries int
+	// AllowOtherBestEffort mounts without allow_other if it is refused.
+	AllowOtherBestEffort bool
+
+	hooks         []opHook                 // notified of each node operation
+	attrOverrides map[string]*attrOverride // -attrOverrides, by absolute path
+	rootOwners    bool                     // some entry may be owned by root, see rootOwners
+	drain         *drainState              // -lingerOnSignal, nil without
+	reads         *readGate                // -blockReads, nil without
+}
+
+// Run mounts cfg.Root and serves it until the mount goes away or ctx is
+// cancelled, in which case it unmounts. It returns once the server has
+// stopped.
+func Run(ctx context.Context, cfg Config) (err error) {
+	// go-fuse takes the magic /dev/fd/N mountpoint to mean an open
+	// connection; the real mountpoint is still needed to verify and
+	// unmount
+	mountSource := cfg.Mountpoint
+	if cfg.FuseFd > 0 {
+		mountSource = fmt.Sprintf("/dev/fd/%d", cfg.FuseFd)
+	}
+
+	control := cfg.Control
+	if control == nil {
+		control = &controlState{}
+		cfg.Control = control
+	}
+	if cfg.DrainWrites && control.requests == nil {
+		control.requests = newInFlight()
+		// first, so the writes the other hooks delay count as in progress
+		cfg.hooks = append([]opHook{control.requests.hook}, cfg.hooks...)
+	}
+	attachState(&cfg)
+	// however shutting down starts, it gets ShutdownTimeout in all
+	shutdownBy := sync.OnceValue(func() <-chan struct{} { return closeAfter(cfg.ShutdownTimeout) })
+
+	var (
+		server   *fuse.Server
+		mountErr error
+	)
+	done := make(chan struct{})
+	deadline := time.Now().Add(cfg.MountTimeout)
+	go func() {
+		server, mountErr = mountWithRetry(ctx, mountSource, &cfg, deadline)
+		close(done)
+	}()
+	// an attempt in progress can't be interrupted, so giving up on it
+	// means waiting for it and undoing the mount it made rather than
+	// leaving it behind
+	abandon := func() error {
+		select {
+		case <-done:
+		case <-shutdownBy():
+			return withCode(ExitShutdownTimeout, fmt.Errorf("%w after %v, with the mount still in progress", errShutdownTimeout, cfg.ShutdownTimeout))
+		}
+		if mountErr != nil {
+			return nil
+		}
+		stopped := make(chan struct{})
+		go func() {
+			server.Wait()
+			close(stopped)
+		}()
+		switch err := release(server, cfg, stopped, shutdownBy()); {
+		case err == nil, errors.Is(err, errShutdownTimeout):
+			return err
+		default:
+			return withCode(ExitUnmount, fmt.Errorf("failed to unmount: %w", err))
+		}
+	}
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	select {
+	case <-done:
+		if mountErr != nil {
+			return withCode(ExitMount, fmt.Errorf("mount failed: %w", mountErr))
+		}
+		logStackDepth(server, cfg.Options.MaxStackDepth)
+		control.serving.Store(true)
+		control.mountedAt.Store(time.Now().UnixNano())
+		if cfg.OnMount != nil {
+			cfg.OnMount()
+		}
+	case <-timeout.C:
+		if err := abandon(); err != nil {
+			logError("unmount_failed", fmt.Sprintf("Failed to undo the timed out mount: %v", err), "mountpoint", cfg.Mountpoint, "error", err)
+		}
+		return withCode(ExitMountTimeout, fmt.Errorf("mount timed out after %v\nHint: Perhaps mount directory busy? try running 'umount %s'", cfg.MountTimeout, cfg.Mountpoint))
+	case <-ctx.Done():
+		return abandon()
+	}
+
+	// every way out from here, including a panic, goes through this
+	stopped := make(chan struct{})
+	go func() {
+		server.Wait()
+		control.serving.Store(false)
+		close(stopped)
+	}()
+	liveMounts.Store(cfg.Mountpoint, struct{}{})
+	defer func() {
+		p := recover()
+		if uerr := release(server, cfg, stopped, shutdownBy()); errors.Is(uerr, errShutdownTimeout) {
+			err = uerr
+		} else if uerr != nil {
+			if p == nil && err == nil {
+				err = withCode(ExitUnmount, fmt.Errorf("failed to unmount: %w", uerr))
+			} else {
+				logError("unmount_failed", fmt.Sprintf("Failed to unmount: %v", uerr), "mountpoint", cfg.Mountpoint, "error", uerr)
+			}
+		} else if cfg.AfterUnmount != nil {
+			cfg.AfterUnmount()
+		}
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	// SIGHUP re-reads reloadable sources without unmounting, until Run
+	// returns
+	if len(cfg.Reloaders) > 0 {
+		reloadCtx, stopReloads := context.WithCancel(ctx)
+		defer stopReloads()
+		triggers := make(chan string)
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		defer signal.Stop(hupCh)
+		go signalTriggers(reloadCtx, hupCh, triggers)
+		if cfg.WatchConfig {
+			if err := watchTriggers(reloadCtx, cfg.WatchPaths, triggers); err != nil {
+				fmt.Fprintf(logStderr, "Failed to watch for changes: %v\n", err)
+			}
+		}
+		go reloadLoop(reloadCtx, triggers, cfg.ReloadDebounce, cfg.Reloaders)
+	}
+
+	if cfg.SnapshotFile != "" {
+		go func() {
+			defer unmountOnPanic()
+			t := time.NewTicker(cfg.SnapshotInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+				case <-stopped:
+					return
+				}
+				if err := writeSnapshot(cfg.Root.EmbeddedInode(), cfg.SnapshotFile); err != nil {
+					fmt.Fprintf(logStderr, "Failed to write snapshot: %v\n", err)
+				}
+			}
+		}()
+	}
+
+	// a request stuck in the mount stalls the check, so a signal must
+	// still get through meanwhile
+	verifyStart := time.Now()
+	verified := make(chan error, 1)
+	go func() {
+		verified <- tryStatFile(filepath.Join(cfg.Mountpoint, cfg.VerifyFile), min(cfg.ReadyTimeout, time.Until(deadline)))
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			return withCode(ExitNotReady, fmt.Errorf("mount failed verification: %w", err))
+		}
+		took := time.Since(verifyStart)
+		control.ready.Store(true)
+		logEvent("ready", fmt.Sprintf("Mount ready (verified in %v)", took.Round(time.Microsecond)), "mountpoint", cfg.Mountpoint, "verify_seconds", took.Seconds())
+		if cfg.OnReady != nil {
+			if err := cfg.OnReady(); err != nil {
+				return withCode(ExitNotReady, err)
+			}
+		}
+		select {
+		case <-stopped:
+			return detached(ctx, cfg.Mountpoint)
+		case <-ctx.Done():
+		}
+	case <-stopped:
+		return detached(ctx, cfg.Mountpoint)
+	case <-ctx.Done():
+		// the check holds the file open, which would keep the unmount busy
+		select {
+		case <-verified:
+		case <-shutdownBy():
+		}
+	}
+	control.stopping.Store(true)
+	pending := 0
+	if cfg.DrainWrites {
+		pending = drainWrites(cfg.Mountpoint, control.requests, shutdownBy())
+	}
+	if cfg.BeforeUnmount != nil {
+		if err := cfg.BeforeUnmount(); err != nil {
+			logError("before_unmount_failed", fmt.Sprintf("Failed before unmount: %v", err), "mountpoint", cfg.Mountpoint, "error", err)
+		}
+	}
+	if pending > 0 {
+		return withCode(ExitWritesPending, fmt.Errorf("%d writes still in progress after %v were dropped", pending, cfg.ShutdownTimeout))
+	}
+	return nil
+}
+
+// RunAll runs every cfg at once, each as Run does, and returns once all
+// of them stopped. A mount that fails or goes away leaves the others
+// serving unless failFast is set, which unmounts them all. The error
+// joins those of the mounts, each prefixed with its mountpoint if there
+// are several.
+func RunAll(ctx context.Context, cfgs []Config, failFast bool) error {
+	if len(cfgs) == 1 {
+		return Run(ctx, cfgs[0])
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(cfgs))
+	var wg sync.WaitGroup
+	for i, cfg := range cfgs {
+		wg.Go(func() {
+			err := Run(ctx, cfg)
+			if err == nil {
+				return
+			}
+			errs[i] = fmt.Errorf("%s: %w", cfg.Mountpoint, err)
+			switch {
+			case ctx.Err() != nil:
+			case failFast:
+				logError("mount_stopped", fmt.Sprintf("Mount at %s stopped: %v; unmounting the others", cfg.Mountpoint, err), "mountpoint", cfg.Mountpoint, "error", err)
+				cancel()
+			default:
+				logError("mount_stopped", fmt.Sprintf("Mount at %s stopped: %v; serving the others on", cfg.Mountpoint, err), "mountpoint", cfg.Mountpoint, "error", err)
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// DryRun builds cfg.Root's tree as Run would, without mounting it, and
+// reports where it would have been mounted.
+func DryRun(cfg Config) error {
+	attachState(&cfg)
+	fs.NewNodeFS(cfg.Root, cfg.Options)
+	logEvent("dry_run", fmt.Sprintf("Dry run: configuration is valid, would mount at %s", cfg.Mountpoint), "mountpoint", cfg.Mountpoint)
+	return nil
+}
+
+// errShutdownTimeout is returned by Run when the server didn't stop within
+// Config.ShutdownTimeout, e.g. because a client keeps a request busy.
+var errShutdownTimeout = errors.New("timed out waiting for the server to stop")
+
+// errDetached is returned by Run when the server stopped without being
+// asked to, because something else unmounted or aborted the mount.
+var errDetached = errors.New("the mount was detached from outside")
+
+// detached returns the error Run ends with once the server stopped: none
+// if ctx was cancelled, since the shutdown was ours then.
+func detached(ctx context.Context, mountpoint string) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return withCode(ExitDetached, fmt.Errorf("%w: %s was unmounted, or the connection aborted, while serving", errDetached, mountpoint))
+}
+
+// release unmounts unless the mount already went away, and waits for the
+// server to stop until timeout is closed, what is left of
+// cfg.ShutdownTimeout.
+func release(server *fuse.Server, cfg Config, stopped, timeout <-chan struct{}) error {
+	defer liveMounts.Delete(cfg.Mountpoint)
+	select {
+	case <-stopped:
+		return nil
+	default:
+	}
+	done := make(chan error, 1)
+	go func() {
+		if err := unmount(server, cfg.Mountpoint, cfg.UnmountCmd); err != nil {
+			if hint := failureHint(err, cfg.Mountpoint, false, &cfg.Options.MountOptions); hint != "" {
+				err = fmt.Errorf("%w\n%s", err, hint)
+			}
+			done <- err
+			return
+		}
+		<-stopped
+		done <- nil
+	}()
+	if cfg.ShutdownTimeout > 0 {
+		logEvent("stopping", fmt.Sprintf("Waiting up to %v for the server to stop", cfg.ShutdownTimeout), "mountpoint", cfg.Mountpoint, "timeout", cfg.ShutdownTimeout.String())
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		return withCode(ExitShutdownTimeout, fmt.Errorf("%w after %v", errShutdownTimeout, cfg.ShutdownTimeout))
+	}
+}
+
+// closeAfter returns a channel closed after d, or never if d is 0. Unlike
+// that of after, every receiver sees it fire.
+func closeAfter(d time.Duration) <-chan struct{} {
+	c := make(chan struct{})
+	if d > 0 {
+		time.AfterFunc(d, func() { close(c) })
+	}
+	return c
+}
+
+// after is time.After, except that a zero d never fires.
+func after(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		return nil
+	}
+	return time.After(d)
+}
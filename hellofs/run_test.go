@@ -0,0 +1,116 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestRunPanic panics in Run once the mount is up: the panic must reach
+// the caller, and the mount must be gone by then.
+func TestRunPanic(t *testing.T) {
+	skipUnlessMountable(t)
+	dir := t.TempDir()
+	cfg := runConfig(dir)
+	cfg.OnReady = func() error { panic("boom") }
+	panicked := make(chan any, 1)
+	go func() {
+		defer func() { panicked <- recover() }()
+		Run(context.Background(), cfg)
+	}()
+	if p := <-panicked; p != "boom" {
+		t.Fatalf("Run panicked with %v, want boom", p)
+	}
+	if f := mountinfo(t, dir); f != nil {
+		t.Errorf("%s still mounted after the panic: %q", dir, f)
+	}
+}
+
+// TestRunCancelEarly cancels Run before the mount is ready, as an early
+// SIGINT does: it must return cleanly with nothing left mounted.
+func TestRunCancelEarly(t *testing.T) {
+	skipUnlessMountable(t)
+	tests := []struct {
+		name string
+		when string // "before" Run, or on "mount", before verifying
+	}{
+		{"before Run", "before"},
+		{"once mounted", "mount"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cfg := runConfig(dir)
+			if tt.when == "before" {
+				cancel()
+			} else {
+				cfg.OnMount = cancel
+			}
+			if err := Run(ctx, cfg); err != nil {
+				t.Errorf("Run() = %v, want nil", err)
+			}
+			if f := mountinfo(t, dir); f != nil {
+				t.Errorf("%s still mounted: %q", dir, f)
+			}
+		})
+	}
+}
+
+// TestRunCancel cancels a ready Run, as SIGINT does: the shutdown steps
+// run once each and in order, BeforeUnmount with the mount still up and
+// AfterUnmount with it gone, and Run returns nil.
+func TestRunCancel(t *testing.T) {
+	dir := t.TempDir()
+	cfg := runConfig(dir)
+	var steps []string
+	cfg.OnMount = func() { steps = append(steps, "mount") }
+	cfg.OnReady = func() error {
+		steps = append(steps, "ready")
+		return nil
+	}
+	cfg.BeforeUnmount = func() error {
+		steps = append(steps, "before unmount")
+		if mountinfo(t, dir) == nil {
+			t.Error("BeforeUnmount called with nothing mounted")
+		}
+		return nil
+	}
+	cfg.AfterUnmount = func() {
+		steps = append(steps, "after unmount")
+		if f := mountinfo(t, dir); f != nil {
+			t.Errorf("AfterUnmount called with %s still mounted: %q", dir, f)
+		}
+	}
+	stop := startRun(t, cfg)
+	if err := stop(); err != nil {
+		t.Errorf("Run() = %v, want nil", err)
+	}
+	if want := []string{"mount", "ready", "before unmount", "after unmount"}; !slices.Equal(steps, want) {
+		t.Errorf("steps = %q, want %q", steps, want)
+	}
+	if f := mountinfo(t, dir); f != nil {
+		t.Errorf("%s still mounted: %q", dir, f)
+	}
+}
+
+// TestRunNotReady verifies a file the tree doesn't have: Run must give up
+// after ReadyTimeout with ExitNotReady and unmount.
+func TestRunNotReady(t *testing.T) {
+	skipUnlessMountable(t)
+	dir := t.TempDir()
+	cfg := runConfig(dir)
+	cfg.VerifyFile = "missing.txt"
+	cfg.ReadyTimeout = 200 * time.Millisecond
+	err := Run(context.Background(), cfg)
+	if code := ExitCode(err); code != ExitNotReady {
+		t.Errorf("Run() = %v, exit code %d, want %d", err, code, ExitNotReady)
+	}
+	if f := mountinfo(t, dir); f != nil {
+		t.Errorf("%s still mounted: %q", dir, f)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// selfTestRead is the -selfTest check run once the mount is verified:
+// file.txt must read back as the content it was given.
+func selfTestRead(mountpoint string, want []byte) error {
+	got, err := os.ReadFile(filepath.Join(mountpoint, "file.txt"))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("file.txt has %q, want %q", got, want)
+	}
+	return nil
+}
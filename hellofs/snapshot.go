@@ -0,0 +1,120 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// writeSnapshot serializes the live tree under root into a tar archive at
+// dst. The archive is written to a temporary file next to dst and renamed
+// into place so readers never observe a partial snapshot.
+func writeSnapshot(root *fs.Inode, dst string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tw := tar.NewWriter(tmp)
+	if err := snapshotDir(tw, root, ""); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func snapshotDir(tw *tar.Writer, dir *fs.Inode, prefix string) error {
+	children := dir.Children()
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := snapshotNode(tw, children[name], path.Join(prefix, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func snapshotNode(tw *tar.Writer, n *fs.Inode, name string) error {
+	ctx := context.Background()
+	var attr fuse.AttrOut
+	if g, ok := n.Operations().(fs.NodeGetattrer); ok {
+		if errno := g.Getattr(ctx, nil, &attr); errno != 0 {
+			return errno
+		}
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(attr.Mode & 07777),
+		Uid:     int(attr.Uid),
+		Gid:     int(attr.Gid),
+		ModTime: time.Unix(int64(attr.Mtime), int64(attr.Mtimensec)),
+	}
+	switch n.Mode() {
+	case syscall.S_IFDIR:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return snapshotDir(tw, n, name)
+	case syscall.S_IFIFO:
+		hdr.Typeflag = tar.TypeFifo
+		return tw.WriteHeader(hdr)
+	case syscall.S_IFLNK:
+		l, ok := n.Operations().(fs.NodeReadlinker)
+		if !ok {
+			return nil
+		}
+		target, errno := l.Readlink(ctx)
+		if errno != 0 {
+			return errno
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = string(target)
+		return tw.WriteHeader(hdr)
+	case syscall.S_IFREG:
+		r, ok := n.Operations().(fs.NodeReader)
+		if !ok {
+			return nil
+		}
+		buf := make([]byte, attr.Size)
+		res, errno := r.Read(ctx, nil, buf, 0)
+		if errno != 0 {
+			return errno
+		}
+		data, status := res.Bytes(buf)
+		if !status.Ok() {
+			return syscall.Errno(status)
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	// other node types have no portable tar representation
+	return nil
+}
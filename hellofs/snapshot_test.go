@@ -0,0 +1,146 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// tarItem is what the round trip must keep of an archive entry.
+type tarItem struct {
+	Name     string
+	Typeflag byte
+	Mode     int64
+	Uid, Gid int
+	ModTime  time.Time
+	Linkname string
+	Content  string
+}
+
+func readTarItems(t *testing.T, file string) []tarItem {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var items []tarItem
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return items
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, tarItem{hdr.Name, hdr.Typeflag, hdr.Mode, hdr.Uid, hdr.Gid, hdr.ModTime.UTC(), hdr.Linkname, string(b)})
+	}
+}
+
+func writeTarItems(t *testing.T, file string, items []tarItem) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, it := range items {
+		hdr := &tar.Header{Name: it.Name, Typeflag: it.Typeflag, Mode: it.Mode, Uid: it.Uid, Gid: it.Gid, ModTime: it.ModTime, Linkname: it.Linkname, Size: int64(len(it.Content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(it.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restoreAndSnapshot builds a tree from the -tarFile archive src and
+// writes its snapshot to dst.
+func restoreAndSnapshot(t *testing.T, src, dst string) {
+	t.Helper()
+	entries, err := loadTar(src, DefaultMaxNameLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("file.txt")}, RootOptions{})
+	root.tarEntries = entries
+	fs.NewNodeFS(root, &fs.Options{})
+	if err := writeSnapshot(&root.Inode, dst); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	want := []tarItem{
+		{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0750, Uid: 1000, Gid: 1000},
+		{Name: "docs/empty", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime},
+		{Name: "docs/latest", Typeflag: tar.TypeSymlink, Mode: 0777, Linkname: "readme.txt"},
+		{Name: "docs/nested/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "docs/nested/deep.txt", Typeflag: tar.TypeReg, Mode: 0644, Uid: 1000, ModTime: mtime, Content: "deep\n"},
+		{Name: "docs/readme.txt", Typeflag: tar.TypeReg, Mode: 0640, ModTime: mtime.Add(time.Hour), Content: "hello\n"},
+		{Name: "events", Typeflag: tar.TypeFifo, Mode: 0620},
+		{Name: "file.txt", Typeflag: tar.TypeReg, Mode: 0444, ModTime: mtime, Content: "restored"},
+	}
+	in := filepath.Join(dir, "in.tar")
+	writeTarItems(t, in, want)
+
+	first, second := filepath.Join(dir, "first.tar"), filepath.Join(dir, "second.tar")
+	restoreAndSnapshot(t, in, first)
+	restoreAndSnapshot(t, first, second)
+
+	got := readTarItems(t, first)
+	// directories, symlinks and pipes keep no mtime
+	for i := range got {
+		if got[i].Typeflag != tar.TypeReg {
+			got[i].ModTime = time.Time{}
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot of the restored tree:\n got %+v\nwant %+v", got, want)
+	}
+	if again := readTarItems(t, second); !reflect.DeepEqual(again, readTarItems(t, first)) {
+		t.Errorf("restoring a snapshot changed the tree:\n got %+v\nwant %+v", again, readTarItems(t, first))
+	}
+}
+
+func TestLoadTarRefuses(t *testing.T) {
+	tests := []struct {
+		name string
+		item tarItem
+	}{
+		{"hard link", tarItem{Name: "a", Typeflag: tar.TypeLink, Linkname: "b"}},
+		{"device", tarItem{Name: "a", Typeflag: tar.TypeChar}},
+		{"escaping name", tarItem{Name: "../a", Typeflag: tar.TypeReg}},
+		{"empty symlink", tarItem{Name: "a", Typeflag: tar.TypeSymlink}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "in.tar")
+			writeTarItems(t, file, []tarItem{tt.item})
+			if _, err := loadTar(file, DefaultMaxNameLen); err == nil {
+				t.Errorf("loadTar accepted %+v", tt.item)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// SourceNode is a read-only view of a file, directory or symlink below a
+// host directory. All access goes through an os.Root, so nothing outside
+// the directory is reachable, not even through symlinks in it; those are
+// shown as symlinks and resolved by the client.
+type SourceNode struct {
+	fs.Inode
+	birth
+
+	root  *os.Root
+	rel   string       // path below root, "." for the top
+	cache *sourceCache // nil without -cacheSize
+	state *mountState  // set by Run on the top node, see stateOf
+}
+
+func newSourceRoot(dir string) (*SourceNode, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &SourceNode{root: root, rel: "."}, nil
+}
+
+// sourceStable derives the inode number from the host's, mixing in the
+// device so files from different host filesystems don't collide.
+func sourceStable(st *syscall.Stat_t) fs.StableAttr {
+	dev := uint64(st.Dev)
+	return fs.StableAttr{
+		Mode: uint32(st.Mode) & syscall.S_IFMT,
+		Ino:  (dev<<32 | dev>>32) ^ st.Ino,
+	}
+}
+
+func (n *SourceNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "lookup", &n.Inode)(&errno)
+	rel := path.Join(n.rel, name)
+	fi, err := n.root.Lstat(rel)
+	if err != nil {
+		return nil, toErrno(&n.Inode, err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	out.FromStat(st)
+	overrideEntry(stateOf(&n.Inode).attrOverrides, &n.Inode, name, &out.Attr)
+	return n.NewInode(ctx, &SourceNode{root: n.root, rel: rel, cache: n.cache}, sourceStable(st)), 0
+}
+
+func (n *SourceNode) Readdir(ctx context.Context) (ds fs.DirStream, errno syscall.Errno) {
+	defer startOp(ctx, "readdir", &n.Inode)(&errno)
+	f, err := n.root.Open(n.rel)
+	if err != nil {
+		return nil, toErrno(&n.Inode, err)
+	}
+	return &sourceDirStream{dir: f, strict: stateOf(&n.Inode).strictErrno}, 0
+}
+
+// sourceType converts the type bits of a host directory entry.
+func sourceType(m iofs.FileMode) uint32 {
+	switch {
+	case m&iofs.ModeDir != 0:
+		return syscall.S_IFDIR
+	case m&iofs.ModeSymlink != 0:
+		return syscall.S_IFLNK
+	case m&iofs.ModeNamedPipe != 0:
+		return syscall.S_IFIFO
+	case m&iofs.ModeSocket != 0:
+		return syscall.S_IFSOCK
+	case m&iofs.ModeCharDevice != 0:
+		return syscall.S_IFCHR
+	case m&iofs.ModeDevice != 0:
+		return syscall.S_IFBLK
+	}
+	return syscall.S_IFREG
+}
+
+func (n *SourceNode) Readlink(ctx context.Context) (target []byte, errno syscall.Errno) {
+	defer startOp(ctx, "readlink", &n.Inode)(&errno)
+	t, err := n.root.Readlink(n.rel)
+	if err != nil {
+		return nil, toErrno(&n.Inode, err)
+	}
+	return []byte(t), 0
+}
+
+// sourceHandle is an open host file.
+type sourceHandle struct {
+	f *os.File
+}
+
+func (n *SourceNode) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &n.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY || flags&syscall.O_TRUNC != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	f, err := n.root.Open(n.rel)
+	if err != nil {
+		return nil, 0, toErrno(&n.Inode, err)
+	}
+	return &sourceHandle{f: f}, 0, 0
+}
+
+func (n *SourceNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &n.Inode, len(dest))(&res, &errno)
+	h, ok := fh.(*sourceHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	if n.cache != nil {
+		data, err := n.cache.get(n.rel, h.f)
+		if err != nil {
+			return nil, toErrno(&n.Inode, err)
+		}
+		if data != nil {
+			if off >= int64(len(data)) {
+				return fuse.ReadResultData(nil), 0
+			}
+			return fuse.ReadResultData(data[off:min(off+int64(len(dest)), int64(len(data)))]), 0
+		}
+	}
+	c, err := h.f.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, toErrno(&n.Inode, err)
+	}
+	return fuse.ReadResultData(dest[:c]), 0
+}
+
+func (n *SourceNode) Release(ctx context.Context, fh fs.FileHandle) (errno syscall.Errno) {
+	defer startOp(ctx, "release", &n.Inode)(&errno)
+	if h, ok := fh.(*sourceHandle); ok {
+		return toErrno(&n.Inode, h.f.Close())
+	}
+	return 0
+}
+
+func (n *SourceNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &n.Inode)(out, &errno)
+	var fi iofs.FileInfo
+	var err error
+	if h, ok := fh.(*sourceHandle); ok {
+		fi, err = h.f.Stat()
+	} else {
+		fi, err = n.root.Lstat(n.rel)
+	}
+	if err != nil {
+		return toErrno(&n.Inode, err)
+	}
+	out.FromStat(fi.Sys().(*syscall.Stat_t))
+	return 0
+}
+
+func (n *SourceNode) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, n, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*SourceNode)(nil))
+	_ = (fs.NodeLookuper)((*SourceNode)(nil))
+	_ = (fs.NodeReaddirer)((*SourceNode)(nil))
+	_ = (fs.NodeReadlinker)((*SourceNode)(nil))
+	_ = (fs.NodeOpener)((*SourceNode)(nil))
+	_ = (fs.NodeReader)((*SourceNode)(nil))
+	_ = (fs.NodeReleaser)((*SourceNode)(nil))
+	_ = (fs.NodeGetattrer)((*SourceNode)(nil))
+)
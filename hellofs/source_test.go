@@ -0,0 +1,53 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// TestStackedMounts serves one mount's tree through a -source mount of
+// it, two FUSE layers deep, with -maxStackDepth allowing for both.
+func TestStackedMounts(t *testing.T) {
+	lower := mountRoot(t, NewRoot(map[string][]byte{"file.txt": []byte("lower\n")}, RootOptions{}))
+	src, err := newSourceRoot(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// after upper is unmounted, before lower is
+	t.Cleanup(func() { src.root.Close() })
+	upper := mountTest(t, Config{Root: src, Options: &fs.Options{MountOptions: fuse.MountOptions{MaxStackDepth: 2}}})
+	if b, err := os.ReadFile(filepath.Join(upper, "file.txt")); err != nil || string(b) != "lower\n" {
+		t.Errorf("read through both layers: %q, %v, want %q", b, err, "lower\n")
+	}
+	entries, err := os.ReadDir(upper)
+	if err != nil || len(entries) != 1 || entries[0].Name() != "file.txt" {
+		t.Errorf("listing through both layers: %v, %v, want file.txt", entries, err)
+	}
+	// the kernel releases upper's handles in the background, and any still
+	// open on lower when upper is unmounted would keep lower busy; src's
+	// own stays open until the cleanup
+	for deadline := time.Now().Add(2 * time.Second); openUnder(lower) > 1 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// openUnder counts the process's open files of dir and below it.
+func openUnder(dir string) int {
+	n := 0
+	fds, _ := os.ReadDir("/proc/self/fd")
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && (target == dir || strings.HasPrefix(target, dir+"/")) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,83 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+)
+
+// sourceCache holds the content of recently read -source files, up to max
+// bytes in total, dropping the least recently used first. An entry is
+// only served while the host file has the mtime and size it was read
+// with, so a changed file is read again.
+type sourceCache struct {
+	mu      sync.Mutex
+	max     int64
+	used    int64
+	lru     *list.List // of *sourceCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type sourceCacheEntry struct {
+	rel   string
+	mtime int64 // unix nanoseconds
+	size  int64
+	data  []byte
+}
+
+func newSourceCache(max int64) *sourceCache {
+	return &sourceCache{max: max, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the content of rel, the host file open as f, reading it
+// whole on a miss. It returns nil for a file too big to cache.
+func (c *sourceCache) get(rel string, f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	mtime, size := fi.ModTime().UnixNano(), fi.Size()
+	if size > c.max {
+		return nil, nil
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[rel]; ok {
+		e := el.Value.(*sourceCacheEntry)
+		if e.mtime == mtime && e.size == size {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return e.data, nil
+		}
+		c.remove(el)
+	}
+	c.mu.Unlock()
+
+	data := make([]byte, size)
+	n, err := f.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = data[:n]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[rel]; ok {
+		// read by someone else meanwhile
+		c.remove(el)
+	}
+	c.entries[rel] = c.lru.PushFront(&sourceCacheEntry{rel: rel, mtime: mtime, size: size, data: data})
+	c.used += int64(len(data))
+	for c.used > c.max {
+		c.remove(c.lru.Back())
+	}
+	return data, nil
+}
+
+func (c *sourceCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*sourceCacheEntry)
+	delete(c.entries, e.rel)
+	c.used -= int64(len(e.data))
+}
@@ -0,0 +1,78 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cacheGet opens path and gets it from c as rel.
+func cacheGet(t *testing.T, c *sourceCache, path, rel string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := c.get(rel, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestSourceCacheMtime rewrites a cached file in place: the cache keeps
+// serving the old bytes until the mtime changes.
+func TestSourceCacheMtime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("aaaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	c := newSourceCache(1 << 20)
+	if got := cacheGet(t, c, path, "a.txt"); got != "aaaa" {
+		t.Fatalf("first get = %q, want %q", got, "aaaa")
+	}
+	if err := os.WriteFile(path, []byte("bbbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got := cacheGet(t, c, path, "a.txt"); got != "aaaa" {
+		t.Errorf("get with the mtime unchanged = %q, want the cached %q", got, "aaaa")
+	}
+	if err := os.Chtimes(path, mtime, mtime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := cacheGet(t, c, path, "a.txt"); got != "bbbb" {
+		t.Errorf("get after the mtime changed = %q, want %q", got, "bbbb")
+	}
+}
+
+func TestSourceCacheEvicts(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a": "aaaaa", "b": "bbbbb", "big": "longer than the cache"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := newSourceCache(8)
+	cacheGet(t, c, filepath.Join(dir, "a"), "a")
+	cacheGet(t, c, filepath.Join(dir, "b"), "b")
+	if _, ok := c.entries["a"]; ok {
+		t.Error("a is still cached after b pushed the cache over its size")
+	}
+	if _, ok := c.entries["b"]; !ok || c.used != 5 {
+		t.Errorf("b cached %v, used %d, want b alone with 5 bytes", ok, c.used)
+	}
+	if got := cacheGet(t, c, filepath.Join(dir, "big"), "big"); got != "" {
+		t.Errorf("get of a file over the cache size = %q, want nil", got)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// SparseFile is a hole of the given size: it reads as zeros and has no
+// blocks allocated, so du reports it as empty while ls shows its size.
+type SparseFile struct {
+	fs.Inode
+	birth
+
+	size int64
+}
+
+func (f *SparseFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *SparseFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	if off >= f.size {
+		return fuse.ReadResultData(nil), 0
+	}
+	buf := dest[:min(off+int64(len(dest)), f.size)-off]
+	clear(buf)
+	return fuse.ReadResultData(buf), 0
+}
+
+func (f *SparseFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = 0444
+	out.Size = uint64(f.size)
+	// go-fuse estimates blocks from the size unless the block size is set
+	out.Blksize = 4096
+	out.Blocks = 0
+	return 0
+}
+
+func (f *SparseFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*SparseFile)(nil))
+	_ = (fs.NodeOpener)((*SparseFile)(nil))
+	_ = (fs.NodeReader)((*SparseFile)(nil))
+	_ = (fs.NodeGetattrer)((*SparseFile)(nil))
+)
+
+// ZeroFile reads as zeros like SparseFile, generated on each read, but is
+// meant for measuring read throughput: it reports its blocks as allocated
+// and doesn't keep the page cache across opens, so every run of a
+// benchmark goes through the mount.
+type ZeroFile struct {
+	SparseFile
+}
+
+func (f *ZeroFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	fh, fuseFlags, errno = f.SparseFile.Open(ctx, flags)
+	return fh, fuseFlags &^ fuse.FOPEN_KEEP_CACHE, errno
+}
+
+func (f *ZeroFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = 0444
+	out.Size = uint64(f.size)
+	return 0
+}
+
+func (f *ZeroFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*ZeroFile)(nil))
+	_ = (fs.NodeOpener)((*ZeroFile)(nil))
+	_ = (fs.NodeGetattrer)((*ZeroFile)(nil))
+)
@@ -0,0 +1,203 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// specEntry is one line of a tree spec.
+type specEntry struct {
+	line    int
+	kind    string // dir, file or link
+	path    string
+	mode    uint32
+	content []byte
+	target  string
+}
+
+// parseSpec reads a tree spec. Each line declares one node, parents before
+// their children:
+//
+//	dir docs 0755
+//	file docs/readme.txt 0644 "hello\n"
+//	link cur -> docs
+//
+// File content is a Go string literal. Blank lines and lines starting with
+// # are ignored.
+func parseSpec(file string) ([]specEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []specEntry
+	kinds := map[string]string{"": "dir"}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := parseSpecLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		e.line = n
+		if _, ok := kinds[e.path]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is already defined", file, n, e.path)
+		}
+		parent, _ := path.Split(e.path)
+		parent = strings.TrimSuffix(parent, "/")
+		if kinds[parent] != "dir" {
+			return nil, fmt.Errorf("%s:%d: parent directory of %s is not defined", file, n, e.path)
+		}
+		kinds[e.path] = e.kind
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// nextField splits off the first whitespace separated field of s.
+func nextField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+func parseSpecLine(line string) (specEntry, error) {
+	var e specEntry
+	var p, mode, rest string
+	e.kind, rest = nextField(line)
+	p, rest = nextField(rest)
+	switch e.kind {
+	case "dir":
+		mode, rest = nextField(rest)
+		if p == "" || mode == "" || rest != "" {
+			return e, fmt.Errorf("want: dir PATH MODE")
+		}
+	case "file":
+		mode, rest = nextField(rest)
+		if p == "" || mode == "" || rest == "" {
+			return e, fmt.Errorf("want: file PATH MODE \"CONTENT\"")
+		}
+		content, err := strconv.Unquote(rest)
+		if err != nil {
+			return e, fmt.Errorf("content must be a quoted string, got %s", rest)
+		}
+		e.content = []byte(content)
+	case "link":
+		var arrow string
+		arrow, rest = nextField(rest)
+		e.target, rest = nextField(rest)
+		if p == "" || arrow != "->" || e.target == "" || rest != "" {
+			return e, fmt.Errorf("want: link PATH -> TARGET")
+		}
+	default:
+		return e, fmt.Errorf("unknown node type %q, want dir, file or link", e.kind)
+	}
+	e.path = strings.Trim(p, "/")
+	for _, name := range strings.Split(e.path, "/") {
+		if !validName(name) {
+			return e, fmt.Errorf("invalid path %q", p)
+		}
+	}
+	if e.kind != "link" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m&^07777 != 0 {
+			return e, fmt.Errorf("invalid mode %q", mode)
+		}
+		e.mode = uint32(m)
+	}
+	return e, nil
+}
+
+// cloneSpec returns a copy of entries with content of its own, to build
+// another tree from.
+func cloneSpec(entries []specEntry) []specEntry {
+	entries = slices.Clone(entries)
+	for i := range entries {
+		entries[i].content = slices.Clone(entries[i].content)
+	}
+	return entries
+}
+
+// addSpec builds the nodes of a spec below root.
+func addSpec(ctx context.Context, root *HelloRoot, entries []specEntry) {
+	for _, e := range entries {
+		// parseSpec checked that parents come first
+		dir, name := path.Split(e.path)
+		parent := &root.Inode
+		if dir != "" {
+			for _, p := range strings.Split(strings.TrimSuffix(dir, "/"), "/") {
+				parent = parent.GetChild(p)
+			}
+		}
+		var ch *fs.Inode
+		switch e.kind {
+		case "dir":
+			ch = parent.NewPersistentInode(ctx, &SpecDir{birth: born(), mode: e.mode &^ root.umask}, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: root.inos.alloc()})
+		case "file":
+			f := &HelloFile{
+				birth:          born(),
+				root:           root,
+				MemRegularFile: fs.MemRegularFile{Data: e.content, Attr: fuse.Attr{Mode: e.mode &^ root.umask}},
+			}
+			ch = parent.NewPersistentInode(ctx, f, fs.StableAttr{Ino: root.inos.alloc()})
+		case "link":
+			ch = parent.NewPersistentInode(ctx, &Symlink{birth: born(), target: []byte(e.target)}, fs.StableAttr{Mode: syscall.S_IFLNK, Ino: root.inos.alloc()})
+		}
+		if !parent.AddChild(name, ch, false) {
+			root.logger.Printf("spec line %d: %s already exists, skipping", e.line, e.path)
+		}
+	}
+}
+
+// SpecDir is a directory declared in a spec or manifest.
+type SpecDir struct {
+	fs.Inode
+	birth
+	cacheTimeouts
+
+	mode  uint32
+	owner fuse.Owner
+}
+
+func (d *SpecDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = d.mode
+	out.Owner = d.owner
+	d.setAttrTimeout(out)
+	return 0
+}
+
+func (d *SpecDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "lookup", &d.Inode)(&errno)
+	return lookupChild(ctx, &d.Inode, name, out)
+}
+
+func (d *SpecDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*SpecDir)(nil))
+	_ = (fs.NodeLookuper)((*SpecDir)(nil))
+	_ = (fs.NodeGetattrer)((*SpecDir)(nil))
+)
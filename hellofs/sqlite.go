@@ -0,0 +1,133 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	_ "modernc.org/sqlite"
+)
+
+// SQLDir presents the result of a query as a directory. The query must
+// return two columns: the file name and its content. NULL content is
+// served as an empty file.
+type SQLDir struct {
+	fs.Inode
+	birth
+
+	db     *sql.DB
+	query  string
+	logger *log.Logger
+	rows   map[string][]byte // initial result, applied in OnAdd
+}
+
+// openSQLDir opens the database and runs the query once, so that a bad
+// database or query fails before mounting.
+func openSQLDir(path, query string, logger *log.Logger) (*SQLDir, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	d := &SQLDir{birth: born(), db: db, query: query, logger: logger}
+	if d.rows, err = d.fetch(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *SQLDir) fetch() (map[string][]byte, error) {
+	rows, err := d.db.Query(d.query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	files := map[string][]byte{}
+	for rows.Next() {
+		var name sql.NullString
+		var content []byte
+		if err := rows.Scan(&name, &content); err != nil {
+			return nil, err
+		}
+		if !name.Valid || !validName(name.String) {
+			d.logger.Printf("sqlite: skipping row with invalid name %q", name.String)
+			continue
+		}
+		if _, ok := files[name.String]; ok {
+			d.logger.Printf("sqlite: skipping duplicate name %q", name.String)
+			continue
+		}
+		files[name.String] = content
+	}
+	return files, rows.Err()
+}
+
+func (d *SQLDir) OnAdd(ctx context.Context) {
+	d.apply(ctx, d.rows, false)
+	d.rows = nil
+}
+
+// apply makes the directory match files. Existing files are updated in
+// place, so open handles keep working. If notify is set, the kernel is
+// told to drop what it cached for changed and removed entries; this must
+// only be done once the server is running.
+func (d *SQLDir) apply(ctx context.Context, files map[string][]byte, notify bool) {
+	for name, ch := range d.Children() {
+		if _, ok := files[name]; !ok {
+			ch.Operations().(*StaticFile).markStale()
+			ch.ForgetPersistent()
+			d.RmChild(name)
+			if notify {
+				// cached pages would let open handles read on
+				ch.NotifyContent(0, 0)
+				d.NotifyDelete(name, ch)
+			}
+		}
+	}
+	for name, content := range files {
+		if ch := d.GetChild(name); ch != nil {
+			if ch.Operations().(*StaticFile).set(content) && notify {
+				ch.NotifyContent(0, 0)
+			}
+			continue
+		}
+		ch := d.NewPersistentInode(ctx, newStaticFile(content), fs.StableAttr{})
+		d.AddChild(name, ch, false)
+		if notify {
+			d.NotifyEntry(name)
+		}
+	}
+}
+
+// reload re-runs the query and applies the new result. On error the
+// previous content is left in place.
+func (d *SQLDir) reload(ctx context.Context) error {
+	files, err := d.fetch()
+	if err != nil {
+		return fmt.Errorf("sqlite reload: %w", err)
+	}
+	d.apply(ctx, files, true)
+	return nil
+}
+
+func (d *SQLDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 0755
+	return 0
+}
+
+func (d *SQLDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*SQLDir)(nil))
+	_ = (fs.NodeOnAdder)((*SQLDir)(nil))
+	_ = (fs.NodeGetattrer)((*SQLDir)(nil))
+)
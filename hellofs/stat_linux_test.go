@@ -0,0 +1,8 @@
+package hellofs
+
+import "syscall"
+
+// statTimes returns the access, modification and change times of st.
+func statTimes(st *syscall.Stat_t) (atime, mtime, ctime syscall.Timespec) {
+	return st.Atim, st.Mtim, st.Ctim
+}
@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// mountState is what the nodes of one mount share, taken from its Config
+// by Run. A node finds it through the root of its tree, see stateOf.
+type mountState struct {
+	hooks         []opHook
+	recoverPanics bool
+	strictErrno   bool
+	maxNameLen    int
+	maxDirEntries int
+	attrOverrides map[string]*attrOverride
+	reads         *readGate // nil without -blockReads
+}
+
+func newMountState(cfg *Config) *mountState {
+	return &mountState{
+		hooks:         cfg.hooks,
+		recoverPanics: cfg.RecoverPanics,
+		strictErrno:   cfg.StrictErrno,
+		maxNameLen:    cfg.MaxNameLen,
+		maxDirEntries: cfg.MaxDirEntries,
+		attrOverrides: cfg.attrOverrides,
+		reads:         cfg.reads,
+	}
+}
+
+// stateRoot is a root that keeps the mountState of its tree: HelloRoot
+// and SourceNode.
+type stateRoot interface {
+	mountState() *mountState
+	setMountState(s *mountState)
+}
+
+// attachState hands the root of cfg the state of the mount, before its
+// tree is built so OnAdd sees it already.
+func attachState(cfg *Config) {
+	if r, ok := cfg.Root.(stateRoot); ok {
+		r.setMountState(newMountState(cfg))
+	}
+}
+
+// stateOf returns the state of the mount n is in. A tree no Run was
+// given, such as one a test builds, gets the zero state: no hooks, no
+// limits and panics not recovered.
+func stateOf(n *fs.Inode) *mountState {
+	if r, ok := n.Root().Operations().(stateRoot); ok {
+		if s := r.mountState(); s != nil {
+			return s
+		}
+	}
+	return &mountState{}
+}
+
+func (r *HelloRoot) mountState() *mountState      { return r.state }
+func (r *HelloRoot) setMountState(s *mountState)  { r.state = s }
+func (n *SourceNode) mountState() *mountState     { return n.state }
+func (n *SourceNode) setMountState(s *mountState) { n.state = s }
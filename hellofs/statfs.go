@@ -0,0 +1,65 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+const statfsBlock = 4096
+
+// ByteSize is a flag value taking a byte count with an optional K, M, G or
+// T suffix, in powers of 1024.
+type ByteSize uint64
+
+func (s *ByteSize) String() string {
+	return strconv.FormatUint(uint64(*s), 10)
+}
+
+func (s *ByteSize) Set(v string) error {
+	mult := uint64(1)
+	num := strings.TrimSuffix(strings.ToUpper(v), "B")
+	if i := strings.IndexAny(num, "KMGT"); i >= 0 && i == len(num)-1 {
+		mult = 1 << (10 * (strings.IndexByte("KMGT", num[i]) + 1))
+		num = num[:i]
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", v)
+	}
+	if n > (1<<64-1)/mult {
+		return fmt.Errorf("size %q is too large", v)
+	}
+	*s = ByteSize(n * mult)
+	return nil
+}
+
+// Statfs reports a filesystem of fsSize bytes. Unless fsFree is set, the
+// free space is what the in-memory content leaves. There is one inode per
+// block, so the inode counts follow the block counts.
+func (r *HelloRoot) Statfs(ctx context.Context, out *fuse.StatfsOut) (errno syscall.Errno) {
+	defer startOp(ctx, "statfs", &r.Inode)(&errno)
+	free := r.fsFree
+	if free < 0 {
+		free = int64(r.fsSize) - int64(treeUsage(&r.Inode))
+	}
+	free = max(0, min(free, int64(r.fsSize)))
+	out.Bsize = statfsBlock
+	out.Frsize = statfsBlock
+	out.NameLen = 255
+	if limit := stateOf(&r.Inode).maxNameLen; limit > 0 {
+		out.NameLen = uint32(limit)
+	}
+	out.Blocks = r.fsSize / statfsBlock
+	out.Bfree = uint64(free) / statfsBlock
+	out.Bavail = out.Bfree
+	out.Files = out.Blocks
+	out.Ffree = out.Bfree
+	return 0
+}
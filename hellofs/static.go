@@ -0,0 +1,108 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// StaticFile is a read-only file whose content can be replaced as a whole,
+// e.g. when its source is reloaded. Readers never see a mix of old and new
+// content within a single read. Once a reload drops the file, handles
+// still open on it get ESTALE.
+type StaticFile struct {
+	fs.Inode
+	birth
+
+	mode uint32 // permission bits, 0444 if zero
+
+	mu    sync.Mutex
+	data  []byte
+	stale bool // removed by a reload
+}
+
+func newStaticFile(data []byte) *StaticFile {
+	return &StaticFile{birth: born(), data: data}
+}
+
+func (f *StaticFile) content() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.data
+}
+
+// markStale makes further access through existing handles fail.
+func (f *StaticFile) markStale() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stale = true
+}
+
+// set replaces the content and reports whether it changed.
+func (f *StaticFile) set(data []byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if string(f.data) == string(data) {
+		return false
+	}
+	f.data = data
+	return true
+}
+
+func (f *StaticFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stale {
+		return nil, 0, syscall.ESTALE
+	}
+	return nil, 0, 0
+}
+
+func (f *StaticFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stale {
+		return nil, syscall.ESTALE
+	}
+	if off >= int64(len(f.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(f.data)))
+	return fuse.ReadResultData(f.data[off:end]), 0
+}
+
+func (f *StaticFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stale {
+		return syscall.ESTALE
+	}
+	out.Mode = 0444
+	if f.mode != 0 {
+		out.Mode = f.mode
+	}
+	out.Size = uint64(len(f.data))
+	return 0
+}
+
+func (f *StaticFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*StaticFile)(nil))
+	_ = (fs.NodeOpener)((*StaticFile)(nil))
+	_ = (fs.NodeReader)((*StaticFile)(nil))
+	_ = (fs.NodeGetattrer)((*StaticFile)(nil))
+)
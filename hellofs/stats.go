@@ -0,0 +1,137 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// statsName is the file at the root of the mount showing the read
+// statistics. Reads of it are not counted.
+const statsName = ".stats"
+
+// readStats counts the read requests the kernel sends for each file, to
+// show how much readahead fetches compared to what it ends up serving.
+type readStats struct {
+	mu    sync.Mutex
+	files map[string]*readCounter
+}
+
+type readCounter struct {
+	reads     uint64
+	requested uint64 // bytes asked for
+	served    uint64 // bytes returned
+}
+
+func (c *readCounter) add(o *readCounter) {
+	c.reads += o.reads
+	c.requested += o.requested
+	c.served += o.served
+}
+
+func newReadStats() *readStats {
+	return &readStats{files: map[string]*readCounter{}}
+}
+
+func (s *readStats) hook(ctx context.Context, op, path string) func(opResult) {
+	if op != "read" || path == "/"+statsName {
+		return func(opResult) {}
+	}
+	return func(r opResult) {
+		if r.errno != 0 {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c, ok := s.files[path]
+		if !ok {
+			c = &readCounter{}
+			s.files[path] = c
+		}
+		c.add(&readCounter{reads: 1, requested: uint64(r.requested), served: uint64(r.bytes)})
+	}
+}
+
+// render prints the totals followed by one line per file, for example
+//
+//	total reads=3 requested=393216 served=12 avg_request=131072 avg_served=4
+func (s *readStats) render() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b bytes.Buffer
+	line := func(name string, c *readCounter) {
+		var avgReq, avgServed uint64
+		if c.reads > 0 {
+			avgReq, avgServed = c.requested/c.reads, c.served/c.reads
+		}
+		fmt.Fprintf(&b, "%s reads=%d requested=%d served=%d avg_request=%d avg_served=%d\n",
+			name, c.reads, c.requested, c.served, avgReq, avgServed)
+	}
+	var total readCounter
+	for _, c := range s.files {
+		total.add(c)
+	}
+	line("total", &total)
+	for _, p := range slices.Sorted(maps.Keys(s.files)) {
+		line(p, s.files[p])
+	}
+	return b.Bytes()
+}
+
+// StatsFile shows the read statistics. Like the proc status files, the
+// content is captured at open.
+type StatsFile struct {
+	fs.Inode
+	birth
+
+	stats *readStats
+}
+
+func (f *StatsFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	return &procStatusHandle{data: f.stats.render()}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *StatsFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	h, ok := fh.(*procStatusHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(h.data)))
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+func (f *StatsFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = 0444
+	out.Size = uint64(len(f.stats.render()))
+	out.SetTimeout(0)
+	return 0
+}
+
+func (f *StatsFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*StatsFile)(nil))
+	_ = (fs.NodeOpener)((*StatsFile)(nil))
+	_ = (fs.NodeReader)((*StatsFile)(nil))
+	_ = (fs.NodeGetattrer)((*StatsFile)(nil))
+)
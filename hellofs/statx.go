@@ -0,0 +1,68 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// statx mask bits of the FUSE protocol, which are Linux's on every
+// platform
+const (
+	statxBasicStats = 0x7ff
+	statxBtime      = 0x800
+)
+
+// birth records when a node was created. It is reported as the statx
+// birth time and, unlike ctime, never changes afterwards.
+type birth struct {
+	btime time.Time
+}
+
+func born() birth {
+	return birth{btime: time.Now()}
+}
+
+func (b *birth) birthTime() time.Time {
+	return b.btime
+}
+
+type birthNode interface {
+	fs.NodeGetattrer
+	birthTime() time.Time
+}
+
+// statx answers a statx request from the node's Getattr and adds its birth
+// time. Every node type needs a Statx method built on this: after the
+// first ENOSYS the kernel stops sending statx for the whole mount, and
+// btime would be lost everywhere.
+func statx(ctx context.Context, n birthNode, fh fs.FileHandle, out *fuse.StatxOut) syscall.Errno {
+	var attr fuse.AttrOut
+	if errno := n.Getattr(ctx, fh, &attr); errno != 0 {
+		return errno
+	}
+	a := &attr.Attr
+	out.AttrValid, out.AttrValidNsec = attr.AttrValid, attr.AttrValidNsec
+	out.Mask = statxBasicStats
+	out.Blksize = a.Blksize
+	out.Nlink = a.Nlink
+	out.Uid, out.Gid = a.Uid, a.Gid
+	out.Mode = uint16(a.Mode)
+	out.Size = a.Size
+	out.Blocks = a.Blocks
+	out.Atime = fuse.SxTime{Sec: a.Atime, Nsec: a.Atimensec}
+	out.Mtime = fuse.SxTime{Sec: a.Mtime, Nsec: a.Mtimensec}
+	out.Ctime = fuse.SxTime{Sec: a.Ctime, Nsec: a.Ctimensec}
+	out.RdevMajor, out.RdevMinor = unix.Major(uint64(a.Rdev)), unix.Minor(uint64(a.Rdev))
+	if t := n.birthTime(); !t.IsZero() {
+		out.Mask |= statxBtime
+		out.Btime = fuse.SxTime{Sec: uint64(t.Unix()), Nsec: uint32(t.Nanosecond())}
+	}
+	return 0
+}
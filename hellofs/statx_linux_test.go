@@ -0,0 +1,48 @@
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestStatxNsec reads file.txt's times with statx: before a write they
+// are RootOptions.Mtime, after one the time of the write, both to the
+// nanosecond.
+func TestStatxNsec(t *testing.T) {
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 123456789, time.UTC)
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{Mtime: mtime})
+	dir := mountRoot(t, root)
+	path := filepath.Join(dir, "file.txt")
+	statx := func() *unix.Statx_t {
+		t.Helper()
+		var st unix.Statx_t
+		if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_STATX_FORCE_SYNC, unix.STATX_BASIC_STATS, &st); err != nil {
+			t.Fatal(err)
+		}
+		return &st
+	}
+	sx := func(ts unix.StatxTimestamp) time.Time { return time.Unix(ts.Sec, int64(ts.Nsec)) }
+
+	st := statx()
+	for name, ts := range map[string]unix.StatxTimestamp{"atime": st.Atime, "mtime": st.Mtime, "ctime": st.Ctime} {
+		if got := sx(ts); !got.Equal(mtime) {
+			t.Errorf("%s = %v, want %v", name, got, mtime)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("changed\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	f := root.GetChild("file.txt").Operations().(*HelloFile)
+	want := time.Unix(0, f.mtime.Load())
+	if want.Nanosecond()%1000 == 0 {
+		t.Logf("the write time %v has no nanoseconds to compare", want)
+	}
+	if got := sx(statx().Mtime); !got.Equal(want) {
+		t.Errorf("mtime after a write = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSymlinks mounts a manifest symlink and a dangling -symlink: each
+// reads back its target and stats as a symlink.
+func TestSymlinks(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(file, []byte("- name: latest\n  target: file.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := loadManifest(file, DefaultMaxNameLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dangling, err := parseSymlinkFlag("dangling=no/such/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.manifest, root.files = nodes, []manifestNode{dangling}
+	dir := mountRoot(t, root)
+
+	tests := []struct {
+		name, target string
+	}{
+		{"latest", "file.txt"},
+		{"dangling", "no/such/file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if got, err := os.Readlink(path); err != nil || got != tt.target {
+				t.Errorf("Readlink(%s) = %q, %v, want %q", tt.name, got, err, tt.target)
+			}
+			if fi, err := os.Lstat(path); err != nil || fi.Mode().Type() != os.ModeSymlink {
+				t.Errorf("Lstat(%s): %v, %v, want a symlink", tt.name, fi, err)
+			}
+		})
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "latest")); err != nil || string(b) != "hello\n" {
+		t.Errorf("read through latest: %q, %v, want file.txt's content", b, err)
+	}
+}
@@ -0,0 +1,100 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// syntheticIno is the first inode number of the -syntheticEntries files,
+// which each get a fixed one so they keep it across lookups.
+const syntheticIno = 1 << 47
+
+// syntheticName returns the name of the i-th synthetic entry, counting
+// from 1, zero-padded so names sort in numeric order.
+func (r *HelloRoot) syntheticName(i int) string {
+	return fmt.Sprintf("file-%0*d", max(5, len(strconv.Itoa(r.syntheticEntries))), i)
+}
+
+// syntheticIndex parses a synthetic entry name, returning 0 for other
+// names.
+func (r *HelloRoot) syntheticIndex(name string) int {
+	digits, ok := strings.CutPrefix(name, "file-")
+	if !ok {
+		return 0
+	}
+	i, err := strconv.Atoi(digits)
+	if err != nil || i < 1 || i > r.syntheticEntries || r.syntheticName(i) != name {
+		return 0
+	}
+	return i
+}
+
+// Lookup finds the root's children, and with -syntheticEntries creates the
+// generated files on demand rather than keeping them all in memory.
+func (r *HelloRoot) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (ch *fs.Inode, errno syscall.Errno) {
+	defer startOp(ctx, "lookup", &r.Inode)(&errno)
+	if ch, errno := lookupChild(ctx, &r.Inode, name, out); errno == 0 {
+		return ch, 0
+	}
+	if r.caseInsensitive {
+		if ch, errno := r.lookupFold(ctx, name, out); errno == 0 {
+			return ch, 0
+		}
+	}
+	i := r.syntheticIndex(name)
+	if i == 0 && r.caseInsensitive {
+		if i = r.syntheticIndex(strings.ToLower(name)); i != 0 {
+			r.folded.Store(name, struct{}{})
+		}
+	}
+	if i == 0 {
+		return nil, syscall.ENOENT
+	}
+	if r.syntheticSize > 0 {
+		name := r.syntheticName(i)
+		f := &RandomFile{birth: born(), seed: pathSeed(r.seed, name), size: r.syntheticSize}
+		out.Mode = 0444
+		out.Size = uint64(r.syntheticSize)
+		return r.NewInode(ctx, f, fs.StableAttr{Ino: syntheticIno + uint64(i)}), 0
+	}
+	content := r.syntheticName(i) + "\n"
+	f := newStaticFile([]byte(content))
+	out.Mode = 0444
+	out.Size = uint64(len(content))
+	return r.NewInode(ctx, f, fs.StableAttr{Ino: syntheticIno + uint64(i)}), 0
+}
+
+// Readdir lists the children and the synthetic entries in sorted order,
+// the same on every call while the tree doesn't change. The synthetic
+// entries are streamed, see rootDirStream.
+func (r *HelloRoot) Readdir(ctx context.Context) (ds fs.DirStream, errno syscall.Errno) {
+	defer startOp(ctx, "readdir", &r.Inode)(&errno)
+	children := r.Children()
+	entries := make([]fuse.DirEntry, 0, len(children))
+	for _, name := range slices.Sorted(maps.Keys(children)) {
+		st := children[name].StableAttr()
+		if st.Ino > syntheticIno && r.syntheticIndex(name) != 0 {
+			continue // looked up before, listed below
+		}
+		if r.caseInsensitive && r.isFolded(name) {
+			continue
+		}
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: st.Mode, Ino: st.Ino})
+	}
+	return &rootDirStream{root: r, stored: entries, k: 1}, 0
+}
+
+var (
+	_ = (fs.NodeLookuper)((*HelloRoot)(nil))
+	_ = (fs.NodeReaddirer)((*HelloRoot)(nil))
+)
@@ -0,0 +1,179 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// tarEntry is a directory, file, symlink or named pipe read from a
+// -tarFile archive.
+type tarEntry struct {
+	name    string // slash-separated path below the root
+	typ     byte   // tar.TypeDir, tar.TypeReg, tar.TypeSymlink or tar.TypeFifo
+	mode    uint32
+	owner   fuse.Owner
+	mtime   time.Time
+	content []byte
+	target  string
+}
+
+// loadTar reads a tar archive, such as one written by -snapshotFile, to
+// restore with -tarFile. Hard links, devices and the other types
+// snapshots don't write are refused. Owners are taken as snapshots record
+// them: 0 stands for -uid and -gid, and rootID for root. Names over
+// maxNameLen bytes are refused, unless it is 0.
+func loadTar(file string, maxNameLen int) ([]tarEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []tarEntry
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		name := strings.TrimSuffix(path.Clean(strings.TrimPrefix(hdr.Name, "/")), "/")
+		if name == "." {
+			continue
+		}
+		if !validPath(name) {
+			return nil, fmt.Errorf("%s: invalid name %q", file, hdr.Name)
+		}
+		if long := longName(name, maxNameLen); long != "" {
+			return nil, fmt.Errorf("%s: name %q is longer than -maxNameLen %d", file, long, maxNameLen)
+		}
+		e := tarEntry{
+			name:  name,
+			typ:   hdr.Typeflag,
+			mode:  uint32(hdr.Mode & 07777),
+			owner: fuse.Owner{Uid: uint32(hdr.Uid), Gid: uint32(hdr.Gid)},
+			mtime: hdr.ModTime,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeFifo:
+		case tar.TypeReg:
+			if e.content, err = io.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", file, name, err)
+			}
+		case tar.TypeSymlink:
+			if hdr.Linkname == "" {
+				return nil, fmt.Errorf("%s: symlink %s has an empty target", file, name)
+			}
+			e.target = hdr.Linkname
+		default:
+			return nil, fmt.Errorf("%s: %s: unsupported entry type %q", file, name, hdr.Typeflag)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// cloneTar returns a copy of entries with content of its own, to build
+// another tree from.
+func cloneTar(entries []tarEntry) []tarEntry {
+	entries = slices.Clone(entries)
+	for i := range entries {
+		entries[i].content = slices.Clone(entries[i].content)
+	}
+	return entries
+}
+
+// addTar builds the -tarFile entries below the root as writable in-memory
+// files and directories, like ones created through the mount. Missing
+// parent directories are created. A directory that exists already is
+// merged into, and an in-memory file that does, like file.txt, gets the
+// entry's content and attributes; anything else in the way is skipped.
+func addTar(ctx context.Context, root *HelloRoot, entries []tarEntry) {
+	for _, e := range entries {
+		dir, name := &root.Inode, e.name
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			if dir = tarMkdirAll(ctx, root, name[:i]); dir == nil {
+				root.logger.Printf("tarFile: a parent of /%s is not a directory, skipping", e.name)
+				continue
+			}
+			name = name[i+1:]
+		}
+		if ch := dir.GetChild(name); ch != nil {
+			switch n := ch.Operations().(type) {
+			case *MemDir:
+				if e.typ == tar.TypeDir {
+					n.mode, n.owner = e.mode, e.owner
+					continue
+				}
+			case *HelloFile:
+				if e.typ == tar.TypeReg {
+					n.restore(e)
+					continue
+				}
+			}
+			root.logger.Printf("tarFile: /%s already exists, skipping", e.name)
+			continue
+		}
+		var ch *fs.Inode
+		switch e.typ {
+		case tar.TypeDir:
+			d := &MemDir{birth: born(), root: root, mode: e.mode, owner: e.owner}
+			ch = dir.NewPersistentInode(ctx, d, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: root.inos.alloc()})
+		case tar.TypeSymlink:
+			l := &Symlink{birth: born(), target: []byte(e.target), owner: e.owner}
+			ch = dir.NewPersistentInode(ctx, l, fs.StableAttr{Mode: syscall.S_IFLNK, Ino: root.inos.alloc()})
+		case tar.TypeFifo:
+			f := &FifoNode{birth: born(), mode: e.mode, owner: e.owner}
+			ch = dir.NewPersistentInode(ctx, f, fs.StableAttr{Mode: syscall.S_IFIFO, Ino: root.inos.alloc()})
+		default:
+			f := &HelloFile{birth: born(), root: root}
+			f.restore(e)
+			ch = dir.NewPersistentInode(ctx, f, fs.StableAttr{Ino: root.inos.alloc()})
+		}
+		dir.AddChild(name, ch, false)
+	}
+}
+
+// restore replaces the content and attributes of f with those of e.
+func (f *HelloFile) restore(e tarEntry) {
+	f.data.Lock()
+	defer f.data.Unlock()
+	b := f.extents()
+	b.truncate(0)
+	b.writeAt(e.content, 0)
+	f.Attr.Mode, f.Attr.Owner = e.mode, e.owner
+	f.mtime.Store(e.mtime.UnixNano())
+	f.ctime.Store(e.mtime.UnixNano())
+}
+
+// tarMkdirAll returns the directory rel below the root, creating what is
+// missing, or nil if part of it is not a directory.
+func tarMkdirAll(ctx context.Context, root *HelloRoot, rel string) *fs.Inode {
+	dir := &root.Inode
+	for name := range strings.SplitSeq(rel, "/") {
+		ch := dir.GetChild(name)
+		if ch == nil {
+			d := &MemDir{birth: born(), root: root, mode: 0755 &^ root.umask}
+			ch = dir.NewPersistentInode(ctx, d, fs.StableAttr{Mode: syscall.S_IFDIR, Ino: root.inos.alloc()})
+			dir.AddChild(name, ch, false)
+		} else if !ch.IsDir() {
+			return nil
+		}
+		dir = ch
+	}
+	return dir
+}
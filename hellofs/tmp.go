@@ -0,0 +1,206 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// ttlXattr overrides the lifetime of a single file in the tmp directory.
+const ttlXattr = "user.ttl"
+
+// TmpDir holds in-memory scratch files that are removed once their TTL
+// runs out. The TTL counts from creation, or from the last open, read or
+// write if idle is set.
+type TmpDir struct {
+	fs.Inode
+	birth
+
+	root *HelloRoot
+	ttl  time.Duration
+	idle bool
+}
+
+func (d *TmpDir) OnAdd(ctx context.Context) {
+	go d.reap()
+}
+
+// reap removes expired files until shutdown. Their names disappear right
+// away; handles opened before keep working until closed.
+func (d *TmpDir) reap() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-tick.C:
+		case <-d.root.done.Done():
+			return
+		}
+		for name, ch := range d.Children() {
+			f, ok := ch.Operations().(*TmpFile)
+			if !ok || !f.expired(now) {
+				continue
+			}
+			d.RmChild(name)
+			ch.ForgetPersistent()
+			if errno := d.NotifyDelete(name, ch); errno != 0 && errno != syscall.ENOENT {
+				f.root.logger.Printf("tmp: invalidating expired %q: %v", name, errno)
+			}
+		}
+	}
+}
+
+func (d *TmpDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &d.Inode)(out, &errno)
+	out.Mode = 01777
+	return 0
+}
+
+func (d *TmpDir) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "create", &d.Inode)(&errno)
+	if d.root.readOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+	if d.GetChild(name) != nil {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	if errno := dirFull(&d.Inode, false); errno != 0 {
+		return nil, nil, 0, errno
+	}
+	now := time.Now()
+	f := &TmpFile{
+		HelloFile: HelloFile{
+			birth:          born(),
+			root:           d.root,
+			MemRegularFile: fs.MemRegularFile{Attr: fuse.Attr{Mode: mode &^ syscall.S_IFMT &^ d.root.umask}},
+		},
+		dir:    d,
+		ttl:    d.ttl,
+		access: now,
+	}
+	ch := d.NewPersistentInode(ctx, f, fs.StableAttr{Mode: syscall.S_IFREG, Ino: d.root.inos.alloc()})
+	d.AddChild(name, ch, false)
+	out.Mode = fuse.S_IFREG | f.Attr.Mode
+	return ch, nil, 0, 0
+}
+
+func (d *TmpDir) Unlink(ctx context.Context, name string) (errno syscall.Errno) {
+	defer startOp(ctx, "unlink", &d.Inode)(&errno)
+	if d.root.readOnly {
+		return syscall.EROFS
+	}
+	if ch := d.GetChild(name); ch != nil {
+		ch.ForgetPersistent()
+	}
+	return 0
+}
+
+func (d *TmpDir) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, d, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*TmpDir)(nil))
+	_ = (fs.NodeOnAdder)((*TmpDir)(nil))
+	_ = (fs.NodeGetattrer)((*TmpDir)(nil))
+	_ = (fs.NodeCreater)((*TmpDir)(nil))
+	_ = (fs.NodeUnlinker)((*TmpDir)(nil))
+)
+
+// TmpFile is a HelloFile with an expiry time.
+type TmpFile struct {
+	HelloFile
+
+	dir *TmpDir
+
+	mu     sync.Mutex
+	ttl    time.Duration
+	access time.Time // creation, or last access for idle TTLs
+}
+
+func (f *TmpFile) touch() {
+	if !f.dir.idle {
+		return
+	}
+	f.mu.Lock()
+	f.access = time.Now()
+	f.mu.Unlock()
+}
+
+func (f *TmpFile) expired(now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return now.Sub(f.access) >= f.ttl
+}
+
+func (f *TmpFile) OnForget() {
+	f.dir.root.inos.release(f.StableAttr().Ino)
+}
+
+func (f *TmpFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	f.touch()
+	return f.HelloFile.Open(ctx, flags)
+}
+
+func (f *TmpFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	f.touch()
+	return f.HelloFile.Read(ctx, fh, dest, off)
+}
+
+func (f *TmpFile) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	f.touch()
+	return f.HelloFile.Write(ctx, fh, data, off)
+}
+
+func (f *TmpFile) Getxattr(ctx context.Context, attr string, dest []byte) (sz uint32, errno syscall.Errno) {
+	if attr != ttlXattr {
+		return f.HelloFile.Getxattr(ctx, attr, dest)
+	}
+	defer startOp(ctx, "getxattr", &f.Inode)(&errno)
+	f.mu.Lock()
+	val := f.ttl.String()
+	f.mu.Unlock()
+	return xattrReply(dest, []byte(val))
+}
+
+// Setxattr sets the file's TTL from a duration such as "30s". Other
+// attributes are stored like on any HelloFile.
+func (f *TmpFile) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	if attr != ttlXattr {
+		return f.HelloFile.Setxattr(ctx, attr, data, flags)
+	}
+	defer startOp(ctx, "setxattr", &f.Inode)(&errno)
+	if f.root.readOnly {
+		return syscall.EROFS
+	}
+	ttl, err := time.ParseDuration(string(data))
+	if err != nil || ttl <= 0 {
+		return syscall.EINVAL
+	}
+	f.mu.Lock()
+	f.ttl = ttl
+	f.mu.Unlock()
+	return 0
+}
+
+func (f *TmpFile) Listxattr(ctx context.Context, dest []byte) (sz uint32, errno syscall.Errno) {
+	defer startOp(ctx, "listxattr", &f.Inode)(&errno)
+	return xattrList(dest, append([]string{ttlXattr}, f.xattrNames()...)...)
+}
+
+var (
+	_ = (fs.NodeOnForgetter)((*TmpFile)(nil))
+	_ = (fs.NodeOpener)((*TmpFile)(nil))
+	_ = (fs.NodeReader)((*TmpFile)(nil))
+	_ = (fs.NodeWriter)((*TmpFile)(nil))
+	_ = (fs.NodeGetxattrer)((*TmpFile)(nil))
+	_ = (fs.NodeSetxattrer)((*TmpFile)(nil))
+	_ = (fs.NodeListxattrer)((*TmpFile)(nil))
+)
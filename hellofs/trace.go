@@ -0,0 +1,67 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTracing returns a hook exporting a span per node operation to the
+// OTLP/HTTP collector at endpoint. If the process was started with a
+// TRACEPARENT in the environment, spans are parented to it. The returned
+// function flushes and stops the exporter.
+func setupTracing(endpoint string) (opHook, func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	tracer := provider.Tracer("github.com/nopcoder/hello-fuse")
+
+	parent := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": os.Getenv("TRACEPARENT"),
+		"tracestate":  os.Getenv("TRACESTATE"),
+	})
+	hook := func(ctx context.Context, op, path string) func(opResult) {
+		_, span := tracer.Start(parent, op, trace.WithAttributes(
+			attribute.String("fuse.opcode", op),
+			attribute.String("fuse.path", path),
+		))
+		return func(r opResult) {
+			if r.bytes > 0 {
+				span.SetAttributes(attribute.Int("fuse.bytes", r.bytes))
+			}
+			if r.errno != 0 {
+				span.SetAttributes(attribute.String("fuse.errno", r.errno.Error()))
+			}
+			if r.errno != 0 && !transientErrno(r.errno) {
+				span.SetStatus(codes.Error, r.errno.Error())
+			}
+			span.End()
+		}
+	}
+	return hook, provider.Shutdown, nil
+}
+
+// flushTraces exports any pending spans, bounded by a short timeout so a
+// dead collector can't hold up shutdown.
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to flush traces: %v\n", err)
+	}
+}
@@ -0,0 +1,60 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"syscall"
+)
+
+// transientErrno reports whether errno only means the request was
+// interrupted or should be retried, rather than that it failed.
+func transientErrno(errno syscall.Errno) bool {
+	return errno == syscall.EINTR || errno == syscall.EAGAIN
+}
+
+// transientFilter drops log lines about interrupted or retried requests,
+// which show up in bursts when clients are signalled and say nothing about
+// the health of the mount.
+type transientFilter struct {
+	w io.Writer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+var transientMessages = [][]byte{
+	[]byte(syscall.EINTR.Error()),
+	[]byte(syscall.EAGAIN.Error()),
+}
+
+func (f *transientFilter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := f.buf[:i+1]
+		f.buf = f.buf[i+1:]
+		if isTransientLine(line) {
+			continue
+		}
+		if _, err := f.w.Write(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func isTransientLine(line []byte) bool {
+	for _, m := range transientMessages {
+		if bytes.Contains(line, m) {
+			return true
+		}
+	}
+	return false
+}
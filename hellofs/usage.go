@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package hellofs
+
+import "github.com/hanwen/go-fuse/v2/fs"
+
+// memUser is implemented by nodes that keep content in memory.
+type memUser interface {
+	memUsage() uint64
+}
+
+// memUsage leaves out holes, which take no memory.
+func (f *HelloFile) memUsage() uint64 {
+	f.data.RLock()
+	defer f.data.RUnlock()
+	return f.extents().allocated()
+}
+
+func (f *StaticFile) memUsage() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return uint64(len(f.data))
+}
+
+func (f *CASIngestFile) memUsage() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return uint64(len(f.data))
+}
+
+// treeUsage sums the in-memory content below n.
+func treeUsage(n *fs.Inode) uint64 {
+	var total uint64
+	if u, ok := n.Operations().(memUser); ok {
+		total += u.memUsage()
+	}
+	for _, ch := range n.Children() {
+		total += treeUsage(ch)
+	}
+	return total
+}
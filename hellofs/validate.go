@@ -0,0 +1,222 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validate checks the flags in o and how they combine, for a run on
+// mountpoints, without touching the host: files are loaded, users looked
+// up and mountpoints checked later, while Main builds the mounts.
+func (o *Options) validate(mountpoints []string) error {
+	if o.LogFormat != "text" && o.LogFormat != "json" {
+		return fmt.Errorf("invalid -logFormat %q: must be text or json", o.LogFormat)
+	}
+	if o.Background && o.FuseFd > 0 {
+		return errors.New("-background can't be combined with -fuseFd")
+	}
+	if o.MaxStackDepth < 1 {
+		return fmt.Errorf("invalid -maxStackDepth %d: must be at least 1", o.MaxStackDepth)
+	}
+	if o.MountTimeout <= 0 {
+		return fmt.Errorf("invalid -mountTimeout %v: must be positive", o.MountTimeout)
+	}
+	if o.ReadyTimeout <= 0 {
+		return fmt.Errorf("invalid -readyTimeout %v: must be positive", o.ReadyTimeout)
+	}
+	if o.ReadyTimeout >= o.MountTimeout {
+		return fmt.Errorf("invalid -readyTimeout %v: must be below -mountTimeout %v, which bounds mounting and verifying together", o.ReadyTimeout, o.MountTimeout)
+	}
+	if _, err := parseUmask(o.Umask); err != nil {
+		return err
+	}
+	if _, err := parseRootMode(o.RootMode, 0); err != nil {
+		return err
+	}
+	if err := checkFirstIno(o.FirstAutomaticIno, o.SyntheticEntries); err != nil {
+		return fmt.Errorf("invalid %w", err)
+	}
+	if _, _, _, err := checkIOSizes(o.MaxWrite, o.MaxReadAhead); err != nil {
+		return fmt.Errorf("invalid %w", err)
+	}
+	options, _, err := parseMountOptions(o.Options, o.AllowUnknownOptions)
+	if err != nil {
+		return fmt.Errorf("invalid -options: %w", err)
+	}
+	if options, err = addMountFlags(o.MountFlags, options); err != nil {
+		return fmt.Errorf("invalid -mountFlags: %w", err)
+	}
+	if o.AllowRoot {
+		switch {
+		case o.AllowOther:
+			return errors.New("-allowRoot can't be combined with -allowOther, which already lets root in")
+		case o.DirectMountStrict:
+			// allow_root is enforced by fusermount, the kernel doesn't know it
+			return errors.New("-allowRoot can't be combined with -directMountStrict, it needs fusermount")
+		}
+	}
+	if o.AllowOtherBestEffort && !o.AllowOther {
+		return errors.New("-allowOtherBestEffort needs -allowOther")
+	}
+
+	if o.MaxNameLen < 0 {
+		return fmt.Errorf("invalid -maxNameLen %d: must not be negative", o.MaxNameLen)
+	}
+	for _, n := range o.files {
+		if longName(n.name, o.MaxNameLen) != "" {
+			return fmt.Errorf("invalid -file or -symlink name %q: longer than -maxNameLen %d", n.name, o.MaxNameLen)
+		}
+	}
+	if o.LingerOnSignal < 0 {
+		return fmt.Errorf("invalid -lingerOnSignal %v: must not be negative", o.LingerOnSignal)
+	}
+	if o.LatencyModel != "" {
+		if _, err := parseLatencyModel(o.LatencyModel); err != nil {
+			return fmt.Errorf("invalid -latencyModel: %w", err)
+		}
+	}
+	if o.ClockFile && o.ClockFormat == "" {
+		return errors.New("invalid -clockFormat: must not be empty")
+	}
+	if o.GzipContentFile != "" && o.ContentFile != "" {
+		return errors.New("-gzipContentFile can't be combined with -contentFile")
+	}
+	if (o.ContentFile == "-" || o.GzipContentFile == "-") && o.AllowRemount {
+		return errors.New("-allowRemount can't be combined with content from stdin, which can't be read again")
+	}
+	if o.ExpectSha256 != "" {
+		if _, err := parseSHA256(o.ExpectSha256); err != nil {
+			return fmt.Errorf("invalid -expectSha256: %w", err)
+		}
+	}
+	if o.Mtime != "" {
+		if _, err := time.Parse(time.RFC3339Nano, o.Mtime); err != nil {
+			return fmt.Errorf("invalid -mtime: %w", err)
+		}
+	}
+	if o.SyntheticSize < 0 {
+		return fmt.Errorf("invalid -syntheticSize %d: must not be negative", o.SyntheticSize)
+	}
+	if o.SyntheticSize > 0 && o.SyntheticEntries == 0 {
+		return errors.New("-syntheticSize needs -syntheticEntries")
+	}
+	if o.StatusInterval < 0 {
+		return fmt.Errorf("invalid -statusInterval %v: must not be negative", o.StatusInterval)
+	}
+	if o.HealthInterval <= 0 {
+		return fmt.Errorf("invalid -healthInterval %v: must be positive", o.HealthInterval)
+	}
+	if o.OnReadyFatal && o.OnReady == "" {
+		return errors.New("-onReadyFatal needs -onReady")
+	}
+	if o.CacheSize > 0 && o.Source == "" {
+		return errors.New("-cacheSize needs -source")
+	}
+	if o.HttpAllowRemote && o.HttpAddr == "" {
+		return errors.New("-httpAllowRemote needs -httpAddr")
+	}
+	if o.DefaultAcl != "" {
+		if !o.EnableAcl {
+			return errors.New("-defaultAcl needs -enableAcl")
+		}
+		if _, err := parseACL(o.DefaultAcl); err != nil {
+			return fmt.Errorf("invalid -defaultAcl: %w", err)
+		}
+	}
+	if atime := cmp.Or(o.Atime, atimeFromOptions(options)); !slices.Contains(AtimeModes, atime) {
+		return fmt.Errorf("invalid -atime %q: must be one of %s", atime, strings.Join(AtimeModes, ", "))
+	}
+	if !slices.Contains(cachePolicies, o.CachePolicy) {
+		return fmt.Errorf("invalid -cachePolicy %q: must be one of %s", o.CachePolicy, strings.Join(cachePolicies, ", "))
+	}
+	if o.UnmountCmd != "" && !slices.Contains(UnmountMethods, o.UnmountCmd) {
+		return fmt.Errorf("invalid -unmountCmd %q: must be one of %s", o.UnmountCmd, strings.Join(UnmountMethods, ", "))
+	}
+	if o.Source != "" {
+		switch {
+		case o.Writeback != "":
+			return errors.New("-writeback can't be combined with -source, which has no file.txt")
+		case o.Embedded:
+			return errors.New("-embedded can't be combined with -source, which replaces the built-in tree")
+		case o.RootMode != "" || o.RootUser != "" || o.RootGroup != "":
+			return errors.New("-rootMode, -rootUser and -rootGroup can't be combined with -source, whose top directory is the source's")
+		}
+	}
+
+	if len(mountpoints) > 1 {
+		// these serve one host file, directory or connection that several
+		// mounts would fight over, or track a single mount
+		for _, c := range []struct {
+			name string
+			set  bool
+		}{
+			{"-fuseFd", o.FuseFd > 0},
+			{"-writeback", o.Writeback != ""},
+			{"-snapshotFile", o.SnapshotFile != ""},
+			{"-sqliteDB", o.SqliteDB != ""},
+			{"-configMap", o.ConfigMap != ""},
+			{"-secret", o.Secret != ""},
+			{"-procDir", o.ProcDir},
+			{"-readStats", o.ReadStats},
+		} {
+			if c.set {
+				return fmt.Errorf("%s can't be combined with more than one MOUNTPOINT", c.name)
+			}
+		}
+		seen := map[string]bool{}
+		for _, mp := range mountpoints {
+			abs, _ := filepath.Abs(mp)
+			if seen[abs] {
+				return fmt.Errorf("MOUNTPOINT %s is given more than once", mp)
+			}
+			seen[abs] = true
+		}
+	}
+	switch {
+	case o.SelfTest && (o.Background || o.FuseFd > 0 || o.Source != "" || o.DryRun):
+		return errors.New("-selfTest can't be combined with -background, -fuseFd, -source or -dryRun")
+	case !o.SelfTest && len(mountpoints) == 0:
+		return errors.New("no MOUNTPOINT given")
+	}
+	if o.FuseFd > 0 {
+		if o.AllowRemount {
+			return errors.New("-allowRemount can't be combined with -fuseFd, whose connection can't be mounted again")
+		}
+		if o.DirectMount || o.DirectMountStrict || o.RecoverStaleMount {
+			return errors.New("-fuseFd can't be combined with -directMount, -directMountStrict or -recoverStaleMount")
+		}
+	}
+	return nil
+}
+
+// parseUmask parses a -umask, returning 0 for none.
+func parseUmask(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m&^0777 != 0 {
+		return 0, fmt.Errorf("invalid -umask %q: must be octal permission bits, e.g. 022", s)
+	}
+	return uint32(m), nil
+}
+
+// parseRootMode parses a -rootMode, returning def for none.
+func parseRootMode(s string, def uint32) (uint32, error) {
+	if s == "" {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m&^07777 != 0 {
+		return 0, fmt.Errorf("invalid -rootMode %q: must be octal permission bits, e.g. 0700", s)
+	}
+	return uint32(m), nil
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"testing"
+	"time"
+)
+
+// flagDefaults are the Options the command line gives without flags.
+func flagDefaults() Options {
+	return Options{
+		LogFormat:      "text",
+		Uid:            -1,
+		Gid:            -1,
+		MaxStackDepth:  1,
+		MountTimeout:   5 * time.Second,
+		ReadyTimeout:   2 * time.Second,
+		HealthInterval: 10 * time.Second,
+		CachePolicy:    "cache",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(o *Options)
+		wantErr bool
+	}{
+		{name: "defaults", set: func(o *Options) {}},
+		{name: "maxStackDepth 2", set: func(o *Options) { o.MaxStackDepth = 2 }},
+		{name: "maxStackDepth 0", set: func(o *Options) { o.MaxStackDepth = 0 }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := flagDefaults()
+			tt.set(&o)
+			if err := o.validate([]string{"/mnt"}); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
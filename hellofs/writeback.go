@@ -0,0 +1,107 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// contents returns a copy of the file's current data.
+func (f *HelloFile) contents() []byte {
+	f.data.RLock()
+	defer f.data.RUnlock()
+	return f.extents().bytes()
+}
+
+// set replaces the file's data.
+func (f *HelloFile) set(data []byte) {
+	f.data.Lock()
+	b := f.extents()
+	b.truncate(0)
+	b.writeAt(data, 0)
+	f.data.Unlock()
+	f.touch()
+}
+
+// reloadContent re-reads the -contentFile into file.txt and drops the
+// kernel's cached pages and attributes of it, so the next read sees the
+// new content. Writes made through the mount before it are lost. Content
+// not matching the -expectSha256 digest sum, if set, is refused and the
+// old content kept. inotify only reports changes made through the mount,
+// so watchers of file.txt see writes and truncates but not a reload.
+func (r *HelloRoot) reloadContent(file string, gzipped bool, sum string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		data, err := readContentFile(file, gzipped)
+		if err == nil {
+			err = checkSHA256(data, sum)
+		}
+		if err != nil {
+			return fmt.Errorf("content reload: %w", err)
+		}
+		ch := r.GetChild("file.txt")
+		if ch == nil {
+			return fmt.Errorf("content reload: file.txt is gone")
+		}
+		ch.Operations().(*HelloFile).set(data)
+		r.invalidate(ch)
+		return nil
+	}
+}
+
+// Flush has nothing to do: writes land in memory right away.
+func (f *HelloFile) Flush(ctx context.Context, fh fs.FileHandle) (errno syscall.Errno) {
+	defer startOp(ctx, "flush", &f.Inode)(&errno)
+	return 0
+}
+
+// Fsync makes what was written so far durable. The data lives in memory,
+// so only file.txt with -writeback has anything to save: it is written
+// back now rather than at unmount.
+func (f *HelloFile) Fsync(ctx context.Context, fh fs.FileHandle, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "fsync", &f.Inode)(&errno)
+	r := f.root
+	if r.writebackFile == "" || r.GetChild("file.txt") != &f.Inode {
+		return 0
+	}
+	if err := r.writeBack(r.writebackFile); err != nil {
+		r.logger.Printf("fsync: writing back file.txt: %v", err)
+		return syscall.EIO
+	}
+	return 0
+}
+
+// writeBack saves the content of file.txt to dst. Like snapshots it is
+// written to a temporary file, synced and renamed, so dst is never left
+// partial.
+func (r *HelloRoot) writeBack(dst string) error {
+	r.writebackMu.Lock()
+	defer r.writebackMu.Unlock()
+	ch := r.GetChild("file.txt")
+	if ch == nil {
+		return fmt.Errorf("file.txt is gone")
+	}
+	data := ch.Operations().(*HelloFile).contents()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
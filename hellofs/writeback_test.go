@@ -0,0 +1,173 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// opCounter is a hook counting the operations of each kind.
+type opCounter struct {
+	mu  sync.Mutex
+	ops map[string]int
+}
+
+func (c *opCounter) hook(ctx context.Context, op, path string) func(opResult) {
+	c.mu.Lock()
+	if c.ops == nil {
+		c.ops = map[string]int{}
+	}
+	c.ops[op]++
+	c.mu.Unlock()
+	return func(opResult) {}
+}
+
+func (c *opCounter) count(op string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ops[op]
+}
+
+func TestWriteNotifiesWatchers(t *testing.T) {
+	dir := mountRoot(t, NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}))
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	path := filepath.Join(dir, "file.txt")
+	if err := w.Add(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("changed\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-w.Events:
+			if ev.Has(fsnotify.Write) {
+				return
+			}
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatal("no write event for a write through the mount")
+		}
+	}
+}
+
+// TestReloadContent reloads file.txt while it is cached and being written
+// to: readers must see the new content at once, and the reload must not
+// reach the mount as a client operation that could cut a write short.
+func TestReloadContent(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "content")
+	if err := os.WriteFile(src, []byte("new content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	root := NewRoot(map[string][]byte{"file.txt": []byte("old\n")}, RootOptions{})
+	var ops opCounter
+	dir := mountTest(t, Config{Root: root, hooks: []opHook{ops.hook}})
+	root.mounted.Store(true)
+	reload := root.reloadContent(src, false, "")
+	path := filepath.Join(dir, "file.txt")
+
+	if b, err := os.ReadFile(path); err != nil || string(b) != "old\n" {
+		t.Fatalf("before reload: %q, %v", b, err)
+	}
+	if err := reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "new content\n" {
+		t.Fatalf("after reload: %q, %v, want the new content", b, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	const records = 200
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range 20 {
+			if err := reload(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	for i := range records {
+		if _, err := f.WriteAt(fmt.Appendf(nil, "rec%04d\n", i), int64(i*8)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if n := ops.count("setattr"); n != 0 {
+		t.Errorf("reloads made %d setattr calls through the mount, want none", n)
+	}
+	last := fmt.Appendf(nil, "rec%04d\n", records)
+	if _, err := f.WriteAt(last, records*8); err != nil {
+		t.Fatal(err)
+	}
+	got := root.GetChild("file.txt").Operations().(*HelloFile).contents()
+	if len(got) != (records+1)*8 || string(got[records*8:]) != string(last) {
+		t.Errorf("after the reloads, file.txt is %d bytes ending %q, want %d ending %q", len(got), got[max(0, len(got)-8):], (records+1)*8, last)
+	}
+}
+
+// TestWritebackOnShutdown writes through the mount and cancels Run: the
+// host file must then have the new bytes, with no temporary file left.
+func TestWritebackOnShutdown(t *testing.T) {
+	host := t.TempDir()
+	dst := filepath.Join(host, "saved.txt")
+	cfg := runConfig(t.TempDir())
+	root := cfg.Root.(*HelloRoot)
+	cfg.BeforeUnmount = func() error { return root.writeBack(dst) }
+	stop := startRun(t, cfg)
+	if err := os.WriteFile(filepath.Join(cfg.Mountpoint, "file.txt"), []byte("written\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	if b, err := os.ReadFile(dst); err != nil || string(b) != "written\n" {
+		t.Errorf("written back: %q, %v, want %q", b, err, "written\n")
+	}
+	if names, _ := filepath.Glob(filepath.Join(host, "*")); len(names) != 1 {
+		t.Errorf("%s holds %q, want only the written back file", host, names)
+	}
+}
+
+// TestWritebackOnFsync writes file.txt through the mount: the writeback
+// file is only written on fsync, and then at once, before any unmount.
+func TestWritebackOnFsync(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "saved.txt")
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.writebackFile = dst
+	dir := mountRoot(t, root)
+	f, err := os.OpenFile(filepath.Join(dir, "file.txt"), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("synced\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("before fsync: stat %s = %v, want it not to exist", dst, err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(dst); err != nil || string(b) != "synced\n" {
+		t.Errorf("after fsync: %q, %v, want %q", b, err, "synced\n")
+	}
+}
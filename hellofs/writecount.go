@@ -0,0 +1,73 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"strconv"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// WriteCountFile shows how many write requests the in-memory files
+// served since the start; a write the kernel splits counts once per
+// request. Like ClockFile it uses direct I/O and uncached attributes, so
+// every open sees the current count.
+type WriteCountFile struct {
+	fs.Inode
+	birth
+
+	root *HelloRoot
+}
+
+func (f *WriteCountFile) content() []byte {
+	return append(strconv.AppendUint(nil, f.root.writes.Load(), 10), '\n')
+}
+
+// writeCountHandle keeps the count as it was on open, so reads of one
+// handle never mix two counts.
+type writeCountHandle struct {
+	data []byte
+}
+
+func (f *WriteCountFile) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	defer startOp(ctx, "open", &f.Inode)(&errno)
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		return nil, 0, syscall.EACCES
+	}
+	return &writeCountHandle{data: f.content()}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *WriteCountFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	defer startRead(ctx, &f.Inode, len(dest))(&res, &errno)
+	h, ok := fh.(*writeCountHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(h.data)))
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+func (f *WriteCountFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	defer startGetattr(ctx, &f.Inode)(out, &errno)
+	out.Mode = 0444
+	out.Size = uint64(len(f.content()))
+	out.SetTimeout(0)
+	return 0
+}
+
+func (f *WriteCountFile) Statx(ctx context.Context, fh fs.FileHandle, flags uint32, mask uint32, out *fuse.StatxOut) syscall.Errno {
+	return statx(ctx, f, fh, out)
+}
+
+var (
+	_ = (fs.NodeStatxer)((*WriteCountFile)(nil))
+	_ = (fs.NodeOpener)((*WriteCountFile)(nil))
+	_ = (fs.NodeReader)((*WriteCountFile)(nil))
+	_ = (fs.NodeGetattrer)((*WriteCountFile)(nil))
+)
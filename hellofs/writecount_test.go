@@ -0,0 +1,39 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteCountFile(t *testing.T) {
+	root := NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{})
+	root.writeCountFile = true
+	dir := mountRoot(t, root)
+	count := func() string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, "writes.count"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := count(); got != "0\n" {
+		t.Errorf("writes.count before any write = %q, want %q", got, "0\n")
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "file.txt"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, off := range []int64{0, 100} {
+		if _, err := f.WriteAt([]byte("x"), off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := count(); got != "2\n" {
+		t.Errorf("writes.count after two writes = %q, want %q", got, "2\n")
+	}
+}
@@ -0,0 +1,93 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// writeOps are the operations -shutdownDrainWrites waits for.
+var writeOps = []string{"write", "fsync", "copy_file_range", "fallocate", "setattr"}
+
+// pendingWrites returns how many of the operations in progress on t are
+// writes.
+func pendingWrites(t *inFlight) int {
+	byOp, _ := t.snapshot()
+	n := 0
+	for _, op := range writeOps {
+		n += byOp[op]
+	}
+	return n
+}
+
+// writeRefuser refuses new writes with EAGAIN once the mount is stopping,
+// so that -shutdownDrainWrites waits for the writes in progress and not
+// for ones that keep coming.
+type writeRefuser struct {
+	fuse.RawFileSystem
+
+	stopping *atomic.Bool
+}
+
+func (w *writeRefuser) Write(cancel <-chan struct{}, in *fuse.WriteIn, data []byte) (uint32, fuse.Status) {
+	if w.stopping.Load() {
+		return 0, fuse.Status(syscall.EAGAIN)
+	}
+	return w.RawFileSystem.Write(cancel, in, data)
+}
+
+func (w *writeRefuser) Fsync(cancel <-chan struct{}, in *fuse.FsyncIn) fuse.Status {
+	if w.stopping.Load() {
+		return fuse.Status(syscall.EAGAIN)
+	}
+	return w.RawFileSystem.Fsync(cancel, in)
+}
+
+func (w *writeRefuser) CopyFileRange(cancel <-chan struct{}, in *fuse.CopyFileRangeIn) (uint32, fuse.Status) {
+	if w.stopping.Load() {
+		return 0, fuse.Status(syscall.EAGAIN)
+	}
+	return w.RawFileSystem.CopyFileRange(cancel, in)
+}
+
+func (w *writeRefuser) Fallocate(cancel <-chan struct{}, in *fuse.FallocateIn) fuse.Status {
+	if w.stopping.Load() {
+		return fuse.Status(syscall.EAGAIN)
+	}
+	return w.RawFileSystem.Fallocate(cancel, in)
+}
+
+func (w *writeRefuser) SetAttr(cancel <-chan struct{}, in *fuse.SetAttrIn, out *fuse.AttrOut) fuse.Status {
+	if w.stopping.Load() {
+		return fuse.Status(syscall.EAGAIN)
+	}
+	return w.RawFileSystem.SetAttr(cancel, in, out)
+}
+
+// drainWrites waits for the writes in progress on requests to finish, or
+// until timeout is closed. It returns how many are left.
+func drainWrites(mountpoint string, requests *inFlight, timeout <-chan struct{}) int {
+	n := pendingWrites(requests)
+	if n == 0 {
+		return 0
+	}
+	logEvent("draining_writes", fmt.Sprintf("Waiting for %d writes in progress before unmounting", n), "mountpoint", mountpoint, "writes", n)
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-timeout:
+			return pendingWrites(requests)
+		}
+		if n = pendingWrites(requests); n == 0 {
+			logEvent("writes_drained", "Writes in progress finished", "mountpoint", mountpoint)
+			return 0
+		}
+	}
+}
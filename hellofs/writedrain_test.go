@@ -0,0 +1,92 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// TestDrainWrites shuts a mount down while a slow write is in progress:
+// the write must finish, a new one must be refused, and Run must return
+// cleanly once the slow one is done.
+func TestDrainWrites(t *testing.T) {
+	skipUnlessMountable(t)
+	dir := t.TempDir()
+	started := make(chan struct{}, 1)
+	slow := func(ctx context.Context, op, path string) func(opResult) {
+		if op == "write" {
+			select {
+			case started <- struct{}{}:
+				time.Sleep(500 * time.Millisecond)
+			default:
+			}
+		}
+		return func(opResult) {}
+	}
+	ready := make(chan struct{})
+	control := &controlState{}
+	cfg := Config{
+		Mountpoint:      dir,
+		Root:            NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}),
+		VerifyFile:      "file.txt",
+		ReadyTimeout:    5 * time.Second,
+		MountTimeout:    5 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+		DrainWrites:     true,
+		Control:         control,
+		Options:         &fs.Options{MountOptions: fuse.MountOptions{DirectMount: true}},
+		OnReady:         func() error { close(ready); return nil },
+		hooks:           []opHook{slow},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := make(chan error, 1)
+	go func() { ran <- Run(ctx, cfg) }()
+	select {
+	case <-ready:
+	case err := <-ran:
+		t.Fatalf("Run returned before the mount was ready: %v", err)
+	}
+
+	path := filepath.Join(dir, "file.txt")
+	first, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := first.WriteAt([]byte("HELLO"), 0)
+		wrote <- err
+	}()
+	<-started
+	cancel()
+	for !control.stopping.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := second.WriteAt([]byte("J"), 0); !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("write while stopping: error = %v, want EAGAIN", err)
+	}
+	if err := <-wrote; err != nil {
+		t.Errorf("write in progress: %v", err)
+	}
+	first.Close()
+	second.Close()
+	if err := <-ran; err != nil {
+		t.Errorf("Run() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,220 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	usageXattr = "user.usage"
+	gidsXattr  = "user.supplementary_gids"
+)
+
+// xattrReply answers a getxattr request with val, or with the size
+// needed when dest is too small.
+func xattrReply(dest, val []byte) (uint32, syscall.Errno) {
+	if len(dest) < len(val) {
+		return uint32(len(val)), syscall.ERANGE
+	}
+	return uint32(copy(dest, val)), 0
+}
+
+// xattrList answers a listxattr request with names.
+func xattrList(dest []byte, names ...string) (uint32, syscall.Errno) {
+	var b strings.Builder
+	for _, n := range names {
+		b.WriteString(n)
+		b.WriteByte(0)
+	}
+	return xattrReply(dest, []byte(b.String()))
+}
+
+// formatGids renders gids as a comma separated list.
+func formatGids(gids []uint32) []byte {
+	var b []byte
+	for i, g := range gids {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendUint(b, uint64(g), 10)
+	}
+	return b
+}
+
+// Getxattr reports the bytes of file content held in memory as
+// user.usage, and the -supplementaryGid list if one was given.
+func (r *HelloRoot) Getxattr(ctx context.Context, attr string, dest []byte) (sz uint32, errno syscall.Errno) {
+	defer startOp(ctx, "getxattr", &r.Inode)(&errno)
+	switch {
+	case attr == usageXattr:
+		return xattrReply(dest, strconv.AppendUint(nil, treeUsage(&r.Inode), 10))
+	case attr == gidsXattr && len(r.supplementaryGids) > 0:
+		return xattrReply(dest, formatGids(r.supplementaryGids))
+	}
+	return dirGetxattr(r, &r.xattrs, attr, dest)
+}
+
+func (r *HelloRoot) Listxattr(ctx context.Context, dest []byte) (sz uint32, errno syscall.Errno) {
+	defer startOp(ctx, "listxattr", &r.Inode)(&errno)
+	names := []string{usageXattr}
+	if len(r.supplementaryGids) > 0 {
+		names = append(names, gidsXattr)
+	}
+	return xattrList(dest, append(names, dirXattrNames(&r.xattrs)...)...)
+}
+
+// xattrStore holds the user.* attributes set on a file, and with
+// -enableAcl its ACL, in memory.
+type xattrStore struct {
+	mu     sync.Mutex
+	attrs  map[string][]byte
+	seeded bool // the -defaultAcl was added
+}
+
+// lockXattrs locks the attributes of f, giving it the -defaultAcl first if
+// this is the first use.
+func (f *HelloFile) lockXattrs() {
+	f.xattrs.mu.Lock()
+	if f.xattrs.seeded || !f.root.enableAcl || f.root.defaultACL == nil {
+		return
+	}
+	f.xattrs.seeded = true
+	if f.xattrs.attrs == nil {
+		f.xattrs.attrs = map[string][]byte{}
+	}
+	f.xattrs.attrs[aclXattr] = f.root.defaultACL
+}
+
+// aclMode returns the permission bits of the file's ACL, if it has one.
+func (f *HelloFile) aclMode() (uint32, bool) {
+	if !f.root.enableAcl {
+		return 0, false
+	}
+	f.lockXattrs()
+	defer f.xattrs.mu.Unlock()
+	b, ok := f.xattrs.attrs[aclXattr]
+	if !ok {
+		return 0, false
+	}
+	return aclMode(b)
+}
+
+// aclChmod applies a chmod to the file's ACL, if it has one.
+func (f *HelloFile) aclChmod(mode uint32) {
+	if !f.root.enableAcl {
+		return
+	}
+	f.lockXattrs()
+	defer f.xattrs.mu.Unlock()
+	if b, ok := f.xattrs.attrs[aclXattr]; ok {
+		f.xattrs.attrs[aclXattr] = aclChmod(b, mode)
+	}
+}
+
+// Getxattr reports the -supplementaryGid list, if one was given, and the
+// attributes set on the file.
+func (f *HelloFile) Getxattr(ctx context.Context, attr string, dest []byte) (sz uint32, errno syscall.Errno) {
+	defer startOp(ctx, "getxattr", &f.Inode)(&errno)
+	if attr == gidsXattr && len(f.root.supplementaryGids) > 0 {
+		return xattrReply(dest, formatGids(f.root.supplementaryGids))
+	}
+	f.lockXattrs()
+	defer f.xattrs.mu.Unlock()
+	val, ok := f.xattrs.attrs[attr]
+	if !ok {
+		return 0, fs.ENOATTR
+	}
+	return xattrReply(dest, val)
+}
+
+// Setxattr stores attributes in the user namespace, except the ones
+// Getxattr computes, and with -enableAcl the ACL the kernel sets on chmod
+// and setfacl.
+func (f *HelloFile) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer startOp(ctx, "setxattr", &f.Inode)(&errno)
+	if errno := f.checkXattr(attr); errno != 0 {
+		return errno
+	}
+	if _, ok := aclMode(data); attr == aclXattr && !ok {
+		return syscall.EINVAL
+	}
+	f.lockXattrs()
+	defer f.xattrs.mu.Unlock()
+	_, ok := f.xattrs.attrs[attr]
+	switch {
+	case flags&unix.XATTR_CREATE != 0 && ok:
+		return syscall.EEXIST
+	case flags&unix.XATTR_REPLACE != 0 && !ok:
+		return fs.ENOATTR
+	}
+	if f.xattrs.attrs == nil {
+		f.xattrs.attrs = map[string][]byte{}
+	}
+	f.xattrs.attrs[attr] = bytes.Clone(data)
+	return 0
+}
+
+func (f *HelloFile) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer startOp(ctx, "removexattr", &f.Inode)(&errno)
+	if errno := f.checkXattr(attr); errno != 0 {
+		return errno
+	}
+	f.lockXattrs()
+	defer f.xattrs.mu.Unlock()
+	if _, ok := f.xattrs.attrs[attr]; !ok {
+		return fs.ENOATTR
+	}
+	delete(f.xattrs.attrs, attr)
+	return 0
+}
+
+// checkXattr reports why attr can't be changed, if it can't.
+func (f *HelloFile) checkXattr(attr string) syscall.Errno {
+	switch {
+	case f.root.disableXAttrs:
+		return syscall.ENOTSUP
+	case f.root.readOnly:
+		return syscall.EROFS
+	case attr == aclXattr && f.root.enableAcl:
+	case !strings.HasPrefix(attr, "user."):
+		return syscall.ENOTSUP
+	case attr == gidsXattr:
+		return syscall.EPERM
+	}
+	return 0
+}
+
+func (f *HelloFile) Listxattr(ctx context.Context, dest []byte) (sz uint32, errno syscall.Errno) {
+	defer startOp(ctx, "listxattr", &f.Inode)(&errno)
+	return xattrList(dest, f.xattrNames()...)
+}
+
+func (f *HelloFile) xattrNames() []string {
+	var names []string
+	if len(f.root.supplementaryGids) > 0 {
+		names = append(names, gidsXattr)
+	}
+	f.lockXattrs()
+	defer f.xattrs.mu.Unlock()
+	return append(names, slices.Sorted(maps.Keys(f.xattrs.attrs))...)
+}
+
+var (
+	_ = (fs.NodeGetxattrer)((*HelloRoot)(nil))
+	_ = (fs.NodeListxattrer)((*HelloRoot)(nil))
+	_ = (fs.NodeGetxattrer)((*HelloFile)(nil))
+	_ = (fs.NodeSetxattrer)((*HelloFile)(nil))
+	_ = (fs.NodeRemovexattrer)((*HelloFile)(nil))
+	_ = (fs.NodeListxattrer)((*HelloFile)(nil))
+)
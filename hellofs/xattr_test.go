@@ -0,0 +1,75 @@
+//go:build linux || darwin
+
+package hellofs
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// listXattrs returns the names of the attributes on path.
+func listXattrs(t *testing.T, path string) []string {
+	t.Helper()
+	buf := make([]byte, 1024)
+	n, err := unix.Listxattr(path, buf)
+	if err != nil {
+		t.Fatalf("listxattr: %v", err)
+	}
+	var names []string
+	for name := range strings.SplitSeq(string(buf[:n]), "\x00") {
+		if name != "" && !strings.HasPrefix(name, "security.") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestXattrRoundTrip(t *testing.T) {
+	dir := mountRoot(t, NewRoot(map[string][]byte{"file.txt": []byte("hello\n")}, RootOptions{}))
+	path := filepath.Join(dir, "file.txt")
+	if names := listXattrs(t, path); len(names) != 0 {
+		t.Errorf("new file has attributes %q", names)
+	}
+	if err := unix.Setxattr(path, "user.b", []byte("two"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(path, "user.a", []byte("one"), unix.XATTR_CREATE); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := unix.Getxattr(path, "user.a", buf)
+	if err != nil || !bytes.Equal(buf[:n], []byte("one")) {
+		t.Errorf("getxattr user.a = %q, %v, want %q", buf[:n], err, "one")
+	}
+	if n, err := unix.Getxattr(path, "user.a", nil); err != nil || n != 3 {
+		t.Errorf("getxattr user.a size = %d, %v, want 3", n, err)
+	}
+	if want := []string{"user.a", "user.b"}; !reflect.DeepEqual(listXattrs(t, path), want) {
+		t.Errorf("listxattr = %q, want %q", listXattrs(t, path), want)
+	}
+	if err := unix.Setxattr(path, "user.a", []byte("x"), unix.XATTR_CREATE); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("setxattr XATTR_CREATE of an existing one: %v, want EEXIST", err)
+	}
+	if err := unix.Setxattr(path, "user.c", []byte("x"), unix.XATTR_REPLACE); !errors.Is(err, unix.ENODATA) {
+		t.Errorf("setxattr XATTR_REPLACE of a missing one: %v, want ENODATA", err)
+	}
+	if err := unix.Removexattr(path, "user.a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unix.Getxattr(path, "user.a", buf); !errors.Is(err, unix.ENODATA) {
+		t.Errorf("getxattr after removing: %v, want ENODATA", err)
+	}
+	if err := unix.Removexattr(path, "user.a"); !errors.Is(err, unix.ENODATA) {
+		t.Errorf("removexattr of a missing one: %v, want ENODATA", err)
+	}
+	if want := []string{"user.b"}; !reflect.DeepEqual(listXattrs(t, path), want) {
+		t.Errorf("listxattr after removing = %q, want %q", listXattrs(t, path), want)
+	}
+}
@@ -1,234 +1,223 @@
+//go:build linux || darwin
+
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
-	"log"
+	"maps"
 	"os"
-	"os/exec"
-	"os/signal"
-	"os/user"
-	"strconv"
+	"slices"
 	"strings"
-	"sync"
-	"syscall"
 	"time"
 
-	"github.com/hanwen/go-fuse/v2/fs"
-	"github.com/hanwen/go-fuse/v2/fuse"
-)
-
-type HelloRoot struct {
-	fs.Inode
-}
-
-func (r *HelloRoot) OnAdd(ctx context.Context) {
-	ch := r.NewPersistentInode(
-		ctx, &fs.MemRegularFile{
-			Data: []byte("file.txt"),
-			Attr: fuse.Attr{
-				Mode: 0644,
-			},
-		}, fs.StableAttr{Ino: 2})
-	r.AddChild("file.txt", ch, false)
-}
-
-func (r *HelloRoot) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
-	out.Mode = 0755
-	return 0
-}
-
-var (
-	_ = (fs.NodeGetattrer)((*HelloRoot)(nil))
-	_ = (fs.NodeOnAdder)((*HelloRoot)(nil))
+	"github.com/nopcoder/hello-fuse/hellofs"
 )
 
-func resolveUIDGID(uid int64, gid int64) (uint32, uint32, error) {
-	currentUID, currentGID, err := getCurrentUIDGID()
-	if err != nil {
-		return 0, 0, err
-	}
-	if uid == -1 {
-		uid = int64(currentUID)
-	}
-	if gid == -1 {
-		gid = int64(currentGID)
-	}
-	return uint32(uid), uint32(gid), nil //nolint:gosec
-}
-
-func getCurrentUIDGID() (uid, gid uint32, err error) {
-	var currentUser *user.User
-	currentUser, err = user.Current()
-	if err != nil {
-		return
-	}
-	var uidInt, gidInt int
-	uidInt, err = strconv.Atoi(currentUser.Uid)
-	if err != nil {
-		return
-	}
-	gidInt, err = strconv.Atoi(currentUser.Gid)
-	if err != nil {
-		return
-	}
-	return uint32(uidInt), uint32(gidInt), nil //nolint:gosec
-}
-
+// main fills in the hellofs.Options from the command line, the
+// HELLOFUSE_ environment variables and -config, and leaves the rest to
+// hellofs.Main.
 func main() {
-	debug := flag.Bool("debug", false, "print debug data")
+	o := hellofs.Options{FsSize: 64 << 20}
+	flag.BoolVar(&o.Debug, "debug", false, "print debug data")
+	flag.StringVar(&o.PidFile, "pidFile", "", "write the process id to this file once the mount is ready, and remove it on shutdown")
+	flag.BoolVar(&o.Background, "background", false, "detach from the terminal once the mount is ready")
+	flag.StringVar(&o.LogFile, "logFile", "", "append messages and the go-fuse log to this file instead of the terminal, or with -background instead of discarding them")
+	flag.Var(&o.LogMaxSize, "logMaxSize", "rotate -logFile to FILE.1 once it would grow past this size, e.g. 10M; 0 never rotates")
+	flag.StringVar(&o.LogFormat, "logFormat", "text", "format of lifecycle and diagnostic messages: text or json")
+	flag.BoolVar(&o.Quiet, "quiet", false, "leave out lifecycle messages such as the UID/GID line and Mount ready; warnings and errors still go to stderr")
+	flag.BoolVar(&o.PrintOptions, "printOptions", false, "print the resolved go-fuse options as JSON on stdout before mounting")
+	flag.BoolVar(&o.AllowRemount, "allowRemount", false, "on SIGUSR1, check the configuration with -dryRun and, if it passes, unmount and run again with the same arguments, re-reading -config; in-memory changes not written back are lost")
+	flag.BoolVar(&o.DryRun, "dryRun", false, "check the configuration and build the tree, print the resolved options as with -printOptions, and exit without mounting")
+	showVersion := flag.Bool("version", false, "print the version, commit and build date and exit")
 
 	// fs.Options
-	entryTimeout := flag.Duration("entryTimeout", time.Second, "fuse entry timeout")
-	attrTimeout := flag.Duration("attrTimeout", time.Second, "fuse attribute timeout")
-	negativeTimeout := flag.Duration("negativeTimeout", time.Second, "fuse negative entry timeout")
-	firstAutomaticIno := flag.Uint64("firstAutomaticIno", 0, "first automatic inode number")
-	nullPermissions := flag.Bool("nullPermissions", false, "support null permissions")
-	uid := flag.Int64("uid", -1, "user id")
-	gid := flag.Int64("gid", -1, "group id")
+	flag.DurationVar(&o.EntryTimeout, "entryTimeout", time.Second, "fuse entry timeout; 0 disables caching, negative uses go-fuse's default (1s)")
+	flag.DurationVar(&o.AttrTimeout, "attrTimeout", time.Second, "fuse attribute timeout; 0 disables caching, negative uses go-fuse's default (1s)")
+	flag.DurationVar(&o.NegativeTimeout, "negativeTimeout", time.Second, "fuse negative entry timeout; 0 disables caching, negative uses go-fuse's default (none cached)")
+	flag.Uint64Var(&o.FirstAutomaticIno, "firstAutomaticIno", 0, "first inode number for files and directories created through the mount or from a manifest or spec (default 1<<48)")
+	flag.BoolVar(&o.NullPermissions, "nullPermissions", false, "report files and directories with mode 0 as such instead of as 0644 and 0755")
+	flag.BoolVar(&o.EchoDir, "echoDir", false, "serve echo/, where any name looked up is a file holding that name")
+	flag.BoolVar(&o.NullFile, "nullFile", false, "serve null.txt, a file with mode 0, to see the effect of -nullPermissions")
+	flag.Int64Var(&o.Uid, "uid", -1, "user id")
+	flag.Int64Var(&o.Gid, "gid", -1, "group id")
+	flag.StringVar(&o.User, "user", "", "user name, looked up instead of -uid")
+	flag.StringVar(&o.Group, "group", "", "group name, looked up instead of -gid")
+	flag.Func("file", "serve a file described as name=NAME[:mode=0644][:contentFile=PATH | :content=TEXT]; repeatable", o.AddFile)
+	flag.Func("symlink", "serve a symlink described as NAME=TARGET; the target may dangle; repeatable", o.AddSymlink)
+	flag.Func("alias", "also serve file.txt under NAME, as a hard link sharing its inode; repeatable", o.AddAlias)
+	flag.Func("supplementaryGid", "supplementary group id or name reported in the user.supplementary_gids xattr; repeatable or comma separated", o.AddSupplementaryGid)
 	// fuse.MountOptions
-	allowOther := flag.Bool("allowOther", false, "allow other users to access the file system")
-	maxBackground := flag.Int("maxBackground", 12, "max number of background requests")
-	maxWrite := flag.Int("maxWrite", 0, "max size for write requests")
-	maxReadAhead := flag.Int("maxReadAhead", 0, "max read ahead size")
-	ignoreSecurityLabels := flag.Bool("ignoreSecurityLabels", false, "ignore security labels")
-	rememberInodes := flag.Bool("rememberInodes", false, "remember inodes")
-	fsName := flag.String("fsName", "", "filesystem name")
-	name := flag.String("name", "", "mount name")
-	singleThreaded := flag.Bool("singleThreaded", false, "single threaded")
-	disableXAttrs := flag.Bool("disableXAttrs", false, "disable extended attributes")
-	enableLocks := flag.Bool("enableLocks", false, "enable file locks")
-	enableSymlinkCaching := flag.Bool("enableSymlinkCaching", false, "enable symlink caching")
-	explicitDataCacheControl := flag.Bool("explicitDataCacheControl", false, "explicit data cache control")
-	syncRead := flag.Bool("syncRead", false, "synchronous read")
-	directMount := flag.Bool("directMount", false, "direct mount")
-	directMountStrict := flag.Bool("directMountStrict", false, "strict direct mount")
-	directMountFlags := flag.Uint("directMountFlags", 0, "direct mount flags")
-	enableAcl := flag.Bool("enableAcl", false, "enable ACL support")
-	disableReadDirPlus := flag.Bool("disableReadDirPlus", false, "disable readdirplus")
-	disableSplice := flag.Bool("disableSplice", false, "disable splice")
-	maxStackDepth := flag.Int("maxStackDepth", 1, "maximum stacking depth")
-	idMappedMount := flag.Bool("idMappedMount", false, "ID-mapped mount")
-	optionsStr := flag.String("options", "", "comma-separated mount options")
-	mountTimeout := flag.Duration("mountTimeout", 5*time.Second, "timeout for mounting the filesystem")
+	flag.BoolVar(&o.AllowOther, "allowOther", false, "allow other users to access the file system")
+	flag.BoolVar(&o.AllowOtherBestEffort, "allowOtherBestEffort", false, "with -allowOther, mount without it, warning, if it isn't permitted, e.g. without user_allow_other in /etc/fuse.conf")
+	flag.BoolVar(&o.AllowRoot, "allowRoot", false, "allow root, besides the mounting user, to access the file system; needs fusermount")
+	flag.IntVar(&o.MaxBackground, "maxBackground", 12, "max number of background requests")
+	flag.IntVar(&o.MaxWrite, "maxWrite", 0, "max size for write requests, a multiple of the page size up to the kernel's limit, usually 1M; 0 uses 128K")
+	flag.IntVar(&o.MaxReadAhead, "maxReadAhead", 0, "max read ahead size, a multiple of the page size up to 128K and -maxWrite; 0 uses the kernel's default")
+	flag.BoolVar(&o.IgnoreSecurityLabels, "ignoreSecurityLabels", false, "ignore security labels")
+	flag.BoolVar(&o.RememberInodes, "rememberInodes", false, "remember inodes")
+	flag.StringVar(&o.FsName, "fsName", "", "filesystem name, the source column of /proc/mounts; defaults to the -source directory, or hello-fuse")
+	flag.StringVar(&o.Name, "name", "", "mount name, shown as the fuse.NAME filesystem type; defaults to fuse")
+	flag.BoolVar(&o.SingleThreaded, "singleThreaded", false, "single threaded")
+	flag.BoolVar(&o.DisableXAttrs, "disableXAttrs", false, "disable extended attributes")
+	flag.BoolVar(&o.EnableLocks, "enableLocks", false, "enable file locks, kept by the in-memory files rather than the kernel")
+	flag.BoolVar(&o.EnableSymlinkCaching, "enableSymlinkCaching", false, "enable symlink caching")
+	flag.BoolVar(&o.ExplicitDataCacheControl, "explicitDataCacheControl", false, "explicit data cache control")
+	flag.BoolVar(&o.SyncRead, "syncRead", false, "synchronous read")
+	flag.BoolVar(&o.DirectMount, "directMount", false, "direct mount")
+	flag.BoolVar(&o.DirectMountStrict, "directMountStrict", false, "strict direct mount")
+	flag.UintVar(&o.DirectMountFlags, "directMountFlags", 0, "direct mount flags")
+	flag.BoolVar(&o.EnableAcl, "enableAcl", false, "enable ACL support, with the ACLs kept by the in-memory files and directories; entries created in a directory inherit its default ACL")
+	flag.StringVar(&o.DefaultAcl, "defaultAcl", "", "with -enableAcl, the access ACL of in-memory files that have none set, e.g. u::rw-,u:1000:rw-,g::r--,o::r--")
+	flag.BoolVar(&o.DisableReadDirPlus, "disableReadDirPlus", false, "disable readdirplus")
+	flag.BoolVar(&o.DisableSplice, "disableSplice", false, "disable splice")
+	flag.IntVar(&o.MaxStackDepth, "maxStackDepth", 1, "maximum stacking depth")
+	flag.BoolVar(&o.IdMappedMount, "idMappedMount", false, "ID-mapped mount")
+	flag.StringVar(&o.Options, "options", "", "comma-separated mount options")
+	flag.StringVar(&o.MountFlags, "mountFlags", "", "comma-separated hardening flags added to -options by name: "+strings.Join(slices.Sorted(maps.Keys(hellofs.MountFlagNames)), ", "))
+	flag.BoolVar(&o.AllowUnknownOptions, "allowUnknownOptions", false, "pass -options the kernel may not know instead of rejecting them")
+	flag.DurationVar(&o.MountTimeout, "mountTimeout", 5*time.Second, "how long mounting and verifying the filesystem may take together before giving up")
+	flag.IntVar(&o.MountRetries, "mountRetries", 0, "retry a mount failing with EBUSY or another transient error this many times")
+	flag.DurationVar(&o.MountRetryBackoff, "mountRetryBackoff", 100*time.Millisecond, "delay before the first -mountRetries retry, doubling after each")
+	flag.StringVar(&o.WaitForFile, "waitForFile", "", "wait for host path to exist before mounting")
+	flag.DurationVar(&o.WaitForFileTimeout, "waitForFileTimeout", time.Minute, "timeout for waiting on -waitForFile")
+	configFile := flag.String("config", "", "read defaults for flags from this JSON, YAML or TOML (*.toml) file, keyed by flag name; the environment and command line override it")
+	flag.BoolVar(&o.SelfTest, "selfTest", false, "mount on a temporary directory, check file.txt and unmount, exiting nonzero if any step fails; takes no MOUNTPOINT")
+	flag.BoolVar(&o.LogWriteFragments, "logWriteFragments", false, "log writes the kernel split into several requests")
+	flag.StringVar(&o.Content, "content", "file.txt", "initial content of file.txt")
+	flag.StringVar(&o.ContentFile, "contentFile", "", "read the initial content of file.txt from this host file instead of -content, or from stdin until EOF if -")
+	flag.StringVar(&o.ExpectSha256, "expectSha256", "", "refuse to mount unless the content of file.txt, from -content or -contentFile, has this hex SHA-256 digest; reloads that don't match are refused too")
+	flag.StringVar(&o.GzipContentFile, "gzipContentFile", "", "like -contentFile, for a gzipped file that is served decompressed")
+	flag.StringVar(&o.Writeback, "writeback", "", "save the content of file.txt to this host path on fsync and on shutdown, before unmounting")
+	flag.BoolVar(&o.ReadOnly, "readOnly", false, "mount read-only with the ro option, and have the writable files and directories refuse changes with EROFS too")
+	flag.BoolVar(&o.ClockFile, "clockFile", false, "serve clock.txt showing the current time on every read")
+	flag.StringVar(&o.ClockFormat, "clockFormat", time.RFC3339, "Go time layout for -clockFile")
+	flag.BoolVar(&o.CaseInsensitive, "caseInsensitive", false, "look up names at the root ignoring case, so FILE.TXT finds file.txt, listing only the stored names, and reject -manifest and -file entries whose names differ only by case; creating and removing use the name as given")
+	flag.BoolVar(&o.WriteCountFile, "writeCountFile", false, "serve writes.count showing how many write requests the in-memory files served since the start")
+	flag.BoolVar(&o.CallerFile, "callerFile", false, "serve caller.txt describing the reading process")
+	flag.StringVar(&o.HttpAddr, "httpAddr", "", "serve /healthz, /readyz, /status and POST /shutdown on this address, e.g. 127.0.0.1:8080; without a host, as in :8080, on loopback only")
+	flag.BoolVar(&o.HttpAllowRemote, "httpAllowRemote", false, "serve the unauthenticated POST /shutdown and /release-reads on a non-loopback -httpAddr too")
+	flag.DurationVar(&o.StatusInterval, "statusInterval", 0, "log each mount's uptime and the requests in progress this often; 0 never does")
+	flag.BoolVar(&o.ShutdownDrainWrites, "shutdownDrainWrites", false, "on shutdown, wait up to -shutdownTimeout for writes in progress to finish before -writeback and unmounting, exiting with status 9 if some don't")
+	flag.DurationVar(&o.ShutdownTimeout, "shutdownTimeout", 10*time.Second, "how long to wait for the server to stop after unmounting before exiting anyway with status 7; 0 waits forever")
+	flag.StringVar(&o.OnReady, "onReady", "", "shell command run once each mount is verified, with the mountpoint in HELLOFUSE_MOUNTPOINT; its output is logged")
+	flag.BoolVar(&o.OnReadyFatal, "onReadyFatal", false, "unmount and exit if the -onReady command fails, rather than just logging it")
+	flag.StringVar(&o.OnUnmount, "onUnmount", "", "shell command run after each mount is unmounted, with the mountpoint in HELLOFUSE_MOUNTPOINT")
+	flag.DurationVar(&o.ReadyTimeout, "readyTimeout", 2*time.Second, "how long to retry verifying the mount by reading file.txt, within -mountTimeout")
+	flag.StringVar(&o.HealthFile, "healthFile", "", "touch this file every -healthInterval while file.txt can be read through every mount, and remove it on shutdown")
+	flag.DurationVar(&o.HealthInterval, "healthInterval", 10*time.Second, "how often -healthFile is checked and touched")
+	flag.StringVar(&o.ReadyTcp, "readyTcp", "", "connect to host:port and send READY once the mount is verified")
+	flag.BoolVar(&o.FailFast, "failFast", false, "with several MOUNTPOINTs, unmount all of them as soon as one fails or goes away, instead of serving the others on")
+	flag.StringVar(&o.ReadySocket, "readySocket", "", "listen on this Unix socket and answer each connection with READY once the mount is verified, PENDING before")
+	flag.StringVar(&o.CasDir, "casDir", "", "serve a content-addressable store kept in this host directory under cas/")
+	flag.StringVar(&o.Atime, "atime", "", "how reads update the atime of the in-memory files: "+strings.Join(hellofs.AtimeModes, ", ")+"; defaults to the atime option in -options, or relatime")
+	flag.StringVar(&o.CachePolicy, "cachePolicy", "cache", "page cache use of the in-memory files: cache keeps it across opens, nocache drops it on open, direct bypasses it; with -explicitDataCacheControl this is all that refreshes it")
+	flag.BoolVar(&o.CloseToOpen, "closeToOpen", false, "enforce close-to-open consistency for written files")
+	flag.BoolVar(&o.ReuseInodes, "reuseInodes", false, "reuse inode numbers of deleted files for new ones")
+	flag.StringVar(&o.SqliteDB, "sqliteDB", "", "serve the rows of -sqliteQuery on this SQLite database under db/")
+	flag.StringVar(&o.SqliteQuery, "sqliteQuery", "SELECT name, content FROM files", "query returning (name, content) rows for -sqliteDB")
+	flag.StringVar(&o.RootMode, "rootMode", "", "octal permission bits of the mount's top directory, e.g. 0700; defaults to 0755 less -umask")
+	flag.StringVar(&o.RootUser, "rootUser", "", "user name or id owning the mount's top directory; defaults to -uid")
+	flag.StringVar(&o.RootGroup, "rootGroup", "", "group name or id of the mount's top directory; defaults to -gid")
+	flag.StringVar(&o.Umask, "umask", "", "octal mask, e.g. 022, cleared from the mode of files and directories created through the mount and of file.txt and the -file, -manifest and -spec entries; the process umask still applies on top to what callers create")
+	flag.StringVar(&o.Mtime, "mtime", "", "RFC3339 time, to the nanosecond if wanted, reported as atime, mtime and ctime of the served files until they are changed; defaults to the start time")
+	flag.BoolVar(&o.Embedded, "embedded", false, "serve the read-only files compiled in from assets/ at the root")
+	flag.StringVar(&o.Source, "source", "", "serve a read-only view of this host directory instead of the built-in tree")
+	flag.Var(&o.CacheSize, "cacheSize", "keep the content of recently read -source files in memory, up to this many bytes, e.g. 64M; a file is read again once its mtime or size changes")
+	flag.StringVar(&o.Manifest, "manifest", "", "build additional files and directories from this YAML or JSON manifest")
+	flag.StringVar(&o.TarFile, "tarFile", "", "restore in-memory files and directories from this tar archive, such as a -snapshotFile one, over the built-in tree")
+	flag.StringVar(&o.Spec, "spec", "", "build additional files, directories and symlinks from this tree spec")
+	flag.StringVar(&o.ConfigMap, "configMap", "", "serve the keys of this ConfigMap JSON manifest under configmap/, laid out like a Kubernetes volume")
+	flag.StringVar(&o.Secret, "secret", "", "serve the keys of this Secret JSON manifest under secret/, laid out like a Kubernetes volume")
+	flag.StringVar(&o.UnmountCmd, "unmountCmd", "", "unmount on shutdown only with this method: "+strings.Join(hellofs.UnmountMethods, ", "))
+	flag.IntVar(&o.FuseFd, "fuseFd", 0, "serve an already mounted /dev/fuse connection inherited as this fd instead of mounting")
+	flag.BoolVar(&o.Force, "force", false, "mount over a mountpoint directory that is not empty")
+	flag.BoolVar(&o.CreateMountpoint, "createMountpoint", false, "create the mountpoint directory, and its parents, if it is missing")
+	flag.BoolVar(&o.RecoverStaleMount, "recoverStaleMount", false, "lazily unmount a disconnected mount left at the mountpoint by a crashed run")
+	flag.DurationVar(&o.ReloadDebounce, "reloadDebounce", 0, "coalesce reload signals arriving within this window into one reload")
+	flag.BoolVar(&o.WatchConfig, "watchConfig", false, "reload automatically when a reloadable source file changes")
+	flag.StringVar(&o.AttrOverrides, "attrOverrides", "", "JSON file of per-path attribute overrides applied on getattr")
+	flag.DurationVar(&o.IdleTimeout, "idleTimeout", 0, "unmount, as on SIGTERM, once no file operation ran for this long; 0 never does")
+	flag.IntVar(&o.ReadBps, "readBps", 0, "throttle reads of the in-memory files to this many bytes per second in total; 0 means no limit. Kernel readahead counts too unless -cachePolicy is direct")
+	flag.IntVar(&o.MaxNameLen, "maxNameLen", hellofs.DefaultMaxNameLen, "refuse looking up and creating names longer than this many bytes with ENAMETOOLONG, and manifest entries with them; 0 means no limit")
+	flag.IntVar(&o.MaxOpenFiles, "maxOpenFiles", 0, "fail opening more than this many files at once with EMFILE; 0 means no limit")
+	flag.BoolVar(&o.BlockReads, "blockReads", false, "make reads of the in-memory files hang until released with SIGUSR2 or POST /release-reads on -httpAddr, or interrupted, to test client timeouts")
+	flag.DurationVar(&o.LingerOnSignal, "lingerOnSignal", 0, "on the first SIGINT or SIGTERM, refuse new lookups and opens and wait this long for open files to be closed before unmounting; a second signal unmounts at once")
+	flag.IntVar(&o.MaxDirEntries, "maxDirEntries", 0, "fail creating entries in writable directories holding this many, with ENOSPC")
+	flag.BoolVar(&o.StrictErrno, "strictErrno", false, "map backend errors such as timeouts and permission errors to matching errnos instead of EIO")
+	flag.BoolVar(&o.RecoverPanics, "recoverPanics", true, "reply EIO to a request whose handler panics instead of crashing the mount")
+	flag.StringVar(&o.Plugin, "plugin", "", "load content generators from this Go plugin and serve them under gen/")
+	flag.Int64Var(&o.RandomSize, "randomSize", 0, "serve random.bin with this many pseudo-random bytes")
+	flag.Int64Var(&o.RandomSeed, "randomSeed", -1, "seed for random.bin, so runs with the same seed serve the same bytes; negative derives it from -seed")
+	flag.Int64Var(&o.Seed, "seed", -1, "seed for the generated content, random.bin and the -syntheticSize files, each deriving its own from it and its path, so runs with the same seed serve the same bytes at each path; negative picks one at startup")
+	flag.Var(&o.FsSize, "fsSize", "capacity reported to df, e.g. 1G")
+	var fsFree hellofs.ByteSize
+	flag.Var(&fsFree, "fsFree", "free space reported to df, e.g. 512M; defaults to -fsSize less the in-memory content")
+	flag.Int64Var(&o.SparseSize, "sparseSize", 0, "serve sparse.bin, a hole of this many bytes with no blocks allocated")
+	flag.IntVar(&o.SyntheticEntries, "syntheticEntries", 0, "list this many generated files, file-00001 and up, at the root, created only when looked up")
+	flag.Int64Var(&o.SyntheticSize, "syntheticSize", 0, "make each -syntheticEntries file this many pseudo-random bytes from -seed; 0 has it hold its name")
+	flag.Var(&o.BenchFileSize, "benchFileSize", "serve zeros.bin, this many zero bytes generated on each read for read benchmarks, e.g. 1G")
+	flag.DurationVar(&o.TmpTTL, "tmpTTL", 0, "serve tmp/ for scratch files that are removed this long after creation; override per file with the user.ttl xattr")
+	flag.BoolVar(&o.TmpIdle, "tmpIdle", false, "count -tmpTTL from the last access instead of creation")
+	flag.BoolVar(&o.ProcDir, "procDir", false, "serve proc/ with per-process statistics on how each caller uses the mount")
+	flag.DurationVar(&o.ProcIdle, "procIdle", time.Minute, "drop -procDir entries of processes idle for this long")
+	flag.BoolVar(&o.ReadStats, "readStats", false, "serve .stats with per-file read request counts and sizes, to measure readahead amplification")
+	flag.StringVar(&o.SnapshotFile, "snapshotFile", "", "periodically write a tar snapshot of the tree to this host path")
+	flag.DurationVar(&o.SnapshotInterval, "snapshotInterval", time.Minute, "interval between -snapshotFile snapshots")
+	flag.StringVar(&o.LatencyModel, "latencyModel", "", "inject per-operation latency, e.g. 'lognormal:mean=5ms,p99=50ms;read=constant:1ms'")
+	flag.StringVar(&o.MetricsAddr, "metricsAddr", "", "serve Prometheus metrics of operation counts and latencies at /metrics on this address, e.g. :9100")
+	flag.BoolVar(&o.TraceOps, "traceOps", false, "log every node operation with its inode, caller and duration, without the go-fuse -debug output")
+	flag.StringVar(&o.OtlpEndpoint, "otlpEndpoint", "", "OTLP/HTTP endpoint (host:port) to export operation traces to")
 
 	flag.Parse()
-	if len(flag.Args()) < 1 {
-		fmt.Printf("Usage:\n  hello-fuse [flags] MOUNTPOINT\n")
-		return
-	}
-	ruid, rgid, err := resolveUIDGID(*uid, *gid)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error resolving UID/GID: %v\n", err)
-		os.Exit(1)
+	if err := flagsFromEnv(flag.CommandLine, "HELLOFUSE_"); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid environment variable %v\n", err)
+		os.Exit(hellofs.ExitFailure)
 	}
-	fmt.Printf("Using UID: '%d', GID: '%d'\n", ruid, rgid)
-	var options []string
-	if *optionsStr != "" {
-		options = strings.Split(*optionsStr, ",")
+	if *configFile != "" {
+		warnings, err := flagsFromConfig(flag.CommandLine, *configFile)
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: -config %s\n", w)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -config: %v\n", err)
+			os.Exit(hellofs.ExitFailure)
+		}
 	}
-
-	opts := &fs.Options{
-		Logger:            log.New(os.Stdout, "", log.LstdFlags),
-		EntryTimeout:      entryTimeout,
-		AttrTimeout:       attrTimeout,
-		NegativeTimeout:   negativeTimeout,
-		FirstAutomaticIno: *firstAutomaticIno,
-		NullPermissions:   *nullPermissions,
-		UID:               ruid,
-		GID:               rgid,
-		MountOptions: fuse.MountOptions{
-			Debug:                    *debug,
-			AllowOther:               *allowOther,
-			Options:                  options,
-			MaxBackground:            *maxBackground,
-			MaxWrite:                 *maxWrite,
-			MaxReadAhead:             *maxReadAhead,
-			IgnoreSecurityLabels:     *ignoreSecurityLabels,
-			RememberInodes:           *rememberInodes,
-			FsName:                   *fsName,
-			Name:                     *name,
-			SingleThreaded:           *singleThreaded,
-			DisableXAttrs:            *disableXAttrs,
-			EnableLocks:              *enableLocks,
-			EnableSymlinkCaching:     *enableSymlinkCaching,
-			ExplicitDataCacheControl: *explicitDataCacheControl,
-			SyncRead:                 *syncRead,
-			DirectMount:              *directMount,
-			DirectMountStrict:        *directMountStrict,
-			DirectMountFlags:         uintptr(*directMountFlags),
-			EnableAcl:                *enableAcl,
-			DisableReadDirPlus:       *disableReadDirPlus,
-			DisableSplice:            *disableSplice,
-			MaxStackDepth:            *maxStackDepth,
-			IDMappedMount:            *idMappedMount,
-		},
+	if *showVersion {
+		fmt.Println(versionString())
+		return
 	}
-
-	var (
-		server   *fuse.Server
-		mountErr error
-	)
-	done := make(chan struct{})
-	// Signal handling for graceful shutdown to call umount
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	mountpoint := flag.Arg(0)
-	go func() {
-		server, mountErr = fs.Mount(mountpoint, &HelloRoot{}, opts)
-		close(done)
-	}()
-	select {
-	case <-done:
-		if mountErr != nil {
-			fmt.Fprintf(os.Stderr, "Mount fail: %v\n", mountErr)
-			os.Exit(1)
-		}
-	case <-time.After(*mountTimeout):
-		fmt.Fprintf(os.Stderr, "ERROR: Mount failed timed out after %v\nHint: Perhaps mount directory busy? try runnning 'umount %s'\n", *mountTimeout, mountpoint)
-		os.Exit(1)
+	if len(flag.Args()) < 1 && !o.SelfTest {
+		fmt.Printf("Usage:\n  hello-fuse [flags] MOUNTPOINT...\n")
+		os.Exit(hellofs.ExitUsage)
 	}
-	// wait group for server
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	// Handle Ctrl+C or shell close
-	go func() {
-		sig := <-sigCh
-		fmt.Printf("Received signal %v, Closing gracefully\n", sig)
-		cmd := exec.Command("umount", mountpoint)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to unmount: %v\n", err)
-			os.Exit(1)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "fsFree" {
+			o.FsFree = &fsFree
 		}
-		os.Exit(0)
-	}()
-
-	go func() {
-		server.Wait()
-		wg.Done()
-	}()
-
-	// verify mount by trying to stat a file
-	go tryStatFile(mountpoint)
-	fmt.Println("Mount ready")
-	wg.Wait()
+	})
+	o.Version = versionString()
+	os.Exit(hellofs.Main(&o, flag.Args()))
 }
 
-func tryStatFile(mountpoint string) {
+// flagsFromEnv sets each flag not given on the command line from the
+// environment variable named by prefix and the upper-cased flag name, e.g.
+// HELLOFUSE_MAXWRITE for -maxWrite.
+func flagsFromEnv(fset *flag.FlagSet, prefix string) error {
+	set := map[string]bool{}
+	fset.Visit(func(f *flag.Flag) { set[f.Name] = true })
 	var err error
-	for range 3 { // try 3 times
-		_, err = os.Stat(mountpoint + "/file.txt")
-		if err == nil {
-			break
+	fset.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
 		}
-		time.Sleep(500 * time.Millisecond)
-	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Mount failed, error stating file: %v\n", err)
-		os.Exit(1)
-	}
+		name := prefix + strings.ToUpper(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if serr := fset.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s=%q: %v", name, v, serr)
+			}
+		}
+	})
+	return err
 }
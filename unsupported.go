@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/nopcoder/hello-fuse/hellofs"
+)
+
+// go-fuse only supports Linux and macFUSE, so elsewhere the program just
+// says so.
+func main() {
+	fmt.Fprintf(os.Stderr, "FUSE mounting is not supported on %s\n", runtime.GOOS)
+	os.Exit(hellofs.ExitFailure)
+}
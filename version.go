@@ -0,0 +1,50 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build metadata, set with e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Values left empty are taken from the build info the go command embeds,
+// with the commit time standing in for the build date.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// buildVersion returns the module version, git commit and build date,
+// "unknown" standing in for any that aren't known.
+func buildVersion() (v, c, d string) {
+	v, c, d = version, commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	for _, s := range []*string{&v, &c, &d} {
+		if *s == "" {
+			*s = "unknown"
+		}
+	}
+	return v, c, d
+}
+
+func versionString() string {
+	v, c, d := buildVersion()
+	return fmt.Sprintf("hello-fuse %s, commit %s, built %s", v, c, d)
+}